@@ -0,0 +1,84 @@
+package main
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "path"
+)
+
+// an identity which can access the images, it is authenticated
+// either by basic auth user/password or by the X-API-Key header
+type AccessIdentity struct {
+    User string `json:"user"`
+    Password string `json:"password"`
+    ApiKey string `json:"api_key"`
+
+    //the repository name patterns (path.Match syntax) which can be pulled
+    Read []string `json:"read"`
+
+    //the repository name patterns which can be pushed and deleted
+    Write []string `json:"write"`
+
+    //can call the /admin/ endpoints
+    Admin bool `json:"admin"`
+}
+
+// the access rules loaded from the access config file:
+//
+//  { "identities": [ { "user": "ci", "password": "secret",
+//                      "read": [ "team-a/*" ], "write": [ "team-a/*" ] } ] }
+type AccessControl struct {
+    Identities []*AccessIdentity `json:"identities"`
+}
+
+func LoadAccessControl( file string ) (*AccessControl, error) {
+    b, err := ioutil.ReadFile( file )
+    if err != nil {
+        return nil, err
+    }
+    ac := &AccessControl{}
+    if err = json.Unmarshal( b, ac ); err != nil {
+        return nil, err
+    }
+    return ac, nil
+}
+
+// find the identity of the request, nil if it is not authenticated
+func (ac *AccessControl)Identify( req *http.Request ) *AccessIdentity {
+    if api_key := req.Header.Get( "X-API-Key" ); api_key != "" {
+        for _, identity := range ac.Identities {
+            if identity.ApiKey != "" && secureEqual( identity.ApiKey, api_key ) {
+                return identity
+            }
+        }
+        return nil
+    }
+    if user, password, ok := req.BasicAuth(); ok {
+        for _, identity := range ac.Identities {
+            if identity.User != "" && identity.User == user && secureEqual( identity.Password, password ) {
+                return identity
+            }
+        }
+    }
+    return nil
+}
+
+// check if the identity can read (or write if write is true) the repository
+func (ai *AccessIdentity)CanAccess( repository string, write bool ) bool {
+    patterns := ai.Read
+    if write {
+        patterns = ai.Write
+    }
+    for _, pattern := range patterns {
+        if ok, _ := path.Match( pattern, repository ); ok {
+            return true
+        }
+    }
+    return false
+}
+
+func secureEqual( a string, b string ) bool {
+    return subtle.ConstantTimeCompare( []byte( a ), []byte( b ) ) == 1
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "path/filepath"
+    "testing"
+)
+
+func TestAccessControlDisjointRepositories( t *testing.T ) {
+    config := filepath.Join( t.TempDir(), "access.json" )
+    ioutil.WriteFile( config, []byte( `{ "identities": [
+                { "api_key": "key-a", "read": [ "team-a*" ], "write": [ "team-a*" ] },
+                { "user": "b", "password": "secret-b", "read": [ "team-b*" ], "write": [ "team-b*" ] } ] }` ), 0600 )
+    ac, err := LoadAccessControl( config )
+    if err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    iw.SetAccessControl( ac )
+    req_b, _ := http.NewRequest( "GET", "/", nil )
+    req_b.SetBasicAuth( "b", "secret-b" )
+    as_a := []string{ "X-API-Key", "key-a" }
+    as_b := []string{ "Authorization", req_b.Header.Get( "Authorization" ) }
+
+    for _, push := range []struct{ name string; identity []string; status int }{
+                { "team-a-app/1", as_a, http.StatusCreated },
+                { "team-b-app/1", as_b, http.StatusCreated },
+                { "team-b-app/2", as_a, http.StatusForbidden },
+                { "team-a-app/2", as_b, http.StatusForbidden },
+                { "team-a-app/3", nil, http.StatusUnauthorized } } {
+        rw := doRequest( handler, "POST", "/image/save/" + push.name, bytes.NewReader( makeImageArchive( t, push.name, push.name ) ), push.identity... )
+        if rw.Code != push.status {
+            t.Errorf( "expected %d for the push of %s, got %d", push.status, push.name, rw.Code )
+        }
+    }
+    if rw := doRequest( handler, "GET", "/image/get/team-a-app:1", nil, as_b... ); rw.Code != http.StatusForbidden {
+        t.Errorf( "expected 403 for the pull of the other team, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/get/team-b-app:1", nil, as_b... ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the pull of its own repository, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/team-b-app:1", nil, as_a... ); rw.Code != http.StatusForbidden {
+        t.Errorf( "expected 403 for the delete of the other team, got %d", rw.Code )
+    }
+
+    rw := doRequest( handler, "GET", "/image/list", nil, as_a... )
+    var images []string
+    if err := json.Unmarshal( rw.Body.Bytes(), &images ); err != nil || len( images ) != 1 || images[0] != "team-a-app:1" {
+        t.Errorf( "expected only the images of team-a to be listed, got %s", rw.Body.String() )
+    }
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+    "github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/service"
+)
+
+// the size of the blocks an image is uploaded in and how many of them
+// are uploaded at the same time, so at most 32MiB of an upload is in memory
+const (
+    azureBlockSize = 8 * 1024 * 1024
+    azureUploadConcurrency = 4
+)
+
+// store the images as the block blobs "<prefix>/<name>/<tag>" of an Azure
+// Blob Storage container. The images are streamed to and from the
+// container and the list is built by enumerating the blobs
+type AzureBlobImageStorage struct {
+    client *azblob.Client
+    container string
+
+    //the blob path prefix of the images, empty for the container root
+    prefix string
+
+    //the content type of the blobs, it is detected if it is empty
+    contentType string
+
+    //the access tier of the written blobs, the account's default tier
+    //if it is empty
+    accessTier blob.AccessTier
+
+    //start rehydrating the archived blob when its pull is rejected
+    restoreArchived bool
+}
+
+// the access tiers of the block blobs, the Archive blobs are offline and
+// must be rehydrated to an online tier before they can be read
+var azureAccessTiers = []blob.AccessTier{ blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold, blob.AccessTierArchive }
+
+// create the storage of the container. The connection string in the
+// AZURE_STORAGE_CONNECTION_STRING environment variable is used if it is
+// set, otherwise the account is accessed with the managed identity or
+// the other credentials found by the default Azure credential chain
+func NewAzureBlobImageStorage( account, containerName, prefix string ) (*AzureBlobImageStorage, error) {
+    var client *azblob.Client
+    var err error
+    if connection_string := os.Getenv( "AZURE_STORAGE_CONNECTION_STRING" ); connection_string != "" {
+        client, err = azblob.NewClientFromConnectionString( connection_string, nil )
+    } else if account == "" {
+        return nil, fmt.Errorf( "the account or AZURE_STORAGE_CONNECTION_STRING is required by the azure backend" )
+    } else {
+        credential, cred_err := azidentity.NewDefaultAzureCredential( nil )
+        if cred_err != nil {
+            return nil, cred_err
+        }
+        client, err = azblob.NewClient( fmt.Sprintf( "https://%s.blob.core.windows.net/", account ), credential, nil )
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &AzureBlobImageStorage{ client: client, container: containerName, prefix: strings.Trim( prefix, "/" ) }, nil
+}
+
+// set the content type of the written blobs instead of detecting it
+func (abis *AzureBlobImageStorage) SetContentType( content_type string ) {
+    abis.contentType = content_type
+}
+
+// get the storage accessing the container with the SAS token of a
+// request instead of the credentials of the server
+func (abis *AzureBlobImageStorage) WithCredentials( sas_token string ) (ImageStorage, error) {
+    query, err := url.ParseQuery( strings.TrimPrefix( sas_token, "?" ) )
+    if err != nil || query.Get( "sig" ) == "" {
+        return nil, fmt.Errorf( "the SAS token is invalid" )
+    }
+    service_url := abis.client.URL()
+    if i := strings.Index( service_url, "?" ); i >= 0 {
+        service_url = service_url[:i]
+    }
+    client, err := azblob.NewClientWithNoCredential( service_url + "?" + query.Encode(), nil )
+    if err != nil {
+        return nil, err
+    }
+    return &AzureBlobImageStorage{ client: client, container: abis.container, prefix: abis.prefix, contentType: abis.contentType, restoreArchived: abis.restoreArchived }, nil
+}
+
+// get the storage writing the blobs in the access tier
+func (abis *AzureBlobImageStorage) WithStorageClass( class string ) (ImageStorage, error) {
+    tiers := make( []string, 0, len( azureAccessTiers ) )
+    for _, tier := range azureAccessTiers {
+        if strings.EqualFold( class, string( tier ) ) {
+            tier_storage := *abis
+            tier_storage.accessTier = tier
+            return &tier_storage, nil
+        }
+        tiers = append( tiers, string( tier ) )
+    }
+    return nil, fmt.Errorf( "%w: %s is not one of %s", ErrInvalidStorageClass, class, strings.Join( tiers, ", " ) )
+}
+
+// rehydrate the archived blob to the Hot tier when its pull is rejected,
+// so it can be pulled once the rehydration is done
+func (abis *AzureBlobImageStorage) SetRestoreArchived( restore bool ) {
+    abis.restoreArchived = restore
+}
+
+// map the missing blob to ErrNotFound and the archived one to ErrArchived
+func azureError( name string, err error ) error {
+    if bloberror.HasCode( err, bloberror.BlobNotFound ) {
+        return fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    if bloberror.HasCode( err, bloberror.BlobArchived ) {
+        return fmt.Errorf( "%w: %s must be restored from the Archive tier before it is pulled", ErrArchived, name )
+    }
+    return err
+}
+
+// start rehydrating the archived blob of image name to the Hot tier
+func (abis *AzureBlobImageStorage) restore( name string, object string ) error {
+    blob_client := abis.client.ServiceClient().NewContainerClient( abis.container ).NewBlobClient( object )
+    priority := blob.RehydratePriorityStandard
+    if _, err := blob_client.SetTier( context.Background(), blob.AccessTierHot, &blob.SetTierOptions{ RehydratePriority: &priority } ); err != nil {
+        return fmt.Errorf( "%w: %s must be restored from the Archive tier before it is pulled, fail to start the restore: %v", ErrArchived, name, err )
+    }
+    return fmt.Errorf( "%w: the restore of %s from the Archive tier is started, pull it again when it is done", ErrArchived, name )
+}
+
+// the blocks are staged while the image is read and committed at the
+// end, so a failed upload leaves the previous blob untouched
+func (abis *AzureBlobImageStorage) Write( name string, reader io.Reader ) error {
+    object, err := imageObjectName( abis.prefix, name )
+    if err != nil {
+        return err
+    }
+    content_type, reader := objectContentType( reader, abis.contentType )
+    options := &azblob.UploadStreamOptions{
+                BlockSize: azureBlockSize,
+                Concurrency: azureUploadConcurrency,
+                HTTPHeaders: &blob.HTTPHeaders{ BlobContentType: &content_type } }
+    if abis.accessTier != "" {
+        options.AccessTier = &abis.accessTier
+    }
+    _, err = abis.client.UploadStream( context.Background(), abis.container, object, reader, options )
+    return err
+}
+
+func (abis *AzureBlobImageStorage) Get( name string, writer io.Writer ) error {
+    object, err := imageObjectName( abis.prefix, name )
+    if err != nil {
+        return err
+    }
+    resp, err := abis.client.DownloadStream( context.Background(), abis.container, object, nil )
+    if bloberror.HasCode( err, bloberror.BlobArchived ) && abis.restoreArchived {
+        return abis.restore( name, object )
+    }
+    if err != nil {
+        return azureError( name, err )
+    }
+    defer resp.Body.Close()
+    _, err = io.Copy( writer, resp.Body )
+    return err
+}
+
+func (abis *AzureBlobImageStorage) Delete( name string ) error {
+    object, err := imageObjectName( abis.prefix, name )
+    if err != nil {
+        return err
+    }
+    _, err = abis.client.DeleteBlob( context.Background(), abis.container, object, nil )
+    return azureError( name, err )
+}
+
+// enumerate the blobs starting with prefix and call found for each of them
+func (abis *AzureBlobImageStorage) listBlobs( prefix string, found func( item *container.BlobItem ) ) error {
+    options := &azblob.ListBlobsFlatOptions{}
+    if prefix != "" {
+        options.Prefix = &prefix
+    }
+    pager := abis.client.NewListBlobsFlatPager( abis.container, options )
+    for pager.More() {
+        resp, err := pager.NextPage( context.Background() )
+        if err != nil {
+            return err
+        }
+        for _, item := range resp.Segment.BlobItems {
+            if item.Name != nil {
+                found( item )
+            }
+        }
+    }
+    return nil
+}
+
+// rebuild the "<name>:<tag>" of every blob under the prefix
+func (abis *AzureBlobImageStorage) List() ([]string, error) {
+    prefix := ""
+    if abis.prefix != "" {
+        prefix = abis.prefix + "/"
+    }
+    result := make( []string, 0 )
+    err := abis.listBlobs( prefix, func( item *container.BlobItem ) {
+        if name, ok := objectImageName( abis.prefix, *item.Name ); ok {
+            result = append( result, name )
+        }
+    } )
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// list the size, creation time and access tier of every image with the
+// blobs
+func (abis *AzureBlobImageStorage) ListDetailed() ([]ImageInfo, error) {
+    prefix := ""
+    if abis.prefix != "" {
+        prefix = abis.prefix + "/"
+    }
+    infos := make( []ImageInfo, 0 )
+    err := abis.listBlobs( prefix, func( item *container.BlobItem ) {
+        name, ok := objectImageName( abis.prefix, *item.Name )
+        if !ok {
+            return
+        }
+        info := ImageInfo{ Name: name, RefCount: 1 }
+        info.Repository, info.Tag = parseImageName( name )
+        if properties := item.Properties; properties != nil {
+            if properties.ContentLength != nil {
+                info.Size = *properties.ContentLength
+            }
+            if properties.CreationTime != nil {
+                created := *properties.CreationTime
+                info.Created = &created
+            }
+            if properties.AccessTier != nil {
+                info.StorageClass = string( *properties.AccessTier )
+            }
+        }
+        infos = append( infos, info )
+    } )
+    if err != nil {
+        return nil, err
+    }
+    return infos, nil
+}
+
+// check if the listed blob is in the Archive tier
+func isArchivedBlob( item *container.BlobItem ) bool {
+    return item.Properties != nil && item.Properties.AccessTier != nil && *item.Properties.AccessTier == blob.AccessTierArchive
+}
+
+// get the listed blob of image name, nil if there is no such blob
+func (abis *AzureBlobImageStorage) findBlob( name string ) (*container.BlobItem, error) {
+    object, err := imageObjectName( abis.prefix, name )
+    if err != nil {
+        return nil, err
+    }
+    var result *container.BlobItem
+    err = abis.listBlobs( object, func( item *container.BlobItem ) {
+        if *item.Name == object {
+            result = item
+        }
+    } )
+    return result, err
+}
+
+func (abis *AzureBlobImageStorage) Exists( name string ) (bool, error) {
+    item, err := abis.findBlob( name )
+    return item != nil, err
+}
+
+// the size of the blob, without downloading it
+func (abis *AzureBlobImageStorage) Size( name string ) (int64, bool, error) {
+    item, err := abis.findBlob( name )
+    if err != nil {
+        return 0, false, err
+    }
+    if item == nil {
+        return 0, false, fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    if item.Properties == nil || item.Properties.ContentLength == nil {
+        return 0, false, nil
+    }
+    return *item.Properties.ContentLength, true, nil
+}
+
+// get a read-only SAS URL of the blob. The SAS is signed with the account
+// key of the connection string if there is one, otherwise with a user
+// delegation key of the managed identity. ErrNotFound if the image doesn't
+// exist, so no URL of a missing blob is handed out
+func (abis *AzureBlobImageStorage) PresignURL( name string, expires time.Duration ) (string, error) {
+    item, err := abis.findBlob( name )
+    if err != nil {
+        return "", err
+    }
+    if item == nil {
+        return "", fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    //the SAS URL of an archived blob can't be downloaded
+    if isArchivedBlob( item ) {
+        return "", fmt.Errorf( "%w: %s", ErrArchived, name )
+    }
+    permissions := sas.BlobPermissions{ Read: true }
+    expiry := time.Now().UTC().Add( expires )
+    blob_client := abis.client.ServiceClient().NewContainerClient( abis.container ).NewBlobClient( *item.Name )
+    url, err := blob_client.GetSASURL( permissions, expiry, nil )
+    if !errors.Is( err, bloberror.MissingSharedKeyCredential ) {
+        return url, err
+    }
+    //allow for the clock skew between this host and the storage
+    start := time.Now().UTC().Add( -5 * time.Minute )
+    key_start := start.Format( sas.TimeFormat )
+    key_expiry := expiry.Format( sas.TimeFormat )
+    credential, err := abis.client.ServiceClient().GetUserDelegationCredential( context.Background(), service.KeyInfo{ Start: &key_start, Expiry: &key_expiry }, nil )
+    if err != nil {
+        return "", err
+    }
+    query, err := sas.BlobSignatureValues{ Protocol: sas.ProtocolHTTPS,
+                StartTime: start,
+                ExpiryTime: expiry,
+                Permissions: permissions.String(),
+                ContainerName: abis.container,
+                BlobName: *item.Name }.SignWithUserDelegation( credential )
+    if err != nil {
+        return "", err
+    }
+    return blob_client.URL() + "?" + query.Encode(), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAzurePresignURL( t *testing.T ) {
+    _, storage := newFakeAzureStorage( t, "images", "prod" )
+    if err := storage.Write( "team/app:1", strings.NewReader( "image" ) ); err != nil {
+        t.Fatal( err )
+    }
+    signed, err := storage.PresignURL( "team/app:1", 10 * time.Minute )
+    if err != nil {
+        t.Fatal( err )
+    }
+    u, err := url.Parse( signed )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if !strings.HasSuffix( u.Path, "/images/prod/team/app/1" ) {
+        t.Errorf( "expected the URL of the blob, got %s", signed )
+    }
+    query := u.Query()
+    if query.Get( "sp" ) != "r" || query.Get( "sig" ) == "" {
+        t.Errorf( "expected a signed read-only SAS, got %s", u.RawQuery )
+    }
+    expiry, err := time.Parse( time.RFC3339, query.Get( "se" ) )
+    if err != nil || expiry.Before( time.Now().Add( 9 * time.Minute ) ) || expiry.After( time.Now().Add( 11 * time.Minute ) ) {
+        t.Errorf( "expected the SAS to expire in 10 minutes, got %s", query.Get( "se" ) )
+    }
+
+    if _, err = storage.PresignURL( "team/app:2", time.Minute ); !isNotFound( err ) {
+        t.Errorf( "expected not found for the missing image, got %v", err )
+    }
+}
+
+func TestAzurePresignedURLDownloadsTheImage( t *testing.T ) {
+    _, storage := newFakeAzureStorage( t, "images", "" )
+    image := bytes.Repeat( []byte( "layer" ), 100 )
+    if err := storage.Write( "app:1", bytes.NewReader( image ) ); err != nil {
+        t.Fatal( err )
+    }
+    signed, err := storage.PresignURL( "app:1", time.Minute )
+    if err != nil {
+        t.Fatal( err )
+    }
+    resp, err := httpGet( signed )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if !bytes.Equal( resp, image ) {
+        t.Errorf( "expected the presigned URL to serve the image" )
+    }
+}
+
+// the large image is uploaded in staged blocks and committed at the end
+func TestAzureStagedBlocks( t *testing.T ) {
+    fab, storage := newFakeAzureStorage( t, "images", "prefix" )
+    content := bytes.Repeat( []byte( "0123456789abcdef" ), ( 2 * azureBlockSize + 16 ) / 16 )
+    if err := storage.Write( "team/app:1", bytes.NewReader( content ) ); err != nil {
+        t.Fatal( err )
+    }
+    fab.mutex.Lock()
+    staged := fab.staged
+    fab.mutex.Unlock()
+    if staged != 3 {
+        t.Errorf( "expected 3 staged blocks, got %d", staged )
+    }
+    if names := fab.names(); len( names ) != 1 || names[0] != "images/prefix/team/app/1" {
+        t.Errorf( "expected the blob of team/app:1, got %v", names )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "team/app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), content ) {
+        t.Errorf( "expected the uploaded image, got %d bytes: %v", b.Len(), err )
+    }
+
+    //the failed upload leaves the committed blob as it was
+    broken := &errorAfterReader{ r: strings.NewReader( "new" ), err: io.ErrUnexpectedEOF }
+    if err := storage.Write( "team/app:1", broken ); err == nil {
+        t.Fatal( "expected the broken upload to fail" )
+    }
+    b.Reset()
+    if err := storage.Get( "team/app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), content ) {
+        t.Errorf( "expected the image to be kept, got %d bytes: %v", b.Len(), err )
+    }
+}
+
+// the content type of the blob is detected from the image unless it is
+// overridden
+func TestAzureContentType( t *testing.T ) {
+    fab, storage := newFakeAzureStorage( t, "images", "" )
+    archive := makeImageArchive( t, "app", "app:1" )
+    for name, content := range map[string][]byte{ "app:1": archive, "app:2": gzipBytes( t, archive ) } {
+        if err := storage.Write( name, bytes.NewReader( content ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    storage.SetContentType( "application/octet-stream" )
+    storage.Write( "app:3", bytes.NewReader( archive ) )
+
+    fab.mutex.Lock()
+    defer fab.mutex.Unlock()
+    for blob, expected := range map[string]string{ "images/app/1": "application/x-tar",
+                "images/app/2": "application/gzip",
+                "images/app/3": "application/octet-stream" } {
+        if content_type := fab.contentTypes[blob]; content_type != expected {
+            t.Errorf( "expected the content type %s of %s, got %s", expected, blob, content_type )
+        }
+    }
+}
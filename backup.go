@@ -0,0 +1,261 @@
+package main
+
+import (
+    "archive/tar"
+    "bufio"
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+)
+
+type restoreResult struct {
+    Name string `json:"name"`
+
+    //"imported", "skipped" or "failed"
+    Status string `json:"status"`
+
+    Error string `json:"error,omitempty"`
+}
+
+type restoreSummary struct {
+    Images []restoreResult `json:"images"`
+
+    //the archive is not completely read if it is set
+    Error string `json:"error,omitempty"`
+}
+
+// the name of the entry listing the sha256 of every image in the backup
+// archive in the sha256sum format, it is the first entry of the archive
+const backupChecksumEntry = "SHA256SUMS"
+
+// the tar entry name of the image "name:version" in the backup archive
+func backupEntryName( name string ) string {
+    image_name, image_version := parseImageName( name )
+    return image_name + "/" + image_version
+}
+
+// the image name "name:version" of the tar entry in the backup archive
+func backupImageName( entry_name string ) string {
+    pos := strings.LastIndex( entry_name, "/" )
+    if pos == -1 {
+        return entry_name
+    }
+    return entry_name[0:pos] + ":" + entry_name[pos+1:]
+}
+
+// write all the images to w as a tar.gz archive with one "name/version"
+// entry per image after the checksum manifest of the images
+func (iw *ImageWeb) backup( w io.Writer ) error {
+    names, err := iw.image_storage.List()
+    if err != nil {
+        return err
+    }
+
+    //the images are read twice so the manifest can be written first and
+    //the restore can verify every image while it is written
+    checksums := make( []string, 0, len( names ) )
+    var manifest bytes.Buffer
+    for _, name := range names {
+        hash := sha256.New()
+        if err = iw.image_storage.Get( name, hash ); err != nil {
+            return err
+        }
+        checksums = append( checksums, hex.EncodeToString( hash.Sum( nil ) ) )
+        fmt.Fprintf( &manifest, "%s  %s\n", checksums[len( checksums ) - 1], backupEntryName( name ) )
+    }
+
+    gz := gzip.NewWriter( w )
+    tw := tar.NewWriter( gz )
+    err = tw.WriteHeader( &tar.Header{ Name: backupChecksumEntry, Mode: 0644, Size: int64( manifest.Len() ) } )
+    if err != nil {
+        return err
+    }
+    if _, err = tw.Write( manifest.Bytes() ); err != nil {
+        return err
+    }
+    for i, name := range names {
+        if err = iw.backupImage( tw, name, checksums[i] ); err != nil {
+            return err
+        }
+    }
+    if err = tw.Close(); err != nil {
+        return err
+    }
+    return gz.Close()
+}
+
+// the size of tar entry must be known before its content, so the image
+// is spooled to a temporary file first. An error is returned if the image
+// is changed after its checksum is written to the manifest
+func (iw *ImageWeb) backupImage( tw *tar.Writer, name string, checksum string ) error {
+    f, err := ioutil.TempFile( "", "image-backup" )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( f.Name() )
+    defer f.Close()
+
+    hash := sha256.New()
+    if err = iw.image_storage.Get( name, io.MultiWriter( f, hash ) ); err != nil {
+        return err
+    }
+    if hex.EncodeToString( hash.Sum( nil ) ) != checksum {
+        return fmt.Errorf( "image %s is changed during the backup", name )
+    }
+    size, err := f.Seek( 0, io.SeekCurrent )
+    if err != nil {
+        return err
+    }
+    if _, err = f.Seek( 0, io.SeekStart ); err != nil {
+        return err
+    }
+    err = tw.WriteHeader( &tar.Header{ Name: backupEntryName( name ), Mode: 0644, Size: size } )
+    if err != nil {
+        return err
+    }
+    _, err = io.Copy( tw, f )
+    return err
+}
+
+// parse the checksum manifest in the sha256sum format to a map from the
+// entry name to the hex sha256
+func parseChecksumManifest( r io.Reader ) (map[string]string, error) {
+    checksums := make( map[string]string )
+    scanner := bufio.NewScanner( r )
+    for scanner.Scan() {
+        line := strings.TrimSpace( scanner.Text() )
+        if line == "" {
+            continue
+        }
+        fields := strings.SplitN( line, "  ", 2 )
+        if len( fields ) != 2 {
+            return nil, fmt.Errorf( "invalid line in %s: %s", backupChecksumEntry, line )
+        }
+        checksums[fields[1]] = strings.ToLower( fields[0] )
+    }
+    return checksums, scanner.Err()
+}
+
+// import the images from the tar.gz backup archive read from r. The
+// images which already exist are skipped unless overwrite is true.
+// If the archive starts with a checksum manifest, every image is verified
+// while it is written and deleted again if its checksum doesn't match
+func (iw *ImageWeb) restore( r io.Reader, overwrite bool ) ([]restoreResult, error) {
+    names, err := iw.image_storage.List()
+    if err != nil {
+        return nil, err
+    }
+    existing := make( map[string]bool )
+    for _, name := range names {
+        existing[name] = true
+    }
+
+    gz, err := gzip.NewReader( r )
+    if err != nil {
+        return nil, err
+    }
+    defer gz.Close()
+
+    defer iw.listCache.Invalidate()
+    results := make( []restoreResult, 0 )
+    var checksums map[string]string
+    restored := make( map[string]bool )
+    tr := tar.NewReader( gz )
+    for first := true; ; first = false {
+        header, err := tr.Next()
+        if err == io.EOF {
+            //the images in the manifest but not in the archive
+            for entry_name := range checksums {
+                if !restored[entry_name] {
+                    results = append( results, restoreResult{ Name: backupImageName( entry_name ), Status: "failed", Error: "image is missing in the archive" } )
+                }
+            }
+            return results, nil
+        }
+        if err != nil {
+            return results, err
+        }
+        if header.Typeflag != tar.TypeReg {
+            continue
+        }
+        if first && header.Name == backupChecksumEntry {
+            if checksums, err = parseChecksumManifest( tr ); err != nil {
+                return results, err
+            }
+            continue
+        }
+        restored[header.Name] = true
+        name := backupImageName( header.Name )
+        if existing[name] && iw.immutable.IsImmutable( name ) {
+            results = append( results, restoreResult{ Name: name, Status: "skipped", Error: "image is immutable" } )
+            continue
+        }
+        if existing[name] && !overwrite {
+            results = append( results, restoreResult{ Name: name, Status: "skipped" } )
+            continue
+        }
+        hash := sha256.New()
+        if err = iw.image_storage.Write( name, io.TeeReader( tr, hash ) ); err != nil {
+            results = append( results, restoreResult{ Name: name, Status: "failed", Error: err.Error() } )
+            continue
+        }
+        checksum := hex.EncodeToString( hash.Sum( nil ) )
+        if expected, ok := checksums[header.Name]; ok && expected != checksum {
+            iw.image_storage.Delete( name )
+            iw.digests.Remove( name )
+            results = append( results, restoreResult{ Name: name, Status: "failed", Error: "checksum mismatch, expected sha256 " + expected + " but got " + checksum } )
+            continue
+        }
+        iw.indexDigest( iw.image_storage, name, "sha256:" + checksum )
+        results = append( results, restoreResult{ Name: name, Status: "imported" } )
+    }
+}
+
+func (iw *ImageWeb) initBackup() {
+    http.HandleFunc("/backup", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/gzip" )
+        tw := &trackingWriter{ ResponseWriter: rw }
+        if err := iw.backup( tw ); err != nil {
+            //abort the partial backup so the client doesn't keep it
+            log.Printf( "fail to backup the images: %v", err )
+            if tw.written {
+                panic( http.ErrAbortHandler )
+            }
+            http.Error( rw, "fail to backup the images: " + err.Error(), http.StatusInternalServerError )
+        }
+    })
+
+    http.HandleFunc("/restore", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "POST" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        defer req.Body.Close()
+        results, err := iw.restore( req.Body, req.URL.Query().Get( "overwrite" ) == "true" )
+        if err != nil && len( results ) == 0 {
+            http.Error( rw, err.Error(), http.StatusBadRequest )
+            return
+        }
+        summary := restoreSummary{ Images: results }
+        if err != nil {
+            summary.Error = err.Error()
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        json.NewEncoder( rw ).Encode( summary )
+    })
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestBackupAndRestore( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    storage.Write( "team/app:2", bytes.NewReader( []byte( "two" ) ) )
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "GET", "/backup", nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "fail to backup: %d %s", rw.Code, responseBody( t, rw ) )
+    }
+
+    restored := newFileStorage( t )
+    iw, _ := newTestWeb( t, restored )
+    results, err := iw.restore( bytes.NewReader( rw.Body.Bytes() ), false )
+    if err != nil || len( results ) != 2 {
+        t.Fatalf( "expected 2 images to be restored, got %v: %v", results, err )
+    }
+    for name, content := range map[string]string{ "app:1": "one", "team/app:2": "two" } {
+        var b bytes.Buffer
+        if err := restored.Get( name, &b ); err != nil || b.String() != content {
+            t.Errorf( "expected %s to be restored, got %q: %v", name, b.String(), err )
+        }
+    }
+}
+
+func TestBackupFailsBeforeSending( t *testing.T ) {
+    storage := &failingStorage{ ImageStorage: newFileStorage( t ) }
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "GET", "/backup", nil ); rw.Code != http.StatusInternalServerError {
+        t.Errorf( "expected 500, got %d", rw.Code )
+    }
+}
+
+func TestBackupAbortsWhenTruncated( t *testing.T ) {
+    //the checksums of both images are computed, then the second image fails
+    storage := &failingStorage{ ImageStorage: newFileStorage( t ), failAfter: 3 }
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    storage.Write( "app:2", bytes.NewReader( []byte( "two" ) ) )
+    _, handler := newTestWeb( t, storage )
+    recovered := servePanic( handler, httptest.NewRecorder(), httptest.NewRequest( "GET", "/backup", nil ) )
+    if recovered != http.ErrAbortHandler {
+        t.Errorf( "expected the backup to be aborted, got %v", recovered )
+    }
+}
+
+func TestRestoreSkipsExisting( t *testing.T ) {
+    source := newFileStorage( t )
+    source.Write( "app:1", bytes.NewReader( []byte( "new" ) ) )
+    source.Write( "app:2", bytes.NewReader( []byte( "two" ) ) )
+    _, source_handler := newTestWeb( t, source )
+    backup := doRequest( source_handler, "GET", "/backup", nil ).Body.Bytes()
+
+    restored := newFileStorage( t )
+    restored.Write( "app:1", bytes.NewReader( []byte( "old" ) ) )
+    _, handler := newTestWeb( t, restored )
+    restore := func( url string ) map[string]string {
+        rw := doRequest( handler, "POST", url, bytes.NewReader( backup ) )
+        summary := restoreSummary{}
+        if err := json.Unmarshal( rw.Body.Bytes(), &summary ); err != nil {
+            t.Fatalf( "invalid restore summary %q: %v", rw.Body.String(), err )
+        }
+        statuses := make( map[string]string )
+        for _, result := range summary.Images {
+            statuses[result.Name] = result.Status
+        }
+        return statuses
+    }
+    if statuses := restore( "/restore" ); statuses["app:1"] != "skipped" || statuses["app:2"] != "imported" {
+        t.Errorf( "expected the existing image to be skipped, got %v", statuses )
+    }
+    if b := storedImage( restored, "app:1" ); b != "old" {
+        t.Errorf( "expected the existing image to be kept, got %q", b )
+    }
+    if statuses := restore( "/restore?overwrite=true" ); statuses["app:1"] != "imported" {
+        t.Errorf( "expected the existing image to be overwritten, got %v", statuses )
+    }
+    if b := storedImage( restored, "app:1" ); b != "new" {
+        t.Errorf( "expected the image of the backup, got %q", b )
+    }
+}
+
+// the content of image name in storage, empty if it can't be read
+func storedImage( storage ImageStorage, name string ) string {
+    var b bytes.Buffer
+    storage.Get( name, &b )
+    return b.String()
+}
+
+// a backup archive of the checksum manifest and the entries, by their
+// tar entry names
+func makeBackupArchive( t *testing.T, manifest string, entries map[string]string, names ...string ) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    gz := gzip.NewWriter( &b )
+    tw := tar.NewWriter( gz )
+    writeTarFile( t, tw, backupChecksumEntry, []byte( manifest ) )
+    for _, name := range names {
+        writeTarFile( t, tw, name, []byte( entries[name] ) )
+    }
+    if err := tw.Close(); err != nil {
+        t.Fatal( err )
+    }
+    gz.Close()
+    return b.Bytes()
+}
+
+func TestRestoreVerifiesChecksums( t *testing.T ) {
+    checksum := func( content string ) string {
+        return strings.TrimPrefix( sha256Digest( []byte( content ) ), "sha256:" )
+    }
+    manifest := checksum( "one" ) + "  app/1\n" + checksum( "two" ) + "  app/2\n" + checksum( "three" ) + "  app/3\n"
+    //app/2 is corrupted and app/3 is missing
+    archive := makeBackupArchive( t, manifest, map[string]string{ "app/1": "one", "app/2": "corrupted" }, "app/1", "app/2" )
+
+    storage := newFileStorage( t )
+    iw, _ := newTestWeb( t, storage )
+    results, err := iw.restore( bytes.NewReader( archive ), false )
+    if err != nil {
+        t.Fatal( err )
+    }
+    statuses := make( map[string]restoreResult )
+    for _, result := range results {
+        statuses[result.Name] = result
+    }
+    if statuses["app:1"].Status != "imported" {
+        t.Errorf( "expected app:1 to be imported, got %+v", statuses["app:1"] )
+    }
+    if result := statuses["app:2"]; result.Status != "failed" || !strings.Contains( result.Error, "checksum mismatch" ) {
+        t.Errorf( "expected the checksum mismatch of app:2 to be reported, got %+v", result )
+    }
+    if result := statuses["app:3"]; result.Status != "failed" || !strings.Contains( result.Error, "missing" ) {
+        t.Errorf( "expected the missing app:3 to be reported, got %+v", result )
+    }
+    if names, _ := storage.List(); len( names ) != 1 || names[0] != "app:1" {
+        t.Errorf( "expected only app:1 to be stored, got %v", names )
+    }
+    if _, ok := iw.digests.Digest( "app:2" ); ok {
+        t.Errorf( "expected no digest of the corrupted image" )
+    }
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "net/http"
+    "strings"
+)
+
+// the single user all the requests must authenticate as
+type BasicAuth struct {
+    user string
+    password string
+}
+
+// check the credentials of the request in constant time
+func (ba *BasicAuth) Check( req *http.Request ) bool {
+    user, password, ok := req.BasicAuth()
+    if !ok {
+        return false
+    }
+    //the hashes have the same length, so the length of the
+    //credentials doesn't leak by the comparison time
+    user_hash, expected_user := sha256.Sum256( []byte( user ) ), sha256.Sum256( []byte( ba.user ) )
+    password_hash, expected_password := sha256.Sum256( []byte( password ) ), sha256.Sum256( []byte( ba.password ) )
+    return subtle.ConstantTimeCompare( user_hash[:], expected_user[:] ) & subtle.ConstantTimeCompare( password_hash[:], expected_password[:] ) == 1
+}
+
+// require the basic auth credentials for every request, an empty user
+// disables it
+func (iw *ImageWeb) SetBasicAuth( user string, password string ) {
+    if user == "" {
+        iw.basicAuth = nil
+    } else {
+        iw.basicAuth = &BasicAuth{ user: user, password: password }
+    }
+}
+
+// reject the requests without the basic auth credentials if they are
+// configured. The health checks and the downloads with a token, which
+// is verified by the handler, don't need the credentials. Neither do the
+// identities of the access control, the handlers check what they can access
+func (iw *ImageWeb) requireBasicAuth( handler http.Handler ) http.Handler {
+    return http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        exempt := req.URL.Path == "/health" || ( strings.HasPrefix( req.URL.Path, "/image/get/" ) && req.URL.Query().Get( "token" ) != "" )
+        if iw.basicAuth != nil && !exempt && !iw.basicAuth.Check( req ) && !iw.hasIdentity( req ) {
+            rw.Header().Set( "WWW-Authenticate", `Basic realm="images"` )
+            http.Error( rw, "unauthorized", http.StatusUnauthorized )
+            return
+        }
+        handler.ServeHTTP( rw, req )
+    })
+}
+
+// check if the request is authenticated as an identity of the access control
+func (iw *ImageWeb) hasIdentity( req *http.Request ) bool {
+    return iw.accessControl != nil && iw.accessControl.Identify( req ) != nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "testing"
+)
+
+func TestBasicAuthWithAccessControl( t *testing.T ) {
+    storage := newFileStorage( t )
+    if err := storage.Write( "team-a/app:1", bytes.NewReader( makeImageArchive( t, "auth", "team-a/app:1" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    iw.SetBasicAuth( "admin", "secret" )
+    iw.SetAccessControl( &AccessControl{ Identities: []*AccessIdentity{
+                { User: "ci", Password: "ci-secret", Read: []string{ "team-a/*" } },
+                { ApiKey: "key", Read: []string{ "team-b/*" } } } } )
+
+    req_ci, _ := http.NewRequest( "GET", "/", nil )
+    req_ci.SetBasicAuth( "ci", "ci-secret" )
+    rw := doRequest( handler, "GET", "/image/get/team-a/app:1", nil, "Authorization", req_ci.Header.Get( "Authorization" ) )
+    if rw.Code != http.StatusOK {
+        t.Errorf( "expected the identity to pull its repository, got %d", rw.Code )
+    }
+    rw = doRequest( handler, "GET", "/image/get/team-a/app:1", nil, "X-API-Key", "key" )
+    if rw.Code != http.StatusForbidden {
+        t.Errorf( "expected the identity to be checked by the access control, got %d", rw.Code )
+    }
+    rw = doRequest( handler, "GET", "/image/get/team-a/app:1", nil )
+    if rw.Code != http.StatusUnauthorized {
+        t.Errorf( "expected 401 without credentials, got %d", rw.Code )
+    }
+    req_bad, _ := http.NewRequest( "GET", "/", nil )
+    req_bad.SetBasicAuth( "ci", "wrong" )
+    rw = doRequest( handler, "GET", "/image/get/team-a/app:1", nil, "Authorization", req_bad.Header.Get( "Authorization" ) )
+    if rw.Code != http.StatusUnauthorized {
+        t.Errorf( "expected 401 for the wrong password, got %d", rw.Code )
+    }
+}
+
+func TestBasicAuth( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) )
+    iw, handler := newTestWeb( t, storage )
+    credentials := func( user, password string ) []string {
+        req, _ := http.NewRequest( "GET", "/", nil )
+        req.SetBasicAuth( user, password )
+        return []string{ "Authorization", req.Header.Get( "Authorization" ) }
+    }
+
+    //no credentials are needed while the auth is disabled
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected 200 with the auth disabled, got %d", rw.Code )
+    }
+
+    iw.SetBasicAuth( "admin", "secret" )
+    for _, headers := range [][]string{ nil, credentials( "admin", "wrong" ), credentials( "other", "secret" ) } {
+        for _, url := range []string{ "/image/get/app:1", "/image/list", "/admin/config" } {
+            rw := doRequest( handler, "GET", url, nil, headers... )
+            if rw.Code != http.StatusUnauthorized || rw.Header().Get( "WWW-Authenticate" ) != `Basic realm="images"` {
+                t.Errorf( "expected 401 with the challenge for %s, got %d", url, rw.Code )
+            }
+        }
+    }
+    rw := doRequest( handler, "GET", "/image/get/app:1", nil, credentials( "admin", "secret" )... )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != "image" {
+        t.Errorf( "expected the image with the credentials, got %d", rw.Code )
+    }
+    //the health check is open to the probes
+    if rw = doRequest( handler, "GET", "/health", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected /health without the credentials, got %d", rw.Code )
+    }
+
+    iw.SetBasicAuth( "", "" )
+    if rw = doRequest( handler, "GET", "/image/get/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected 200 once the auth is disabled again, got %d", rw.Code )
+    }
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "archive/tar"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+)
+
+// the max number of images fetched by one batch
+const maxBatchImages = 100
+
+// the location of an image in the batch tar
+type batchIndexEntry struct {
+    Name string `json:"name"`
+
+    //the tar entry of the image, empty if the image is not included
+    Entry string `json:"entry,omitempty"`
+
+    //the offset of the image content from the start of the response
+    Offset int64 `json:"offset"`
+
+    Size int64 `json:"size"`
+    Digest string `json:"digest,omitempty"`
+
+    //why the image is not included
+    Error string `json:"error,omitempty"`
+}
+
+// count the bytes written to w
+type countingWriter struct {
+    w io.Writer
+    n int64
+}
+
+func (cw *countingWriter) Write( p []byte ) (int, error) {
+    n, err := cw.w.Write( p )
+    cw.n += int64( n )
+    return n, err
+}
+
+// write the image name as a tar entry, the image is spooled to a temporary
+// file first because the size of the entry must be known before its content
+func (iw *ImageWeb) writeBatchEntry( tw *tar.Writer, cw *countingWriter, name string ) (batchIndexEntry, error) {
+    entry := batchIndexEntry{ Name: normalizeImageName( name ) }
+    f, err := ioutil.TempFile( "", "image-batch" )
+    if err != nil {
+        return entry, err
+    }
+    defer os.Remove( f.Name() )
+    defer f.Close()
+
+    hash := sha256.New()
+    if err = iw.image_storage.Get( name, io.MultiWriter( f, hash ) ); err != nil {
+        if isNotFound( err ) {
+            entry.Error = "not found"
+        } else {
+            entry.Error = err.Error()
+        }
+        return entry, nil
+    }
+    if entry.Size, err = f.Seek( 0, io.SeekCurrent ); err != nil {
+        return entry, err
+    }
+    if _, err = f.Seek( 0, io.SeekStart ); err != nil {
+        return entry, err
+    }
+    entry.Entry = backupEntryName( name )
+    entry.Digest = "sha256:" + hex.EncodeToString( hash.Sum( nil ) )
+    if err = tw.WriteHeader( &tar.Header{ Name: entry.Entry, Mode: 0644, Size: entry.Size } ); err != nil {
+        return entry, err
+    }
+    //the header is written through, so the content starts here
+    entry.Offset = cw.n
+    _, err = io.Copy( tw, f )
+    return entry, err
+}
+
+// write the index of the images as the trailing index.json entry and
+// finish the tar
+func writeBatchIndex( tw *tar.Writer, index []batchIndexEntry ) error {
+    b, err := json.Marshal( index )
+    if err == nil {
+        err = tw.WriteHeader( &tar.Header{ Name: "index.json", Mode: 0644, Size: int64( len( b ) ) } )
+    }
+    if err == nil {
+        _, err = tw.Write( b )
+    }
+    if err == nil {
+        err = tw.Close()
+    }
+    return err
+}
+
+func (iw *ImageWeb) initBatchGet() {
+    http.HandleFunc("/image/get-batch", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "POST" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        names := make( []string, 0 )
+        if err := json.NewDecoder( req.Body ).Decode( &names ); err != nil {
+            http.Error( rw, "the body must be a JSON array of the image names", http.StatusBadRequest )
+            return
+        }
+        if len( names ) > maxBatchImages {
+            http.Error( rw, "too many images in one batch", http.StatusRequestEntityTooLarge )
+            return
+        }
+        var identity *AccessIdentity
+        if iw.accessControl != nil {
+            if identity = iw.accessControl.Identify( req ); identity == nil {
+                rw.Header().Set( "WWW-Authenticate", `Basic realm="images"` )
+                http.Error( rw, "unauthorized", http.StatusUnauthorized )
+                return
+            }
+        }
+
+        rw.Header().Set( "Content-Type", "application/x-tar" )
+        cw := &countingWriter{ w: rw }
+        tw := tar.NewWriter( cw )
+        index := make( []batchIndexEntry, 0, len( names ) )
+        for _, name := range names {
+            name = iw.nameTransform.Apply( name )
+            if err := iw.nameLimits.Validate( name ); err != nil {
+                index = append( index, batchIndexEntry{ Name: name, Error: err.Error() } )
+                continue
+            }
+            if image_name, _ := parseImageName( name ); identity != nil && !identity.CanAccess( image_name, false ) {
+                index = append( index, batchIndexEntry{ Name: normalizeImageName( name ), Error: "no permission" } )
+                continue
+            }
+            entry, err := iw.writeBatchEntry( tw, cw, name )
+            if err != nil {
+                //abort the response so the client notices the truncated batch
+                log.Printf( "fail to write image %s to the batch: %v", name, err )
+                panic( http.ErrAbortHandler )
+            }
+            index = append( index, entry )
+        }
+        if err := writeBatchIndex( tw, index ); err != nil {
+            log.Printf( "fail to write the batch index: %v", err )
+            panic( http.ErrAbortHandler )
+        }
+    })
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestBatchGet( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "POST", "/image/get-batch", strings.NewReader( `["app:1","app:2"]` ) )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+
+    entries := make( map[string][]byte )
+    tr := tar.NewReader( rw.Body )
+    for {
+        header, err := tr.Next()
+        if err != nil {
+            break
+        }
+        entries[header.Name], _ = ioutil.ReadAll( tr )
+    }
+    var index []batchIndexEntry
+    if err := json.Unmarshal( entries["index.json"], &index ); err != nil || len( index ) != 2 {
+        t.Fatalf( "expected the index of 2 images, got %s", entries["index.json"] )
+    }
+    if string( entries[index[0].Entry] ) != "one" {
+        t.Errorf( "expected the content of app:1, got %q", entries[index[0].Entry] )
+    }
+    if index[1].Error == "" {
+        t.Error( "expected an error for the missing app:2" )
+    }
+}
+
+func TestBatchGetAbortsWhenTruncated( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( bytes.Repeat( []byte( "x" ), 4096 ) ) )
+    _, handler := newTestWeb( t, storage )
+    rw := &failingResponseWriter{ ResponseRecorder: httptest.NewRecorder(), limit: 1024 }
+    recovered := servePanic( handler, rw, httptest.NewRequest( "POST", "/image/get-batch", strings.NewReader( `["app:1"]` ) ) )
+    if recovered != http.ErrAbortHandler {
+        t.Errorf( "expected the batch to be aborted, got %v", recovered )
+    }
+}
+
+// the offsets and the digests of the index locate the images in the response
+func TestBatchGetSeveral( t *testing.T ) {
+    storage := newFileStorage( t )
+    contents := map[string]string{ "app:1": "one", "app:2": "two", "team/app:3": strings.Repeat( "three", 200 ) }
+    for name, content := range contents {
+        storage.Write( name, strings.NewReader( content ) )
+    }
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "POST", "/image/get-batch", strings.NewReader( `["app:1","app:2","team/app:3"]` ) )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+    body := rw.Body.Bytes()
+    tr := tar.NewReader( bytes.NewReader( body ) )
+    var index []batchIndexEntry
+    for {
+        header, err := tr.Next()
+        if err != nil {
+            break
+        }
+        if header.Name == "index.json" {
+            json.NewDecoder( tr ).Decode( &index )
+        }
+    }
+    if len( index ) != 3 {
+        t.Fatalf( "expected the index of 3 images, got %v", index )
+    }
+    for _, entry := range index {
+        content := body[entry.Offset:entry.Offset + entry.Size]
+        if string( content ) != contents[entry.Name] || entry.Digest != sha256Digest( content ) {
+            t.Errorf( "the index entry %+v doesn't locate the image", entry )
+        }
+    }
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sort"
+)
+
+// compute a stable digest of the catalog over the sorted image names
+// and their content digests if known, so a client can poll it to
+// detect a change without listing all the images
+func (iw *ImageWeb) catalogDigest( images []string ) string {
+    sorted := append( []string{}, images... )
+    sort.Strings( sorted )
+
+    hash := sha256.New()
+    for _, image := range sorted {
+        hash.Write( []byte( image ) )
+        if digest, ok := iw.digests.Digest( image ); ok {
+            hash.Write( []byte( "@" + digest ) )
+        }
+        hash.Write( []byte( "\n" ) )
+    }
+    return "sha256:" + hex.EncodeToString( hash.Sum( nil ) )
+}
+
+func (iw *ImageWeb) initCatalog() {
+    http.HandleFunc("/catalog/digest", func(rw http.ResponseWriter, req *http.Request) {
+        images, err := iw.listImages()
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        images, ok := iw.filterReadable( rw, req, images )
+        if !ok {
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( map[string]interface{}{ "digest": iw.catalogDigest( images ), "count": len( images ) } )
+    })
+}
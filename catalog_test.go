@@ -0,0 +1,42 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "testing"
+)
+
+func TestCatalogDigest( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    catalog := func() string {
+        rw := doRequest( handler, "GET", "/catalog/digest", nil )
+        result := struct{ Digest string `json:"digest"` }{}
+        if err := json.Unmarshal( rw.Body.Bytes(), &result ); err != nil || result.Digest == "" {
+            t.Fatalf( "invalid catalog digest %q: %v", rw.Body.String(), err )
+        }
+        return result.Digest
+    }
+    save := func( name string, content string ) {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, content, name ) ) ); responseBody( t, rw ) != "save image successfully" {
+            t.Fatalf( "fail to save %s", name )
+        }
+    }
+
+    empty := catalog()
+    save( "app/1", "one" )
+    first := catalog()
+    if first == empty {
+        t.Error( "expected the digest to change after a write" )
+    }
+    if again := catalog(); again != first {
+        t.Errorf( "expected the digest to be stable without a write, got %s and %s", first, again )
+    }
+    save( "app/1", "changed" )
+    if overwritten := catalog(); overwritten == first {
+        t.Error( "expected the digest to change after the image is overwritten" )
+    }
+    doRequest( handler, "DELETE", "/image/delete/app:1", nil )
+    if deleted := catalog(); deleted != empty {
+        t.Errorf( "expected the digest of the empty catalog after the delete, got %s", deleted )
+    }
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strings"
+
+    "gopkg.in/mgo.v2"
+)
+
+// returned when the uploaded image doesn't match the checksum sent by the client
+var ErrChecksumMismatch = errors.New( "checksum mismatch" )
+
+// the header of the upload with the expected sha256 of the image, in
+// "<hex>" or "sha256:<hex>" format
+const checksumHeader = "X-Checksum-Sha256"
+
+// the checksum in the ".<version>.sha256" sidecar file
+func (fis *FileImageStorage) Checksum( name string ) (string, bool, error) {
+    if _, err := fis.imageFile( name ); err != nil {
+        return "", false, err
+    }
+    b, err := ioutil.ReadFile( fis.sidecarFile( name, "sha256" ) )
+    if os.IsNotExist( err ) {
+        return "", false, nil
+    }
+    if err != nil {
+        return "", false, err
+    }
+    return strings.TrimSpace( string( b ) ), true, nil
+}
+
+// the checksum of the inline image or in the metadata of the GridFS file
+func (mis *MongoImageStorage) Checksum( name string ) (string, bool, error) {
+    name = normalizeImageName( name )
+    session, fs, err := mis.createGridFS()
+    if err != nil {
+        return "", false, err
+    }
+    defer session.Close()
+
+    if image, err := mis.getInline( session, name ); err == nil {
+        return image.Sha256, image.Sha256 != "", nil
+    } else if err != mgo.ErrNotFound {
+        return "", false, err
+    }
+    file, err := mis.openGridFile( session, fs, name )
+    if err != nil {
+        return "", false, err
+    }
+    defer file.Close()
+    var meta struct{ Sha256 string `bson:"sha256"` }
+    if err = file.GetMeta( &meta ); err != nil {
+        return "", false, err
+    }
+    return meta.Sha256, meta.Sha256 != "", nil
+}
+
+// get the expected "sha256:<hex>" sent with the upload, empty if none
+func expectedChecksum( req *http.Request ) string {
+    checksum := strings.ToLower( strings.TrimSpace( req.Header.Get( checksumHeader ) ) )
+    if checksum != "" && !strings.HasPrefix( checksum, "sha256:" ) {
+        checksum = "sha256:" + checksum
+    }
+    return checksum
+}
+
+// get the recorded checksum of image name, from the storage if it keeps
+// them or from the digest index otherwise
+func (iw *ImageWeb) recordedChecksum( name string ) (string, bool, error) {
+    if checksum_storage, ok := iw.image_storage.(ChecksumStorage); ok {
+        checksum, ok, err := checksum_storage.Checksum( name )
+        if err != nil || ok {
+            return checksum, ok, err
+        }
+    }
+    checksum, ok := iw.digests.Digest( normalizeImageName( name ) )
+    return checksum, ok, nil
+}
+
+func (iw *ImageWeb) initVerify() {
+    http.HandleFunc("/image/verify/", func(rw http.ResponseWriter, req *http.Request) {
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/verify/" ) )
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        expected, ok, err := iw.recordedChecksum( name )
+        if err == nil && !ok {
+            http.Error( rw, "no checksum is recorded for image " + name, http.StatusNotFound )
+            return
+        }
+        var actual string
+        if err == nil {
+            actual, err = iw.computeDigest( name )
+        }
+        switch {
+        case err == nil && actual == expected:
+            rw.Write( []byte( "ok" ) )
+        case err == nil:
+            http.Error( rw, "checksum mismatch, expected " + expected + " but got " + actual, http.StatusConflict )
+        case isNotFound( err ):
+            http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+        case errors.Is( err, ErrBusy ):
+            http.Error( rw, err.Error(), http.StatusServiceUnavailable )
+        case errors.Is( err, ErrInvalidName ):
+            http.Error( rw, err.Error(), http.StatusBadRequest )
+        default:
+            http.Error( rw, "fail to verify image " + name + ": " + err.Error(), http.StatusInternalServerError )
+        }
+    })
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestChecksumVerify( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    _, handler := newTestWeb( t, storage )
+    content := []byte( "image content" )
+    digest := sha256Digest( content )
+
+    //the expected digest can be sent without the "sha256:" prefix
+    rw := doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( content ), checksumHeader, strings.TrimPrefix( digest, "sha256:" ) )
+    if rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    if checksum, ok, err := storage.Checksum( "app:1" ); err != nil || !ok || checksum != digest {
+        t.Errorf( "expected the checksum %s to be recorded, got %s %v: %v", digest, checksum, ok, err )
+    }
+    if rw = doRequest( handler, "GET", "/image/verify/app:1", nil ); rw.Code != http.StatusOK || rw.Body.String() != "ok" {
+        t.Errorf( "expected 200 ok, got %d: %s", rw.Code, rw.Body.String() )
+    }
+
+    image_file, err := storage.imageFile( "app:1" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if err = ioutil.WriteFile( image_file, []byte( "tampered content" ), 0644 ); err != nil {
+        t.Fatal( err )
+    }
+    if rw = doRequest( handler, "GET", "/image/verify/app:1", nil ); rw.Code != http.StatusConflict {
+        t.Errorf( "expected 409 for the tampered image, got %d: %s", rw.Code, rw.Body.String() )
+    }
+
+    rw = doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( content ), checksumHeader, sha256Digest( []byte( "other" ) ) )
+    if rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 for the wrong checksum, got %d", rw.Code )
+    }
+    if exists, err := storage.Exists( "app:2" ); err != nil || exists {
+        t.Errorf( "expected the mismatched upload to be rejected: %v", err )
+    }
+    if rw = doRequest( handler, "GET", "/image/verify/app:3", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
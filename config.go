@@ -0,0 +1,83 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// the words in the setting names whose values are never shown
+var secretSettingWords = []string{ "password", "secret", "token", "credential", "apikey", "api-key", "private-key", "auth-pass" }
+
+// a setting of the running instance
+type ConfigSetting struct {
+    Value string `json:"value"`
+
+    //"flag" if it is given on the command line, "default" otherwise
+    Source string `json:"source"`
+}
+
+func isSecretSetting( name string ) bool {
+    name = strings.ToLower( name )
+    for _, word := range secretSettingWords {
+        if strings.Contains( name, word ) {
+            return true
+        }
+    }
+    return false
+}
+
+// hide the value of the secret setting and the password in the URLs
+func redactSetting( name string, value string ) string {
+    if value == "" {
+        return value
+    }
+    if isSecretSetting( name ) {
+        return "<redacted>"
+    }
+    if u, err := url.Parse( value ); err == nil && u.User != nil {
+        if _, ok := u.User.Password(); ok {
+            u.User = url.UserPassword( u.User.Username(), "redacted" )
+            return u.String()
+        }
+    }
+    return value
+}
+
+// get the effective value of every flag of fs with the secrets redacted
+func EffectiveConfig( fs *flag.FlagSet ) map[string]ConfigSetting {
+    given := make( map[string]bool )
+    fs.Visit( func( f *flag.Flag ) {
+        given[f.Name] = true
+    } )
+    config := make( map[string]ConfigSetting )
+    fs.VisitAll( func( f *flag.Flag ) {
+        source := "default"
+        if given[f.Name] {
+            source = "flag"
+        }
+        config[f.Name] = ConfigSetting{ Value: redactSetting( f.Name, f.Value.String() ), Source: source }
+    } )
+    return config
+}
+
+// set the configuration shown by /admin/config
+func (iw *ImageWeb) SetEffectiveConfig( config map[string]ConfigSetting ) {
+    iw.config = config
+}
+
+func (iw *ImageWeb) initConfig() {
+    http.HandleFunc("/admin/config", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        config := iw.config
+        if config == nil {
+            config = make( map[string]ConfigSetting )
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( config )
+    })
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+)
+
+// compare the cached names with the actual names
+func diffNames( cached []string, actual []string ) ([]string, []string) {
+    cached_set := make( map[string]bool )
+    for _, name := range cached {
+        cached_set[name] = true
+    }
+    actual_set := make( map[string]bool )
+    for _, name := range actual {
+        actual_set[name] = true
+    }
+
+    missing := make( []string, 0 )
+    for _, name := range cached {
+        if !actual_set[name] {
+            missing = append( missing, name )
+        }
+    }
+    extra := make( []string, 0 )
+    for _, name := range actual {
+        if !cached_set[name] {
+            extra = append( extra, name )
+        }
+    }
+    return missing, extra
+}
+
+// update the cached names with the result of CheckConsistency
+func repairNames( images *ImageNameList, missing []string, extra []string ) {
+    for _, name := range missing {
+        images.Remove( name )
+    }
+    for _, name := range extra {
+        images.Add( name )
+    }
+}
+
+// compare the cached names with the image files on disk
+func (fis *FileImageStorage) CheckConsistency() ([]string, []string, error) {
+    actual := NewImageNameList()
+    if err := fis.scanImageNames( actual ); err != nil {
+        return nil, nil, err
+    }
+    missing, extra := diffNames( fis.images.Names(), actual.Names() )
+    return missing, extra, nil
+}
+
+func (fis *FileImageStorage) RepairConsistency() error {
+    missing, extra, err := fis.CheckConsistency()
+    if err == nil {
+        repairNames( fis.images, missing, extra )
+    }
+    return err
+}
+
+// compare the cached names with the files in GridFS
+func (mis *MongoImageStorage) CheckConsistency() ([]string, []string, error) {
+    actual := NewImageNameList()
+    if err := mis.scanImageNames( actual ); err != nil {
+        return nil, nil, err
+    }
+    missing, extra := diffNames( mis.images.Names(), actual.Names() )
+    return missing, extra, nil
+}
+
+func (mis *MongoImageStorage) RepairConsistency() error {
+    missing, extra, err := mis.CheckConsistency()
+    if err == nil {
+        repairNames( mis.images, missing, extra )
+    }
+    return err
+}
+
+// the docker storage caches no names but the tags expected to exist,
+// the expected tags dropped by the daemon are reported as missing
+func (dis *DockerImageStorage) CheckConsistency() ([]string, []string, error) {
+    actual, err := dis.List()
+    if err != nil {
+        return nil, nil, err
+    }
+    dis.tagsMutex.Lock()
+    expected := make( []string, 0, len( dis.expectedTags ) )
+    for name := range dis.expectedTags {
+        expected = append( expected, name )
+    }
+    dis.tagsMutex.Unlock()
+
+    missing, _ := diffNames( expected, actual )
+    return missing, make( []string, 0 ), nil
+}
+
+func (dis *DockerImageStorage) RepairConsistency() error {
+    dis.ReconcileTags()
+    return nil
+}
+
+type consistencyReport struct {
+    Missing []string `json:"missing"`
+    Extra []string `json:"extra"`
+    Repaired bool `json:"repaired"`
+}
+
+func (iw *ImageWeb) initConsistency() {
+    http.HandleFunc("/admin/consistency", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        checker, ok := iw.image_storage.(ConsistencyChecker)
+        if !ok {
+            http.Error( rw, "consistency check is not supported by the storage", http.StatusNotImplemented )
+            return
+        }
+        missing, extra, err := checker.CheckConsistency()
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        report := consistencyReport{ Missing: missing, Extra: extra }
+        if req.URL.Query().Get( "repair" ) == "true" && ( len( missing ) > 0 || len( extra ) > 0 ) {
+            if req.Method != "POST" {
+                http.Error( rw, "repair must be requested with POST", http.StatusMethodNotAllowed )
+                return
+            }
+            if err = checker.RepairConsistency(); err != nil {
+                http.Error( rw, err.Error(), http.StatusInternalServerError )
+                return
+            }
+            report.Repaired = true
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( report )
+    })
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestFileConsistencyDrift( t *testing.T ) {
+    storage := newFileStorage( t )
+    for _, name := range []string{ "app:1", "app:2" } {
+        if err := storage.Write( name, bytes.NewReader( []byte( name ) ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    _, handler := newTestWeb( t, storage )
+
+    //app:1 is removed and team/app:3 is added behind the storage
+    image_file, _ := storage.imageFile( "app:1" )
+    os.Remove( image_file )
+    os.MkdirAll( filepath.Join( storage.Dir, "team", "app" ), 0755 )
+    ioutil.WriteFile( filepath.Join( storage.Dir, "team", "app", "3" ), []byte( "drift" ), 0644 )
+
+    check := func( method string, url string ) consistencyReport {
+        rw := doRequest( handler, method, url, nil )
+        report := consistencyReport{}
+        if err := json.Unmarshal( rw.Body.Bytes(), &report ); err != nil {
+            t.Fatalf( "invalid consistency report %d %q", rw.Code, rw.Body.String() )
+        }
+        return report
+    }
+    report := check( "GET", "/admin/consistency" )
+    if len( report.Missing ) != 1 || report.Missing[0] != "app:1" || len( report.Extra ) != 1 || report.Extra[0] != "team/app:3" || report.Repaired {
+        t.Errorf( "expected app:1 missing and team/app:3 extra, got %+v", report )
+    }
+    if rw := doRequest( handler, "GET", "/admin/consistency?repair=true", nil ); rw.Code != http.StatusMethodNotAllowed {
+        t.Errorf( "expected 405 for the repair with GET, got %d", rw.Code )
+    }
+    if report = check( "POST", "/admin/consistency?repair=true" ); !report.Repaired {
+        t.Errorf( "expected the drift to be repaired, got %+v", report )
+    }
+    if report = check( "GET", "/admin/consistency" ); len( report.Missing ) != 0 || len( report.Extra ) != 0 {
+        t.Errorf( "expected no drift after the repair, got %+v", report )
+    }
+    if names, _ := storage.List(); len( names ) != 2 {
+        t.Errorf( "expected app:2 and team/app:3 to be listed, got %v", names )
+    }
+}
+
+func TestDockerConsistencyDroppedTag( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    if err := storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "drift", "app:1" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    fd.dropTag( "app:1" )
+    missing, _, err := storage.CheckConsistency()
+    if err != nil || len( missing ) != 1 || missing[0] != "app:1" {
+        t.Fatalf( "expected the dropped tag to be missing, got %v: %v", missing, err )
+    }
+    if err = storage.RepairConsistency(); err != nil {
+        t.Fatal( err )
+    }
+    if missing, _, _ = storage.CheckConsistency(); len( missing ) != 0 {
+        t.Errorf( "expected the dropped tag to be re-applied, got %v missing", missing )
+    }
+}
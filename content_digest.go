@@ -0,0 +1,59 @@
+package main
+
+import (
+    "log"
+)
+
+// optional interface implemented by the storage which doesn't give back
+// the uploaded bytes, e.g. the docker daemon exports an image in its own
+// layout. Such image is indexed and verified by a digest which stays the
+// same as long as the image content is the same
+type ContentDigester interface {
+    // get the stable digest of image name, false if the digest of the
+    // image is the sha256 of the bytes Get writes
+    ContentDigest(name string) (string, bool, error)
+}
+
+// get the stable digest of image name if image_storage has one
+func contentDigest( image_storage ImageStorage, name string ) (string, bool, error) {
+    if digester, ok := image_storage.(ContentDigester); ok {
+        return digester.ContentDigest( name )
+    }
+    return "", false, nil
+}
+
+// the image ID is the digest of the image config, which doesn't
+// change when the image is exported again
+func (dis *DockerImageStorage) ContentDigest( name string ) (string, bool, error) {
+    image, err := dis.client.InspectImage( name )
+    if err != nil {
+        return "", true, err
+    }
+    return image.ID, true, nil
+}
+
+// every daemon having the image has the same image ID
+func (mdis *MultiDockerImageStorage) ContentDigest( name string ) (string, bool, error) {
+    daemons := mdis.daemonsHaving( name )
+    if len( daemons ) == 0 {
+        return "", true, ErrNotFound
+    }
+    return daemons[0].storage.ContentDigest( name )
+}
+
+// index the image name written with the content of digest. The image of
+// the storage with a stable digest is indexed with that digest instead
+func (iw *ImageWeb) indexDigest( storage ImageStorage, name string, digest string ) {
+    name = normalizeImageName( name )
+    stable, ok, err := contentDigest( storage, name )
+    if err != nil {
+        log.Printf( "fail to get the digest of image %s: %v", name, err )
+        iw.digests.Remove( name )
+        return
+    }
+    if ok {
+        digest = stable
+    }
+    iw.digests.Add( digest, name )
+    iw.indexRegistryImage( name )
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "testing"
+)
+
+func TestDockerUploadIndexesImageID( t *testing.T ) {
+    _, storage := newFakeDocker( t )
+    iw, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "stable", "app:1" )
+    id := archiveImageID( t, archive )
+
+    //the expected checksum is still the one of the uploaded bytes
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), checksumHeader, sha256Digest( archive ) )
+    if body := responseBody( t, rw ); body != "save image successfully" {
+        t.Fatalf( "fail to save the image: %s", body )
+    }
+    if digest, _ := iw.digests.Digest( "app:1" ); digest != id {
+        t.Errorf( "expected the image ID %s to be indexed, got %s", id, digest )
+    }
+    if rw = doRequest( handler, "GET", "/image/get/app:1?verify=true", nil ); rw.Code != http.StatusNotImplemented {
+        t.Errorf( "expected 501 for verify on docker, got %d", rw.Code )
+    }
+    if rw = doRequest( handler, "GET", "/image/verify/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the image to be verified, got %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    if rw = doRequest( handler, "GET", "/image/get/" + id[:19], nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the image by its ID, got %d", rw.Code )
+    }
+
+    //the index is seeded with the image IDs after a restart
+    iw, _ = newTestWeb( t, storage )
+    if n, err := iw.loadDigests(); err != nil || n != 1 {
+        t.Fatalf( "expected 1 image to be indexed, got %d: %v", n, err )
+    }
+    if digest, _ := iw.digests.Digest( "app:1" ); digest != id {
+        t.Errorf( "expected the image ID %s to be indexed, got %s", id, digest )
+    }
+}
+
+func TestGetVerified( t *testing.T ) {
+    storage := newFileStorage( t )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "verified", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); responseBody( t, rw ) != "save image successfully" {
+        t.Fatal( "fail to save the image" )
+    }
+    rw := doRequest( handler, "GET", "/image/get/app:1?verify=true", nil )
+    if rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+        t.Fatalf( "expected the verified image, got %d", rw.Code )
+    }
+
+    //the corrupted image aborts the response
+    corrupted := append( []byte{ 0 }, archive[1:]... )
+    if err := ioutil.WriteFile( fmt.Sprintf( "%s/app/1", storage.Dir ), corrupted, 0644 ); err != nil {
+        t.Fatal( err )
+    }
+    defer func() {
+        if r := recover(); r != http.ErrAbortHandler {
+            t.Errorf( "expected the response to be aborted, got %v", r )
+        }
+    }()
+    doRequest( handler, "GET", "/image/get/app:1?verify=true", nil )
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "strconv"
+    "testing"
+)
+
+func TestGetContentLength( t *testing.T ) {
+    archive := makeImageArchive( t, "app", "app:1" )
+    for _, compress := range []bool{ false, true } {
+        storage := newFileStorage( t )
+        storage.Compress = compress
+        storage.Write( "app:1", bytes.NewReader( archive ) )
+        _, handler := newTestWeb( t, storage )
+        rw := doRequest( handler, "GET", "/image/get/app:1", nil )
+        if rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+            t.Fatalf( "expected the image, got %d", rw.Code )
+        }
+        //the length of the compressed image is not known before it is decoded
+        expected := strconv.Itoa( len( archive ) )
+        if compress {
+            expected = ""
+        }
+        if length := rw.Header().Get( "Content-Length" ); length != expected {
+            t.Errorf( "compress %v: expected the Content-Length %q, got %q", compress, expected, length )
+        }
+        if size, known, err := storage.Size( "app:1" ); err != nil || known == compress || ( known && size != int64( len( archive ) ) ) {
+            t.Errorf( "compress %v: unexpected size %d, known %v: %v", compress, size, known, err )
+        }
+    }
+}
+
+// the response is chunked if the storage can't tell the size
+func TestGetWithoutSize( t *testing.T ) {
+    storage := &countingStorage{ ImageStorage: newFileStorage( t ) }
+    storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) )
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "GET", "/image/get/app:1", nil )
+    if rw.Code != http.StatusOK || rw.Header().Get( "Content-Length" ) != "" {
+        t.Errorf( "expected no Content-Length, got %d %q", rw.Code, rw.Header().Get( "Content-Length" ) )
+    }
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+)
+
+// the space saved by storing the identical images once
+type DedupStats struct {
+    //the number of distinct blobs and the image files linked to them
+    Blobs int `json:"blobs"`
+    References int `json:"references"`
+
+    StoredBytes int64 `json:"stored_bytes"`
+    SavedBytes int64 `json:"saved_bytes"`
+}
+
+// optional interface implemented by the storage which deduplicates
+// the identical images
+type DedupStorage interface {
+    DedupStats() (DedupStats, error)
+}
+
+// the blobs are kept in "<Dir>/.blobs/sha256/<hex>", the hidden directory
+// is skipped when the image names are loaded
+func (fis *FileImageStorage) blobDir() string {
+    return filepath.Join( fis.Dir, ".blobs", "sha256" )
+}
+
+// create a temporary file on the same filesystem as the blobs, so it
+// can be renamed to its blob once its digest is known
+func (fis *FileImageStorage) createBlobTemp() (*os.File, error) {
+    tmp_dir := filepath.Join( fis.Dir, ".blobs", "tmp" )
+    if err := fis.mkdirAll( tmp_dir ); err != nil {
+        return nil, err
+    }
+    f, err := ioutil.TempFile( tmp_dir, "blob" )
+    if err != nil {
+        return nil, err
+    }
+    //the blob keeps the mode of the temporary file
+    if err = f.Chmod( fis.fileMode() ); err != nil {
+        f.Close()
+        os.Remove( f.Name() )
+        return nil, err
+    }
+    return f, nil
+}
+
+// the number of hard links of the file
+func linkCount( fi os.FileInfo ) uint64 {
+    if stat, ok := fi.Sys().(*syscall.Stat_t); ok {
+        return uint64( stat.Nlink )
+    }
+    return 1
+}
+
+// move the written temporary file to the blob of digest unless the blob
+// already exists, and replace the image file with a link to the blob.
+// The blob previously linked by the image is removed if it is unused
+func (fis *FileImageStorage) linkBlob( name string, tmp_file string, digest string, image_file string ) error {
+    fis.blobMutex.Lock()
+    defer fis.blobMutex.Unlock()
+
+    blob_file := filepath.Join( fis.blobDir(), digest )
+    if _, err := os.Stat( blob_file ); err == nil {
+        os.Remove( tmp_file )
+    } else if err = fis.mkdirAll( fis.blobDir() ); err != nil {
+        return err
+    } else if err = os.Rename( tmp_file, blob_file ); err != nil {
+        return err
+    }
+    if err := fis.mkdirAll( filepath.Dir( image_file ) ); err != nil {
+        return err
+    }
+    //the link is renamed over the image file, so the readers get
+    //either the previous image or the new one
+    link_file := fis.sidecarFile( name, "link" )
+    os.Remove( link_file )
+    if err := os.Link( blob_file, link_file ); err != nil {
+        return err
+    }
+    previous, _ := ioutil.ReadFile( fis.sidecarFile( name, "blob" ) )
+    if err := os.Rename( link_file, image_file ); err != nil {
+        os.Remove( link_file )
+        return err
+    }
+    if err := fis.writeSmallFile( fis.sidecarFile( name, "blob" ), []byte( digest ) ); err != nil {
+        return err
+    }
+    if len( previous ) > 0 && string( previous ) != digest {
+        fis.removeUnusedBlob( string( previous ) )
+    }
+    return nil
+}
+
+// remove the image file linked to a blob, so it can be written in place
+// without touching the other images. false is returned if the image
+// file is not linked to a blob and is left as it is
+func (fis *FileImageStorage) unlinkBlob( name string, image_file string ) (bool, error) {
+    blob_file := fis.sidecarFile( name, "blob" )
+    digest, err := ioutil.ReadFile( blob_file )
+    if os.IsNotExist( err ) {
+        return false, nil
+    }
+    if err != nil {
+        return false, err
+    }
+    fis.blobMutex.Lock()
+    defer fis.blobMutex.Unlock()
+    if err = os.Remove( image_file ); err != nil && !os.IsNotExist( err ) {
+        return false, err
+    }
+    os.Remove( blob_file )
+    fis.removeUnusedBlob( string( digest ) )
+    return true, nil
+}
+
+// remove the blob which is not linked by any image file, the caller
+// must hold blobMutex
+func (fis *FileImageStorage) removeUnusedBlob( digest string ) {
+    blob_file := filepath.Join( fis.blobDir(), digest )
+    if fi, err := os.Stat( blob_file ); err == nil && linkCount( fi ) <= 1 {
+        os.Remove( blob_file )
+    }
+}
+
+// the number of images sharing the blob of image name
+func (fis *FileImageStorage) RefCount( name string ) (int, error) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return 0, err
+    }
+    fi, err := os.Stat( image_file )
+    if err != nil {
+        return 0, err
+    }
+    if _, err = os.Stat( fis.sidecarFile( name, "blob" ) ); err != nil {
+        return 1, nil
+    }
+    //one of the links is the blob itself
+    return int( linkCount( fi ) ) - 1, nil
+}
+
+func (fis *FileImageStorage) DedupStats() (DedupStats, error) {
+    stats := DedupStats{}
+    blobs, err := ioutil.ReadDir( fis.blobDir() )
+    if os.IsNotExist( err ) {
+        return stats, nil
+    }
+    if err != nil {
+        return stats, err
+    }
+    for _, blob := range blobs {
+        refs := int64( linkCount( blob ) ) - 1
+        if refs < 1 || strings.HasPrefix( blob.Name(), "." ) {
+            continue
+        }
+        stats.Blobs++
+        stats.References += int( refs )
+        stats.StoredBytes += blob.Size()
+        stats.SavedBytes += blob.Size() * ( refs - 1 )
+    }
+    return stats, nil
+}
+
+func (iw *ImageWeb) initDedup() {
+    http.HandleFunc("/admin/dedup", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        dedup_storage, ok := iw.image_storage.(DedupStorage)
+        if !ok {
+            http.Error( rw, "the storage doesn't deduplicate the images", http.StatusNotImplemented )
+            return
+        }
+        stats, err := dedup_storage.DedupStats()
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( stats )
+    })
+}
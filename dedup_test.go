@@ -0,0 +1,75 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "testing"
+)
+
+func TestDedupStoresIdenticalImagesOnce( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    storage.Dedup = true
+    _, handler := newTestWeb( t, storage )
+    content := bytes.Repeat( []byte( "layer" ), 100 )
+    for _, name := range []string{ "app:1", "app:2" } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( content ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+    blobs := func() int {
+        entries, _ := ioutil.ReadDir( storage.blobDir() )
+        return len( entries )
+    }
+    if n := blobs(); n != 1 {
+        t.Errorf( "expected one physical blob, got %d", n )
+    }
+    first, _ := storage.imageFile( "app:1" )
+    second, _ := storage.imageFile( "app:2" )
+    first_info, err1 := os.Stat( first )
+    second_info, err2 := os.Stat( second )
+    if err1 != nil || err2 != nil || !os.SameFile( first_info, second_info ) {
+        t.Errorf( "expected both tags to reference the same file: %v %v", err1, err2 )
+    }
+
+    rw := doRequest( handler, "GET", "/admin/dedup", nil )
+    stats := DedupStats{}
+    if err = json.Unmarshal( rw.Body.Bytes(), &stats ); err != nil {
+        t.Fatalf( "invalid stats %q: %v", rw.Body.String(), err )
+    }
+    if stats != ( DedupStats{ Blobs: 1, References: 2, StoredBytes: int64( len( content ) ), SavedBytes: int64( len( content ) ) } ) {
+        t.Errorf( "unexpected stats %+v", stats )
+    }
+
+    //rewriting one tag leaves the other one as it was
+    if err = storage.Write( "app:1", bytes.NewReader( []byte( "other" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    var b bytes.Buffer
+    if err = storage.Get( "app:2", &b ); err != nil || !bytes.Equal( b.Bytes(), content ) {
+        t.Errorf( "expected app:2 to be kept, got %d bytes: %v", b.Len(), err )
+    }
+    if n := blobs(); n != 2 {
+        t.Errorf( "expected 2 blobs after the rewrite, got %d", n )
+    }
+    for _, name := range []string{ "app:1", "app:2" } {
+        if err = storage.Delete( name ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    if n := blobs(); n != 0 {
+        t.Errorf( "expected the unused blobs to be removed, got %d", n )
+    }
+}
+
+func TestDedupStatsNotSupported( t *testing.T ) {
+    _, handler := newTestWeb( t, struct{ ImageStorage }{ newFileStorage( t ) } )
+    if rw := doRequest( handler, "GET", "/admin/dedup", nil ); rw.Code != http.StatusNotImplemented {
+        t.Errorf( "expected 501, got %d", rw.Code )
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+)
+
+// what a delete of the image would do
+type deleteReport struct {
+    Name string `json:"name"`
+
+    //the digest of the image content if it is known
+    Digest string `json:"digest,omitempty"`
+
+    //the other images with the same content, they are not affected
+    SharedWith []string `json:"shared_with"`
+
+    //if the space of the image content would be freed
+    BlobFreed bool `json:"blob_freed"`
+}
+
+// the result of deleting an image
+type deleteResult struct {
+    Name string `json:"name"`
+    Deleted bool `json:"deleted"`
+    Error string `json:"error,omitempty"`
+}
+
+func writeDeleteResult( rw http.ResponseWriter, status int, result deleteResult ) {
+    rw.Header().Set( "Content-Type", "application/json" )
+    rw.WriteHeader( status )
+    json.NewEncoder( rw ).Encode( result )
+}
+
+// get the image name from the path "<name>[:<tag>]" or "<name>/<tag>"
+// after prefix, the tag is "latest" if it is not given. The name may have
+// several levels, so only a colon after the final slash starts the tag
+func pathImageName( path string, prefix string ) string {
+    path = strings.Trim( strings.TrimPrefix( path, prefix ), "/" )
+    image_name, _ := parseImageName( path )
+    if image_name == path {
+        if pos := strings.LastIndex( path, "/" ); pos != -1 {
+            return path[0:pos] + ":" + path[pos+1:]
+        }
+    }
+    return normalizeImageName( path )
+}
+
+// report what deleting the image name would remove without deleting it
+func (iw *ImageWeb) dryRunDelete( name string ) deleteReport {
+    image_name, image_version := parseImageName( name )
+    name = image_name + ":" + image_version
+    report := deleteReport{ Name: name, SharedWith: make( []string, 0 ), BlobFreed: true }
+    if digest, ok := iw.digests.Digest( name ); ok {
+        report.Digest = digest
+        for _, entry := range iw.digests.Match( digest ) {
+            if entry.Name != name {
+                report.SharedWith = append( report.SharedWith, entry.Name )
+            }
+        }
+    }
+    //the storage sharing the content keeps it for the other images
+    if counter, ok := iw.image_storage.(RefCounter); ok {
+        if refs, err := counter.RefCount( name ); err == nil {
+            report.BlobFreed = refs <= 1
+        }
+    }
+    return report
+}
+
+func (iw *ImageWeb) initDelete() {
+    http.HandleFunc("/image/delete/", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "DELETE" && req.Method != "POST" {
+            rw.Header().Set( "Allow", "DELETE, POST" )
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        //the name is parsed as /image/get/ does, "team/app" is "team/app:latest"
+        name, ok := iw.resolveName( rw, iw.nameTransform.Apply( normalizeImageName( strings.TrimPrefix( req.URL.Path, "/image/delete/" ) ) ) )
+        if !ok || !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, true ) {
+            return
+        }
+        if iw.immutable.IsImmutable( name ) {
+            http.Error( rw, "image " + name + " is immutable", http.StatusForbidden )
+            return
+        }
+        if iw.protected.IsProtected( name ) {
+            http.Error( rw, "image " + name + " is protected", http.StatusForbidden )
+            return
+        }
+        if req.URL.Query().Get( "dry_run" ) == "true" {
+            rw.Header().Set( "Content-Type", "application/json" )
+            json.NewEncoder( rw ).Encode( iw.dryRunDelete( name ) )
+            return
+        }
+        err := iw.storage( req ).Delete( name )
+        iw.listCache.Invalidate()
+        if err == nil {
+            iw.digests.Remove( normalizeImageName( name ) )
+            iw.ociCache.Remove( normalizeImageName( name ) )
+            iw.registry.Remove( normalizeImageName( name ) )
+            writeDeleteResult( rw, http.StatusOK, deleteResult{ Name: normalizeImageName( name ), Deleted: true } )
+            return
+        }
+        status := http.StatusInternalServerError
+        if isNotFound( err ) {
+            status = http.StatusNotFound
+        } else if errors.Is( err, ErrBusy ) {
+            status = http.StatusServiceUnavailable
+        } else if errors.Is( err, ErrImageConflict ) {
+            status = http.StatusConflict
+        }
+        writeDeleteResult( rw, status, deleteResult{ Name: normalizeImageName( name ), Error: err.Error() } )
+    })
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestDeleteImage( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "team/app:latest", bytes.NewReader( []byte( "latest" ) ) )
+    storage.Write( "team/app:1", bytes.NewReader( []byte( "one" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    if rw := doRequest( handler, "GET", "/image/delete/team/app", nil ); rw.Code != http.StatusMethodNotAllowed {
+        t.Errorf( "expected 405 for GET, got %d", rw.Code )
+    }
+
+    //the path is parsed as /image/get/ does
+    rw := doRequest( handler, "DELETE", "/image/delete/team/app", nil )
+    var result deleteResult
+    if err := json.NewDecoder( rw.Body ).Decode( &result ); err != nil || rw.Code != http.StatusOK || result.Name != "team/app:latest" || !result.Deleted {
+        t.Fatalf( "expected team/app:latest to be deleted, got %d %+v", rw.Code, result )
+    }
+    if names, _ := storage.List(); len( names ) != 1 || names[0] != "team/app:1" {
+        t.Errorf( "expected only team/app:1 to be left, got %v", names )
+    }
+
+    rw = doRequest( handler, "POST", "/image/delete/team/app", nil )
+    result = deleteResult{}
+    if err := json.NewDecoder( rw.Body ).Decode( &result ); err != nil || rw.Code != http.StatusNotFound || result.Deleted || result.Error == "" {
+        t.Errorf( "expected 404 with the error, got %d %+v", rw.Code, result )
+    }
+}
+
+func TestDeleteDryRun( t *testing.T ) {
+    storage := newFileStorage( t )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "shared", "app:1" )
+    for _, name := range []string{ "app/1", "app/2" } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+    rw := doRequest( handler, "DELETE", "/image/delete/app:1?dry_run=true", nil )
+    var report deleteReport
+    if err := json.NewDecoder( rw.Body ).Decode( &report ); err != nil || len( report.SharedWith ) != 1 || report.SharedWith[0] != "app:2" {
+        t.Errorf( "expected app:1 to share the content with app:2, got %+v", report )
+    }
+    if names, _ := storage.List(); len( names ) != 2 {
+        t.Errorf( "the dry run deleted an image, got %v", names )
+    }
+}
+
+func TestDeleteDryRunSharedBlob( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    storage.Dedup = true
+    _, handler := newTestWeb( t, storage )
+    shared := makeImageArchive( t, "shared", "app:1" )
+    for name, archive := range map[string][]byte{ "app:1": shared, "app:2": shared, "app:3": makeImageArchive( t, "unique", "app:3" ) } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+    dry_run := func( name string ) deleteReport {
+        rw := doRequest( handler, "DELETE", "/image/delete/" + name + "?dry_run=true", nil )
+        var report deleteReport
+        if err := json.NewDecoder( rw.Body ).Decode( &report ); err != nil {
+            t.Fatalf( "invalid dry run report of %s: %v", name, err )
+        }
+        return report
+    }
+    if report := dry_run( "app:1" ); report.BlobFreed || len( report.SharedWith ) != 1 {
+        t.Errorf( "expected the shared blob to be kept for app:2, got %+v", report )
+    }
+    if report := dry_run( "app:3" ); !report.BlobFreed || len( report.SharedWith ) != 0 {
+        t.Errorf( "expected the unique blob to be freed, got %+v", report )
+    }
+    if names, _ := storage.List(); len( names ) != 3 {
+        t.Errorf( "the dry run deleted an image, got %v", names )
+    }
+}
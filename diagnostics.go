@@ -0,0 +1,167 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "syscall"
+
+    "gopkg.in/mgo.v2/bson"
+)
+
+// optional interface implemented by the storage which can report
+// the details of its backend for troubleshooting
+type DiagnosticsProvider interface {
+    // get the backend details, without any credential
+    Diagnostics() (map[string]interface{}, error)
+}
+
+// the names of the common filesystem magic numbers of statfs
+var filesystemTypes = map[int64]string{
+    0xEF53: "ext4",
+    0x58465342: "xfs",
+    0x9123683E: "btrfs",
+    0x01021994: "tmpfs",
+    0x794C7630: "overlay",
+    0x6969: "nfs",
+    0x2FC12FC1: "zfs",
+}
+
+// get the filesystem type and the space of the filesystem of dir
+func filesystemDiagnostics( dir string ) (map[string]interface{}, error) {
+    var stat syscall.Statfs_t
+    if err := syscall.Statfs( dir, &stat ); err != nil {
+        return nil, err
+    }
+    fs_type, ok := filesystemTypes[int64( stat.Type )]
+    if !ok {
+        fs_type = fmt.Sprintf( "%#x", stat.Type )
+    }
+    return map[string]interface{}{
+        "backend": "file",
+        "dir": dir,
+        "filesystem_type": fs_type,
+        "free_bytes": stat.Bavail * uint64( stat.Bsize ),
+        "total_bytes": stat.Blocks * uint64( stat.Bsize ) }, nil
+}
+
+func (fis *FileImageStorage) Diagnostics() (map[string]interface{}, error) {
+    result, err := filesystemDiagnostics( fis.Dir )
+    if err != nil {
+        return nil, err
+    }
+    result["images"] = len( fis.images.Names() )
+    return result, nil
+}
+
+func (lis *LayeredImageStorage) Diagnostics() (map[string]interface{}, error) {
+    result, err := filesystemDiagnostics( lis.Dir )
+    if err != nil {
+        return nil, err
+    }
+    result["backend"] = "layered"
+    return result, nil
+}
+
+// the daemon version and the storage driver of the docker daemon
+func (dis *DockerImageStorage) Diagnostics() (map[string]interface{}, error) {
+    version, err := dis.client.Version()
+    if err != nil {
+        return nil, err
+    }
+    info, err := dis.client.Info()
+    if err != nil {
+        return nil, err
+    }
+    return map[string]interface{}{
+        "backend": "docker",
+        "version": version.Get( "Version" ),
+        "api_version": version.Get( "ApiVersion" ),
+        "storage_driver": info.Driver,
+        "operating_system": info.OperatingSystem,
+        "images": info.Images,
+        "containers": info.Containers }, nil
+}
+
+// the diagnostics of every daemon by its endpoint, an unreachable daemon
+// reports its error instead
+func (mdis *MultiDockerImageStorage) Diagnostics() (map[string]interface{}, error) {
+    mdis.mutex.Lock()
+    daemons := append( []*dockerDaemon{}, mdis.daemons... )
+    mdis.mutex.Unlock()
+
+    result := make( map[string]interface{} )
+    for _, daemon := range daemons {
+        endpoint := redactSetting( "endpoint", daemon.endpoint )
+        diagnostics, err := daemon.storage.Diagnostics()
+        if err != nil {
+            result[endpoint] = map[string]interface{}{ "error": err.Error() }
+        } else {
+            result[endpoint] = diagnostics
+        }
+    }
+    return map[string]interface{}{ "backend": "multi-docker", "daemons": result }, nil
+}
+
+// the server status and the stats of the collections of the images. Only
+// the selected fields are reported so the server details are not leaked
+func (mis *MongoImageStorage) Diagnostics() (map[string]interface{}, error) {
+    session, err := mis.copySession()
+    if err != nil {
+        return nil, err
+    }
+    defer session.Close()
+
+    status := bson.M{}
+    if err = session.Run( bson.M{ "serverStatus": 1 }, &status ); err != nil {
+        return nil, err
+    }
+    result := map[string]interface{}{
+        "backend": "mongo",
+        "version": status["version"],
+        "uptime_seconds": status["uptime"] }
+    if connections, ok := status["connections"].(bson.M); ok {
+        result["connections"] = bson.M{ "current": connections["current"], "available": connections["available"] }
+    }
+
+    collections := make( map[string]interface{} )
+    for _, name := range []string{ mis.fsPrefix + ".files", mis.fsPrefix + ".chunks", mis.fsPrefix + ".inline" } {
+        stats := bson.M{}
+        if err = session.DB( mis.db ).Run( bson.M{ "collStats": name }, &stats ); err != nil {
+            collections[name] = bson.M{ "error": err.Error() }
+            continue
+        }
+        collections[name] = bson.M{ "count": stats["count"], "size": stats["size"], "storage_size": stats["storageSize"] }
+    }
+    result["collections"] = collections
+    return result, nil
+}
+
+// the local storage serves the images, so its diagnostics are reported
+func (mis *MirrorImageStorage) Diagnostics() (map[string]interface{}, error) {
+    if provider, ok := mis.ImageStorage.(DiagnosticsProvider); ok {
+        return provider.Diagnostics()
+    }
+    return map[string]interface{}{}, nil
+}
+
+func (iw *ImageWeb) initDiagnostics() {
+    http.HandleFunc("/admin/diagnostics", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        provider, ok := iw.image_storage.(DiagnosticsProvider)
+        if !ok {
+            http.Error( rw, "the storage doesn't support diagnostics", http.StatusNotImplemented )
+            return
+        }
+        diagnostics, err := provider.Diagnostics()
+        if err != nil {
+            http.Error( rw, "fail to get the diagnostics: " + err.Error(), http.StatusBadGateway )
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        rw.Header().Set( "Cache-Control", "no-store" )
+        json.NewEncoder( rw ).Encode( diagnostics )
+    })
+}
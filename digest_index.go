@@ -0,0 +1,100 @@
+package main
+
+import (
+    "sort"
+    "strings"
+    "sync"
+)
+
+type DigestEntry struct {
+    //the digest of the image content, e.g. "sha256:<hex>"
+    Digest string `json:"digest"`
+
+    //the image name in "name:version" format
+    Name string `json:"name"`
+}
+
+// index the images by the digest of their content, the entries
+// are kept sorted by digest so a digest prefix can be looked up
+// with a binary search
+type DigestIndex struct {
+    mutex sync.RWMutex
+
+    //all the entries sorted by digest and name
+    entries []DigestEntry
+
+    //the digest of each image name
+    digests map[string]string
+}
+
+func NewDigestIndex() *DigestIndex {
+    return &DigestIndex{ entries: make( []DigestEntry, 0 ),
+                digests: make( map[string]string ) }
+}
+
+// set the digest of the image name, the previous digest
+// of the name is replaced
+func (di *DigestIndex)Add( digest string, name string ) {
+    di.mutex.Lock()
+    defer di.mutex.Unlock()
+
+    di.remove( name )
+    entry := DigestEntry{ Digest: digest, Name: name }
+    i := di.search( entry )
+    di.entries = append( di.entries, DigestEntry{} )
+    copy( di.entries[i+1:], di.entries[i:] )
+    di.entries[i] = entry
+    di.digests[name] = digest
+}
+
+// remove the image name from the index
+func (di *DigestIndex)Remove( name string ) {
+    di.mutex.Lock()
+    defer di.mutex.Unlock()
+
+    di.remove( name )
+}
+
+// get the digest of the image name
+func (di *DigestIndex)Digest( name string ) (string, bool) {
+    di.mutex.RLock()
+    defer di.mutex.RUnlock()
+
+    digest, ok := di.digests[name]
+    return digest, ok
+}
+
+// find all the entries whose digest starts with prefix
+func (di *DigestIndex)Match( prefix string ) []DigestEntry {
+    di.mutex.RLock()
+    defer di.mutex.RUnlock()
+
+    result := make( []DigestEntry, 0 )
+    i := sort.Search( len( di.entries ), func( i int ) bool {
+        return di.entries[i].Digest >= prefix
+    })
+    for ; i < len( di.entries ) && strings.HasPrefix( di.entries[i].Digest, prefix ); i++ {
+        result = append( result, di.entries[i] )
+    }
+    return result
+}
+
+func (di *DigestIndex)remove( name string ) {
+    digest, ok := di.digests[name]
+    if !ok {
+        return
+    }
+    delete( di.digests, name )
+    i := di.search( DigestEntry{ Digest: digest, Name: name } )
+    if i < len( di.entries ) && di.entries[i].Digest == digest && di.entries[i].Name == name {
+        di.entries = append( di.entries[:i], di.entries[i+1:]... )
+    }
+}
+
+// find the position of entry in the sorted entries
+func (di *DigestIndex)search( entry DigestEntry ) int {
+    return sort.Search( len( di.entries ), func( i int ) bool {
+        e := di.entries[i]
+        return e.Digest > entry.Digest || ( e.Digest == entry.Digest && e.Name >= entry.Name )
+    })
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestDigestIndexMatch( t *testing.T ) {
+    di := NewDigestIndex()
+    di.Add( "sha256:abc1", "app:1" )
+    di.Add( "sha256:abc2", "app:2" )
+    di.Add( "sha256:def0", "app:3" )
+    di.Add( "sha256:abc1", "app:2" )
+
+    entries := di.Match( "sha256:abc" )
+    if len( entries ) != 2 || entries[0].Name != "app:1" || entries[1].Name != "app:2" {
+        t.Errorf( "expected app:1 and app:2, got %v", entries )
+    }
+    if entries = di.Match( "sha256:abc2" ); len( entries ) != 0 {
+        t.Errorf( "expected the old digest of app:2 to be removed, got %v", entries )
+    }
+    if entries = di.Match( "sha256:def" ); len( entries ) != 1 || entries[0].Name != "app:3" {
+        t.Errorf( "expected app:3, got %v", entries )
+    }
+    di.Remove( "app:3" )
+    if _, ok := di.Digest( "app:3" ); ok || len( di.Match( "sha256:def" ) ) != 0 {
+        t.Error( "app:3 is still indexed after it was removed" )
+    }
+}
+
+func TestGetByShortDigest( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    archives := [][]byte{ makeImageArchive( t, "first", "app:1" ), makeImageArchive( t, "second", "app:2" ) }
+    for i, name := range []string{ "app/1", "app/2" } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( archives[i] ) ); responseBody( t, rw ) != "save image successfully" {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+
+    sum := sha256.Sum256( archives[1] )
+    rw := doRequest( handler, "GET", "/image/get/sha256:" + hex.EncodeToString( sum[:] )[:12], nil )
+    if rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archives[1] ) {
+        t.Errorf( "expected app:2 for its unique digest prefix, got %d", rw.Code )
+    }
+    rw = doRequest( handler, "GET", "/image/get/sha256:", nil )
+    if rw.Code != http.StatusConflict {
+        t.Fatalf( "expected 409 for the ambiguous digest prefix, got %d", rw.Code )
+    }
+    var matches []DigestEntry
+    if err := json.Unmarshal( rw.Body.Bytes(), &matches ); err != nil || len( matches ) != 2 {
+        t.Errorf( "expected the two matching images, got %s", rw.Body.String() )
+    }
+    if rw = doRequest( handler, "GET", "/image/get/sha256:ffffffffffff", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the digest matching no image, got %d", rw.Code )
+    }
+}
+
+func TestExistsAndDeleteByDigest( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    archive := makeImageArchive( t, "by-digest", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "fail to save the image: %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    sum := sha256.Sum256( archive )
+    short_digest := "sha256:" + hex.EncodeToString( sum[:] )[:12]
+
+    if rw := doRequest( handler, "HEAD", "/image/exists/" + short_digest, nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the image to exist by digest, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/" + short_digest, nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected the image to be deleted by digest, got %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    if rw := doRequest( handler, "HEAD", "/image/exists/app:1", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected app:1 to be deleted, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "HEAD", "/image/exists/" + short_digest, nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected no image to match the digest, got %d", rw.Code )
+    }
+}
+
+func TestLoadDigestsAfterRestart( t *testing.T ) {
+    dir := t.TempDir()
+    storage, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    archive := makeImageArchive( t, "restart", "app:1" )
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); responseBody( t, rw ) != "save image successfully" {
+        t.Fatal( "fail to save the image" )
+    }
+
+    //a new server on the same directory
+    if storage, err = NewFileImageStorage( dir, 0, 0 ); err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    if n, err := iw.loadDigests(); err != nil || n != 1 {
+        t.Fatalf( "expected 1 image to be indexed, got %d: %v", n, err )
+    }
+    rw := doRequest( handler, "GET", "/image/get/" + sha256Digest( archive ), nil )
+    if rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+        t.Errorf( "expected the image by its digest, got %d", rw.Code )
+    }
+}
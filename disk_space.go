@@ -0,0 +1,73 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "syscall"
+)
+
+// returned when the free space of the storage is below its threshold
+var ErrInsufficientStorage = errors.New( "insufficient storage" )
+
+// get the free and total bytes of the filesystem of dir
+type FreeSpaceFunc func( dir string ) (free uint64, total uint64, err error)
+
+func statfsFreeSpace( dir string ) (uint64, uint64, error) {
+    var stat syscall.Statfs_t
+    if err := syscall.Statfs( dir, &stat ); err != nil {
+        return 0, 0, err
+    }
+    return stat.Bavail * uint64( stat.Bsize ), stat.Blocks * uint64( stat.Bsize ), nil
+}
+
+// the minimum free space which must be left on the filesystem, in bytes
+// and in percent of its size. A threshold of 0 is not checked
+type FreeSpaceThreshold struct {
+    MinFreeBytes uint64
+    MinFreePercent float64
+}
+
+// check the free space of the filesystem of dir against the threshold
+func (fst FreeSpaceThreshold) Check( dir string, freeSpace FreeSpaceFunc ) error {
+    if fst.MinFreeBytes == 0 && fst.MinFreePercent <= 0 {
+        return nil
+    }
+    free, total, err := freeSpace( dir )
+    if err != nil {
+        return err
+    }
+    if free < fst.MinFreeBytes {
+        return fmt.Errorf( "%w: %d bytes free, at least %d required", ErrInsufficientStorage, free, fst.MinFreeBytes )
+    }
+    if total > 0 && float64( free ) * 100 / float64( total ) < fst.MinFreePercent {
+        return fmt.Errorf( "%w: %.1f%% free, at least %.1f%% required", ErrInsufficientStorage, float64( free ) * 100 / float64( total ), fst.MinFreePercent )
+    }
+    return nil
+}
+
+// parse the comma separated thresholds like "10737418240,5%", a number is
+// the free bytes and a number followed by "%" is the free percent
+func ParseFreeSpaceThreshold( s string ) (FreeSpaceThreshold, error) {
+    threshold := FreeSpaceThreshold{}
+    for _, item := range strings.Split( s, "," ) {
+        item = strings.TrimSpace( item )
+        if item == "" {
+            continue
+        }
+        var err error
+        if strings.HasSuffix( item, "%" ) {
+            threshold.MinFreePercent, err = strconv.ParseFloat( strings.TrimSuffix( item, "%" ), 64 )
+            if err == nil && ( threshold.MinFreePercent < 0 || threshold.MinFreePercent > 100 ) {
+                err = fmt.Errorf( "out of range" )
+            }
+        } else {
+            threshold.MinFreeBytes, err = strconv.ParseUint( item, 10, 64 )
+        }
+        if err != nil {
+            return threshold, fmt.Errorf( "invalid free space threshold %s", item )
+        }
+    }
+    return threshold, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "net/http"
+    "testing"
+)
+
+func TestParseFreeSpaceThreshold( t *testing.T ) {
+    threshold, err := ParseFreeSpaceThreshold( "1048576, 5.5%" )
+    if err != nil || threshold.MinFreeBytes != 1048576 || threshold.MinFreePercent != 5.5 {
+        t.Errorf( "expected 1048576 bytes and 5.5%%, got %+v: %v", threshold, err )
+    }
+    if threshold, err = ParseFreeSpaceThreshold( "" ); err != nil || threshold != ( FreeSpaceThreshold{} ) {
+        t.Errorf( "expected no threshold, got %+v: %v", threshold, err )
+    }
+    for _, s := range []string{ "10GB", "-1", "120%", "x%" } {
+        if _, err = ParseFreeSpaceThreshold( s ); err == nil {
+            t.Errorf( "expected %s to be rejected", s )
+        }
+    }
+}
+
+func TestFileStorageMinFreeSpace( t *testing.T ) {
+    storage := newFileStorage( t )
+    var free uint64
+    storage.freeSpace = func( dir string ) (uint64, uint64, error) {
+        return free, 1000, nil
+    }
+    storage.SetMinFreeSpace( 100, 20 )
+
+    //below the bytes, then below the percent of the disk
+    var err error
+    for _, free = range []uint64{ 50, 150 } {
+        if err = storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); !errors.Is( err, ErrInsufficientStorage ) {
+            t.Errorf( "expected ErrInsufficientStorage with %d bytes free, got %v", free, err )
+        }
+    }
+    if names, _ := storage.List(); len( names ) != 0 {
+        t.Errorf( "the rejected image is stored, got %v", names )
+    }
+    free = 500
+    if err = storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); err != nil {
+        t.Errorf( "expected the image to be accepted, got %v", err )
+    }
+
+    free = 50
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "POST", "/image/save/app/2", bytes.NewReader( makeImageArchive( t, "full", "app:2" ) ) ); rw.Code != http.StatusInsufficientStorage {
+        t.Errorf( "expected 507, got %d", rw.Code )
+    }
+}
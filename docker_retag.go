@@ -0,0 +1,73 @@
+package main
+
+import (
+    "log"
+    "time"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// remember the ID of the image the tag points to after it is loaded
+func (dis *DockerImageStorage) expectTag( name string ) {
+    image, err := dis.client.InspectImage( name )
+    if err != nil {
+        return
+    }
+    dis.tagsMutex.Lock()
+    defer dis.tagsMutex.Unlock()
+    dis.expectedTags[name] = image.ID
+}
+
+// re-apply the expected tags which were dropped by the daemon (e.g. by
+// its garbage collection) to the images still present by ID
+func (dis *DockerImageStorage) ReconcileTags() {
+    dis.tagsMutex.Lock()
+    expected := make( map[string]string, len( dis.expectedTags ) )
+    for name, id := range dis.expectedTags {
+        expected[name] = id
+    }
+    dis.tagsMutex.Unlock()
+
+    for name, id := range expected {
+        if _, err := dis.client.InspectImage( name ); err != docker.ErrNoSuchImage {
+            continue
+        }
+        if _, err := dis.client.InspectImage( id ); err != nil {
+            continue
+        }
+        image_name, image_version := parseImageName( name )
+        unlock := dis.locker.Lock( name )
+        err := dis.client.TagImage( id, docker.TagImageOptions{ Repo: image_name, Tag: image_version } )
+        unlock()
+        if err != nil {
+            log.Printf( "fail to re-apply tag %s to image %s: %v", name, id, err )
+        } else {
+            log.Printf( "re-apply dropped tag %s to image %s", name, id )
+            dis.listCache.Invalidate()
+        }
+    }
+}
+
+// reconcile the expected tags every interval until StopRetag is called
+func (dis *DockerImageStorage) StartRetag( interval time.Duration ) {
+    dis.stopRetag = make( chan struct{} )
+    go func( stop chan struct{} ) {
+        ticker := time.NewTicker( interval )
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                dis.ReconcileTags()
+            case <-stop:
+                return
+            }
+        }
+    }( dis.stopRetag )
+}
+
+func (dis *DockerImageStorage) StopRetag() {
+    if dis.stopRetag != nil {
+        close( dis.stopRetag )
+        dis.stopRetag = nil
+    }
+}
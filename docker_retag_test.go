@@ -0,0 +1,52 @@
+package main
+
+import (
+    "bytes"
+    "testing"
+    "time"
+)
+
+// drop the tag like the garbage collection of the daemon does
+func (fd *fakeDocker) dropTag( name string ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    delete( fd.tags, normalizeImageName( name ) )
+}
+
+func TestReconcileDroppedTag( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    archive := makeImageArchive( t, "retag", "app:1" )
+    if err := storage.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    fd.dropTag( "app:1" )
+    if images, _ := storage.List(); len( images ) != 0 {
+        t.Fatalf( "expected the dropped tag not to be listed, got %v", images )
+    }
+
+    storage.StartRetag( 10 * time.Millisecond )
+    defer storage.StopRetag()
+    for i := 0; i < 100 && fd.tagged( "app:1" ) == ""; i++ {
+        time.Sleep( 10 * time.Millisecond )
+    }
+    if tagged := fd.tagged( "app:1" ); tagged != archiveImageID( t, archive ) {
+        t.Fatalf( "expected the dropped tag to be re-applied, got %q", tagged )
+    }
+    if images, _ := storage.List(); len( images ) != 1 || images[0] != "app:1" {
+        t.Errorf( "expected the re-applied tag to be listed, got %v", images )
+    }
+}
+
+func TestDeletedTagIsNotReapplied( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    if err := storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "deleted", "app:1", "app:2" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    if err := storage.Delete( "app:1" ); err != nil {
+        t.Fatal( err )
+    }
+    storage.ReconcileTags()
+    if tagged := fd.tagged( "app:1" ); tagged != "" {
+        t.Errorf( "expected the deleted tag to stay deleted, got %q", tagged )
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path"
+    "strings"
+    "time"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// returned when the docker daemon refuses to change an image because of
+// a conflicting tag or an image used by the containers
+var ErrImageConflict = errors.New( "image conflict" )
+
+// an image entry of the manifest.json of a docker-save tar
+type dockerSaveManifest struct {
+    Config string
+    RepoTags []string
+    Layers []string
+}
+
+// how many times a conflicting tag is retried before giving up
+const dockerTagRetries = 3
+
+// check if the daemon refused the request with 409, e.g. for the
+// "tag already exists" or "image is being used" conflicts
+func isDockerConflict( err error ) bool {
+    var docker_err *docker.Error
+    return errors.As( err, &docker_err ) && docker_err.Status == 409
+}
+
+// get the image ID "sha256:<hex>" of the image name from the manifest.json
+// of the archive, the image is the entry tagged with name or the only entry.
+// Empty if the archive doesn't tell which image name is
+func loadedImageID( manifest []dockerSaveManifest, name string ) string {
+    var entry *dockerSaveManifest
+    for i := range manifest {
+        for _, tag := range manifest[i].RepoTags {
+            if normalizeImageName( tag ) == name {
+                entry = &manifest[i]
+            }
+        }
+    }
+    if entry == nil && len( manifest ) == 1 {
+        entry = &manifest[0]
+    }
+    if entry == nil || entry.Config == "" {
+        return ""
+    }
+    //the config is "<hex>.json" in the legacy layout and
+    //"blobs/sha256/<hex>" in the OCI layout
+    return "sha256:" + strings.TrimSuffix( path.Base( entry.Config ), ".json" )
+}
+
+// tag the image id as name, the conflicting tag is retried as another
+// load may be moving the same tag at the same time
+func (dis *DockerImageStorage) tagImage( id string, image_name string, image_version string ) error {
+    var err error
+    for i := 0; i < dockerTagRetries; i++ {
+        if i > 0 {
+            time.Sleep( time.Duration( i ) * 100 * time.Millisecond )
+        }
+        err = dis.client.TagImage( id, docker.TagImageOptions{ Repo: image_name, Tag: image_version, Force: true } )
+        if !isDockerConflict( err ) {
+            return err
+        }
+    }
+    return fmt.Errorf( "%w: fail to tag image %s as %s:%s: %v", ErrImageConflict, id, image_name, image_version, err )
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "errors"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestLoadedImageID( t *testing.T ) {
+    manifest := []dockerSaveManifest{
+        { Config: "aaaa.json", RepoTags: []string{ "app:1" } },
+        { Config: "blobs/sha256/bbbb", RepoTags: []string{ "team/app" } } }
+    if id := loadedImageID( manifest, "app:1" ); id != "sha256:aaaa" {
+        t.Errorf( "expected the legacy config ID, got %s", id )
+    }
+    if id := loadedImageID( manifest, "team/app:latest" ); id != "sha256:bbbb" {
+        t.Errorf( "expected the OCI config ID, got %s", id )
+    }
+    if id := loadedImageID( manifest, "other:1" ); id != "" {
+        t.Errorf( "expected no ID for the image not in the archive, got %s", id )
+    }
+    if id := loadedImageID( manifest[:1], "other:1" ); id != "sha256:aaaa" {
+        t.Errorf( "expected the only image of the archive, got %s", id )
+    }
+}
+
+func TestDockerWriteSameImageConcurrently( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    fd.loadDelay = 50 * time.Millisecond
+    archive := makeImageArchive( t, "same", "app:1" )
+    id := archiveImageID( t, archive )
+
+    names := []string{ "app:1", "app:2", "app:1", "app:2" }
+    errs := make( []error, len( names ) )
+    var wg sync.WaitGroup
+    for i, name := range names {
+        wg.Add( 1 )
+        go func( i int, name string ) {
+            defer wg.Done()
+            errs[i] = storage.Write( name, bytes.NewReader( archive ) )
+        }( i, name )
+    }
+    wg.Wait()
+    for i, err := range errs {
+        if err != nil {
+            t.Fatalf( "write %s: %v", names[i], err )
+        }
+    }
+    if fd.overlapped {
+        t.Error( "two loads of the same image ID were not serialized" )
+    }
+    for _, name := range []string{ "app:1", "app:2" } {
+        if tagged := fd.tagged( name ); tagged != id {
+            t.Errorf( "expected %s to point to %s, got %q", name, id, tagged )
+        }
+    }
+    images, err := storage.List()
+    if err != nil {
+        t.Fatal( err )
+    }
+    sort.Strings( images )
+    if len( images ) != 2 || images[0] != "app:1" || images[1] != "app:2" {
+        t.Errorf( "expected app:1 and app:2, got %v", images )
+    }
+}
+
+func TestDockerWriteTagsTheName( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    archive := makeImageArchive( t, "renamed", "other:9" )
+    if err := storage.Write( "team/app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    if tagged := fd.tagged( "team/app:1" ); tagged != archiveImageID( t, archive ) {
+        t.Errorf( "expected team/app:1 to be tagged, got %q", tagged )
+    }
+}
+
+func TestDockerWriteRejectsInvalidArchive( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    err := storage.Write( "app:1", bytes.NewReader( make( []byte, 1024 ) ) )
+    if !errors.Is( err, ErrInvalidImageArchive ) {
+        t.Fatalf( "expected ErrInvalidImageArchive, got %v", err )
+    }
+    if len( fd.images ) != 0 {
+        t.Error( "the invalid archive reached the daemon" )
+    }
+}
+
+func TestDockerWriteTagConflict( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    fd.tagStatus = http.StatusConflict
+    err := storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "conflict", "app:1" ) ) )
+    if !errors.Is( err, ErrImageConflict ) {
+        t.Fatalf( "expected ErrImageConflict, got %v", err )
+    }
+
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( makeImageArchive( t, "conflict", "app:2" ) ) )
+    if rw.Code != http.StatusConflict {
+        t.Errorf( "expected 409 for the conflicting tag, got %d", rw.Code )
+    }
+}
+
+func TestDockerDeleteImageInUse( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    if err := storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "used", "app:1" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    fd.removeStatus = http.StatusConflict
+    if err := storage.Delete( "app:1" ); !errors.Is( err, ErrImageConflict ) {
+        t.Fatalf( "expected ErrImageConflict, got %v", err )
+    }
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "DELETE", "/image/delete/app:1", nil ); rw.Code != http.StatusConflict {
+        t.Errorf( "expected 409 for the image in use, got %d", rw.Code )
+    }
+}
+
+func TestSaveStreamsLoadProgress( t *testing.T ) {
+    _, storage := newFakeDocker( t )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "progress", "app:1" )
+
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Accept", "application/x-ndjson" )
+    if rw.Header().Get( "Content-Type" ) != "application/x-ndjson" {
+        t.Fatalf( "expected the NDJSON progress, got %s", rw.Header().Get( "Content-Type" ) )
+    }
+    lines := strings.Split( strings.TrimSpace( rw.Body.String() ), "\n" )
+    if len( lines ) != 3 || !strings.Contains( lines[0], "Loading layer" ) || !strings.Contains( lines[1], "Loaded image ID" ) || !strings.Contains( lines[2], "save image successfully" ) {
+        t.Errorf( "expected the load progress followed by the result, got %q", lines )
+    }
+
+    rw = doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) )
+    if rw.Code != http.StatusCreated || rw.Body.String() != "save image successfully" {
+        t.Errorf( "expected the simple result without asking for the progress, got %d %q", rw.Code, rw.Body.String() )
+    }
+}
+
+func TestDockerRemoveDangling( t *testing.T ) {
+    for _, c := range []struct{
+        remove bool
+        inUse bool
+        removed bool
+    }{ { true, false, true }, { false, false, false }, { true, true, false } } {
+        fd, storage := newFakeDocker( t )
+        storage.SetRemoveDangling( c.remove )
+        first := makeImageArchive( t, "first", "app:1" )
+        if err := storage.Write( "app:1", bytes.NewReader( first ) ); err != nil {
+            t.Fatal( err )
+        }
+        first_id := archiveImageID( t, first )
+        if c.inUse {
+            fd.inUse[first_id] = true
+        }
+        second := makeImageArchive( t, "second", "app:1" )
+        if err := storage.Write( "app:1", bytes.NewReader( second ) ); err != nil {
+            t.Fatal( err )
+        }
+        if tagged := fd.tagged( "app:1" ); tagged != archiveImageID( t, second ) {
+            t.Errorf( "expected app:1 to be the second image, got %q", tagged )
+        }
+        fd.mutex.Lock()
+        _, kept := fd.images[first_id]
+        fd.mutex.Unlock()
+        if kept == c.removed {
+            t.Errorf( "remove %v, in use %v: expected the dangling image removed %v, got %v", c.remove, c.inUse, c.removed, !kept )
+        }
+    }
+}
+
+// a tar without manifest.json is rejected before the daemon sees it,
+// the docker-save tar is loaded
+func TestDockerSaveValidatesArchive( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    _, handler := newTestWeb( t, storage )
+    var plain bytes.Buffer
+    tw := tar.NewWriter( &plain )
+    writeTarFile( t, tw, "README.md", []byte( "not an image" ) )
+    tw.Close()
+
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( plain.Bytes() ) )
+    if rw.Code != http.StatusBadRequest || !strings.Contains( rw.Body.String(), "manifest.json" ) {
+        t.Errorf( "expected 400 for the plain tar, got %d %s", rw.Code, rw.Body.String() )
+    }
+    if len( fd.images ) != 0 {
+        t.Error( "the plain tar reached the daemon" )
+    }
+
+    archive := makeImageArchive( t, "app", "app:1" )
+    if rw = doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201 for the docker-save tar, got %d", rw.Code )
+    }
+    if tagged := fd.tagged( "app:1" ); tagged != archiveImageID( t, archive ) {
+        t.Errorf( "expected app:1 to be loaded, got %q", tagged )
+    }
+}
+
+func TestDockerListDetailed( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    first := makeImageArchive( t, "first", "app:1", "app:latest" )
+    second := makeImageArchive( t, "second", "team/app:2" )
+    for name, archive := range map[string][]byte{ "app:1": first, "team/app:2": second } {
+        if err := storage.Write( name, bytes.NewReader( archive ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    //the dangling image is listed by the daemon with the <none> tag
+    dangling := makeImageArchive( t, "dangling" )
+    fd.mutex.Lock()
+    fd.images[archiveImageID( t, dangling )] = dangling
+    fd.tags["<none>:<none>"] = archiveImageID( t, dangling )
+    fd.mutex.Unlock()
+
+    _, handler := newTestWeb( t, storage )
+    infos := listDetailedByName( t, handler )
+    if len( infos ) != 3 {
+        t.Fatalf( "expected the 3 tags, got %v", infos )
+    }
+    for name, archive := range map[string][]byte{ "app:1": first, "app:latest": first, "team/app:2": second } {
+        info, ok := infos[name]
+        if !ok || info.ID != archiveImageID( t, archive ) || info.Size != int64( len( archive ) ) || info.Created == nil {
+            t.Errorf( "unexpected info of %s: %+v", name, info )
+        }
+    }
+    if info := infos["team/app:2"]; info.Repository != "team/app" || info.Tag != "2" {
+        t.Errorf( "expected the repository and the tag of team/app:2, got %+v", info )
+    }
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+var ErrInvalidToken = errors.New( "invalid download token" )
+var ErrTokenExpired = errors.New( "download token is expired" )
+
+// issue and verify the short-lived tokens which allow downloading one image
+// without the Authorization header, e.g. by a link in the browser
+type DownloadTokens struct {
+    key []byte
+    ttl time.Duration
+}
+
+// the tokens are signed by key, a random key is used if it is empty so the
+// tokens are only valid until the restart
+func NewDownloadTokens( key []byte, ttl time.Duration ) (*DownloadTokens, error) {
+    if len( key ) == 0 {
+        key = make( []byte, 32 )
+        if _, err := rand.Read( key ); err != nil {
+            return nil, err
+        }
+    }
+    return &DownloadTokens{ key: key, ttl: ttl }, nil
+}
+
+func (dt *DownloadTokens) sign( name string, expires int64 ) string {
+    mac := hmac.New( sha256.New, dt.key )
+    fmt.Fprintf( mac, "%s\n%d", normalizeImageName( name ), expires )
+    return hex.EncodeToString( mac.Sum( nil ) )
+}
+
+// issue the token "<expiry unix time>.<signature>" for image name
+func (dt *DownloadTokens) Issue( name string ) (string, time.Time) {
+    expires := time.Now().Add( dt.ttl ).Truncate( time.Second )
+    return fmt.Sprintf( "%d.%s", expires.Unix(), dt.sign( name, expires.Unix() ) ), expires
+}
+
+// check the token is issued for image name and not expired
+func (dt *DownloadTokens) Verify( name string, token string ) error {
+    pos := strings.Index( token, "." )
+    if pos == -1 {
+        return ErrInvalidToken
+    }
+    expires, err := strconv.ParseInt( token[0:pos], 10, 64 )
+    if err != nil {
+        return ErrInvalidToken
+    }
+    if !hmac.Equal( []byte( token[pos+1:] ), []byte( dt.sign( name, expires ) ) ) {
+        return ErrInvalidToken
+    }
+    if time.Now().Unix() > expires {
+        return ErrTokenExpired
+    }
+    return nil
+}
+
+// set the key signing the download tokens and how long they are valid
+func (iw *ImageWeb) SetDownloadTokens( key []byte, ttl time.Duration ) error {
+    tokens, err := NewDownloadTokens( key, ttl )
+    if err == nil {
+        iw.tokens = tokens
+    }
+    return err
+}
+
+// check if the request can download image name, either by a valid
+// download token or by the Authorization header
+func (iw *ImageWeb) authorizeDownload( rw http.ResponseWriter, req *http.Request, name string ) bool {
+    token := req.URL.Query().Get( "token" )
+    if token == "" {
+        return iw.authorize( rw, req, name, false )
+    }
+    if err := iw.tokens.Verify( name, token ); err != nil {
+        http.Error( rw, err.Error(), http.StatusForbidden )
+        return false
+    }
+    return true
+}
+
+func (iw *ImageWeb) initDownloadToken() {
+    http.HandleFunc("/image/token/", func(rw http.ResponseWriter, req *http.Request) {
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/token/" ) )
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        token, expires := iw.tokens.Issue( name )
+        rw.Header().Set( "Content-Type", "application/json" )
+        rw.Header().Set( "Cache-Control", "no-store" )
+        json.NewEncoder( rw ).Encode( map[string]interface{}{ "token": token,
+                    "expires_at": expires.UTC(),
+                    "url": "/image/get/" + normalizeImageName( name ) + "?token=" + url.QueryEscape( token ) } )
+    })
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestDownloadToken( t *testing.T ) {
+    access := filepath.Join( t.TempDir(), "access.json" )
+    ioutil.WriteFile( access, []byte( `{ "identities": [ { "api_key": "key", "read": [ "*" ] } ] }` ), 0600 )
+    ac, err := LoadAccessControl( access )
+    if err != nil {
+        t.Fatal( err )
+    }
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    storage.Write( "app:2", bytes.NewReader( []byte( "two" ) ) )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetAccessControl( ac )
+    if err = iw.SetDownloadTokens( []byte( "key" ), time.Minute ); err != nil {
+        t.Fatal( err )
+    }
+
+    if rw := doRequest( handler, "GET", "/image/token/app:1", nil ); rw.Code != http.StatusUnauthorized {
+        t.Errorf( "expected 401 for the token without an identity, got %d", rw.Code )
+    }
+    rw := doRequest( handler, "GET", "/image/token/app:1", nil, "X-API-Key", "key" )
+    issued := struct{ Token string; Url string }{}
+    if err = json.Unmarshal( rw.Body.Bytes(), &issued ); err != nil || issued.Token == "" {
+        t.Fatalf( "expected a token, got %d %s", rw.Code, rw.Body.String() )
+    }
+
+    //the token is enough to download the image it is issued for
+    rw = doRequest( handler, "GET", issued.Url, nil )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != "one" {
+        t.Errorf( "expected the image by the token, got %d", rw.Code )
+    }
+    for _, link := range []string{
+                "/image/get/app:2?token=" + url.QueryEscape( issued.Token ),
+                "/image/get/app:1?token=" + url.QueryEscape( strings.Replace( issued.Token, ".", "0.", 1 ) ),
+                "/image/get/app:1?token=invalid" } {
+        if rw = doRequest( handler, "GET", link, nil ); rw.Code != http.StatusForbidden {
+            t.Errorf( "expected 403 for %s, got %d", link, rw.Code )
+        }
+    }
+
+    expired, _ := NewDownloadTokens( []byte( "key" ), -2 * time.Second )
+    token, _ := expired.Issue( "app:1" )
+    rw = doRequest( handler, "GET", "/image/get/app:1?token=" + url.QueryEscape( token ), nil )
+    if rw.Code != http.StatusForbidden || !strings.Contains( responseBody( t, rw ), "expired" ) {
+        t.Errorf( "expected 403 for the expired token, got %d", rw.Code )
+    }
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "os"
+    "strings"
+)
+
+// optional interface implemented by the storage which can check if an
+// image exists without reading it
+type Exister interface {
+    // check if the image name is stored
+    Exists(name string) (bool, error)
+}
+
+// check if the image name is in the storage, the storages without
+// Exister are checked by listing their images
+func storageExists( image_storage ImageStorage, name string ) (bool, error) {
+    if exister, ok := image_storage.(Exister); ok {
+        return exister.Exists( name )
+    }
+    names, err := image_storage.List()
+    if err != nil {
+        return false, err
+    }
+    name = normalizeImageName( name )
+    for _, image := range names {
+        if normalizeImageName( image ) == name {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// check if the image name is stored. If the storage can't tell, the
+// error response is written to rw and ok is false
+func (iw *ImageWeb) imageExists( rw http.ResponseWriter, req *http.Request, name string ) (exists bool, ok bool) {
+    exists, err := storageExists( iw.storage( req ), name )
+    switch {
+    case err == nil:
+        return exists, true
+    case isNotFound( err ):
+        return false, true
+    case errors.Is( err, ErrBusy ):
+        http.Error( rw, err.Error(), http.StatusServiceUnavailable )
+    case errors.Is( err, ErrInvalidName ):
+        http.Error( rw, err.Error(), http.StatusBadRequest )
+    default:
+        http.Error( rw, "fail to check image " + name + ": " + err.Error(), http.StatusInternalServerError )
+    }
+    return false, false
+}
+
+func (fis *FileImageStorage) Exists( name string ) (bool, error) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return false, err
+    }
+    if _, err = os.Stat( image_file ); os.IsNotExist( err ) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func (mis *MongoImageStorage) Exists( name string ) (bool, error) {
+    name = normalizeImageName( name )
+    session, fs, err := mis.createGridFS()
+    if err != nil {
+        return false, err
+    }
+    defer session.Close()
+    return mis.hasImage( session, fs, name )
+}
+
+func (dis *DockerImageStorage) Exists( name string ) (bool, error) {
+    _, err := dis.client.InspectImage( normalizeImageName( name ) )
+    if isNotFound( err ) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func (mdis *MultiDockerImageStorage) Exists( name string ) (bool, error) {
+    return len( mdis.daemonsHaving( normalizeImageName( name ) ) ) > 0, nil
+}
+
+func (gis *GCSImageStorage) Exists( name string ) (bool, error) {
+    _, _, err := gis.Size( name )
+    if isNotFound( err ) {
+        return false, nil
+    }
+    return err == nil, err
+}
+
+func (lis *LayeredImageStorage) Exists( name string ) (bool, error) {
+    if _, err := os.Stat( lis.recordFile( name ) ); os.IsNotExist( err ) {
+        return false, nil
+    } else if err != nil {
+        return false, err
+    }
+    return true, nil
+}
+
+// the names are kept in the index storage
+func (sis *SplitImageStorage) Exists( name string ) (bool, error) {
+    return storageExists( sis.index, name )
+}
+
+func (iw *ImageWeb) initExists() {
+    http.HandleFunc("/image/exists/", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "GET" && req.Method != "HEAD" {
+            rw.Header().Set( "Allow", "GET, HEAD" )
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        name, ok := iw.resolveName( rw, iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/exists/" ) ) )
+        if !ok || !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        if exists, ok := iw.imageExists( rw, req, name ); ok && exists {
+            rw.WriteHeader( http.StatusOK )
+        } else if ok {
+            rw.WriteHeader( http.StatusNotFound )
+        }
+    })
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "net/http"
+    "testing"
+)
+
+// a storage which can't tell if an image exists
+type brokenExistsStorage struct {
+    ImageStorage
+}
+
+func (bes *brokenExistsStorage) Exists( name string ) (bool, error) {
+    return false, fmt.Errorf( "storage failure" )
+}
+
+func TestImageExists( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "team/app:1", bytes.NewReader( []byte( "image" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    for _, method := range []string{ "HEAD", "GET" } {
+        rw := doRequest( handler, method, "/image/exists/team/app:1", nil )
+        if rw.Code != http.StatusOK || rw.Body.Len() != 0 {
+            t.Errorf( "expected 200 without body on %s, got %d %q", method, rw.Code, rw.Body.String() )
+        }
+        if rw = doRequest( handler, method, "/image/exists/team/app:2", nil ); rw.Code != http.StatusNotFound {
+            t.Errorf( "expected 404 for the absent image on %s, got %d", method, rw.Code )
+        }
+    }
+    if rw := doRequest( handler, "POST", "/image/exists/team/app:1", nil ); rw.Code != http.StatusMethodNotAllowed {
+        t.Errorf( "expected 405, got %d", rw.Code )
+    }
+}
+
+func TestImageExistsFailure( t *testing.T ) {
+    storage := &brokenExistsStorage{ ImageStorage: newFileStorage( t ) }
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "HEAD", "/image/exists/app:1", nil ); rw.Code != http.StatusInternalServerError {
+        t.Errorf( "expected 500 when the storage fails, got %d", rw.Code )
+    }
+    //the retag doesn't overwrite an image it can't check
+    if rw := doRequest( handler, "POST", "/image/retag/app:1?to=app:2", nil ); rw.Code != http.StatusInternalServerError {
+        t.Errorf( "expected 500 for the retag, got %d", rw.Code )
+    }
+}
+
+// a storage implementing only ImageStorage, as the storages written
+// before Exister do
+type listOnlyStorage struct {
+    storage ImageStorage
+    lists int
+}
+
+func (los *listOnlyStorage) Write( name string, reader io.Reader ) error {
+    return los.storage.Write( name, reader )
+}
+
+func (los *listOnlyStorage) Get( name string, writer io.Writer ) error {
+    return los.storage.Get( name, writer )
+}
+
+func (los *listOnlyStorage) Delete( name string ) error {
+    return los.storage.Delete( name )
+}
+
+func (los *listOnlyStorage) List() ([]string, error) {
+    los.lists++
+    return los.storage.List()
+}
+
+// the storage without Exists is checked by listing its images
+func TestImageExistsByList( t *testing.T ) {
+    storage := &listOnlyStorage{ storage: newFileStorage( t ) }
+    storage.Write( "team/app:1", bytes.NewReader( []byte( "image" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    if rw := doRequest( handler, "HEAD", "/image/exists/team/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected 200 for the listed image, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "HEAD", "/image/exists/team/app:2", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the absent image, got %d", rw.Code )
+    }
+    if storage.lists != 2 {
+        t.Errorf( "expected each check to list the images, got %d lists", storage.lists )
+    }
+    //the existing image is still reported as overwritten
+    rw := doRequest( handler, "POST", "/image/save/team/app:1", bytes.NewReader( []byte( "image 2" ) ) )
+    if rw.Code != http.StatusCreated || rw.Header().Get( "Warning" ) == "" {
+        t.Errorf( "expected the overwrite warning, got %d %v", rw.Code, rw.Header() )
+    }
+}
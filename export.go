@@ -0,0 +1,83 @@
+package main
+
+import (
+    "archive/tar"
+    "log"
+    "net/http"
+    "strings"
+)
+
+// get the image names of the "name" parameters, every one may have
+// several comma separated names
+func exportImageNames( req *http.Request ) []string {
+    names := make( []string, 0 )
+    for _, param := range req.URL.Query()["name"] {
+        for _, name := range strings.Split( param, "," ) {
+            if name = strings.TrimSpace( name ); name != "" {
+                names = append( names, name )
+            }
+        }
+    }
+    return names
+}
+
+// write the images as a tar with one "name/version" entry per image and
+// a trailing index.json, like the batch get
+func (iw *ImageWeb) exportTar( rw http.ResponseWriter, names []string ) {
+    cw := &countingWriter{ w: rw }
+    tw := tar.NewWriter( cw )
+    index := make( []batchIndexEntry, 0, len( names ) )
+    for _, name := range names {
+        entry, err := iw.writeBatchEntry( tw, cw, name )
+        if err != nil {
+            log.Printf( "fail to write image %s to the export: %v", name, err )
+            return
+        }
+        index = append( index, entry )
+    }
+    if err := writeBatchIndex( tw, index ); err != nil {
+        log.Printf( "fail to write the export index: %v", err )
+    }
+}
+
+func (iw *ImageWeb) initExport() {
+    http.HandleFunc("/image/export", func(rw http.ResponseWriter, req *http.Request) {
+        names := exportImageNames( req )
+        if len( names ) == 0 {
+            http.Error( rw, "at least one name must be given", http.StatusBadRequest )
+            return
+        }
+        if len( names ) > maxBatchImages {
+            http.Error( rw, "too many images in one export", http.StatusRequestEntityTooLarge )
+            return
+        }
+        missing := make( []string, 0 )
+        for i, name := range names {
+            names[i] = iw.nameTransform.Apply( name )
+            if !iw.checkName( rw, names[i] ) || !iw.authorize( rw, req, names[i], false ) {
+                return
+            }
+            names[i] = normalizeImageName( names[i] )
+            if exists, ok := iw.imageExists( rw, req, names[i] ); !ok {
+                return
+            } else if !exists {
+                missing = append( missing, names[i] )
+            }
+        }
+        if len( missing ) > 0 {
+            http.Error( rw, "images are not found: " + strings.Join( missing, ", " ), http.StatusNotFound )
+            return
+        }
+
+        rw.Header().Set( "Content-Type", "application/x-tar" )
+        exporter, ok := iw.image_storage.(MultiExporter)
+        if !ok {
+            iw.exportTar( rw, names )
+            return
+        }
+        tw := &trackingWriter{ ResponseWriter: rw }
+        if err := exporter.GetMany( names, tw ); err != nil {
+            iw.failGet( tw, strings.Join( names, "," ), err )
+        }
+    })
+}
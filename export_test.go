@@ -0,0 +1,81 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+// a storage exporting several images at once, the export is the
+// names it is asked for
+type multiExportStorage struct {
+    ImageStorage
+    exported [][]string
+}
+
+func (mes *multiExportStorage) GetMany( names []string, writer io.Writer ) error {
+    mes.exported = append( mes.exported, names )
+    _, err := io.WriteString( writer, strings.Join( names, "+" ) )
+    return err
+}
+
+func TestExportTar( t *testing.T ) {
+    storage := newFileStorage( t )
+    for _, name := range []string{ "app:1", "app:2", "team/web:1" } {
+        storage.Write( name, strings.NewReader( "content of " + name ) )
+    }
+    _, handler := newTestWeb( t, storage )
+
+    //the names may be repeated or comma separated
+    rw := doRequest( handler, "GET", "/image/export?name=app:1,team/web:1&name=app:2", nil )
+    if rw.Code != http.StatusOK || rw.Header().Get( "Content-Type" ) != "application/x-tar" {
+        t.Fatalf( "expected the tar, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    entries := readTarEntries( t, rw.Body.Bytes() )
+    index := make( []batchIndexEntry, 0 )
+    if err := json.Unmarshal( entries["index.json"], &index ); err != nil {
+        t.Fatalf( "invalid index.json: %v", err )
+    }
+    if len( index ) != 3 {
+        t.Fatalf( "expected 3 images in the index, got %+v", index )
+    }
+    for i, name := range []string{ "app:1", "team/web:1", "app:2" } {
+        if index[i].Name != name || string( entries[index[i].Entry] ) != "content of " + name {
+            t.Errorf( "expected %s at %d, got %+v", name, i, index[i] )
+        }
+    }
+}
+
+func TestExportMultiExporter( t *testing.T ) {
+    storage := &multiExportStorage{ ImageStorage: newFileStorage( t ) }
+    storage.Write( "app:1", strings.NewReader( "1" ) )
+    storage.Write( "web:1", strings.NewReader( "2" ) )
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/image/export?name=app:1&name=web:1", nil )
+    if rw.Code != http.StatusOK || rw.Body.String() != "app:1+web:1" {
+        t.Errorf( "expected the combined export, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    if fmt.Sprint( storage.exported ) != "[[app:1 web:1]]" {
+        t.Errorf( "expected one export of both images, got %v", storage.exported )
+    }
+}
+
+func TestExportInvalid( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", strings.NewReader( "1" ) )
+    _, handler := newTestWeb( t, storage )
+
+    for _, url := range []string{ "/image/export", "/image/export?name=", "/image/export?name=,," } {
+        if rw := doRequest( handler, "GET", url, nil ); rw.Code != http.StatusBadRequest {
+            t.Errorf( "%s: expected 400 without a name, got %d", url, rw.Code )
+        }
+    }
+    rw := doRequest( handler, "GET", "/image/export?name=app:1,app:2,web:1", nil )
+    if rw.Code != http.StatusNotFound || !strings.Contains( rw.Body.String(), "app:2, web:1" ) {
+        t.Errorf( "expected 404 listing the missing images, got %d: %s", rw.Code, rw.Body.String() )
+    }
+}
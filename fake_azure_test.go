@@ -0,0 +1,211 @@
+package main
+
+import (
+    "encoding/xml"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// the key of the Azurite development account, the fake never checks it
+const fakeAzureAccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+// an Azure Blob Storage account serving the block blobs of its
+// containers from memory
+type fakeAzureBlobs struct {
+    mutex sync.Mutex
+
+    //the committed and the staged blobs by "<container>/<blob>"
+    blobs map[string][]byte
+    blocks map[string]map[string][]byte
+
+    //the number of the staged blocks
+    staged int
+
+    //the content types of the committed blobs
+    contentTypes map[string]string
+
+    //the access tiers of the committed blobs and the tiers the archived
+    //blobs are being rehydrated to
+    tiers map[string]string
+    rehydrating map[string]string
+
+    //the SAS signatures of the requests, empty for the shared key
+    signatures []string
+}
+
+// start the fake account and get the storage of its container with
+// prefix, the storage connects with the connection string
+func newFakeAzureStorage( t *testing.T, container string, prefix string ) (*fakeAzureBlobs, *AzureBlobImageStorage) {
+    t.Helper()
+    fab := &fakeAzureBlobs{ blobs: make( map[string][]byte ), blocks: make( map[string]map[string][]byte ), contentTypes: make( map[string]string ),
+                tiers: make( map[string]string ), rehydrating: make( map[string]string ) }
+    server := httptest.NewServer( fab )
+    t.Cleanup( server.Close )
+    t.Setenv( "AZURE_STORAGE_CONNECTION_STRING", fmt.Sprintf( "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;AccountKey=%s;BlobEndpoint=%s/devstoreaccount1;", fakeAzureAccountKey, server.URL ) )
+    storage, err := NewAzureBlobImageStorage( "", container, prefix )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return fab, storage
+}
+
+// the blob names of the account in sorted order
+func (fab *fakeAzureBlobs) names() []string {
+    fab.mutex.Lock()
+    defer fab.mutex.Unlock()
+    result := make( []string, 0, len( fab.blobs ) )
+    for name := range fab.blobs {
+        result = append( result, name )
+    }
+    sort.Strings( result )
+    return result
+}
+
+type fakeAzureBlob struct {
+    Name string `xml:"Name"`
+    ContentLength int `xml:"Properties>Content-Length"`
+    AccessTier string `xml:"Properties>AccessTier"`
+}
+
+type fakeAzureListing struct {
+    XMLName xml.Name `xml:"EnumerationResults"`
+    ContainerName string `xml:"ContainerName,attr"`
+    Prefix string `xml:"Prefix"`
+    Blobs []fakeAzureBlob `xml:"Blobs>Blob"`
+    NextMarker string `xml:"NextMarker"`
+}
+
+type fakeAzureBlockList struct {
+    Latest []string `xml:"Latest"`
+}
+
+func (fab *fakeAzureBlobs) ServeHTTP( rw http.ResponseWriter, req *http.Request ) {
+    fab.mutex.Lock()
+    defer fab.mutex.Unlock()
+
+    //the path is "/<account>/<container>[/<blob>]"
+    parts := strings.SplitN( strings.TrimPrefix( req.URL.Path, "/" ), "/", 3 )
+    if len( parts ) < 2 {
+        http.Error( rw, "invalid path", http.StatusBadRequest )
+        return
+    }
+    query := req.URL.Query()
+    fab.signatures = append( fab.signatures, query.Get( "sig" ) )
+    if len( parts ) == 2 {
+        if req.Method == "GET" && query.Get( "comp" ) == "list" {
+            fab.list( rw, parts[1], query.Get( "prefix" ) )
+            return
+        }
+        http.Error( rw, "not implemented", http.StatusNotImplemented )
+        return
+    }
+    key := parts[1] + "/" + parts[2]
+    switch {
+    case req.Method == "PUT" && query.Get( "comp" ) == "block":
+        data, _ := ioutil.ReadAll( req.Body )
+        if fab.blocks[key] == nil {
+            fab.blocks[key] = make( map[string][]byte )
+        }
+        fab.blocks[key][query.Get( "blockid" )] = data
+        fab.staged++
+        rw.WriteHeader( http.StatusCreated )
+    case req.Method == "PUT" && query.Get( "comp" ) == "blocklist":
+        block_list := fakeAzureBlockList{}
+        if err := xml.NewDecoder( req.Body ).Decode( &block_list ); err != nil {
+            http.Error( rw, err.Error(), http.StatusBadRequest )
+            return
+        }
+        data := make( []byte, 0 )
+        for _, id := range block_list.Latest {
+            data = append( data, fab.blocks[key][id]... )
+        }
+        fab.commit( key, data, req )
+        delete( fab.blocks, key )
+        rw.WriteHeader( http.StatusCreated )
+    case req.Method == "PUT" && query.Get( "comp" ) == "tier":
+        if _, ok := fab.blobs[key]; !ok {
+            fab.notFound( rw )
+            return
+        }
+        fab.rehydrating[key] = req.Header.Get( "x-ms-access-tier" )
+        rw.WriteHeader( http.StatusAccepted )
+    case req.Method == "PUT":
+        data, _ := ioutil.ReadAll( req.Body )
+        fab.commit( key, data, req )
+        rw.WriteHeader( http.StatusCreated )
+    case req.Method == "GET" || req.Method == "HEAD":
+        data, ok := fab.blobs[key]
+        if !ok {
+            fab.notFound( rw )
+            return
+        }
+        if fab.tiers[key] == "Archive" {
+            fab.fail( rw, http.StatusConflict, "BlobArchived", "This operation is not permitted on an archived blob." )
+            return
+        }
+        rw.Header().Set( "Content-Length", fmt.Sprint( len( data ) ) )
+        rw.Header().Set( "Content-Type", fab.contentTypes[key] )
+        rw.Header().Set( "x-ms-blob-type", "BlockBlob" )
+        rw.WriteHeader( http.StatusOK )
+        if req.Method == "GET" {
+            rw.Write( data )
+        }
+    case req.Method == "DELETE":
+        if _, ok := fab.blobs[key]; !ok {
+            fab.notFound( rw )
+            return
+        }
+        delete( fab.blobs, key )
+        delete( fab.contentTypes, key )
+        delete( fab.tiers, key )
+        rw.WriteHeader( http.StatusAccepted )
+    default:
+        http.Error( rw, "not implemented", http.StatusNotImplemented )
+    }
+}
+
+// commit the blob with the content type and the access tier of the request
+func (fab *fakeAzureBlobs) commit( key string, data []byte, req *http.Request ) {
+    fab.blobs[key] = data
+    fab.contentTypes[key] = req.Header.Get( "x-ms-blob-content-type" )
+    fab.tiers[key] = req.Header.Get( "x-ms-access-tier" )
+    if fab.tiers[key] == "" {
+        fab.tiers[key] = "Hot"
+    }
+}
+
+func (fab *fakeAzureBlobs) notFound( rw http.ResponseWriter ) {
+    fab.fail( rw, http.StatusNotFound, "BlobNotFound", "The specified blob does not exist." )
+}
+
+func (fab *fakeAzureBlobs) fail( rw http.ResponseWriter, status int, code string, message string ) {
+    rw.Header().Set( "x-ms-error-code", code )
+    rw.Header().Set( "Content-Type", "application/xml" )
+    rw.WriteHeader( status )
+    fmt.Fprintf( rw, `<?xml version="1.0" encoding="utf-8"?><Error><Code>%s</Code><Message>%s</Message></Error>`, code, message )
+}
+
+// list all the blobs starting with prefix in one page
+func (fab *fakeAzureBlobs) list( rw http.ResponseWriter, container string, prefix string ) {
+    listing := fakeAzureListing{ ContainerName: container, Prefix: prefix }
+    keys := make( []string, 0 )
+    for key := range fab.blobs {
+        keys = append( keys, key )
+    }
+    sort.Strings( keys )
+    for _, key := range keys {
+        name := strings.TrimPrefix( key, container + "/" )
+        if name != key && strings.HasPrefix( name, prefix ) {
+            listing.Blobs = append( listing.Blobs, fakeAzureBlob{ Name: name, ContentLength: len( fab.blobs[key] ), AccessTier: fab.tiers[key] } )
+        }
+    }
+    rw.Header().Set( "Content-Type", "application/xml" )
+    rw.Write( []byte( xml.Header ) )
+    xml.NewEncoder( rw ).Encode( listing )
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// a docker daemon serving the image API from memory
+type fakeDocker struct {
+    mutex sync.Mutex
+
+    //the loaded archives by image ID and the image ID of every tag
+    images map[string][]byte
+    tags map[string]string
+
+    //the IDs being loaded and not tagged yet, and if two loads of
+    //the same ID were ever in progress at the same time
+    loading map[string]bool
+    overlapped bool
+
+    //the status the tag and remove requests fail with, 0 to succeed
+    tagStatus int
+    removeStatus int
+
+    //how long a load takes
+    loadDelay time.Duration
+
+    //the archives of the upstream registry by "<repository>:<tag>", and
+    //the number of the pulls from it
+    upstream map[string][]byte
+    pulls int
+
+    //the number of the image lists
+    lists int
+
+    //the image IDs having a container
+    inUse map[string]bool
+}
+
+// start the fake daemon and get the storage using it
+func newFakeDocker( t *testing.T ) (*fakeDocker, *DockerImageStorage) {
+    t.Helper()
+    fd := &fakeDocker{ images: make( map[string][]byte ), tags: make( map[string]string ), loading: make( map[string]bool ), upstream: make( map[string][]byte ), inUse: make( map[string]bool ) }
+    server := httptest.NewServer( fd )
+    t.Cleanup( server.Close )
+    client, err := docker.NewClient( server.URL )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return fd, NewDockerImageStorage( client )
+}
+
+// the ID the tag points to, empty if no such tag
+func (fd *fakeDocker) tagged( name string ) string {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    return fd.tags[normalizeImageName( name )]
+}
+
+// resolve the image name or ID, empty if there is no such image
+func (fd *fakeDocker) resolve( name string ) string {
+    if _, ok := fd.images[name]; ok {
+        return name
+    }
+    return fd.tags[normalizeImageName( name )]
+}
+
+func (fd *fakeDocker) repoTags( id string ) []string {
+    result := make( []string, 0 )
+    for tag, tag_id := range fd.tags {
+        if tag_id == id {
+            result = append( result, tag )
+        }
+    }
+    return result
+}
+
+func (fd *fakeDocker) ServeHTTP( rw http.ResponseWriter, req *http.Request ) {
+    path := req.URL.Path
+    switch {
+    case path == "/_ping":
+        rw.Write( []byte( "OK" ) )
+    case req.Method == "GET" && path == "/version":
+        rw.Write( []byte( `{"Version":"24.0.0","ApiVersion":"1.43"}` ) )
+    case req.Method == "GET" && path == "/info":
+        fd.info( rw )
+    case req.Method == "POST" && path == "/images/load":
+        fd.load( rw, req )
+    case req.Method == "POST" && path == "/images/create":
+        fd.pull( rw, req )
+    case req.Method == "GET" && path == "/images/json":
+        fd.list( rw )
+    case req.Method == "GET" && path == "/images/get":
+        fd.export( rw, req )
+    case req.Method == "GET" && path == "/containers/json":
+        fd.containers( rw, req )
+    case req.Method == "GET" && strings.HasSuffix( path, "/json" ) && strings.HasPrefix( path, "/images/" ):
+        fd.inspect( rw, strings.TrimSuffix( strings.TrimPrefix( path, "/images/" ), "/json" ) )
+    case req.Method == "POST" && strings.HasSuffix( path, "/tag" ):
+        fd.tag( rw, req, strings.TrimSuffix( strings.TrimPrefix( path, "/images/" ), "/tag" ) )
+    case req.Method == "DELETE" && strings.HasPrefix( path, "/images/" ):
+        fd.remove( rw, strings.TrimPrefix( path, "/images/" ) )
+    default:
+        http.Error( rw, "not implemented", http.StatusNotImplemented )
+    }
+}
+
+// load the archive and apply the tags of its manifest.json
+func (fd *fakeDocker) load( rw http.ResponseWriter, req *http.Request ) {
+    archive, _ := ioutil.ReadAll( req.Body )
+    manifest, err := scanForManifest( bytes.NewReader( archive ) )
+    if err != nil || len( manifest ) == 0 {
+        http.Error( rw, "invalid archive", http.StatusBadRequest )
+        return
+    }
+    id := loadedImageID( manifest, "" )
+    fd.mutex.Lock()
+    if fd.loading[id] {
+        fd.overlapped = true
+    }
+    fd.loading[id] = true
+    fd.mutex.Unlock()
+
+    time.Sleep( fd.loadDelay )
+
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    fd.images[id] = archive
+    for _, tag := range manifest[0].RepoTags {
+        fd.tags[normalizeImageName( tag )] = id
+    }
+    rw.Write( []byte( `{"status":"Loading layer","progressDetail":{"current":1,"total":1}}` + "\n" ) )
+    rw.Write( []byte( `{"stream":"Loaded image ID: ` + id + `"}` + "\n" ) )
+}
+
+// pull the image from the upstream archives and tag it as it is pulled
+func (fd *fakeDocker) pull( rw http.ResponseWriter, req *http.Request ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    name := req.URL.Query().Get( "fromImage" ) + ":" + req.URL.Query().Get( "tag" )
+    archive, ok := fd.upstream[name]
+    if !ok {
+        http.Error( rw, "manifest unknown", http.StatusNotFound )
+        return
+    }
+    fd.pulls++
+    manifest, _ := scanForManifest( bytes.NewReader( archive ) )
+    id := loadedImageID( manifest, "" )
+    fd.images[id] = archive
+    fd.tags[name] = id
+    rw.Write( []byte( `{"status":"Downloaded newer image for ` + name + `"}` + "\n" ) )
+}
+
+func (fd *fakeDocker) tag( rw http.ResponseWriter, req *http.Request, name string ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    if fd.tagStatus != 0 {
+        http.Error( rw, "conflict", fd.tagStatus )
+        return
+    }
+    id := fd.resolve( name )
+    if id == "" {
+        http.Error( rw, "no such image", http.StatusNotFound )
+        return
+    }
+    fd.tags[req.URL.Query().Get( "repo" ) + ":" + req.URL.Query().Get( "tag" )] = id
+    delete( fd.loading, id )
+    rw.WriteHeader( http.StatusCreated )
+}
+
+func (fd *fakeDocker) inspect( rw http.ResponseWriter, name string ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    id := fd.resolve( name )
+    if id == "" {
+        http.Error( rw, "no such image", http.StatusNotFound )
+        return
+    }
+    json.NewEncoder( rw ).Encode( map[string]interface{}{ "Id": id, "RepoTags": fd.repoTags( id ), "Size": len( fd.images[id] ), "Created": time.Unix( 1600000000, 0 ) } )
+}
+
+func (fd *fakeDocker) info( rw http.ResponseWriter ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    json.NewEncoder( rw ).Encode( map[string]interface{}{ "Driver": "overlay2", "OperatingSystem": "Fake Linux", "Images": len( fd.images ), "Containers": len( fd.inUse ) } )
+}
+
+func (fd *fakeDocker) list( rw http.ResponseWriter ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    fd.lists++
+    result := make( []map[string]interface{}, 0 )
+    for id, archive := range fd.images {
+        result = append( result, map[string]interface{}{ "Id": id, "RepoTags": fd.repoTags( id ), "Size": len( archive ), "Created": 1600000000 } )
+    }
+    json.NewEncoder( rw ).Encode( result )
+}
+
+// export the archive of the first name, enough for the single image gets
+func (fd *fakeDocker) export( rw http.ResponseWriter, req *http.Request ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    for _, names := range req.URL.Query()["names"] {
+        for _, name := range strings.Split( names, "," ) {
+            if id := fd.resolve( name ); id != "" {
+                rw.Write( fd.images[id] )
+                return
+            }
+        }
+    }
+    http.Error( rw, "no such image", http.StatusNotFound )
+}
+
+// list a container of every image in use, only the "ancestor" filter
+// is supported
+func (fd *fakeDocker) containers( rw http.ResponseWriter, req *http.Request ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    filters := make( map[string][]string )
+    json.Unmarshal( []byte( req.URL.Query().Get( "filters" ) ), &filters )
+    result := make( []map[string]interface{}, 0 )
+    for id := range fd.inUse {
+        for _, ancestor := range filters["ancestor"] {
+            if ancestor == id {
+                result = append( result, map[string]interface{}{ "Id": "container-" + id, "ImageID": id } )
+            }
+        }
+    }
+    json.NewEncoder( rw ).Encode( result )
+}
+
+func (fd *fakeDocker) remove( rw http.ResponseWriter, name string ) {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    if fd.removeStatus != 0 {
+        http.Error( rw, "conflict: image is being used by running container", fd.removeStatus )
+        return
+    }
+    id := fd.resolve( name )
+    if id == "" {
+        http.Error( rw, "no such image", http.StatusNotFound )
+        return
+    }
+    if _, ok := fd.images[name]; ok {
+        delete( fd.images, name )
+        for tag, tag_id := range fd.tags {
+            if tag_id == id {
+                delete( fd.tags, tag )
+            }
+        }
+    } else {
+        delete( fd.tags, normalizeImageName( name ) )
+    }
+    rw.Write( []byte( "[]" ) )
+}
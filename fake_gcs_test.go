@@ -0,0 +1,169 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "io/ioutil"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "cloud.google.com/go/storage"
+)
+
+type fakeGCSObject struct {
+    data []byte
+    attrs storage.ObjectAttrs
+
+    //the token of the bucket the object is written with
+    token string
+}
+
+// a bucket keeping its objects in memory
+type fakeGCSBucket struct {
+    mutex *sync.Mutex
+    objects map[string]*fakeGCSObject
+
+    //the token the bucket is opened with, empty for the server's, and
+    //if the client of the token is closed
+    token string
+    closed bool
+
+    //the buckets opened with the tokens of the requests
+    opened []*fakeGCSBucket
+}
+
+func newFakeGCSStorage( prefix string ) (*fakeGCSBucket, *GCSImageStorage) {
+    bucket := &fakeGCSBucket{ mutex: &sync.Mutex{}, objects: make( map[string]*fakeGCSObject ) }
+    //the bucket opened with a token is a view of the same objects
+    open_bucket := func( token string ) (gcsBucket, error) {
+        bucket.mutex.Lock()
+        defer bucket.mutex.Unlock()
+        view := &fakeGCSBucket{ mutex: bucket.mutex, objects: bucket.objects, token: token }
+        bucket.opened = append( bucket.opened, view )
+        return view, nil
+    }
+    return bucket, newGCSImageStorage( bucket, open_bucket, prefix )
+}
+
+// the object names of the bucket in sorted order
+func (fgb *fakeGCSBucket) names() []string {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    result := make( []string, 0, len( fgb.objects ) )
+    for name := range fgb.objects {
+        result = append( result, name )
+    }
+    sort.Strings( result )
+    return result
+}
+
+// the token the object is written with
+func (fgb *fakeGCSBucket) writtenWith( object string ) (string, bool) {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    if o, ok := fgb.objects[object]; ok {
+        return o.token, true
+    }
+    return "", false
+}
+
+// close the client of the bucket opened with a token
+func (fgb *fakeGCSBucket) Close() error {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    fgb.closed = true
+    return nil
+}
+
+// create the object directly in the bucket
+func (fgb *fakeGCSBucket) put( object string, data []byte ) {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    fgb.objects[object] = &fakeGCSObject{ data: data, attrs: storage.ObjectAttrs{ Name: object, Size: int64( len( data ) ), StorageClass: "STANDARD" } }
+}
+
+// the object is created when the writer is closed, like the GCS upload
+type fakeGCSWriter struct {
+    bucket *fakeGCSBucket
+    ctx context.Context
+    object string
+    attrs storage.ObjectAttrs
+    buf bytes.Buffer
+}
+
+func (fgw *fakeGCSWriter) Write( p []byte ) (int, error ) {
+    if err := fgw.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return fgw.buf.Write( p )
+}
+
+func (fgw *fakeGCSWriter) Close() error {
+    if err := fgw.ctx.Err(); err != nil {
+        return err
+    }
+    fgw.bucket.mutex.Lock()
+    defer fgw.bucket.mutex.Unlock()
+    attrs := fgw.attrs
+    attrs.Name = fgw.object
+    attrs.Size = int64( fgw.buf.Len() )
+    attrs.Created = time.Now()
+    if attrs.StorageClass == "" {
+        attrs.StorageClass = "STANDARD"
+    }
+    fgw.bucket.objects[fgw.object] = &fakeGCSObject{ data: fgw.buf.Bytes(), attrs: attrs, token: fgw.bucket.token }
+    return nil
+}
+
+func (fgb *fakeGCSBucket) NewWriter( ctx context.Context, object string, attrs storage.ObjectAttrs ) io.WriteCloser {
+    return &fakeGCSWriter{ bucket: fgb, ctx: ctx, object: object, attrs: attrs }
+}
+
+func (fgb *fakeGCSBucket) NewReader( ctx context.Context, object string ) (io.ReadCloser, error) {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    if o, ok := fgb.objects[object]; ok {
+        return ioutil.NopCloser( bytes.NewReader( o.data ) ), nil
+    }
+    return nil, storage.ErrObjectNotExist
+}
+
+func (fgb *fakeGCSBucket) Attrs( ctx context.Context, object string ) (*storage.ObjectAttrs, error) {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    if o, ok := fgb.objects[object]; ok {
+        attrs := o.attrs
+        return &attrs, nil
+    }
+    return nil, storage.ErrObjectNotExist
+}
+
+func (fgb *fakeGCSBucket) Delete( ctx context.Context, object string ) error {
+    fgb.mutex.Lock()
+    defer fgb.mutex.Unlock()
+    if _, ok := fgb.objects[object]; !ok {
+        return storage.ErrObjectNotExist
+    }
+    delete( fgb.objects, object )
+    return nil
+}
+
+func (fgb *fakeGCSBucket) List( ctx context.Context, prefix string, found func( attrs *storage.ObjectAttrs ) ) error {
+    for _, name := range fgb.names() {
+        if strings.HasPrefix( name, prefix ) {
+            attrs, err := fgb.Attrs( ctx, name )
+            if err != nil {
+                continue
+            }
+            found( attrs )
+        }
+    }
+    return nil
+}
+
+func (fgb *fakeGCSBucket) SignedURL( object string, opts *storage.SignedURLOptions ) (string, error) {
+    return "https://storage.googleapis.com/fake/" + object + "?X-Goog-Expires=" + opts.Expires.UTC().Format( time.RFC3339 ), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "time"
+)
+
+// the failure to read the image from the source URL of a fetch, rather
+// than from the client
+type fetchError struct {
+    src string
+    err error
+}
+
+func (fe *fetchError) Error() string {
+    return fmt.Sprintf( "fail to fetch image from %s: %v", fe.src, fe.err )
+}
+
+func (fe *fetchError) Unwrap() error {
+    return fe.err
+}
+
+// the status of the response to the failed fetch, 504 if the source is
+// too slow and 502 otherwise
+func (fe *fetchError) Status() int {
+    var net_err net.Error
+    if errors.As( fe.err, &net_err ) && net_err.Timeout() {
+        return http.StatusGatewayTimeout
+    }
+    return http.StatusBadGateway
+}
+
+// the body of the source URL, its read errors are reported as fetchError
+type fetchBody struct {
+    io.ReadCloser
+    src string
+}
+
+func (fb *fetchBody) Read( p []byte ) (int, error) {
+    n, err := fb.ReadCloser.Read( p )
+    if err != nil && err != io.EOF {
+        err = &fetchError{ src: fb.src, err: err }
+    }
+    return n, err
+}
+
+// let /image/save/ read the image from the URL of ?src=<url> instead of
+// the request body, the whole fetch must finish within timeout. The
+// fetch is disabled if enabled is false, as the server then requests
+// any URL given by the clients
+func (iw *ImageWeb) SetFetch( enabled bool, timeout time.Duration ) {
+    iw.fetchEnabled = enabled
+    iw.fetchClient = &http.Client{ Timeout: timeout }
+}
+
+// start the GET of the image from src. The status of the error response
+// is returned with the error if the fetch can't be started
+func (iw *ImageWeb) fetchImage( req *http.Request, src string ) (io.ReadCloser, int64, int, error) {
+    if !iw.fetchEnabled {
+        return nil, 0, http.StatusForbidden, errors.New( "fetching the image from a URL is disabled" )
+    }
+    u, err := url.Parse( src )
+    if err != nil || ( u.Scheme != "http" && u.Scheme != "https" ) || u.Host == "" {
+        return nil, 0, http.StatusBadRequest, fmt.Errorf( "invalid source URL %s, it must be an absolute http or https URL", src )
+    }
+    //the fetch is cancelled if the client goes away
+    fetch_req, err := http.NewRequestWithContext( req.Context(), "GET", u.String(), nil )
+    if err != nil {
+        return nil, 0, http.StatusBadRequest, err
+    }
+    resp, err := iw.fetchClient.Do( fetch_req )
+    if err != nil {
+        fetch_err := &fetchError{ src: src, err: err }
+        return nil, 0, fetch_err.Status(), fetch_err
+    }
+    if resp.StatusCode != http.StatusOK {
+        resp.Body.Close()
+        return nil, 0, http.StatusBadGateway, &fetchError{ src: src, err: fmt.Errorf( "the source responds %s", resp.Status ) }
+    }
+    return &fetchBody{ ReadCloser: resp.Body, src: src }, resp.ContentLength, 0, nil
+}
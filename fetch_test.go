@@ -0,0 +1,72 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+// serve the images to fetch: /image, a too large /big, a /slow one,
+// a /truncated one and nothing else
+func newImageSource( t *testing.T ) *httptest.Server {
+    t.Helper()
+    server := httptest.NewServer( http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        switch req.URL.Path {
+        case "/image":
+            rw.Write( []byte( "fetched image" ) )
+        case "/big":
+            rw.Write( []byte( strings.Repeat( "x", 100 ) ) )
+        case "/slow":
+            select {
+            case <-req.Context().Done():
+            case <-time.After( 5 * time.Second ):
+            }
+        case "/truncated":
+            rw.Header().Set( "Content-Length", "40" )
+            rw.Write( []byte( "only a part" ) )
+        default:
+            http.NotFound( rw, req )
+        }
+    }) )
+    t.Cleanup( server.Close )
+    return server
+}
+
+func TestSaveFromSource( t *testing.T ) {
+    source := newImageSource( t )
+    storage := NewMemoryImageStorage()
+    iw, handler := newTestWeb( t, storage )
+    save := func( name string, src string ) int {
+        return doRequest( handler, "POST", "/image/save/" + name + "?src=" + url.QueryEscape( src ), nil ).Code
+    }
+
+    if status := save( "app:1", source.URL + "/image" ); status != http.StatusForbidden {
+        t.Errorf( "expected 403 with the fetch disabled, got %d", status )
+    }
+    iw.SetFetch( true, 200 * time.Millisecond )
+    iw.SetMaxImageSize( 50 )
+    if status := save( "app:1", source.URL + "/image" ); status != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", status )
+    }
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil ); rw.Body.String() != "fetched image" {
+        t.Errorf( "expected the fetched image, got %q", rw.Body.String() )
+    }
+
+    for _, c := range []struct{ src string; status int }{
+                { "ftp://" + strings.TrimPrefix( source.URL, "http://" ) + "/image", http.StatusBadRequest },
+                { "/image", http.StatusBadRequest },
+                { source.URL + "/missing", http.StatusBadGateway },
+                { source.URL + "/big", http.StatusRequestEntityTooLarge },
+                { source.URL + "/slow", http.StatusGatewayTimeout },
+                { source.URL + "/truncated", http.StatusBadGateway } } {
+        if status := save( "app:2", c.src ); status != c.status {
+            t.Errorf( "%s: expected %d, got %d", c.src, c.status, status )
+        }
+        if exists, _ := storage.Exists( "app:2" ); exists {
+            t.Errorf( "%s: expected nothing to be saved", c.src )
+        }
+    }
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+)
+
+// the permissions of the directories and files of the file storage
+// when they are not set
+const (
+    defaultDirMode os.FileMode = 0755
+    defaultFileMode os.FileMode = 0644
+)
+
+func (fis *FileImageStorage) dirMode() os.FileMode {
+    if fis.DirMode == 0 {
+        return defaultDirMode
+    }
+    return fis.DirMode
+}
+
+func (fis *FileImageStorage) fileMode() os.FileMode {
+    if fis.FileMode == 0 {
+        return defaultFileMode
+    }
+    return fis.FileMode
+}
+
+// create the directory and its missing parents with DirMode. The created
+// directories are changed to DirMode after the creation, so the mode is
+// not narrowed by the umask of the process
+func (fis *FileImageStorage) mkdirAll( dir string ) error {
+    created := make( []string, 0 )
+    for d := dir; ; d = filepath.Dir( d ) {
+        if _, err := os.Stat( d ); err == nil || !os.IsNotExist( err ) {
+            break
+        }
+        created = append( created, d )
+        if filepath.Dir( d ) == d {
+            break
+        }
+    }
+    if err := os.MkdirAll( dir, fis.dirMode() ); err != nil {
+        return err
+    }
+    for _, d := range created {
+        if err := os.Chmod( d, fis.dirMode() ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// create or truncate the file with FileMode regardless of the umask, an
+// existing file is changed to FileMode too
+func (fis *FileImageStorage) createFile( file string ) (*os.File, error) {
+    f, err := os.OpenFile( file, os.O_RDWR | os.O_CREATE | os.O_TRUNC, fis.fileMode() )
+    if err != nil {
+        return nil, err
+    }
+    if err = f.Chmod( fis.fileMode() ); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+// write the small file like a sidecar with FileMode
+func (fis *FileImageStorage) writeSmallFile( file string, b []byte ) error {
+    f, err := fis.createFile( file )
+    if err != nil {
+        return err
+    }
+    _, err = f.Write( b )
+    if close_err := f.Close(); err == nil {
+        err = close_err
+    }
+    return err
+}
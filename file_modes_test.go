@@ -0,0 +1,49 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+// check the mode of every directory and file the storage created under dir
+func checkModes( t *testing.T, dir string, dir_mode os.FileMode, file_mode os.FileMode ) {
+    t.Helper()
+    filepath.Walk( dir, func( path string, info os.FileInfo, err error ) error {
+        if err != nil || path == dir {
+            return err
+        }
+        expected := file_mode
+        if info.IsDir() {
+            expected = dir_mode
+        }
+        if info.Mode().Perm() != expected {
+            t.Errorf( "expected %s to have mode %v, got %v", path, expected, info.Mode().Perm() )
+        }
+        return nil
+    })
+}
+
+func TestFileStorageModes( t *testing.T ) {
+    //the modes are applied regardless of the umask
+    defer syscall.Umask( syscall.Umask( 0077 ) )
+    for _, c := range []struct{ dirMode os.FileMode; fileMode os.FileMode; expectedDir os.FileMode; expectedFile os.FileMode; dedup bool }{
+                { 0, 0, 0755, 0644, false },
+                { 0770, 0660, 0770, 0660, false },
+                { 0750, 0640, 0750, 0640, true } } {
+        dir := t.TempDir()
+        storage, err := NewFileImageStorage( dir, c.dirMode, c.fileMode )
+        if err != nil {
+            t.Fatal( err )
+        }
+        storage.Dedup = c.dedup
+        for _, name := range []string{ "team/app:1", "team/app:2", "other:1" } {
+            if err = storage.Write( name, strings.NewReader( "image" ) ); err != nil {
+                t.Fatal( err )
+            }
+        }
+        checkModes( t, dir, c.expectedDir, c.expectedFile )
+    }
+}
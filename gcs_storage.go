@@ -0,0 +1,296 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+
+    "cloud.google.com/go/storage"
+    "golang.org/x/oauth2"
+    "google.golang.org/api/iterator"
+    "google.golang.org/api/option"
+)
+
+// the operations of a bucket used by the GCS storage, so the storage
+// can be tested without a bucket
+type gcsBucket interface {
+    // create the object with the attributes from what is written to the
+    // writer when it is closed, nothing is created if ctx is canceled
+    NewWriter( ctx context.Context, object string, attrs storage.ObjectAttrs ) io.WriteCloser
+
+    NewReader( ctx context.Context, object string ) (io.ReadCloser, error)
+    Attrs( ctx context.Context, object string ) (*storage.ObjectAttrs, error)
+    Delete( ctx context.Context, object string ) error
+
+    // call found with the attributes of every object starting with prefix
+    List( ctx context.Context, prefix string, found func( attrs *storage.ObjectAttrs ) ) error
+
+    SignedURL( object string, opts *storage.SignedURLOptions ) (string, error)
+}
+
+// the bucket accessed by the GCS client
+type gcsBucketHandle struct {
+    client *storage.Client
+    bucket *storage.BucketHandle
+}
+
+// open the bucket with the client created by the options
+func newGCSBucketHandle( bucket string, opts ...option.ClientOption ) (*gcsBucketHandle, error) {
+    client, err := storage.NewClient( context.Background(), opts... )
+    if err != nil {
+        return nil, err
+    }
+    return &gcsBucketHandle{ client: client, bucket: client.Bucket( bucket ) }, nil
+}
+
+func (gbh *gcsBucketHandle) Close() error {
+    return gbh.client.Close()
+}
+
+func (gbh *gcsBucketHandle) NewWriter( ctx context.Context, object string, attrs storage.ObjectAttrs ) io.WriteCloser {
+    writer := gbh.bucket.Object( object ).NewWriter( ctx )
+    writer.ContentType = attrs.ContentType
+    writer.StorageClass = attrs.StorageClass
+    return writer
+}
+
+func (gbh *gcsBucketHandle) NewReader( ctx context.Context, object string ) (io.ReadCloser, error) {
+    return gbh.bucket.Object( object ).NewReader( ctx )
+}
+
+func (gbh *gcsBucketHandle) Attrs( ctx context.Context, object string ) (*storage.ObjectAttrs, error) {
+    return gbh.bucket.Object( object ).Attrs( ctx )
+}
+
+func (gbh *gcsBucketHandle) Delete( ctx context.Context, object string ) error {
+    return gbh.bucket.Object( object ).Delete( ctx )
+}
+
+func (gbh *gcsBucketHandle) List( ctx context.Context, prefix string, found func( attrs *storage.ObjectAttrs ) ) error {
+    query := &storage.Query{ Prefix: prefix }
+    query.SetAttrSelection( []string{ "Name", "Size", "Created", "StorageClass" } )
+    objects := gbh.bucket.Objects( ctx, query )
+    for {
+        attrs, err := objects.Next()
+        if err == iterator.Done {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+        found( attrs )
+    }
+}
+
+func (gbh *gcsBucketHandle) SignedURL( object string, opts *storage.SignedURLOptions ) (string, error) {
+    return gbh.bucket.SignedURL( object, opts )
+}
+
+// store the images as the objects "<prefix>/<name>/<tag>" of a Google
+// Cloud Storage bucket. The images are streamed to and from the bucket
+// and the list is built by enumerating the objects
+type GCSImageStorage struct {
+    bucket gcsBucket
+
+    //open the bucket with the OAuth2 access token of a request
+    openBucket func( token string ) (gcsBucket, error)
+
+    //the object path prefix of the images, empty for the bucket root
+    prefix string
+
+    //the content type of the objects, it is detected if it is empty
+    contentType string
+
+    //the storage class of the written objects, the bucket's default
+    //class if it is empty
+    storageClass string
+}
+
+// the storage classes of the GCS objects. The ARCHIVE objects are still
+// online in GCS, so unlike an archive tier they are pulled without a
+// restore
+var gcsStorageClasses = []string{ "STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE" }
+
+// create the storage of the bucket with the application default credentials
+func NewGCSImageStorage( bucket, prefix string ) (*GCSImageStorage, error) {
+    handle, err := newGCSBucketHandle( bucket )
+    if err != nil {
+        return nil, err
+    }
+    open_bucket := func( token string ) (gcsBucket, error) {
+        return newGCSBucketHandle( bucket, option.WithTokenSource( oauth2.StaticTokenSource( &oauth2.Token{ AccessToken: token } ) ) )
+    }
+    return newGCSImageStorage( handle, open_bucket, prefix ), nil
+}
+
+func newGCSImageStorage( bucket gcsBucket, open_bucket func( token string ) (gcsBucket, error), prefix string ) *GCSImageStorage {
+    return &GCSImageStorage{ bucket: bucket, openBucket: open_bucket, prefix: strings.Trim( prefix, "/" ) }
+}
+
+// get the storage accessing the bucket with the OAuth2 access token of a
+// request, e.g. a short-lived token issued by the security token service
+func (gis *GCSImageStorage) WithCredentials( token string ) (ImageStorage, error) {
+    bucket, err := gis.openBucket( token )
+    if err != nil {
+        return nil, err
+    }
+    return &GCSImageStorage{ bucket: bucket, prefix: gis.prefix, contentType: gis.contentType }, nil
+}
+
+// close the client of the storage created for a request
+func (gis *GCSImageStorage) Close() error {
+    if closer, ok := gis.bucket.(io.Closer); ok && gis.openBucket == nil {
+        return closer.Close()
+    }
+    return nil
+}
+
+// get the storage writing the objects in the storage class
+func (gis *GCSImageStorage) WithStorageClass( class string ) (ImageStorage, error) {
+    for _, known := range gcsStorageClasses {
+        if strings.EqualFold( class, known ) {
+            class_storage := *gis
+            class_storage.storageClass = known
+            return &class_storage, nil
+        }
+    }
+    return nil, fmt.Errorf( "%w: %s is not one of %s", ErrInvalidStorageClass, class, strings.Join( gcsStorageClasses, ", " ) )
+}
+
+// set the content type of the written objects instead of detecting it
+func (gis *GCSImageStorage) SetContentType( content_type string ) {
+    gis.contentType = content_type
+}
+
+func (gis *GCSImageStorage) objectName( name string ) (string, error) {
+    return imageObjectName( gis.prefix, name )
+}
+
+// map the missing object to ErrNotFound
+func gcsError( name string, err error ) error {
+    if errors.Is( err, storage.ErrObjectNotExist ) {
+        return fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    return err
+}
+
+// the object is only created when the whole image is uploaded, a failed
+// upload leaves the previous object untouched
+func (gis *GCSImageStorage) Write( name string, reader io.Reader ) error {
+    object, err := gis.objectName( name )
+    if err != nil {
+        return err
+    }
+    ctx, cancel := context.WithCancel( context.Background() )
+    defer cancel()
+
+    content_type, reader := objectContentType( reader, gis.contentType )
+    writer := gis.bucket.NewWriter( ctx, object, storage.ObjectAttrs{ ContentType: content_type, StorageClass: gis.storageClass } )
+    if _, err = io.Copy( writer, reader ); err != nil {
+        //cancel the upload before closing so the partial object is dropped
+        cancel()
+        writer.Close()
+        return err
+    }
+    return writer.Close()
+}
+
+func (gis *GCSImageStorage) Get( name string, writer io.Writer ) error {
+    object, err := gis.objectName( name )
+    if err != nil {
+        return err
+    }
+    reader, err := gis.bucket.NewReader( context.Background(), object )
+    if err != nil {
+        return gcsError( name, err )
+    }
+    defer reader.Close()
+    _, err = io.Copy( writer, reader )
+    return err
+}
+
+func (gis *GCSImageStorage) Delete( name string ) error {
+    object, err := gis.objectName( name )
+    if err != nil {
+        return err
+    }
+    return gcsError( name, gis.bucket.Delete( context.Background(), object ) )
+}
+
+// rebuild the "<name>:<tag>" of every object under the prefix
+func (gis *GCSImageStorage) List() ([]string, error) {
+    prefix := ""
+    if gis.prefix != "" {
+        prefix = gis.prefix + "/"
+    }
+    result := make( []string, 0 )
+    err := gis.bucket.List( context.Background(), prefix, func( attrs *storage.ObjectAttrs ) {
+        if name, ok := objectImageName( gis.prefix, attrs.Name ); ok {
+            result = append( result, name )
+        }
+    } )
+    if err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// the size of the object, without downloading it
+func (gis *GCSImageStorage) Size( name string ) (int64, bool, error) {
+    object, err := gis.objectName( name )
+    if err != nil {
+        return 0, false, err
+    }
+    attrs, err := gis.bucket.Attrs( context.Background(), object )
+    if err != nil {
+        return 0, false, gcsError( name, err )
+    }
+    return attrs.Size, true, nil
+}
+
+// list the size, creation time and storage class of every image with
+// the objects
+func (gis *GCSImageStorage) ListDetailed() ([]ImageInfo, error) {
+    prefix := ""
+    if gis.prefix != "" {
+        prefix = gis.prefix + "/"
+    }
+    infos := make( []ImageInfo, 0 )
+    err := gis.bucket.List( context.Background(), prefix, func( attrs *storage.ObjectAttrs ) {
+        name, ok := objectImageName( gis.prefix, attrs.Name )
+        if !ok {
+            return
+        }
+        info := ImageInfo{ Name: name, Size: attrs.Size, RefCount: 1, StorageClass: attrs.StorageClass }
+        info.Repository, info.Tag = parseImageName( name )
+        if !attrs.Created.IsZero() {
+            created := attrs.Created
+            info.Created = &created
+        }
+        infos = append( infos, info )
+    } )
+    if err != nil {
+        return nil, err
+    }
+    return infos, nil
+}
+
+// sign a V4 URL to GET the object directly from the bucket, the signing
+// credentials are detected like for the client. ErrNotFound if the image
+// doesn't exist, so no URL of a missing object is handed out
+func (gis *GCSImageStorage) PresignURL( name string, expires time.Duration ) (string, error) {
+    object, err := gis.objectName( name )
+    if err != nil {
+        return "", err
+    }
+    if _, err = gis.bucket.Attrs( context.Background(), object ); err != nil {
+        return "", gcsError( name, err )
+    }
+    return gis.bucket.SignedURL( object, &storage.SignedURLOptions{
+                Scheme: storage.SigningSchemeV4,
+                Method: "GET",
+                Expires: time.Now().Add( expires ) } )
+}
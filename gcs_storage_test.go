@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestGCSStorage( t *testing.T ) {
+    bucket, storage := newFakeGCSStorage( "/prod/" )
+    if err := storage.Write( "team/app:1", strings.NewReader( "image" ) ); err != nil {
+        t.Fatal( err )
+    }
+    if names := bucket.names(); len( names ) != 1 || names[0] != "prod/team/app/1" {
+        t.Errorf( "expected the object of team/app:1 under the prefix, got %v", names )
+    }
+    //the objects outside the prefix are not images of the storage
+    bucket.put( "other/app/1", []byte( "image" ) )
+    if names, err := storage.List(); err != nil || len( names ) != 1 || names[0] != "team/app:1" {
+        t.Errorf( "expected team/app:1 to be listed, got %v: %v", names, err )
+    }
+    if size, ok, err := storage.Size( "team/app:1" ); err != nil || !ok || size != 5 {
+        t.Errorf( "expected the size 5, got %d, %v: %v", size, ok, err )
+    }
+    if exists, err := storage.Exists( "team/app:1" ); err != nil || !exists {
+        t.Errorf( "expected team/app:1 to exist: %v", err )
+    }
+    if signed, err := storage.PresignURL( "team/app:1", time.Minute ); err != nil || !strings.Contains( signed, "prod/team/app/1" ) {
+        t.Errorf( "expected the signed URL of the object, got %s: %v", signed, err )
+    }
+
+    //the failed upload leaves the object as it was
+    broken := &errorAfterReader{ r: strings.NewReader( "new" ), err: io.ErrUnexpectedEOF }
+    if err := storage.Write( "team/app:1", broken ); err == nil {
+        t.Fatal( "expected the broken upload to fail" )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "team/app:1", &b ); err != nil || b.String() != "image" {
+        t.Errorf( "expected the image to be kept, got %q: %v", b.String(), err )
+    }
+}
+
+// the missing objects are reported as ErrNotFound
+func TestGCSStorageNotFound( t *testing.T ) {
+    _, storage := newFakeGCSStorage( "" )
+    var b bytes.Buffer
+    if err := storage.Get( "app:1", &b ); !isNotFound( err ) {
+        t.Errorf( "expected not found on get, got %v", err )
+    }
+    if err := storage.Delete( "app:1" ); !isNotFound( err ) {
+        t.Errorf( "expected not found on delete, got %v", err )
+    }
+    if _, _, err := storage.Size( "app:1" ); !isNotFound( err ) {
+        t.Errorf( "expected not found on size, got %v", err )
+    }
+    if _, err := storage.PresignURL( "app:1", time.Minute ); !isNotFound( err ) {
+        t.Errorf( "expected not found on presign, got %v", err )
+    }
+    if exists, err := storage.Exists( "app:1" ); err != nil || exists {
+        t.Errorf( "expected app:1 to not exist, got %v: %v", exists, err )
+    }
+}
+
+// the content type of the object is detected from the image unless it
+// is overridden
+func TestGCSContentType( t *testing.T ) {
+    bucket, storage := newFakeGCSStorage( "" )
+    archive := makeImageArchive( t, "app", "app:1" )
+    for name, content := range map[string][]byte{ "app:1": archive, "app:2": gzipBytes( t, archive ) } {
+        if err := storage.Write( name, bytes.NewReader( content ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    storage.SetContentType( "application/octet-stream" )
+    storage.Write( "app:3", bytes.NewReader( archive ) )
+
+    for object, expected := range map[string]string{ "app/1": "application/x-tar",
+                "app/2": "application/gzip",
+                "app/3": "application/octet-stream" } {
+        if attrs, err := bucket.Attrs( context.Background(), object ); err != nil || attrs.ContentType != expected {
+            t.Errorf( "expected the content type %s of %s, got %v: %v", expected, object, attrs, err )
+        }
+    }
+}
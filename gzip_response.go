@@ -0,0 +1,64 @@
+package main
+
+import (
+    "compress/gzip"
+    "net/http"
+    "strings"
+)
+
+// check if the client accepts the gzip encoded response
+func acceptsGzip( req *http.Request ) bool {
+    for _, part := range strings.Split( req.Header.Get( "Accept-Encoding" ), "," ) {
+        fields := strings.Split( part, ";" )
+        if strings.TrimSpace( fields[0] ) != "gzip" {
+            continue
+        }
+        for _, param := range fields[1:] {
+            if q := strings.TrimSpace( param ); q == "q=0" || q == "q=0.0" || q == "q=0.00" || q == "q=0.000" {
+                return false
+            }
+        }
+        return true
+    }
+    return false
+}
+
+// gzip the response body, the Content-Encoding header is set when the
+// status is sent so the error responses are encoded consistently
+type gzipResponseWriter struct {
+    http.ResponseWriter
+    gz *gzip.Writer
+    wroteHeader bool
+}
+
+func newGzipResponseWriter( rw http.ResponseWriter ) *gzipResponseWriter {
+    rw.Header().Add( "Vary", "Accept-Encoding" )
+    return &gzipResponseWriter{ ResponseWriter: rw, gz: gzip.NewWriter( rw ) }
+}
+
+func (grw *gzipResponseWriter) WriteHeader( status int ) {
+    if grw.wroteHeader {
+        return
+    }
+    grw.wroteHeader = true
+    grw.Header().Set( "Content-Encoding", "gzip" )
+    //the length of the encoded body is unknown
+    grw.Header().Del( "Content-Length" )
+    grw.ResponseWriter.WriteHeader( status )
+}
+
+func (grw *gzipResponseWriter) Write( p []byte ) (int, error) {
+    if !grw.wroteHeader {
+        grw.WriteHeader( http.StatusOK )
+    }
+    return grw.gz.Write( p )
+}
+
+// flush and finish the gzip stream. It must not be called if the
+// response is aborted, otherwise the truncated body looks complete
+func (grw *gzipResponseWriter) Close() error {
+    if !grw.wroteHeader {
+        grw.WriteHeader( http.StatusOK )
+    }
+    return grw.gz.Close()
+}
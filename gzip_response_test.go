@@ -0,0 +1,53 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestAcceptsGzip( t *testing.T ) {
+    for accept, expected := range map[string]bool{
+                "": false,
+                "gzip": true,
+                "deflate, gzip;q=0.5": true,
+                "gzip;q=0": false,
+                "br": false,
+                "x-gzip": false } {
+        req := httptest.NewRequest( "GET", "/", nil )
+        req.Header.Set( "Accept-Encoding", accept )
+        if acceptsGzip( req ) != expected {
+            t.Errorf( "expected %v for %q", expected, accept )
+        }
+    }
+}
+
+func TestGetGzipped( t *testing.T ) {
+    storage := newFileStorage( t )
+    archive := makeImageArchive( t, "app", "app:1" )
+    storage.Write( "app:1", bytes.NewReader( archive ) )
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Accept-Encoding", "gzip" )
+    if rw.Code != http.StatusOK || rw.Header().Get( "Content-Encoding" ) != "gzip" || rw.Header().Get( "Content-Length" ) != "" {
+        t.Fatalf( "expected the gzip encoded image, got %d %v", rw.Code, rw.Header() )
+    }
+    gz, err := gzip.NewReader( rw.Body )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if b, err := ioutil.ReadAll( gz ); err != nil || !bytes.Equal( b, archive ) {
+        t.Errorf( "expected the decoded image, got %d bytes: %v", len( b ), err )
+    }
+
+    rw = doRequest( handler, "GET", "/image/get/app:1", nil, "Accept-Encoding", "gzip;q=0" )
+    if rw.Header().Get( "Content-Encoding" ) != "" || !bytes.Equal( rw.Body.Bytes(), archive ) {
+        t.Errorf( "expected the image not encoded, got %v", rw.Header() )
+    }
+    if rw = doRequest( handler, "GET", "/image/get/app:2", nil, "Accept-Encoding", "gzip" ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
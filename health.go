@@ -0,0 +1,79 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+)
+
+func (dis *DockerImageStorage) CheckHealth() error {
+    return dis.client.Ping()
+}
+
+// the storage is healthy if all the daemons are reachable
+func (mdis *MultiDockerImageStorage) CheckHealth() error {
+    mdis.mutex.Lock()
+    daemons := append( []*dockerDaemon{}, mdis.daemons... )
+    mdis.mutex.Unlock()
+    for _, daemon := range daemons {
+        if err := daemon.storage.CheckHealth(); err != nil {
+            return fmt.Errorf( "docker daemon %s: %v", daemon.endpoint, err )
+        }
+    }
+    return nil
+}
+
+func (mis *MongoImageStorage) CheckHealth() error {
+    session, err := mis.copySession()
+    if err != nil {
+        return err
+    }
+    defer session.Close()
+    return session.Ping()
+}
+
+func checkDirHealth( dir string ) error {
+    fi, err := os.Stat( dir )
+    if err != nil {
+        return err
+    }
+    if !fi.IsDir() {
+        return fmt.Errorf( "%s is not a directory", dir )
+    }
+    return nil
+}
+
+func (fis *FileImageStorage) CheckHealth() error {
+    return checkDirHealth( fis.Dir )
+}
+
+func (lis *LayeredImageStorage) CheckHealth() error {
+    return checkDirHealth( lis.Dir )
+}
+
+// the local storage serves the images, the upstream is only a fallback
+func (mis *MirrorImageStorage) CheckHealth() error {
+    if checker, ok := mis.ImageStorage.(HealthChecker); ok {
+        return checker.CheckHealth()
+    }
+    return nil
+}
+
+func (iw *ImageWeb) initHealth() {
+    http.HandleFunc("/health", func(rw http.ResponseWriter, req *http.Request) {
+        //the storage without a health check is assumed healthy
+        var err error
+        if checker, ok := iw.image_storage.(HealthChecker); ok {
+            err = checker.CheckHealth()
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        rw.Header().Set( "Cache-Control", "no-store" )
+        if err != nil {
+            rw.WriteHeader( http.StatusServiceUnavailable )
+            json.NewEncoder( rw ).Encode( map[string]string{ "status": "unhealthy", "error": err.Error() } )
+            return
+        }
+        json.NewEncoder( rw ).Encode( map[string]string{ "status": "ok" } )
+    })
+}
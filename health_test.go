@@ -0,0 +1,73 @@
+package main
+
+import (
+    "errors"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// a storage reporting the health err
+type fakeHealthStorage struct {
+    ImageStorage
+    err error
+}
+
+func (fhs *fakeHealthStorage) CheckHealth() error {
+    return fhs.err
+}
+
+func TestHealth( t *testing.T ) {
+    storage := &fakeHealthStorage{ ImageStorage: newFileStorage( t ) }
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "GET", "/health", nil )
+    if rw.Code != http.StatusOK || !strings.Contains( rw.Body.String(), `"status":"ok"` ) {
+        t.Errorf( "expected the healthy status, got %d %s", rw.Code, rw.Body.String() )
+    }
+    storage.err = errors.New( "connection refused" )
+    rw = doRequest( handler, "GET", "/health", nil )
+    if rw.Code != http.StatusServiceUnavailable || !strings.Contains( rw.Body.String(), "connection refused" ) {
+        t.Errorf( "expected the unhealthy status, got %d %s", rw.Code, rw.Body.String() )
+    }
+}
+
+func TestHealthWithoutChecker( t *testing.T ) {
+    //hide the check of the file storage
+    _, handler := newTestWeb( t, struct{ ImageStorage }{ newFileStorage( t ) } )
+    if rw := doRequest( handler, "GET", "/health", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the storage without a check to be healthy, got %d", rw.Code )
+    }
+}
+
+func TestFileStorageHealth( t *testing.T ) {
+    dir := filepath.Join( t.TempDir(), "images" )
+    storage, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if err = storage.CheckHealth(); err != nil {
+        t.Errorf( "expected the directory to be healthy: %v", err )
+    }
+    os.RemoveAll( dir )
+    if err = storage.CheckHealth(); err == nil {
+        t.Errorf( "expected the removed directory to be unhealthy" )
+    }
+}
+
+func TestDockerStorageHealth( t *testing.T ) {
+    _, storage := newFakeDocker( t )
+    if err := storage.CheckHealth(); err != nil {
+        t.Errorf( "expected the daemon to be healthy: %v", err )
+    }
+    client, err := docker.NewClient( "http://" + freeAddr( t ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if err = NewDockerImageStorage( client ).CheckHealth(); err == nil {
+        t.Errorf( "expected the unreachable daemon to be unhealthy" )
+    }
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "path"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// build a docker-save archive of one image tagged with tags, the layer
+// holds content so the archives of different contents differ
+func makeImageArchive( t *testing.T, content string, tags ...string ) []byte {
+    t.Helper()
+    return buildImageArchive( t, content, false, tags )
+}
+
+// build the archive as docker 25 and later save it: the config and the
+// layer are the blobs of an OCI layout named by their digests
+func makeOCIImageArchive( t *testing.T, content string, tags ...string ) []byte {
+    t.Helper()
+    return buildImageArchive( t, content, true, tags )
+}
+
+func buildImageArchive( t *testing.T, content string, oci_layout bool, tags []string ) []byte {
+    t.Helper()
+    layer := &bytes.Buffer{}
+    tw := tar.NewWriter( layer )
+    writeTarFile( t, tw, "content.txt", []byte( content ) )
+    tw.Close()
+    layer_sum := sha256.Sum256( layer.Bytes() )
+
+    config, _ := json.Marshal( map[string]interface{}{
+                "architecture": "amd64",
+                "os": "linux",
+                "rootfs": map[string]interface{}{ "type": "layers", "diff_ids": []string{ "sha256:" + hex.EncodeToString( layer_sum[:] ) } } } )
+    config_sum := sha256.Sum256( config )
+    config_name := hex.EncodeToString( config_sum[:] ) + ".json"
+    layer_name := "layer/layer.tar"
+    if oci_layout {
+        config_name = "blobs/sha256/" + hex.EncodeToString( config_sum[:] )
+        layer_name = "blobs/sha256/" + hex.EncodeToString( layer_sum[:] )
+    }
+
+    manifest, _ := json.Marshal( []dockerSaveManifest{ { Config: config_name, RepoTags: tags, Layers: []string{ layer_name } } } )
+
+    archive := &bytes.Buffer{}
+    tw = tar.NewWriter( archive )
+    if oci_layout {
+        writeTarFile( t, tw, "oci-layout", []byte( `{"imageLayoutVersion":"1.0.0"}` ) )
+    }
+    writeTarFile( t, tw, config_name, config )
+    writeTarFile( t, tw, layer_name, layer.Bytes() )
+    writeTarFile( t, tw, "manifest.json", manifest )
+    if err := tw.Close(); err != nil {
+        t.Fatal( err )
+    }
+    return archive.Bytes()
+}
+
+// the image ID "sha256:<hex>" of the archive built by makeImageArchive
+func archiveImageID( t *testing.T, archive []byte ) string {
+    t.Helper()
+    manifest, err := scanForManifest( bytes.NewReader( archive ) )
+    if err != nil || len( manifest ) != 1 {
+        t.Fatalf( "invalid test archive: %v", err )
+    }
+    return "sha256:" + strings.TrimSuffix( path.Base( manifest[0].Config ), ".json" )
+}
+
+// gzip the content
+func gzipBytes( t *testing.T, content []byte ) []byte {
+    t.Helper()
+    var b bytes.Buffer
+    gw := gzip.NewWriter( &b )
+    if _, err := gw.Write( content ); err != nil {
+        t.Fatal( err )
+    }
+    if err := gw.Close(); err != nil {
+        t.Fatal( err )
+    }
+    return b.Bytes()
+}
+
+func writeTarFile( t *testing.T, tw *tar.Writer, name string, content []byte ) {
+    t.Helper()
+    if err := tw.WriteHeader( &tar.Header{ Name: name, Mode: 0644, Size: int64( len( content ) ), Typeflag: tar.TypeReg } ); err != nil {
+        t.Fatal( err )
+    }
+    if _, err := tw.Write( content ); err != nil {
+        t.Fatal( err )
+    }
+}
+
+// create the web of storage with the handlers registered on a fresh
+// default mux, as every ImageWeb registers its handlers there
+func newTestWeb( t *testing.T, storage ImageStorage ) (*ImageWeb, http.Handler) {
+    t.Helper()
+    saved := http.DefaultServeMux
+    http.DefaultServeMux = http.NewServeMux()
+    t.Cleanup( func() { http.DefaultServeMux = saved } )
+    iw := NewImageWeb( storage )
+    iw.SetLogFormat( LogFormatNone )
+    return iw, iw.server.Handler
+}
+
+// send the request to handler and get the recorded response
+func doRequest( handler http.Handler, method string, url string, body io.Reader, headers ...string ) *httptest.ResponseRecorder {
+    req := httptest.NewRequest( method, url, body )
+    for i := 0; i + 1 < len( headers ); i += 2 {
+        req.Header.Set( headers[i], headers[i+1] )
+    }
+    rw := httptest.NewRecorder()
+    handler.ServeHTTP( rw, req )
+    return rw
+}
+
+// read the whole body of the response
+func responseBody( t *testing.T, rw *httptest.ResponseRecorder ) string {
+    t.Helper()
+    b, err := ioutil.ReadAll( rw.Result().Body )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return string( b )
+}
+
+// wrap a storage and count the calls reaching it
+type countingStorage struct {
+    ImageStorage
+
+    mutex sync.Mutex
+    calls []string
+}
+
+func (cs *countingStorage) count( call string ) {
+    cs.mutex.Lock()
+    cs.calls = append( cs.calls, call )
+    cs.mutex.Unlock()
+}
+
+// the number of the calls reaching the storage
+func (cs *countingStorage) called( call string ) int {
+    cs.mutex.Lock()
+    defer cs.mutex.Unlock()
+    n := 0
+    for _, c := range cs.calls {
+        if c == call {
+            n++
+        }
+    }
+    return n
+}
+
+func (cs *countingStorage) Write( name string, reader io.Reader ) error {
+    cs.count( "Write" )
+    return cs.ImageStorage.Write( name, reader )
+}
+
+func (cs *countingStorage) Get( name string, writer io.Writer ) error {
+    cs.count( "Get" )
+    return cs.ImageStorage.Get( name, writer )
+}
+
+func (cs *countingStorage) Delete( name string ) error {
+    cs.count( "Delete" )
+    return cs.ImageStorage.Delete( name )
+}
+
+func (cs *countingStorage) List() ([]string, error) {
+    cs.count( "List" )
+    return cs.ImageStorage.List()
+}
+
+func (cs *countingStorage) Exists( name string ) (bool, error) {
+    cs.count( "Exists" )
+    return storageExists( cs.ImageStorage, name )
+}
+
+// wrap a storage so its Get fails after the first successful gets
+type failingStorage struct {
+    ImageStorage
+
+    mutex sync.Mutex
+    gets int
+    failAfter int
+}
+
+func (fs *failingStorage) Get( name string, writer io.Writer ) error {
+    fs.mutex.Lock()
+    fs.gets++
+    fail := fs.gets > fs.failAfter
+    fs.mutex.Unlock()
+    if fail {
+        return fmt.Errorf( "storage failure" )
+    }
+    return fs.ImageStorage.Get( name, writer )
+}
+
+// a response writer whose writes fail after limit bytes
+type failingResponseWriter struct {
+    *httptest.ResponseRecorder
+    limit int
+}
+
+func (frw *failingResponseWriter) Write( p []byte ) (int, error) {
+    if frw.Body.Len() + len( p ) > frw.limit {
+        return 0, fmt.Errorf( "connection reset" )
+    }
+    return frw.ResponseRecorder.Write( p )
+}
+
+// call the handler and get what it panics with
+func servePanic( handler http.Handler, rw http.ResponseWriter, req *http.Request ) (recovered interface{}) {
+    defer func() {
+        recovered = recover()
+    }()
+    handler.ServeHTTP( rw, req )
+    return nil
+}
+
+// create a file storage in a temporary directory of the test
+func newFileStorage( t *testing.T ) *FileImageStorage {
+    t.Helper()
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    return storage
+}
+
+// get the body of the URL
+func httpGet( url string ) ([]byte, error) {
+    resp, err := http.Get( url )
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf( "GET %s: %s", url, resp.Status )
+    }
+    return ioutil.ReadAll( resp.Body )
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+type idempotentResult struct {
+    //the response body returned for the key
+    body string
+
+    //closed when the request of the key is finished
+    done chan struct{}
+
+    //the result is forgotten after this time
+    expires time.Time
+}
+
+// remember the result of the recent requests by their Idempotency-Key
+// so a retried request gets the same result without doing the work again
+type IdempotencyCache struct {
+    mutex sync.Mutex
+
+    //how long a result is remembered, 0 to disable the cache
+    window time.Duration
+
+    results map[string]*idempotentResult
+}
+
+func NewIdempotencyCache( window time.Duration ) *IdempotencyCache {
+    return &IdempotencyCache{ window: window,
+                results: make( map[string]*idempotentResult ) }
+}
+
+func (ic *IdempotencyCache)SetWindow( window time.Duration ) {
+    ic.mutex.Lock()
+    defer ic.mutex.Unlock()
+    ic.window = window
+}
+
+// start the request of key. If the request of key is done already or
+// is running, its result is returned with true after it is finished.
+// Otherwise the key is recorded as running and the caller must do the
+// request and call Finish with its result
+func (ic *IdempotencyCache)Start( ctx context.Context, key string ) (string, bool, error) {
+    for {
+        ic.mutex.Lock()
+        if ic.window <= 0 {
+            ic.mutex.Unlock()
+            return "", false, nil
+        }
+        ic.purge( time.Now() )
+        result, ok := ic.results[key]
+        if !ok {
+            ic.results[key] = &idempotentResult{ done: make( chan struct{} ) }
+            ic.mutex.Unlock()
+            return "", false, nil
+        }
+        ic.mutex.Unlock()
+
+        select {
+        case <-result.done:
+        case <-ctx.Done():
+            return "", false, ctx.Err()
+        }
+        ic.mutex.Lock()
+        finished := ic.results[key] == result
+        ic.mutex.Unlock()
+        if finished {
+            return result.body, true, nil
+        }
+        //the running request failed, so it is done by this one
+    }
+}
+
+// finish the request of key started by Start. The result body is
+// remembered for the configured window if the request succeeded,
+// otherwise the key is cleared so it can be retried
+func (ic *IdempotencyCache)Finish( key string, body string, succeeded bool ) {
+    ic.mutex.Lock()
+    defer ic.mutex.Unlock()
+
+    result, ok := ic.results[key]
+    if !ok || isClosed( result.done ) {
+        return
+    }
+    if succeeded {
+        result.body = body
+        result.expires = time.Now().Add( ic.window )
+    } else {
+        delete( ic.results, key )
+    }
+    close( result.done )
+}
+
+// remove the expired results
+func (ic *IdempotencyCache)purge( now time.Time ) {
+    for key, result := range ic.results {
+        if isClosed( result.done ) && now.After( result.expires ) {
+            delete( ic.results, key )
+        }
+    }
+}
+
+func isClosed( done chan struct{} ) bool {
+    select {
+    case <-done:
+        return true
+    default:
+        return false
+    }
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "net/http"
+    "testing"
+    "time"
+)
+
+func TestIdempotentSave( t *testing.T ) {
+    storage := &countingStorage{ ImageStorage: newFileStorage( t ) }
+    iw, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "idempotent", "app:1" )
+
+    for i := 0; i < 2; i++ {
+        rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Idempotency-Key", "build-1" )
+        if rw.Code != http.StatusCreated {
+            t.Fatalf( "expected 201 for the save %d, got %d", i, rw.Code )
+        }
+    }
+    if writes := storage.called( "Write" ); writes != 1 {
+        t.Errorf( "expected the repeated key to be written once, got %d writes", writes )
+    }
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Idempotency-Key", "build-2" )
+    if rw.Code != http.StatusCreated || storage.called( "Write" ) != 2 {
+        t.Errorf( "expected another key to be written again, got %d and %d writes", rw.Code, storage.called( "Write" ) )
+    }
+
+    iw.SetIdempotencyWindow( 0 )
+    doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Idempotency-Key", "build-3" )
+    doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Idempotency-Key", "build-3" )
+    if writes := storage.called( "Write" ); writes != 4 {
+        t.Errorf( "expected every save to be written with the cache disabled, got %d writes", writes )
+    }
+}
+
+func TestIdempotencyCacheExpires( t *testing.T ) {
+    ic := NewIdempotencyCache( 20 * time.Millisecond )
+    if _, ok, _ := ic.Start( context.Background(), "key" ); ok {
+        t.Fatal( "expected a new key to be started" )
+    }
+    ic.Finish( "key", "done", true )
+    if body, ok, _ := ic.Start( context.Background(), "key" ); !ok || body != "done" {
+        t.Fatalf( "expected the result within the window, got %q, %v", body, ok )
+    }
+    time.Sleep( 40 * time.Millisecond )
+    if _, ok, _ := ic.Start( context.Background(), "key" ); ok {
+        t.Error( "expected the result to be forgotten after the window" )
+    }
+}
+
+// a storage whose writes wait until they are released
+type blockingWriteStorage struct {
+    ImageStorage
+    started chan struct{}
+    release chan error
+}
+
+func (bs *blockingWriteStorage) Write( name string, reader io.Reader ) error {
+    bs.started <- struct{}{}
+    if err := <-bs.release; err != nil {
+        return err
+    }
+    return bs.ImageStorage.Write( name, reader )
+}
+
+func TestIdempotentConcurrentRetry( t *testing.T ) {
+    blocking := &blockingWriteStorage{ ImageStorage: newFileStorage( t ),
+                    started: make( chan struct{}, 2 ),
+                    release: make( chan error, 2 ) }
+    storage := &countingStorage{ ImageStorage: blocking }
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "idempotent", "app:1" )
+
+    save := func( key string, codes chan int ) {
+        rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Idempotency-Key", key )
+        codes <- rw.Code
+    }
+    //the retry arrives while the first upload is still running
+    codes := make( chan int, 2 )
+    go save( "build-1", codes )
+    <-blocking.started
+    go save( "build-1", codes )
+    time.Sleep( 50 * time.Millisecond )
+    if writes := storage.called( "Write" ); writes != 1 {
+        t.Fatalf( "expected the retry to wait for the running upload, got %d writes", writes )
+    }
+    blocking.release <- nil
+    for i := 0; i < 2; i++ {
+        if code := <-codes; code != http.StatusCreated {
+            t.Errorf( "expected 201 for both saves, got %d", code )
+        }
+    }
+    if writes := storage.called( "Write" ); writes != 1 {
+        t.Errorf( "expected the key to be written once, got %d writes", writes )
+    }
+
+    //a failed upload clears the key, so the waiting retry does the upload
+    go save( "build-2", codes )
+    <-blocking.started
+    go save( "build-2", codes )
+    time.Sleep( 50 * time.Millisecond )
+    blocking.release <- errors.New( "storage failure" )
+    if code := <-codes; code != http.StatusInternalServerError {
+        t.Errorf( "expected the failed upload to get 500, got %d", code )
+    }
+    <-blocking.started
+    blocking.release <- nil
+    if code := <-codes; code != http.StatusCreated {
+        t.Errorf( "expected the retry to be saved, got %d", code )
+    }
+    if writes := storage.called( "Write" ); writes != 3 {
+        t.Errorf( "expected the retry to write again after the failure, got %d writes", writes )
+    }
+}
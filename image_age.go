@@ -0,0 +1,47 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "time"
+)
+
+// keep the images created within the ?older_than= / ?newer_than= durations
+// of the request. The images whose creation time is unknown are excluded
+// when any filter is given
+func (iw *ImageWeb) filterByAge( req *http.Request, images []string ) ([]string, error) {
+    var older_than, newer_than time.Duration
+    var err error
+    query := req.URL.Query()
+    if s := query.Get( "older_than" ); s != "" {
+        if older_than, err = time.ParseDuration( s ); err != nil {
+            return nil, fmt.Errorf( "invalid older_than %s: %v", s, err )
+        }
+    }
+    if s := query.Get( "newer_than" ); s != "" {
+        if newer_than, err = time.ParseDuration( s ); err != nil {
+            return nil, fmt.Errorf( "invalid newer_than %s: %v", s, err )
+        }
+    }
+    if older_than == 0 && newer_than == 0 {
+        return images, nil
+    }
+
+    timed, ok := iw.image_storage.(TimedStorage)
+    result := make( []string, 0 )
+    if !ok {
+        return result, nil
+    }
+    now := time.Now()
+    for _, image := range images {
+        created, err := timed.CreatedAt( image )
+        if err != nil {
+            continue
+        }
+        age := now.Sub( created )
+        if ( older_than == 0 || age > older_than ) && ( newer_than == 0 || age < newer_than ) {
+            result = append( result, image )
+        }
+    }
+    return result, nil
+}
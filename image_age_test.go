@@ -0,0 +1,50 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "os"
+    "sort"
+    "testing"
+    "time"
+)
+
+func TestListByAge( t *testing.T ) {
+    storage := newFileStorage( t )
+    now := time.Now()
+    for name, age := range map[string]time.Duration{ "app:new": time.Minute, "app:day": 25 * time.Hour, "app:week": 8 * 24 * time.Hour } {
+        if err := storage.Write( name, bytes.NewReader( []byte( name ) ) ); err != nil {
+            t.Fatal( err )
+        }
+        image_name, image_version := parseImageName( name )
+        image_file := fmt.Sprintf( "%s/%s/%s", storage.Dir, image_name, image_version )
+        os.Chtimes( image_file, now.Add( -age ), now.Add( -age ) )
+    }
+    _, handler := newTestWeb( t, storage )
+
+    for query, expected := range map[string][]string{
+                "older_than=24h": { "app:day", "app:week" },
+                "newer_than=1h": { "app:new" },
+                "older_than=24h&newer_than=168h": { "app:day" },
+                "older_than=720h": {} } {
+        rw := doRequest( handler, "GET", "/image/list?" + query, nil )
+        var images []string
+        if err := json.Unmarshal( rw.Body.Bytes(), &images ); err != nil {
+            t.Fatalf( "invalid list for %s: %q", query, rw.Body.String() )
+        }
+        sort.Strings( images )
+        if len( images ) != len( expected ) || ( len( images ) > 0 && images[0] != expected[0] ) {
+            t.Errorf( "expected %v for %s, got %v", expected, query, images )
+        }
+    }
+    for _, url := range []string{ "/image/list?older_than=yesterday", "/image/list/detailed?newer_than=1x" } {
+        if rw := doRequest( handler, "GET", url, nil ); rw.Code != http.StatusBadRequest {
+            t.Errorf( "expected 400 for %s, got %d", url, rw.Code )
+        }
+    }
+    if infos := listDetailedByName( t, handler ); infos["app:week"].Age == "" || infos["app:week"].Created == nil {
+        t.Errorf( "expected the age of the image in the detailed list, got %+v", infos["app:week"] )
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "fmt"
+    "math/rand"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// the names of the list starting with prefix found by scanning all of them
+func naiveSearch( names []string, prefix string ) []string {
+    result := make( []string, 0 )
+    for _, name := range names {
+        if strings.HasPrefix( name, prefix ) {
+            result = append( result, name )
+        }
+    }
+    sort.Strings( result )
+    return result
+}
+
+func TestImageNameListSearch( t *testing.T ) {
+    inl := NewImageNameList()
+    r := rand.New( rand.NewSource( 1 ) )
+    for i := 0; i < 2000; i++ {
+        name := fmt.Sprintf( "team-%d/app-%d:%d", r.Intn( 5 ), r.Intn( 20 ), r.Intn( 10 ) )
+        if r.Intn( 4 ) == 0 {
+            inl.Remove( name )
+        } else {
+            inl.Add( name )
+        }
+    }
+    for _, prefix := range []string{ "", "team-1", "team-2/app-1", "team-3/app-12:", "team-4/app-7:3", "other" } {
+        expected := naiveSearch( inl.Names(), prefix )
+        if found := inl.Search( prefix ); fmt.Sprint( found ) != fmt.Sprint( expected ) {
+            t.Errorf( "expected %d names for prefix %q, got %d", len( expected ), prefix, len( found ) )
+        }
+    }
+}
+
+// a list of n names "repo-<i>/app:<j>"
+func benchmarkNameList( n int ) *ImageNameList {
+    inl := NewImageNameList()
+    for i := 0; i < n; i++ {
+        inl.Add( fmt.Sprintf( "repo-%d/app:%d", i / 10, i % 10 ) )
+    }
+    return inl
+}
+
+func BenchmarkImageNameListSearch( b *testing.B ) {
+    for _, n := range []int{ 1000, 10000, 100000 } {
+        inl := benchmarkNameList( n )
+        b.Run( fmt.Sprint( n ), func( b *testing.B ) {
+            for i := 0; i < b.N; i++ {
+                inl.Search( "repo-42/" )
+            }
+        })
+    }
+}
+
+func BenchmarkNaiveSearch( b *testing.B ) {
+    for _, n := range []int{ 1000, 10000, 100000 } {
+        names := benchmarkNameList( n ).Names()
+        b.Run( fmt.Sprint( n ), func( b *testing.B ) {
+            for i := 0; i < b.N; i++ {
+                naiveSearch( names, "repo-42/" )
+            }
+        })
+    }
+}
+
+// run with -race to catch the unguarded accesses
+func TestImageNameListConcurrent( t *testing.T ) {
+    inl := NewImageNameList()
+    var wg sync.WaitGroup
+    for i := 0; i < 16; i++ {
+        wg.Add( 1 )
+        go func( i int ) {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                name := fmt.Sprintf( "app-%d:%d", i, j )
+                inl.Add( name )
+                for range inl.Names() {
+                }
+                inl.Search( "app-" )
+                if j % 2 == 0 {
+                    inl.Remove( name )
+                }
+            }
+        }( i )
+    }
+    wg.Wait()
+    names := inl.Names()
+    if len( names ) != 16 * 50 {
+        t.Errorf( "expected %d names, got %d", 16 * 50, len( names ) )
+    }
+    sort.Strings( names )
+    if fmt.Sprint( inl.Search( "" ) ) != fmt.Sprint( names ) {
+        t.Errorf( "the sorted names diverge from the list" )
+    }
+}
+
+func TestImageNameListRemove( t *testing.T ) {
+    inl := NewImageNameList()
+    for _, name := range []string{ "app:1", "app:2", "app:3" } {
+        inl.Add( name )
+    }
+    if err := inl.Remove( "app:2" ); err != nil {
+        t.Fatal( err )
+    }
+    if err := inl.Remove( "app:2" ); err == nil {
+        t.Error( "expected the removed name to be not found" )
+    }
+    if err := inl.Remove( "app:4" ); err == nil {
+        t.Error( "expected the missing name to be not found" )
+    }
+    names := inl.Names()
+    sort.Strings( names )
+    if fmt.Sprint( names ) != "[app:1 app:3]" || fmt.Sprint( inl.Search( "app" ) ) != "[app:1 app:3]" {
+        t.Errorf( "expected app:1 and app:3 to be kept, got %v and %v", names, inl.Search( "app" ) )
+    }
+
+    //the name only in the map is removed from it, so it can be added again
+    inl.nameMap["app:5"] = "app:5"
+    if err := inl.Remove( "app:5" ); err != nil {
+        t.Errorf( "expected the name in the map to be removed, got %v", err )
+    }
+    if _, ok := inl.nameMap["app:5"]; ok {
+        t.Error( "expected the name to be dropped from the map" )
+    }
+    if err := inl.Add( "app:5" ); err != nil {
+        t.Fatalf( "expected the removed name to be added again, got %v", err )
+    }
+    names = inl.Names()
+    sort.Strings( names )
+    if fmt.Sprint( names ) != "[app:1 app:3 app:5]" || fmt.Sprint( inl.Search( "app:5" ) ) != "[app:5]" {
+        t.Errorf( "expected app:5 to be listed once, got %v and %v", names, inl.Search( "app:5" ) )
+    }
+    if err := inl.Add( "app:5" ); err == nil {
+        t.Error( "expected the duplicate name to be rejected" )
+    }
+}
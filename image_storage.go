@@ -1,59 +1,162 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/fsouza/go-dockerclient"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ImageNameList struct {
+    //the names are added and removed by the concurrent requests
+    mutex sync.RWMutex
+
     //all the names
     nameList []string
 
     //name map for avoiding duplicate name
     nameMap map[string]string
+
+    //all the names in sorted order for the prefix search
+    sortedNames []string
 }
 
 func NewImageNameList() *ImageNameList {
     return &ImageNameList{ nameList: make( []string, 0 ),
-                nameMap: make(map[string]string) }
+                nameMap: make(map[string]string),
+                sortedNames: make( []string, 0 ) }
 }
 
 // add a image name and if the image already exists
 // an error will be return
 func (inl *ImageNameList)Add( name string) error {
+    inl.mutex.Lock()
+    defer inl.mutex.Unlock()
     if _, ok := inl.nameMap[name]; ok {
         return fmt.Errorf( "%s already exists", name )
     }
     inl.nameMap[name] = name
     inl.nameList = append( inl.nameList, name )
+    i := sort.SearchStrings( inl.sortedNames, name )
+    inl.sortedNames = append( inl.sortedNames, "" )
+    copy( inl.sortedNames[i+1:], inl.sortedNames[i:] )
+    inl.sortedNames[i] = name
     return nil
 }
 
-// get all the image names
+// get the image names starting with prefix in sorted order
+func (inl *ImageNameList)Search( prefix string ) []string {
+    inl.mutex.RLock()
+    defer inl.mutex.RUnlock()
+    result := make( []string, 0 )
+    for i := sort.SearchStrings( inl.sortedNames, prefix ); i < len( inl.sortedNames ) && strings.HasPrefix( inl.sortedNames[i], prefix ); i++ {
+        result = append( result, inl.sortedNames[i] )
+    }
+    return result
+}
+
+// get up to limit image names after the name after in sorted order
+func (inl *ImageNameList)After( after string, limit int ) []string {
+    inl.mutex.RLock()
+    defer inl.mutex.RUnlock()
+    i := sort.SearchStrings( inl.sortedNames, after )
+    if i < len( inl.sortedNames ) && inl.sortedNames[i] == after {
+        i++
+    }
+    end := i + limit
+    if end > len( inl.sortedNames ) {
+        end = len( inl.sortedNames )
+    }
+    return append( make( []string, 0, end - i ), inl.sortedNames[i:end]... )
+}
+
+// get a copy of all the image names, so the caller can iterate it
+// while the names are added or removed
 func (inl *ImageNameList)Names() []string {
-    return inl.nameList
+    inl.mutex.RLock()
+    defer inl.mutex.RUnlock()
+    return append( make( []string, 0, len( inl.nameList ) ), inl.nameList... )
 }
 
+// remove a image name, the name is dropped from the map, the list and the
+// sorted names together so they never diverge even if one of them has
+// lost the name
 func (inl *ImageNameList)Remove( name string) error {
-    if _, ok := inl.nameMap[name]; ok {
-        delete (inl.nameMap,name)
-        for i, image_name := range inl.nameList {
+    inl.mutex.Lock()
+    defer inl.mutex.Unlock()
+    if _, ok := inl.nameMap[name]; !ok {
+        return fmt.Errorf( "image %s is not found", name )
+    }
+    for i, image_name := range inl.nameList {
+        if name == image_name {
+            //swap the last with this
             n := len( inl.nameList )
-            if name == image_name {
-                //swap the last with this
-                inl.nameList[i], inl.nameList[ n - 1 ] = inl.nameList[n-1], inl.nameList[i]
-                inl.nameList = inl.nameList[0:n-1]
-                return nil
-            }
+            inl.nameList[i], inl.nameList[ n - 1 ] = inl.nameList[n-1], inl.nameList[i]
+            inl.nameList = inl.nameList[0:n-1]
+            break
         }
     }
-    return fmt.Errorf( "image %s is not found", name )
+    if i := sort.SearchStrings( inl.sortedNames, name ); i < len( inl.sortedNames ) && inl.sortedNames[i] == name {
+        inl.sortedNames = append( inl.sortedNames[:i], inl.sortedNames[i+1:]... )
+    }
+    delete( inl.nameMap, name )
+    return nil
+}
+
+// serialize the operations on the same image name
+type NameLocker struct {
+    mutex sync.Mutex
+    locks map[string]*nameLock
+}
+
+type nameLock struct {
+    sync.Mutex
+
+    //number of goroutines holding or waiting for the lock
+    refs int
+}
+
+func NewNameLocker() *NameLocker {
+    return &NameLocker{ locks: make( map[string]*nameLock ) }
+}
+
+// lock the name and return the function to unlock it
+func (nl *NameLocker)Lock( name string ) func() {
+    nl.mutex.Lock()
+    lock, ok := nl.locks[name]
+    if !ok {
+        lock = &nameLock{}
+        nl.locks[name] = lock
+    }
+    lock.refs++
+    nl.mutex.Unlock()
+
+    lock.Lock()
+    return func() {
+        lock.Unlock()
+        nl.mutex.Lock()
+        lock.refs--
+        if lock.refs == 0 {
+            delete( nl.locks, name )
+        }
+        nl.mutex.Unlock()
+    }
 }
 
 type ImageStorage interface {
@@ -72,42 +175,390 @@ type ImageStorage interface {
 	List() ([]string, error)
 }
 
+// catch the storages which no longer satisfy ImageStorage at compile time
+var (
+    _ ImageStorage = (*FileImageStorage)(nil)
+    _ ImageStorage = (*DockerImageStorage)(nil)
+    _ ImageStorage = (*MongoImageStorage)(nil)
+)
+
+// returned when the requested object does not exist in the storage
+var ErrNotFound = errors.New("not found")
+
+// optional interface implemented by the content-addressable storage
+// where several image names can share one stored blob
+type RefCounter interface {
+    // get the number of image names sharing the blob of image name
+    RefCount(name string) (int, error)
+}
+
+// optional interface implemented by the storage which can get the
+// details of all the images from its backend at once
+type DetailedLister interface {
+    ListDetailed() ([]ImageInfo, error)
+}
+
+// optional interface implemented by the storage which can check
+// if its backend is reachable
+type HealthChecker interface {
+    CheckHealth() error
+}
+
+// optional interface implemented by the storage which keeps
+// the labels of the images
+type MetadataStorage interface {
+    // get the metadata of image name, ErrNotFound if no such image
+    GetMetadata(name string) (*ImageMetadata, error)
+
+    // replace the labels if the metadata is still at version (any
+    // version if it is negative), ErrVersionConflict otherwise
+    UpdateMetadata(name string, version int64, labels map[string]string) (*ImageMetadata, error)
+}
+
+// optional interface implemented by the storage which keeps the
+// layers of the images as separate content addressable blobs
+type LayerStorage interface {
+    // write the blob "sha256:<hex>" to writer, ErrNotFound if no such blob
+    GetLayer(digest string, writer io.Writer) error
+
+    // get the config and the layer digests of image name
+    Layers(name string) (*LayerManifest, error)
+
+    // get the images having the blob digest
+    ImagesWithLayer(digest string) []string
+}
+
+// optional interface implemented by the storage which caches
+// the image names and can check the cache against the backend
+type ConsistencyChecker interface {
+    // get the cached names which are missing in the backend and
+    // the names in the backend which are not cached
+    CheckConsistency() (missing []string, extra []string, err error)
+
+    // make the cached names match the backend again
+    RepairConsistency() error
+}
+
+// optional interface implemented by the storage which knows
+// when the images were stored
+type TimedStorage interface {
+    // get the time when the image name was stored
+    CreatedAt(name string) (time.Time, error)
+}
+
+// optional interface implemented by the storage which can write
+// several images as one archive
+type MultiExporter interface {
+    // write the images with names to writer as one docker-save tar
+    GetMany(names []string, writer io.Writer) error
+}
+
+// optional interface implemented by the storage which can copy
+// an image without reading it through the service
+type Copier interface {
+    // store the image src also as dst
+    Copy(src string, dst string) error
+}
+
+// optional interface implemented by the storage which keeps the
+// checksum of the images computed while they are written
+type ChecksumStorage interface {
+    // get the "sha256:<hex>" of image name, false if it is not recorded
+    Checksum(name string) (string, bool, error)
+}
+
+// optional interface implemented by the storage which can get
+// the size of an image before it is sent
+type SizedStorage interface {
+    // get the number of bytes Get writes for image name, false if
+    // the size is not known without reading the whole image
+    Size(name string) (int64, bool, error)
+}
+
+// optional interface implemented by the storage which can find
+// the image names by prefix without scanning all the names
+type PrefixSearcher interface {
+    // get the image names starting with prefix in sorted order
+    Search(prefix string) ([]string, error)
+}
+
+// optional interface implemented by the storage which can page
+// through the image names in sorted order
+type CursorLister interface {
+    // get up to limit image names after the name after in sorted order
+    ListAfter(after string, limit int) ([]string, error)
+}
+
+// find the image names starting with prefix in sorted order by
+// scanning all the names of the storage
+func searchNames( storage ImageStorage, prefix string ) ([]string, error) {
+    images, err := storage.List()
+    if err != nil {
+        return nil, err
+    }
+    result := make( []string, 0 )
+    for _, image := range images {
+        if strings.HasPrefix( image, prefix ) {
+            result = append( result, image )
+        }
+    }
+    sort.Strings( result )
+    return result, nil
+}
+
+// optional interface implemented by the storage which can store
+// an image uploaded with a content encoding without decoding it
+type EncodedStorage interface {
+    // write the image name encoded with encoding, e.g. "gzip"
+    WriteEncoded(name string, encoding string, reader io.Reader) error
+}
+
+// check if the error returned by a storage means the image doesn't exist
+func isNotFound( err error ) bool {
+    var docker_err *docker.Error
+    if errors.As( err, &docker_err ) && docker_err.Status == 404 {
+        return true
+    }
+    return errors.Is( err, ErrNotFound ) || errors.Is( err, docker.ErrNoSuchImage ) || errors.Is( err, mgo.ErrNotFound ) || os.IsNotExist( err )
+}
+
+// optional interface implemented by the storage which reports
+// the progress while writing the image
+type ProgressStorage interface {
+    // write the image like Write and write the progress messages,
+    // one JSON object per line, to progress
+    WriteWithProgress(name string, reader io.Reader, progress io.Writer) error
+}
+
+// optional interface implemented by the storage which can hand out
+// a presigned URL so the client downloads the image directly from it
+type PresignStorage interface {
+    // get a URL to download the image name which is valid for expires
+    PresignURL(name string, expires time.Duration) (string, error)
+}
+
+// optional interface implemented by the storage which can keep
+// a SBOM/attestation document alongside the image
+type SbomStorage interface {
+    // attach the SBOM document with contentType to the image name,
+    // the document itself can be read from reader
+    WriteSbom(name string, contentType string, reader io.Reader) error
+
+    // Get the SBOM document attached to the image name and its
+    // content type. ErrNotFound is returned if no SBOM is attached
+    GetSbom(name string) ([]byte, string, error)
+}
+
+// split the image name into the repository and the tag. The tag is
+// after the last colon following the final slash, so the port of the
+// registry host in "registry:5000/team/app:v1" stays in the repository.
+// A missing or empty tag is "latest"
 func parseImageName( name string ) (string, string ) {
-    pos := strings.Index(name, ":")
+    pos := strings.LastIndex(name, ":")
 
-    if pos == -1 {
+    if pos == -1 || strings.Contains( name[pos+1:], "/" ) {
         return name, "latest"
     }
+    if pos == len( name ) - 1 {
+        return name[0:pos], "latest"
+    }
     return name[0:pos], name[pos+1:]
 }
 
+// the "<name>:<tag>" key of the image every storage keeps, so "app",
+// "app:" and "app:latest" are the same image
+func normalizeImageName( name string ) string {
+    image_name, image_version := parseImageName( name )
+    return image_name + ":" + image_version
+}
+
 type FileImageStorage struct {
 	Dir string
+
+    //compress the uploaded images with gzip before storing them
+    Compress bool
+
+    //store the identical images once in the blob directory with the
+    //image files hard-linked to their blob
+    Dedup bool
+
+    //the permissions of the created directories and files, they are
+    //applied regardless of the umask
+    DirMode os.FileMode
+    FileMode os.FileMode
+
     images *ImageNameList
+
+    //limit the concurrent reads and writes of the image files
+    limiter *Semaphore
+
+    //serialize the linking and the removing of the blobs
+    blobMutex sync.Mutex
+
+    //serialize the metadata updates of every image
+    metadataLocker *NameLocker
+
+    //reject the uploads when the disk is nearly full
+    minFree FreeSpaceThreshold
+    freeSpace FreeSpaceFunc
+}
+
+// the layout versions of the image files under the storage directory,
+// the active one is recorded in the "<Dir>/.layout" marker file
+const (
+    //every image is kept in "<Dir>/<name>/<version>"
+    fileLayoutNamespaced = 1
+
+    //the layout of the new storage directory
+    currentFileLayout = fileLayoutNamespaced
+)
+
+// create the storage in dir with the directories created with dirMode and
+// the files with fileMode, 0 for 0755 and 0644. An error is returned if
+// dir was written with a file layout this version doesn't know
+func NewFileImageStorage(dir string, dirMode os.FileMode, fileMode os.FileMode) (*FileImageStorage, error) {
+    fis := &FileImageStorage{Dir: dir, DirMode: dirMode, FileMode: fileMode, images: NewImageNameList(), metadataLocker: NewNameLocker() }
+    if err := fis.loadImageNames(); err != nil {
+        return nil, err
+    }
+    return fis, nil
+}
+
+// allow at most max concurrent operations, the operation waits up to
+// wait for a free slot. There is no limit if max is 0
+func (fis *FileImageStorage) SetConcurrency( max int, wait time.Duration ) {
+    fis.limiter = NewSemaphore( max, wait )
 }
 
-func NewFileImageStorage(dir string) *FileImageStorage {
-    fis := &FileImageStorage{Dir: dir, images: NewImageNameList() }
-    fis.loadImageNames()
-    return fis
+// reject the uploads with ErrInsufficientStorage when the free space of
+// the disk is below minFreeBytes or minFreePercent of the disk size
+func (fis *FileImageStorage) SetMinFreeSpace( minFreeBytes uint64, minFreePercent float64 ) {
+    fis.minFree = FreeSpaceThreshold{ MinFreeBytes: minFreeBytes, MinFreePercent: minFreePercent }
+}
+
+// set which waiting operations get the freed slot first
+func (fis *FileImageStorage) SetOperationPriorities( priorities map[string]int ) {
+    fis.limiter.SetPriorities( priorities )
 }
 
 func (fis *FileImageStorage) Write(name string, reader io.Reader ) error {
+    if err := fis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer fis.limiter.Release()
+    if fis.Compress {
+        return fis.writeFile( name, reader, "gzip", true )
+    }
+    return fis.writeFile( name, reader, "", false )
+}
+
+// store the image which is already encoded by the client as it is,
+// only the "gzip" encoding is supported
+func (fis *FileImageStorage) WriteEncoded(name string, encoding string, reader io.Reader ) error {
+    if encoding != "gzip" {
+        return fmt.Errorf( "encoding %s is not supported", encoding )
+    }
+    if err := fis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer fis.limiter.Release()
+    return fis.writeFile( name, reader, encoding, false )
+}
+
+// write the image file and record its codec in the ".<version>.codec"
+// sidecar file, the image is gzipped while writing if compress is true
+func (fis *FileImageStorage) writeFile(name string, reader io.Reader, codec string, compress bool ) error {
 	image_name, image_version := parseImageName( name )
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return err
+    }
+
+    free_space := fis.freeSpace
+    if free_space == nil {
+        free_space = statfsFreeSpace
+    }
+    if err := fis.minFree.Check( fis.Dir, free_space ); err != nil {
+        return err
+    }
 
-	abs_dir := fmt.Sprintf("%s/%s", fis.Dir, image_name)
-	err := os.MkdirAll(abs_dir, 0777)
+	err = fis.mkdirAll( filepath.Dir( image_file ) )
 	if err != nil {
 		return err
 	}
 
-    //create the file
-    f, err := os.Create(fmt.Sprintf("%s/%s", abs_dir, image_version))
+    //the deduplicated image is written to a blob first, the file
+    //linked to a blob must never be overwritten in place
+    var f *os.File
+    if fis.Dedup {
+        f, err = fis.createBlobTemp()
+    } else {
+        if _, err = fis.unlinkBlob( name, image_file ); err != nil {
+            return err
+        }
+        //create the file, the directory may be pruned by the delete of
+        //the last tag of the repository in the meantime
+        f, err = fis.createFile( image_file )
+        if os.IsNotExist( err ) {
+            if err = fis.mkdirAll( filepath.Dir( image_file ) ); err == nil {
+                f, err = fis.createFile( image_file )
+            }
+        }
+    }
     if err != nil {
         return err
     }
     defer f.Close()
-    _, err = io.Copy( f, reader )
+    //the checksum is of the decoded image, so it is only known if
+    //the reader is not encoded
+    hash := sha256.New()
+    if codec == "" || compress {
+        reader = io.TeeReader( reader, hash )
+    }
+    //the blob is identified by the digest of the stored bytes
+    blob_hash := sha256.New()
+    out := io.MultiWriter( f, blob_hash )
+    if compress {
+        gz := gzip.NewWriter( out )
+        _, err = io.Copy( gz, reader )
+        if close_err := gz.Close(); err == nil {
+            err = close_err
+        }
+    } else {
+        _, err = io.Copy( out, reader )
+    }
+    if err != nil && fis.Dedup {
+        //the previous image is kept as the file is not touched yet
+        f.Close()
+        os.Remove( f.Name() )
+        return err
+    }
+    if err != nil {
+        //the partially written file is not a valid image
+        f.Close()
+        os.Remove( image_file )
+        os.Remove( fis.sidecarFile( name, "sha256" ) )
+        fis.images.Remove( fmt.Sprintf( "%s:%s", image_name, image_version ) )
+        return err
+    }
+    if fis.Dedup {
+        f.Close()
+        if err = fis.linkBlob( name, f.Name(), hex.EncodeToString( blob_hash.Sum( nil ) ), image_file ); err != nil {
+            return err
+        }
+    }
+    codec_file := fis.sidecarFile( name, "codec" )
+    if codec == "" {
+        os.Remove( codec_file )
+    } else {
+        err = fis.writeSmallFile( codec_file, []byte( codec ) )
+    }
+    checksum_file := fis.sidecarFile( name, "sha256" )
+    if err == nil && ( codec == "" || compress ) {
+        err = fis.writeSmallFile( checksum_file, []byte( "sha256:" + hex.EncodeToString( hash.Sum( nil ) ) ) )
+    } else {
+        os.Remove( checksum_file )
+    }
     if err == nil {
         fis.images.Add( fmt.Sprintf( "%s:%s", image_name, image_version ) )
     }
@@ -116,50 +567,307 @@ func (fis *FileImageStorage) Write(name string, reader io.Reader ) error {
 }
 
 func (fis *FileImageStorage) Get(name string, writer io.Writer ) error {
-	image_name, image_version := parseImageName( name )
-    r, err := os.Open(fmt.Sprintf("%s/%s/%s", fis.Dir, image_name, image_version))
+    if err := fis.limiter.AcquireFor( OperationGet ); err != nil {
+        return err
+    }
+    defer fis.limiter.Release()
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return err
+    }
+    r, err := os.Open(image_file)
 
     if err != nil {
         return err
     }
     defer r.Close()
-    _, err = io.Copy( writer, r )
+
+    //the stored image is decoded according to its codec
+    var src io.Reader = r
+    if codec, err := ioutil.ReadFile( fis.sidecarFile( name, "codec" ) ); err == nil && string( codec ) == "gzip" {
+        gz, err := gzip.NewReader( r )
+        if err != nil {
+            return err
+        }
+        defer gz.Close()
+        src = gz
+    }
+    _, err = io.Copy( writer, src )
     return err
 }
 
+// the modification time of the image file is its creation time
+func (fis *FileImageStorage)CreatedAt( name string )( time.Time, error ) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return time.Time{}, err
+    }
+    fi, err := os.Stat( image_file )
+    if err != nil {
+        return time.Time{}, err
+    }
+    return fi.ModTime(), nil
+}
+
+// the size of the image file, unknown if it is stored encoded
+func (fis *FileImageStorage)Size( name string )( int64, bool, error ) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return 0, false, err
+    }
+    fi, err := os.Stat( image_file )
+    if err != nil {
+        return 0, false, err
+    }
+    if codec, err := ioutil.ReadFile( fis.sidecarFile( name, "codec" ) ); err == nil && len( codec ) > 0 {
+        return 0, false, nil
+    }
+    return fi.Size(), true, nil
+}
+
 func (fis *FileImageStorage)List()( []string, error ) {
     return fis.images.Names(), nil
 }
 
+// get the images with the size of their file, i.e. the space they take
+// on the disk, and its modification time. The images deleted while they
+// are listed are skipped
+func (fis *FileImageStorage) ListInfo() ([]ImageInfo, error) {
+    names := fis.images.Names()
+    infos := make( []ImageInfo, 0, len( names ) )
+    for _, name := range names {
+        image_file, err := fis.imageFile( name )
+        if err != nil {
+            return nil, err
+        }
+        fi, err := os.Stat( image_file )
+        if os.IsNotExist( err ) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        created := fi.ModTime()
+        info := ImageInfo{ Name: name, Size: fi.Size(), Created: &created, RefCount: 1 }
+        info.Repository, info.Tag = parseImageName( name )
+        infos = append( infos, info )
+    }
+    return infos, nil
+}
+
+// the details of /image/list/detailed are listed by ListInfo
+func (fis *FileImageStorage) ListDetailed() ([]ImageInfo, error) {
+    return fis.ListInfo()
+}
+
+func (fis *FileImageStorage) ListAfter( after string, limit int ) ([]string, error) {
+    return fis.images.After( after, limit ), nil
+}
+
+func (fis *FileImageStorage)Search( prefix string )( []string, error ) {
+    return fis.images.Search( prefix ), nil
+}
+
 func (fis *FileImageStorage)Delete( name string ) error {
+    if err := fis.limiter.AcquireFor( OperationDelete ); err != nil {
+        return err
+    }
+    defer fis.limiter.Release()
     image_name, image_version := parseImageName( name )
-    err := os.Remove( fmt.Sprintf("%s/%s/%s", fis.Dir, image_name, image_version) )
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return err
+    }
+    unlinked, err := fis.unlinkBlob( name, image_file )
+    if err == nil && !unlinked {
+        err = os.Remove( image_file )
+    }
     if err == nil {
         fis.images.Remove( fmt.Sprintf( "%s:%s", image_name, image_version ) )
+        //remove the sidecar files of the image
+        sbom_file := fis.sbomFile( name )
+        os.Remove( sbom_file )
+        os.Remove( sbom_file + ".type" )
+        os.Remove( fis.sidecarFile( name, "codec" ) )
+        os.Remove( fis.sidecarFile( name, "metadata" ) )
+        os.Remove( fis.sidecarFile( name, "sha256" ) )
+        fis.pruneRepositoryDirs( filepath.Dir( image_file ) )
     }
     return err
 }
 
+// remove the directory of a repository and its parents up to Dir once
+// they are empty. The directory with other tags, sidecars or nested
+// repositories is not empty, so it is kept
+func (fis *FileImageStorage) pruneRepositoryDirs( dir string ) {
+    root, err := filepath.Abs( fis.Dir )
+    if err != nil {
+        return
+    }
+    for dir != root && strings.HasPrefix( dir, root + string( filepath.Separator ) ) {
+        if os.Remove( dir ) != nil {
+            return
+        }
+        dir = filepath.Dir( dir )
+    }
+}
+
+// returned when an image name would escape the storage directory
+var ErrInvalidName = errors.New( "invalid image name" )
+
+// get the file of image name "<Dir>/<name>/<version>". The names with
+// hidden or empty segments, absolute names and the hidden versions or the
+// versions with a path separator are rejected, so the file always stays inside Dir
+func (fis *FileImageStorage) imageFile( name string ) (string, error) {
+    image_name, image_version := parseImageName( name )
+    if image_name == "" || strings.HasPrefix( image_name, "/" ) || strings.Contains( image_name, "\\" ) {
+        return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+    }
+    //the hidden directories like the blob directory are not repositories
+    for _, segment := range strings.Split( image_name, "/" ) {
+        if segment == "" || strings.HasPrefix( segment, "." ) {
+            return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+        }
+    }
+    //the hidden files are the sidecars of the images
+    if image_version == "" || strings.HasPrefix( image_version, "." ) || strings.ContainsAny( image_version, "/\\" ) {
+        return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+    }
+    dir, err := filepath.Abs( fis.Dir )
+    if err != nil {
+        return "", err
+    }
+    file := filepath.Join( dir, filepath.FromSlash( image_name ), image_version )
+    if !strings.HasPrefix( file, dir + string( filepath.Separator ) ) {
+        return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+    }
+    return file, nil
+}
+
+// the extra data of the image is kept in the hidden sidecar
+// file ".<version>.<kind>" next to the image file
+func (fis *FileImageStorage) sidecarFile( name string, kind string ) string {
+    image_name, image_version := parseImageName( name )
+    return fmt.Sprintf("%s/%s/.%s.%s", fis.Dir, image_name, image_version, kind)
+}
+
+// the SBOM of image is kept in the sidecar file ".<version>.sbom"
+// and its content type in ".<version>.sbom.type"
+func (fis *FileImageStorage) sbomFile( name string ) string {
+    return fis.sidecarFile( name, "sbom" )
+}
+
+func (fis *FileImageStorage) WriteSbom(name string, contentType string, reader io.Reader ) error {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return err
+    }
+    if _, err := os.Stat( image_file ); err != nil {
+        if os.IsNotExist( err ) {
+            return ErrNotFound
+        }
+        return err
+    }
+
+    sbom_file := fis.sbomFile( name )
+    f, err := fis.createFile( sbom_file )
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    if _, err = io.Copy( f, reader ); err != nil {
+        os.Remove( sbom_file )
+        return err
+    }
+    return fis.writeSmallFile( sbom_file + ".type", []byte( contentType ) )
+}
+
+func (fis *FileImageStorage) GetSbom(name string) ([]byte, string, error) {
+    if _, err := fis.imageFile( name ); err != nil {
+        return nil, "", err
+    }
+    sbom_file := fis.sbomFile( name )
+    b, err := ioutil.ReadFile( sbom_file )
+    if err != nil {
+        if os.IsNotExist( err ) {
+            return nil, "", ErrNotFound
+        }
+        return nil, "", err
+    }
+    content_type, err := ioutil.ReadFile( sbom_file + ".type" )
+    if err != nil {
+        return nil, "", err
+    }
+    return b, string( content_type ), nil
+}
+
+// read the layout version from the ".layout" marker, the directory
+// without the marker is marked with the current layout
+func (fis *FileImageStorage) detectLayout() (int, error) {
+    layout_file := path.Join( fis.Dir, ".layout" )
+    b, err := ioutil.ReadFile( layout_file )
+    if os.IsNotExist( err ) {
+        //the directory written before the marker was introduced
+        //can only have the namespaced layout
+        if err = fis.mkdirAll( fis.Dir ); err != nil {
+            return 0, err
+        }
+        return currentFileLayout, fis.writeSmallFile( layout_file, []byte( strconv.Itoa( currentFileLayout ) ) )
+    }
+    if err != nil {
+        return 0, err
+    }
+    layout, err := strconv.Atoi( strings.TrimSpace( string( b ) ) )
+    if err != nil {
+        return 0, fmt.Errorf( "unrecognized file layout \"%s\" in %s", strings.TrimSpace( string( b ) ), layout_file )
+    }
+    return layout, nil
+}
+
+// load the image names with the scanner of the layout of the directory
 func (fis *FileImageStorage) loadImageNames() error {
-	files, err := ioutil.ReadDir(fis.Dir)
+    return fis.scanImageNames( fis.images )
+}
+
+// add the names of the image files in the directory to images
+func (fis *FileImageStorage) scanImageNames( images *ImageNameList ) error {
+    layout, err := fis.detectLayout()
+    if err != nil {
+        return err
+    }
+    switch layout {
+    case fileLayoutNamespaced:
+        return fis.scanNamespacedLayout( images )
+    }
+    return fmt.Errorf( "file layout version %d of %s is not supported, the latest supported version is %d", layout, fis.Dir, currentFileLayout )
+}
+
+// the image file "<Dir>/<repository>/<version>" is found at any depth,
+// as the repositories like "registry:5000/team/app" have several levels
+func (fis *FileImageStorage) scanNamespacedLayout( images *ImageNameList ) error {
+    return fis.scanRepositoryDir( images, "" )
+}
+
+// add the images of the repository in the directory repo relative to Dir
+// and scan its sub-directories for the nested repositories
+func (fis *FileImageStorage) scanRepositoryDir( images *ImageNameList, repo string ) error {
+    files, err := ioutil.ReadDir( path.Join( fis.Dir, repo ) )
 	if err != nil {
 		return err
 	}
 
 	for _, file := range files {
+        //skip the hidden sidecar files and the ".layout" marker
+        if strings.HasPrefix( file.Name(), "." ) {
+            continue
+        }
 		if file.IsDir() {
-			fName := path.Join(fis.Dir, file.Name())
-			version_files, err := ioutil.ReadDir(fName)
-			if err == nil {
-				for _, vf := range version_files {
-					if !vf.IsDir() {
-                        fis.images.Add( fmt.Sprintf("%s:%s", file.Name(), vf.Name()) )
+            //the unreadable repository is skipped like before
+            fis.scanRepositoryDir( images, path.Join( repo, file.Name() ) )
+        } else if repo != "" {
+            images.Add( fmt.Sprintf( "%s:%s", repo, file.Name() ) )
 					}
 				}
-			}
-		}
-	}
-
 	return nil
 }
 
@@ -167,25 +875,210 @@ func (fis *FileImageStorage) loadImageNames() error {
 
 type DockerImageStorage struct {
 	client *docker.Client
+
+    //the loading and removing of the same image are serialized
+    locker *NameLocker
+
+    //the loading and tagging of the same image ID are serialized
+    idLocker *NameLocker
+
+    //the expected tags and the ID of the image they point to
+    tagsMutex sync.Mutex
+    expectedTags map[string]string
+
+    //stop the tag reconciliation
+    stopRetag chan struct{}
+
+    //limit the concurrent calls to the docker daemon
+    limiter *Semaphore
+
+    //remove the previous image of a tag once it becomes dangling
+    removeDangling bool
+
+    //the listing of the daemon, invalidated when the images are changed
+    listCache *ListCache
 }
 
 func NewDockerImageStorage(client *docker.Client) *DockerImageStorage {
-	return &DockerImageStorage{client: client}
+	return &DockerImageStorage{client: client,
+                locker: NewNameLocker(),
+                idLocker: NewNameLocker(),
+                expectedTags: make( map[string]string ),
+                listCache: NewListCache( 0 ) }
 }
 
+// reuse the listing of the daemon for ttl, 0 to list the daemon every time
+func (dis *DockerImageStorage) SetListCacheTTL( ttl time.Duration ) {
+    dis.listCache = NewListCache( ttl )
+}
+
+// allow at most max concurrent operations, the operation waits up to
+// wait for a free slot. There is no limit if max is 0
+func (dis *DockerImageStorage) SetConcurrency( max int, wait time.Duration ) {
+    dis.limiter = NewSemaphore( max, wait )
+}
+
+// set which waiting operations get the freed slot first
+func (dis *DockerImageStorage) SetOperationPriorities( priorities map[string]int ) {
+    dis.limiter.SetPriorities( priorities )
+}
+
+// load the image. The archive is spooled to a temporary file first, so
+// its image ID is known before the load and the loads of the same image
+// are serialized until the image is tagged as name
 func (dis *DockerImageStorage) Write(name string, reader io.Reader ) error {
-    return dis.client.LoadImage(docker.LoadImageOptions{InputStream: reader })
+    return dis.WriteWithProgress( name, reader, nil )
+}
+
+// load the image and write the progress messages of the docker daemon
+// to progress if it is not nil
+func (dis *DockerImageStorage) WriteWithProgress(name string, reader io.Reader, progress io.Writer ) error {
+    if err := dis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer dis.limiter.Release()
+    image_name, image_version := parseImageName( name )
+    name = fmt.Sprintf( "%s:%s", image_name, image_version )
+    unlock := dis.locker.Lock( name )
+    defer unlock()
+    //a failed load may have tagged some of the images of the tar too
+    defer dis.listCache.Invalidate()
+
+    //the daemon errors for the non-image tars are cryptic, so the stream
+    //is validated while it is spooled and never reaches the daemon if invalid
+    br := bufio.NewReader( reader )
+    if err := checkArchiveHeader( br ); err != nil {
+        return err
+    }
+    spool, err := ioutil.TempFile( "", "image-load-" )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( spool.Name() )
+    defer spool.Close()
+    validator := newArchiveValidator()
+    _, err = io.Copy( spool, io.TeeReader( br, validator ) )
+    if validate_err := validator.Result(); err == nil && validate_err != nil {
+        return validate_err
+    }
+    if err != nil {
+        return err
+    }
+    if _, err = spool.Seek( 0, io.SeekStart ); err != nil {
+        return err
+    }
+
+    id := loadedImageID( validator.Manifest(), name )
+    unlock_id := func() {}
+    if id != "" {
+        unlock_id = dis.idLocker.Lock( id )
+    }
+    //the image the tag pointed to before the load
+    previous_id := ""
+    if dis.removeDangling {
+        if image, err := dis.client.InspectImage( name ); err == nil {
+            previous_id = image.ID
+        }
+    }
+    err = dis.client.LoadImage(docker.LoadImageOptions{InputStream: spool, OutputStream: progress })
+    if isDockerConflict( err ) {
+        unlock_id()
+        return fmt.Errorf( "%w: fail to load image %s: %v", ErrImageConflict, name, err )
+    }
+    //the tags in the archive may differ from name
+    if err == nil && id != "" {
+        err = dis.tagImage( id, image_name, image_version )
+    }
+    unlock_id()
+    if err == nil {
+        dis.expectTag( name )
+        if previous_id != "" && previous_id != id {
+            dis.removeIfDangling( previous_id )
+        }
+    }
+    return err
+}
+
+// remove the image loaded before by the newly loaded one if the image
+// is removed automatically after it becomes dangling
+func (dis *DockerImageStorage) SetRemoveDangling( remove bool ) {
+    dis.removeDangling = remove
+}
+
+// remove the image id if no tag points to it anymore and no container
+// (even a stopped one) is created from it
+func (dis *DockerImageStorage) removeIfDangling( id string ) {
+    //a load of the same image may be about to tag it
+    unlock := dis.idLocker.Lock( id )
+    defer unlock()
+    image, err := dis.client.InspectImage( id )
+    if err != nil || len( image.RepoTags ) > 0 {
+        return
+    }
+    containers, err := dis.client.ListContainers( docker.ListContainersOptions{ All: true, Filters: map[string][]string{ "ancestor": []string{ id } } } )
+    if err != nil || len( containers ) > 0 {
+        return
+    }
+    dis.client.RemoveImage( id )
+}
+
+// export the images with names at once, the layers they share are
+// written only once
+func (dis *DockerImageStorage) GetMany(names []string, writer io.Writer ) error {
+    if err := dis.limiter.AcquireFor( OperationGet ); err != nil {
+        return err
+    }
+    defer dis.limiter.Release()
+    return dis.client.ExportImages(docker.ExportImagesOptions{Names: names, OutputStream: writer})
 }
 
 func (dis *DockerImageStorage) Get(name string, writer io.Writer ) error {
+    if err := dis.limiter.AcquireFor( OperationGet ); err != nil {
+        return err
+    }
+    defer dis.limiter.Release()
     return dis.client.ExportImages(docker.ExportImagesOptions{Names: []string{name}, OutputStream: writer})
 }
 
 func (dis *DockerImageStorage)Delete( name string) error {
-    return dis.client.RemoveImage( name )
+    if err := dis.limiter.AcquireFor( OperationDelete ); err != nil {
+        return err
+    }
+    defer dis.limiter.Release()
+    image_name, image_version := parseImageName( name )
+    unlock := dis.locker.Lock( fmt.Sprintf( "%s:%s", image_name, image_version ) )
+    defer unlock()
+
+    err := dis.client.RemoveImage( name )
+    if err == docker.ErrNoSuchImage {
+        return ErrNotFound
+    }
+    //the image is being used by a container
+    if isDockerConflict( err ) {
+        return fmt.Errorf( "%w: fail to remove image %s: %v", ErrImageConflict, name, err )
+    }
+    if err == nil {
+        dis.tagsMutex.Lock()
+        delete( dis.expectedTags, fmt.Sprintf( "%s:%s", image_name, image_version ) )
+        dis.tagsMutex.Unlock()
+        dis.listCache.Invalidate()
+    }
+    return err
+}
+
+func (dis *DockerImageStorage) CreatedAt( name string ) (time.Time, error) {
+    image, err := dis.client.InspectImage( name )
+    if err != nil {
+        return time.Time{}, err
+    }
+    return image.Created, nil
 }
 
 func (dis *DockerImageStorage) List() ([]string, error) {
+    return dis.listCache.Names( dis.listImages )
+}
+
+func (dis *DockerImageStorage) listImages() ([]string, error) {
 	result := make([]string, 0)
 	imgs, err := dis.client.ListImages(docker.ListImagesOptions{All: false})
 	if err != nil {
@@ -204,11 +1097,67 @@ func (dis *DockerImageStorage) List() ([]string, error) {
 	return result, nil
 }
 
+// list the tagged images with their ID, size and creation time
+func (dis *DockerImageStorage) ListDetailed() ([]ImageInfo, error) {
+    return dis.listCache.Infos( dis.listDetailed )
+}
+
+func (dis *DockerImageStorage) listDetailed() ([]ImageInfo, error) {
+	result := make([]ImageInfo, 0)
+	imgs, err := dis.client.ListImages(docker.ListImagesOptions{All: false})
+	if err != nil {
+		return result, err
+	}
+
+	for _, img := range imgs {
+        created := time.Unix( img.Created, 0 )
+        for _, name := range img.RepoTags {
+            //discard the <none> image
+            if strings.HasPrefix( name, "<none>" ) || strings.HasSuffix( name, ":<none>" ) {
+                continue
+            }
+            image_name, image_version := parseImageName( name )
+            result = append( result, ImageInfo{ Name: name,
+                        Repository: image_name,
+                        Tag: image_version,
+                        ID: img.ID,
+                        Size: img.Size,
+                        Created: &created,
+                        RefCount: 1 } )
+        }
+	}
+	return result, nil
+}
+
 type MongoImageStorage struct {
 	url      string
 	db       string
 	fsPrefix string
     images *ImageNameList
+
+    //limit the concurrent operations on the GridFS
+    limiter *Semaphore
+
+    //the images not larger than it are stored in a document of the
+    //inline collection instead of the GridFS, 0 to store all in GridFS
+    inlineThreshold int64
+
+    //the long-lived session copied by every operation, dialed on first use
+    sessionMutex sync.Mutex
+    session *mgo.Session
+
+    dialTimeout time.Duration
+
+    //how many times a failed dial, open or remove is retried
+    retries int
+}
+
+// the document of an image stored inline
+type mongoInlineImage struct {
+    Name string `bson:"_id"`
+    Data []byte `bson:"data"`
+    UploadDate time.Time `bson:"uploadDate"`
+    Sha256 string `bson:"sha256,omitempty"`
 }
 
 type MongoFileIndex struct {
@@ -218,23 +1167,81 @@ type MongoFileIndex struct {
 	Filename   string
 }
 
-func NewMongoImageStorage(url string, db string, fsPrefix string) *MongoImageStorage {
+func NewMongoImageStorage(url string, db string, fsPrefix string, dialTimeout time.Duration, retries int) *MongoImageStorage {
     mis := &MongoImageStorage{url: url,
             db: db,
             fsPrefix: fsPrefix,
-            images: NewImageNameList() }
+            images: NewImageNameList(),
+            dialTimeout: dialTimeout,
+            retries: retries }
     mis.loadImageNames()
     return mis
 }
 
+// allow at most max concurrent operations, the operation waits up to
+// wait for a free slot. There is no limit if max is 0
+func (mis *MongoImageStorage) SetConcurrency( max int, wait time.Duration ) {
+    mis.limiter = NewSemaphore( max, wait )
+}
+
+// set which waiting operations get the freed slot first
+func (mis *MongoImageStorage) SetOperationPriorities( priorities map[string]int ) {
+    mis.limiter.SetPriorities( priorities )
+}
+
+// store the images not larger than threshold bytes in a regular collection
+// to avoid the chunk overhead of GridFS for many tiny images
+func (mis *MongoImageStorage) SetInlineThreshold( threshold int64 ) {
+    mis.inlineThreshold = threshold
+}
+
+// the collection of the images stored inline
+func (mis *MongoImageStorage) inlineCollection(session *mgo.Session) *mgo.Collection {
+    return session.DB(mis.db).C(mis.fsPrefix + ".inline")
+}
+
+// get the image stored inline, mgo.ErrNotFound if it is not stored inline
+func (mis *MongoImageStorage) getInline(session *mgo.Session, name string) (*mongoInlineImage, error) {
+    image := &mongoInlineImage{}
+    if err := mis.inlineCollection( session ).Find( bson.M{ "_id": name } ).One( image ); err != nil {
+        return nil, err
+    }
+    return image, nil
+}
+
+// the image is either stored inline or in a GridFS file
+func (mis *MongoImageStorage) hasImage( session *mgo.Session, fs *mgo.GridFS, name string ) (bool, error) {
+    n, err := mis.inlineCollection( session ).Find( bson.M{ "_id": name } ).Count()
+    if err != nil || n > 0 {
+        return n > 0, err
+    }
+    n, err = fs.Find( bson.M{ "filename": name } ).Count()
+    return n > 0, err
+}
+
 func (mis *MongoImageStorage) Get(name string, writer io.Writer ) error {
+    name = normalizeImageName( name )
+    if err := mis.limiter.AcquireFor( OperationGet ); err != nil {
+        return err
+    }
+    defer mis.limiter.Release()
 	session, fs, err := mis.createGridFS()
 	if err != nil {
 		return err
 	}
 
-	file, err := fs.Open(name)
+    if image, err := mis.getInline( session, name ); err == nil {
+        session.Close()
+        _, err = writer.Write( image.Data )
+        return err
+    } else if err != mgo.ErrNotFound {
+        session.Close()
+        return err
+    }
+
+	file, err := mis.openGridFile( session, fs, name )
 	if err != nil {
+        session.Close()
 		return err
 	}
 
@@ -250,7 +1257,78 @@ func (mis *MongoImageStorage) List()([]string, error ) {
     return mis.images.Names(), nil
 }
 
+func (mis *MongoImageStorage) CreatedAt( name string )(time.Time, error ) {
+    name = normalizeImageName( name )
+    session, fs, err := mis.createGridFS()
+    if err != nil {
+        return time.Time{}, err
+    }
+    defer session.Close()
+
+    if image, err := mis.getInline( session, name ); err == nil {
+        return image.UploadDate, nil
+    } else if err != mgo.ErrNotFound {
+        return time.Time{}, err
+    }
+    file, err := mis.openGridFile( session, fs, name )
+    if err != nil {
+        return time.Time{}, err
+    }
+    defer file.Close()
+    return file.UploadDate(), nil
+}
+
+// the size of the inline image or the length of the GridFS file
+func (mis *MongoImageStorage) Size( name string )(int64, bool, error ) {
+    name = normalizeImageName( name )
+    session, fs, err := mis.createGridFS()
+    if err != nil {
+        return 0, false, err
+    }
+    defer session.Close()
+
+    if image, err := mis.getInline( session, name ); err == nil {
+        return int64( len( image.Data ) ), true, nil
+    } else if err != mgo.ErrNotFound {
+        return 0, false, err
+    }
+    file, err := mis.openGridFile( session, fs, name )
+    if err != nil {
+        return 0, false, err
+    }
+    defer file.Close()
+    return file.Size(), true, nil
+}
+
+func (mis *MongoImageStorage) ListAfter( after string, limit int ) ([]string, error) {
+    return mis.images.After( after, limit ), nil
+}
+
+func (mis *MongoImageStorage) Search( prefix string )([]string, error ) {
+    return mis.images.Search( prefix ), nil
+}
+
+// remove the GridFS files of name except the one with id keep
+func removeOtherGridFiles( fs *mgo.GridFS, name string, keep interface{} ) error {
+    var files []struct{ Id interface{} `bson:"_id"` }
+    err := fs.Find( bson.M{ "filename": name, "_id": bson.M{ "$ne": keep } } ).Select( bson.M{ "_id": 1 } ).All( &files )
+    if err != nil {
+        return err
+    }
+    for _, file := range files {
+        if err = fs.RemoveId( file.Id ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
 func (mis *MongoImageStorage) Write(name string, reader io.Reader ) error {
+    name = normalizeImageName( name )
+    if err := mis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer mis.limiter.Release()
 
 	session, fs, err := mis.createGridFS()
 	if err != nil {
@@ -258,15 +1336,54 @@ func (mis *MongoImageStorage) Write(name string, reader io.Reader ) error {
 	}
     defer session.Close()
 
-	file, err := fs.Open(name)
+    //read one more byte than the threshold to know if it is larger
+    if mis.inlineThreshold > 0 {
+        data, err := ioutil.ReadAll( io.LimitReader( reader, mis.inlineThreshold + 1 ) )
+        if err != nil {
+            return err
+        }
+        if int64( len( data ) ) <= mis.inlineThreshold {
+            checksum := sha256.Sum256( data )
+            image := mongoInlineImage{ Name: name, Data: data, UploadDate: time.Now(), Sha256: "sha256:" + hex.EncodeToString( checksum[:] ) }
+            if _, err = mis.inlineCollection( session ).Upsert( bson.M{ "_id": name }, image ); err != nil {
+                return err
+            }
+            if err = mis.removeGridFile( session, fs, name ); err != nil && err != mgo.ErrNotFound {
+                return err
+            }
+            mis.images.Add( name )
+            return nil
+        }
+        reader = io.MultiReader( bytes.NewReader( data ), reader )
+    }
+
+	file, err := fs.Create(name)
 	if err != nil {
 		return err
 	}
 
-    defer file.Close()
-    defer session.Close()
-
-    _, err = io.Copy( file, reader )
+    hash := sha256.New()
+    if _, err = io.Copy( file, io.TeeReader( reader, hash ) ); err != nil {
+        //the chunks written so far are removed by Close
+        file.Abort()
+        file.Close()
+        return err
+    }
+    file.SetMeta( bson.M{ "sha256": "sha256:" + hex.EncodeToString( hash.Sum( nil ) ) } )
+    //the chunks and the md5 are finalized by Close
+    if err = file.Close(); err != nil {
+        return err
+    }
+    //an existing image is replaced: the previous files of the name are
+    //removed only after the new one is complete, so a failed upload
+    //keeps the previous image
+    err = removeOtherGridFiles( fs, name, file.Id() )
+    if err == nil {
+        err = mis.inlineCollection( session ).Remove( bson.M{ "_id": name } )
+        if err == mgo.ErrNotFound {
+            err = nil
+        }
+    }
 
     if err == nil {
         mis.images.Add( name )
@@ -275,7 +1392,12 @@ func (mis *MongoImageStorage) Write(name string, reader io.Reader ) error {
 
 }
 
-func (mis *MongoImageStorage)Remove( name string ) error {
+func (mis *MongoImageStorage)Delete( name string ) error {
+    name = normalizeImageName( name )
+    if err := mis.limiter.AcquireFor( OperationDelete ); err != nil {
+        return err
+    }
+    defer mis.limiter.Release()
     session, fs, err := mis.createGridFS()
     if err != nil {
         return err
@@ -283,14 +1405,90 @@ func (mis *MongoImageStorage)Remove( name string ) error {
 
     defer session.Close()
 
-    err = fs.Remove( name )
+    //the image is either stored inline or in the GridFS
+    err = mis.inlineCollection( session ).Remove( bson.M{ "_id": name } )
+    if err == mgo.ErrNotFound {
+        err = mis.removeGridFile( session, fs, name )
+    } else if err == nil {
+        mis.removeGridFile( session, fs, name )
+    }
     if err == nil {
         mis.images.Remove( name )
+        mis.sbomGridFS( session ).Remove( name )
+    }
+    return err
+}
+
+func (mis *MongoImageStorage) WriteSbom(name string, contentType string, reader io.Reader ) error {
+    name = normalizeImageName( name )
+    session, fs, err := mis.createGridFS()
+    if err != nil {
+        return err
+    }
+    defer session.Close()
+
+    if exists, err := mis.hasImage( session, fs, name ); err != nil {
+        return err
+    } else if !exists {
+        return ErrNotFound
+    }
+
+    //replace the previous SBOM if any
+    sbom_fs := mis.sbomGridFS( session )
+    if err = sbom_fs.Remove( name ); err != nil {
+        return err
+    }
+    file, err := sbom_fs.Create( name )
+    if err != nil {
+        return err
+    }
+    file.SetContentType( contentType )
+    _, err = io.Copy( file, reader )
+    if err != nil {
+        file.Abort()
+    }
+    if close_err := file.Close(); err == nil {
+        err = close_err
     }
     return err
 }
 
+func (mis *MongoImageStorage) GetSbom(name string) ([]byte, string, error) {
+    name = normalizeImageName( name )
+    session, err := mis.copySession()
+    if err != nil {
+        return nil, "", err
+    }
+    defer session.Close()
+
+    file, err := mis.sbomGridFS( session ).Open( name )
+    if err != nil {
+        if err == mgo.ErrNotFound {
+            return nil, "", ErrNotFound
+        }
+        return nil, "", err
+    }
+    defer file.Close()
+
+    b, err := ioutil.ReadAll( file )
+    if err != nil {
+        return nil, "", err
+    }
+    return b, file.ContentType(), nil
+}
+
+// the SBOM documents are kept as companion files in a separate GridFS
+// so they never show up in the image list
+func (mis *MongoImageStorage) sbomGridFS(session *mgo.Session) *mgo.GridFS {
+    return session.DB(mis.db).GridFS(mis.fsPrefix + ".sbom")
+}
+
 func (mis *MongoImageStorage) loadImageNames() error {
+    return mis.scanImageNames( mis.images )
+}
+
+// add the names of the files in the GridFS to images
+func (mis *MongoImageStorage) scanImageNames( images *ImageNameList ) error {
 	session, fs, err := mis.createGridFS()
 	if err != nil {
 		return err
@@ -304,14 +1502,23 @@ func (mis *MongoImageStorage) loadImageNames() error {
         if !iter.Next( &mongoFile) {
             break
         }
-        mis.images.Add( mongoFile.Filename )
+        images.Add( mongoFile.Filename )
 
     }
-	return nil
+    if err = iter.Close(); err != nil {
+        return err
+    }
+
+    inline_iter := mis.inlineCollection( session ).Find( nil ).Select( bson.M{ "_id": 1 } ).Iter()
+    image := mongoInlineImage{}
+    for inline_iter.Next( &image ) {
+        images.Add( image.Name )
+    }
+	return inline_iter.Close()
 }
 
 func (mis *MongoImageStorage) createGridFS() (*mgo.Session, *mgo.GridFS, error) {
-	session, err := mgo.Dial(mis.url)
+	session, err := mis.copySession()
 	if err != nil {
 		return nil, nil, err
 	}
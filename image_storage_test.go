@@ -0,0 +1,228 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestFileStorageCompress( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Compress = true
+    archive := makeImageArchive( t, "compressed", "app:1" )
+    if err := storage.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    stored, err := ioutil.ReadFile( fmt.Sprintf( "%s/app/1", storage.Dir ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if len( stored ) < 2 || stored[0] != 0x1f || stored[1] != 0x8b {
+        t.Error( "the image is not stored gzipped" )
+    }
+    var b bytes.Buffer
+    if err = storage.Get( "app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), archive ) {
+        t.Errorf( "expected the decompressed image, got %d bytes: %v", b.Len(), err )
+    }
+    if checksum, ok, err := storage.Checksum( "app:1" ); err != nil || !ok || checksum != sha256Digest( archive ) {
+        t.Errorf( "expected the checksum of the uncompressed image, got %s: %v", checksum, err )
+    }
+}
+
+func TestSaveGzipEncodedNotCompressedTwice( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Compress = true
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "encoded", "app:1" )
+    var encoded bytes.Buffer
+    gw := gzip.NewWriter( &encoded )
+    gw.Write( archive )
+    gw.Close()
+
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( encoded.Bytes() ), "Content-Encoding", "gzip" )
+    if body := responseBody( t, rw ); body != "save image successfully" {
+        t.Fatalf( "expected the gzip encoded image to be saved, got %s", body )
+    }
+    stored, err := ioutil.ReadFile( fmt.Sprintf( "%s/app/1", storage.Dir ) )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if !bytes.Equal( stored, encoded.Bytes() ) {
+        t.Error( "expected the gzip encoded upload to be stored as it is" )
+    }
+    rw = doRequest( handler, "GET", "/image/get/app:1", nil )
+    if !bytes.Equal( rw.Body.Bytes(), archive ) {
+        t.Errorf( "expected the decoded image, got %d bytes", rw.Body.Len() )
+    }
+}
+
+func TestSaveTruncatedGzipRejected( t *testing.T ) {
+    storage := newFileStorage( t )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetValidateGzip( true )
+    var encoded bytes.Buffer
+    gw := gzip.NewWriter( &encoded )
+    gw.Write( makeImageArchive( t, "truncated", "app:1" ) )
+    gw.Close()
+
+    truncated := encoded.Bytes()[:encoded.Len() - 10]
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( truncated ), "Content-Encoding", "gzip" )
+    if rw.Code != http.StatusUnprocessableEntity {
+        t.Errorf( "expected 422 for the truncated gzip, got %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    if _, err := os.Stat( fmt.Sprintf( "%s/app/1", storage.Dir ) ); !os.IsNotExist( err ) {
+        t.Errorf( "expected the truncated upload to be cleaned up: %v", err )
+    }
+
+    rw = doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( encoded.Bytes() ), "Content-Encoding", "gzip" )
+    if body := responseBody( t, rw ); body != "save image successfully" {
+        t.Errorf( "expected the complete gzip to be saved, got %d %s", rw.Code, body )
+    }
+}
+
+func TestFileLayoutDetection( t *testing.T ) {
+    //the directory written before the marker has the namespaced layout
+    legacy := t.TempDir()
+    os.MkdirAll( filepath.Join( legacy, "team", "app" ), 0755 )
+    ioutil.WriteFile( filepath.Join( legacy, "team", "app", "1" ), []byte( "image" ), 0644 )
+    storage, err := NewFileImageStorage( legacy, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if names, _ := storage.List(); len( names ) != 1 || names[0] != "team/app:1" {
+        t.Errorf( "expected the image of the legacy directory, got %v", names )
+    }
+    if b, err := ioutil.ReadFile( filepath.Join( legacy, ".layout" ) ); err != nil || string( b ) != "1" {
+        t.Errorf( "expected the legacy directory to be marked with layout 1, got %q: %v", b, err )
+    }
+    if _, err = NewFileImageStorage( legacy, 0, 0 ); err != nil {
+        t.Errorf( "expected the marked directory to be opened again, got %v", err )
+    }
+
+    for _, marker := range []string{ "2", "flat" } {
+        dir := t.TempDir()
+        ioutil.WriteFile( filepath.Join( dir, ".layout" ), []byte( marker ), 0644 )
+        if _, err := NewFileImageStorage( dir, 0, 0 ); err == nil {
+            t.Errorf( "expected an error for the unrecognized layout %s", marker )
+        }
+    }
+}
+
+// every backend is usable as the storage of the web
+func TestBackendsAreImageStorages( t *testing.T ) {
+    file_storage := newFileStorage( t )
+    _, docker_storage := newFakeDocker( t )
+    //the mongo storage is not created by NewMongoImageStorage as it dials
+    for _, image_storage := range []ImageStorage{ file_storage, docker_storage, &MongoImageStorage{} } {
+        if image_storage == nil {
+            t.Errorf( "expected a storage" )
+        }
+    }
+}
+
+func TestFileStorageRejectsTraversal( t *testing.T ) {
+    root := t.TempDir()
+    storage, err := NewFileImageStorage( filepath.Join( root, "images" ), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, name := range []string{ "../escape:1", "app/../../escape:1", "/etc/passwd:1", "app:../../escape", "app:..", "app\\..\\escape:1", "app:.1", ".blobs/app:1" } {
+        if err = storage.Write( name, bytes.NewReader( []byte( "image" ) ) ); !errors.Is( err, ErrInvalidName ) {
+            t.Errorf( "expected the write of %s to be rejected, got %v", name, err )
+        }
+        if err = storage.Get( name, ioutil.Discard ); !errors.Is( err, ErrInvalidName ) {
+            t.Errorf( "expected the get of %s to be rejected, got %v", name, err )
+        }
+        if err = storage.Delete( name ); !errors.Is( err, ErrInvalidName ) {
+            t.Errorf( "expected the delete of %s to be rejected, got %v", name, err )
+        }
+    }
+    //nothing is written next to the storage directory
+    if entries, _ := ioutil.ReadDir( root ); len( entries ) != 1 {
+        t.Errorf( "expected only the storage directory, got %d entries", len( entries ) )
+    }
+}
+
+// the registry port is part of the repository, not the tag
+func TestFileStorageNamespacedName( t *testing.T ) {
+    dir := t.TempDir()
+    storage, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    name := "registry:5000/team/app:v1"
+    if err = storage.Write( name, bytes.NewReader( []byte( "image" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    if _, err = os.Stat( filepath.Join( dir, "registry:5000", "team", "app", "v1" ) ); err != nil {
+        t.Errorf( "expected the image file in the repository directory: %v", err )
+    }
+    //the names are rebuilt from the directories by a new storage
+    reloaded, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if names, _ := reloaded.List(); len( names ) != 1 || names[0] != name {
+        t.Errorf( "expected %s to be listed, got %v", name, names )
+    }
+    var b bytes.Buffer
+    if err = reloaded.Get( name, &b ); err != nil || b.String() != "image" {
+        t.Errorf( "expected the image, got %q: %v", b.String(), err )
+    }
+    if err = reloaded.Delete( name ); err != nil {
+        t.Fatal( err )
+    }
+    if names, _ := reloaded.List(); len( names ) != 0 {
+        t.Errorf( "expected no image after the delete, got %v", names )
+    }
+}
+
+func TestFileStorageDeletePrunesRepository( t *testing.T ) {
+    dir := t.TempDir()
+    storage, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, name := range []string{ "team/app:1", "team/app:2", "team/web:1", "other:1" } {
+        if err = storage.Write( name, bytes.NewReader( []byte( name ) ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    exists := func( path string ) bool {
+        _, err := os.Stat( filepath.Join( dir, path ) )
+        return err == nil
+    }
+
+    steps := []struct {
+        name string
+        kept []string
+        pruned []string
+    }{
+        //the repository with another tag is kept
+        { "team/app:1", []string{ "team/app", "team/web", "other" }, nil },
+        { "team/app:2", []string{ "team/web", "other" }, []string{ "team/app" } },
+        { "team/web:1", []string{ "other" }, []string{ "team" } },
+        { "other:1", []string{ "" }, []string{ "other" } },
+    }
+    for _, step := range steps {
+        if err = storage.Delete( step.name ); err != nil {
+            t.Fatal( err )
+        }
+        for _, path := range step.kept {
+            if !exists( path ) {
+                t.Errorf( "after deleting %s: expected %q to be kept", step.name, path )
+            }
+        }
+        for _, path := range step.pruned {
+            if exists( path ) {
+                t.Errorf( "after deleting %s: expected %q to be pruned", step.name, path )
+            }
+        }
+    }
+}
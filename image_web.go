@@ -1,30 +1,614 @@
 package main
 
 import (
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
     "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "mime"
+    "net"
     "net/http"
+    "os"
+    "strconv"
     "strings"
+    "sync"
+    "time"
 )
 
+// returned when the uploaded image is found broken
+var ErrCorruptUpload = errors.New("corrupt upload")
+
 type ImageWeb struct {
     image_storage ImageStorage
+
+    //max size in bytes of an uploaded SBOM document
+    sbomMaxSize int64
+
+    //the accepted media types of the SBOM document
+    sbomContentTypes []string
+
+    //how long a presigned download URL is valid
+    presignExpires time.Duration
+
+    //the results of the recent uploads by Idempotency-Key
+    idempotency *IdempotencyCache
+
+    //the digests of the uploaded images
+    digests *DigestIndex
+
+    //max number of images read at the same time by /admin/reindex
+    reindexConcurrency int
+
+    //only one reindex can run at a time
+    reindexing sync.Mutex
+
+    //decompress the gzip encoded uploads to check them before storing
+    validateGzip bool
+
+    //the max size in bytes of an uploaded image, 0 for no limit
+    maxImageSize int64
+
+    //the images which can't be deleted
+    protected *ProtectedImages
+
+    //the repositories whose images are never overwritten or deleted
+    immutable ImmutableRepositories
+
+    metrics *Metrics
+
+    //the limits of the accepted image names
+    nameLimits NameLimits
+
+    //rewrite the image names on ingress, nil to keep them as they are
+    nameTransform *NameTransform
+
+    //the name uploaded by the client of the images whose name is rewritten
+    originalNames sync.Map
+
+    //the access rules of the identities, nil if everyone can access everything
+    accessControl *AccessControl
+
+    //the credentials every request must have, nil if they are not required
+    basicAuth *BasicAuth
+
+    //the listings of the storage reused by the requests
+    listCache *ListCache
+
+    server *http.Server
+
+    //the permission of the unix socket if it listens on "unix:<path>"
+    socketMode os.FileMode
+
+    //the certificate and key files of HTTPS, plain HTTP if they are empty
+    tlsCert string
+    tlsKey string
+
+    //the requests being served
+    transfers *TransferTracker
+
+    //how long the in-flight transfers can take to finish on shutdown
+    shutdownGrace time.Duration
+
+    //the format of the request log
+    logFormat string
+
+    //the OCI layout form of the downloaded images
+    ociCache *OCICache
+
+    //serve the pulls of the Docker Registry HTTP API v2 under /v2/
+    registryAPI bool
+    registry *RegistryIndex
+
+    //read the uploaded images from the ?src URL
+    fetchEnabled bool
+    fetchClient *http.Client
+
+    //the last upload attempts of every image
+    uploadHistory *UploadHistory
+
+    //the effective configuration shown to the operators
+    config map[string]ConfigSetting
+
+    //the tokens to download an image without the Authorization header
+    tokens *DownloadTokens
+
+    //the periodic compaction of the storage
+    maintenance *MaintenanceScheduler
+
+    //limit the pushes of every repository
+    pushLimiter *RepoRateLimiter
 }
 
 func NewImageWeb( image_storage ImageStorage ) *ImageWeb {
-    iw := &ImageWeb{ image_storage: image_storage }
+    iw := &ImageWeb{ image_storage: image_storage,
+                sbomMaxSize: 10 * 1024 * 1024,
+                sbomContentTypes: []string{ "application/spdx+json", "application/vnd.cyclonedx+json" },
+                presignExpires: 15 * time.Minute,
+                idempotency: NewIdempotencyCache( 10 * time.Minute ),
+                digests: NewDigestIndex(),
+                reindexConcurrency: 4,
+                socketMode: 0660,
+                protected: NewProtectedImages( nil ),
+                metrics: NewMetrics(),
+                nameLimits: NameLimits{ MaxNameLength: 255, MaxTagLength: 128 },
+                transfers: NewTransferTracker(),
+                ociCache: NewOCICache( 16 ),
+                registry: NewRegistryIndex(),
+                uploadHistory: NewUploadHistory( 10 ),
+                listCache: NewListCache( 0 ),
+                shutdownGrace: 5 * time.Minute,
+                logFormat: LogFormatText }
+    iw.tokens, _ = NewDownloadTokens( nil, 5 * time.Minute )
+    iw.maintenance = NewMaintenanceScheduler( image_storage )
+    iw.server = &http.Server{ Addr: defaultListenAddr, Handler: iw.logRequests( iw.metrics.Instrument( iw.transfers.Wrap( iw.requireBasicAuth( iw.withRequestStorage( http.DefaultServeMux ) ) ) ) ) }
     iw.init()
     return iw
 }
 
+// restrict which repositories each identity can read and write
+func (iw *ImageWeb) SetAccessControl( ac *AccessControl ) {
+    iw.accessControl = ac
+}
+
+// check if the request can read (or write if write is true) the image
+// name. The error response is written and false is returned if it can't
+func (iw *ImageWeb) authorize( rw http.ResponseWriter, req *http.Request, name string, write bool ) bool {
+    if iw.accessControl == nil {
+        return true
+    }
+    identity := iw.accessControl.Identify( req )
+    if identity == nil {
+        rw.Header().Set( "WWW-Authenticate", `Basic realm="images"` )
+        http.Error( rw, "unauthorized", http.StatusUnauthorized )
+        return false
+    }
+    image_name, _ := parseImageName( name )
+    if !identity.CanAccess( image_name, write ) {
+        http.Error( rw, "no permission to access " + image_name, http.StatusForbidden )
+        return false
+    }
+    return true
+}
+
+// keep only the images the request can read. The error response is
+// written and false is returned if the request is not authenticated
+func (iw *ImageWeb) filterReadable( rw http.ResponseWriter, req *http.Request, images []string ) ([]string, bool) {
+    if iw.accessControl == nil {
+        return images, true
+    }
+    identity := iw.accessControl.Identify( req )
+    if identity == nil {
+        rw.Header().Set( "WWW-Authenticate", `Basic realm="images"` )
+        http.Error( rw, "unauthorized", http.StatusUnauthorized )
+        return nil, false
+    }
+    readable := make( []string, 0 )
+    for _, image := range images {
+        if image_name, _ := parseImageName( image ); identity.CanAccess( image_name, false ) {
+            readable = append( readable, image )
+        }
+    }
+    return readable, true
+}
+
+// check if the request can call the /admin/ endpoints
+func (iw *ImageWeb) authorizeAdmin( rw http.ResponseWriter, req *http.Request ) bool {
+    if iw.accessControl == nil {
+        return true
+    }
+    identity := iw.accessControl.Identify( req )
+    if identity == nil {
+        rw.Header().Set( "WWW-Authenticate", `Basic realm="images"` )
+        http.Error( rw, "unauthorized", http.StatusUnauthorized )
+        return false
+    }
+    if !identity.Admin {
+        http.Error( rw, "no permission to administrate", http.StatusForbidden )
+        return false
+    }
+    return true
+}
+
+// check the integrity of the gzip encoded uploads which are stored as-is
+func (iw *ImageWeb) SetValidateGzip( validate bool ) {
+    iw.validateGzip = validate
+}
+
+// protect the images matching one of the "name:version" patterns from deletion
+func (iw *ImageWeb) SetProtectedPatterns( patterns []string ) {
+    iw.protected = NewProtectedImages( patterns )
+}
+
+// reject the uploaded images larger than size bytes, 0 for no limit
+func (iw *ImageWeb) SetMaxImageSize( size int64 ) {
+    iw.maxImageSize = size
+}
+
+// make the images of the repositories matching one of the patterns
+// append-only, regardless of the protected patterns and the overwrite flag
+func (iw *ImageWeb) SetImmutableRepositories( patterns []string ) {
+    iw.immutable = ImmutableRepositories( patterns )
+}
+
+// set the limits of the image names accepted by the requests
+func (iw *ImageWeb) SetNameLimits( limits NameLimits ) {
+    iw.nameLimits = limits
+}
+
+// rewrite the image names of the requests with the transform
+func (iw *ImageWeb) SetNameTransform( nt *NameTransform ) {
+    iw.nameTransform = nt
+}
+
+// set the max size and the accepted media types of the uploaded SBOM
+func (iw *ImageWeb) SetSbomLimits( maxSize int64, contentTypes []string ) {
+    iw.sbomMaxSize = maxSize
+    iw.sbomContentTypes = contentTypes
+}
+
+// set how long the presigned URL of ?redirect=true downloads is valid
+func (iw *ImageWeb) SetPresignExpires( expires time.Duration ) {
+    iw.presignExpires = expires
+}
+
+// set how long the result of an upload is remembered by its Idempotency-Key
+func (iw *ImageWeb) SetIdempotencyWindow( window time.Duration ) {
+    iw.idempotency.SetWindow( window )
+}
+
+// set how many images are kept converted to the OCI layout, 0 to disable
+func (iw *ImageWeb) SetOCICacheSize( size int ) {
+    iw.ociCache.SetMaxEntries( size )
+}
+
+// flush every write to the client immediately
+type flushWriter struct {
+    rw http.ResponseWriter
+}
+
+func (fw *flushWriter) Write( b []byte ) (int, error) {
+    n, err := fw.rw.Write( b )
+    if flusher, ok := fw.rw.(http.Flusher); ok {
+        flusher.Flush()
+    }
+    return n, err
+}
+
+// write the image uploaded in req to the storage and index its digest.
+// The progress of the storage is written to progress if it is not nil.
+// The image uploaded with "Content-Encoding: gzip" is stored as it is
+// if the storage supports it, otherwise it is decoded before writing
+func (iw *ImageWeb) writeImage( name string, req *http.Request, progress io.Writer, warnings *Warnings ) error {
+    storage, err := classStorage( iw.storage( req ), req )
+    if err != nil {
+        return err
+    }
+    image_name, image_version := parseImageName( name )
+    var body io.Reader = req.Body
+    switch req.Header.Get( "Content-Encoding" ) {
+    case "", "identity":
+    case "gzip":
+        if encoded_storage, ok := storage.(EncodedStorage); ok {
+            if iw.validateGzip {
+                return iw.writeValidatedGzip( storage, name, encoded_storage, req.Body, expectedChecksum( req ) )
+            }
+            //the digest of the decoded image is left to /admin/reindex
+            iw.digests.Remove( image_name + ":" + image_version )
+            warnings.Add( "image is stored gzip encoded without validation, its digest is not indexed" )
+            if expectedChecksum( req ) != "" {
+                warnings.Add( "the expected checksum is not verified without validation" )
+            }
+            return encoded_storage.WriteEncoded( name, "gzip", req.Body )
+        }
+        gz, err := gzip.NewReader( req.Body )
+        if err != nil {
+            return err
+        }
+        defer gz.Close()
+        body = gz
+    default:
+        return fmt.Errorf( "content encoding %s is not supported", req.Header.Get( "Content-Encoding" ) )
+    }
+
+    hash := sha256.New()
+    if progress_storage, ok := storage.(ProgressStorage); ok && progress != nil {
+        err = progress_storage.WriteWithProgress( name, io.TeeReader( body, hash ), progress )
+    } else {
+        err = storage.Write( name, io.TeeReader( body, hash ) )
+    }
+    if err != nil {
+        return err
+    }
+    return iw.indexUploadDigest( storage, name, "sha256:" + hex.EncodeToString( hash.Sum( nil ) ), expectedChecksum( req ) )
+}
+
+// index the digest of the uploaded image name. If it doesn't match the
+// checksum expected by the client, the image is deleted instead
+func (iw *ImageWeb) indexUploadDigest( storage ImageStorage, name string, digest string, expected string ) error {
+    name = normalizeImageName( name )
+    if expected != "" && expected != digest {
+        storage.Delete( name )
+        iw.digests.Remove( name )
+        return fmt.Errorf( "%w: expected %s but got %s", ErrChecksumMismatch, expected, digest )
+    }
+    iw.indexDigest( storage, name, digest )
+    return nil
+}
+
+// store the gzipped image as it is while decompressing it in parallel to
+// check the gzip stream is complete and its CRC is valid. The stored image
+// is deleted and ErrCorruptUpload is returned if the gzip stream is invalid
+func (iw *ImageWeb) writeValidatedGzip( storage ImageStorage, name string, encoded_storage EncodedStorage, body io.Reader, expected string ) error {
+    pr, pw := io.Pipe()
+    hash := sha256.New()
+    validated := make( chan error, 1 )
+    go func() {
+        gz, err := gzip.NewReader( pr )
+        if err == nil {
+            _, err = io.Copy( hash, gz )
+        }
+        //keep reading so the upload is never blocked by the validation
+        io.Copy( ioutil.Discard, pr )
+        validated <- err
+    }()
+
+    err := encoded_storage.WriteEncoded( name, "gzip", io.TeeReader( body, pw ) )
+    pw.Close()
+    validate_err := <-validated
+    image_name, image_version := parseImageName( name )
+    if err != nil {
+        return err
+    }
+    if validate_err != nil {
+        storage.Delete( name )
+        iw.digests.Remove( image_name + ":" + image_version )
+        return fmt.Errorf( "%w: %v", ErrCorruptUpload, validate_err )
+    }
+    return iw.indexUploadDigest( storage, name, "sha256:" + hex.EncodeToString( hash.Sum( nil ) ), expected )
+}
+
+// remember if any content is written, the status of the response can't
+// be changed after that
+type trackingWriter struct {
+    http.ResponseWriter
+    written bool
+}
+
+func (tw *trackingWriter) Write( p []byte ) (int, error) {
+    tw.written = true
+    return tw.ResponseWriter.Write( p )
+}
+
+// stream the image name to rw, the error status is sent if the storage
+// fails before any content is sent
+func (iw *ImageWeb) getImage( storage ImageStorage, name string, rw http.ResponseWriter ) {
+    tw := &trackingWriter{ ResponseWriter: rw }
+    if err := storage.Get( name, tw ); err != nil {
+        iw.failGet( tw, name, err )
+    }
+}
+
+// report the failed get of image name. If the content is partially sent,
+// the connection is aborted so the client notices the truncated download
+func (iw *ImageWeb) failGet( tw *trackingWriter, name string, err error ) {
+    iw.metrics.CountFailure( "get", err )
+    if tw.written {
+        log.Printf( "fail to send image %s: %v", name, err )
+        panic( http.ErrAbortHandler )
+    }
+    //the size set for the image doesn't apply to the error
+    tw.Header().Del( "Content-Length" )
+    switch {
+    case isNotFound( err ):
+        http.Error( tw, "image " + name + " is not found", http.StatusNotFound )
+    case errors.Is( err, ErrBusy ):
+        http.Error( tw, err.Error(), http.StatusServiceUnavailable )
+    case errors.Is( err, ErrArchived ):
+        http.Error( tw, err.Error(), http.StatusConflict )
+    case errors.Is( err, ErrInvalidName ):
+        http.Error( tw, err.Error(), http.StatusBadRequest )
+    default:
+        http.Error( tw, "fail to get image " + name + ": " + err.Error(), http.StatusInternalServerError )
+    }
+}
+
+// stream the image to rw and check its digest against the indexed one on
+// the fly. If they don't match, the connection is aborted so the client
+// detects the bad download instead of getting a complete response
+func (iw *ImageWeb) getVerified( storage ImageStorage, name string, rw http.ResponseWriter ) {
+    image_name, image_version := parseImageName( name )
+    expected, ok := iw.digests.Digest( image_name + ":" + image_version )
+    if !ok {
+        iw.getImage( storage, name, rw )
+        return
+    }
+
+    hash := sha256.New()
+    tw := &trackingWriter{ ResponseWriter: rw }
+    if err := storage.Get( name, io.MultiWriter( tw, hash ) ); err != nil {
+        iw.failGet( tw, name, err )
+        return
+    }
+    if actual := "sha256:" + hex.EncodeToString( hash.Sum( nil ) ); actual != expected {
+        iw.metrics.CountFailure( "get", ErrCorruptUpload )
+        log.Printf( "image %s is corrupted, expected digest %s but got %s", name, expected, actual )
+        panic( http.ErrAbortHandler )
+    }
+}
+
+// find the image names starting with prefix in sorted order, the storage
+// without a prefix index is searched by scanning all the names
+func (iw *ImageWeb) searchImages( prefix string ) ([]string, error) {
+    if searcher, ok := iw.image_storage.(PrefixSearcher); ok {
+        return searcher.Search( prefix )
+    }
+    return searchNames( iw.image_storage, prefix )
+}
+
+// resolve the image name which may be a (short) digest like "sha256:abc123"
+// to the stored image name. If the digest does not match exactly one image
+// the error response is written and false is returned
+func (iw *ImageWeb) resolveName( rw http.ResponseWriter, name string ) (string, bool) {
+    if !strings.HasPrefix( name, "sha256:" ) {
+        return name, true
+    }
+    entries := iw.digests.Match( name )
+    if len( entries ) == 0 {
+        http.Error( rw, "no image matches digest " + name, http.StatusNotFound )
+        return "", false
+    }
+
+    //the images with same digest have same content, so only
+    //the prefix matching different digests is ambiguous
+    for _, entry := range entries {
+        if entry.Digest != entries[0].Digest {
+            rw.Header().Set("Content-Type", "application/json")
+            rw.WriteHeader( http.StatusConflict )
+            json.NewEncoder( rw ).Encode( entries )
+            return "", false
+        }
+    }
+    return entries[0].Name, true
+}
+
+// set the max number of images read at the same time by /admin/reindex
+func (iw *ImageWeb) SetReindexConcurrency( concurrency int ) {
+    if concurrency > 0 {
+        iw.reindexConcurrency = concurrency
+    }
+}
+
+func (iw *ImageWeb) isSbomContentTypeAllowed( content_type string ) bool {
+    media_type, _, err := mime.ParseMediaType( content_type )
+    if err != nil {
+        return false
+    }
+    for _, t := range iw.sbomContentTypes {
+        if strings.EqualFold( t, media_type ) {
+            return true
+        }
+    }
+    return false
+}
+
 func (iw *ImageWeb) init() {
     http.HandleFunc("/image/get/", func(rw http.ResponseWriter, req *http.Request) {
-        a := strings.Split(req.URL.Path, "/")
-        iw.image_storage.Get(a[len(a)-1], rw )
+        name, ok := iw.resolveName( rw, iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/get/" ) ) )
+        if !ok || !iw.checkName( rw, name ) || !iw.authorizeDownload( rw, req, name ) {
+            return
+        }
+        format, ok := negotiateImageFormat( req.Header.Get( "Accept" ) )
+        if !ok {
+            http.Error( rw, "supported formats are application/x-tar and " + ociLayoutMediaType, http.StatusNotAcceptable )
+            return
+        }
+        storage := iw.storage( req )
+        if format == "oci" {
+            if hasRequestStorage( req ) {
+                //the converted layouts are shared by all the requests
+                http.Error( rw, "the storage credentials are not supported by the OCI layout download", http.StatusBadRequest )
+                return
+            }
+            if exists, ok := iw.imageExists( rw, req, name ); !ok {
+                return
+            } else if !exists {
+                http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+                return
+            }
+            //the image is converted before the response is started, so
+            //a failure is still reported by the status
+            tw := &trackingWriter{ ResponseWriter: rw }
+            f, err := iw.openOCI( name )
+            if err != nil {
+                iw.failGet( tw, name, err )
+                return
+            }
+            defer f.Close()
+            rw.Header().Set( "Content-Type", ociLayoutMediaType )
+            if _, err = io.Copy( tw, f ); err != nil {
+                iw.failGet( tw, name, err )
+            }
+            return
+        }
+        if req.URL.Query().Get( "redirect" ) == "true" {
+            //let the client download from the backend directly if possible
+            if presign_storage, ok := storage.(PresignStorage); ok {
+                if url, err := presign_storage.PresignURL( name, iw.presignExpires ); err == nil {
+                    http.Redirect( rw, req, url, http.StatusFound )
+                    return
+                }
+            }
+        }
+        //the ranges are served from the uncompressed image, so the
+        //download can be resumed
+        verify := req.URL.Query().Get( "verify" ) == "true"
+        if verify {
+            //the image is not sent back as uploaded, so there is no digest of
+            //the sent bytes to check
+            if _, stable, _ := contentDigest( storage, name ); stable {
+                http.Error( rw, "verify is not supported by the storage, use /image/verify/ instead", http.StatusNotImplemented )
+                return
+            }
+        }
+        if !verify && ( req.Header.Get( "Range" ) != "" || !acceptsGzip( req ) ) && iw.serveRange( rw, req, name ) {
+            return
+        }
+        //compress the docker-save tar if the client accepts it
+        var gzip_writer *gzipResponseWriter
+        if acceptsGzip( req ) {
+            gzip_writer = newGzipResponseWriter( rw )
+            rw = gzip_writer
+        } else if sized_storage, ok := storage.(SizedStorage); ok {
+            //the response is chunked if the size is not known
+            if size, known, err := sized_storage.Size( name ); err == nil && known {
+                rw.Header().Set( "Content-Length", strconv.FormatInt( size, 10 ) )
+            }
+        }
+        if verify {
+            iw.getVerified( storage, name, rw )
+        } else {
+            iw.getImage( storage, name, rw )
+        }
+        //not reached if the response is aborted by a panic above
+        if gzip_writer != nil {
+            gzip_writer.Close()
+        }
 
     })
 
     http.HandleFunc("/image/list", func(rw http.ResponseWriter, req *http.Request) {
-        if images, err := iw.image_storage.List(); err == nil {
+        query := req.URL.Query()
+        if query.Get( "cursor" ) == "" && ( query.Get( "prefix" ) != "" || query.Get( "offset" ) != "" ) {
+            iw.listOffsetPage( rw, req )
+            return
+        }
+        if query.Get( "cursor" ) != "" || query.Get( "limit" ) != "" {
+            iw.listPage( rw, req )
+            return
+        }
+        if images, err := iw.listImages(); err == nil {
+            images, ok := iw.filterReadable( rw, req, images )
+            if !ok {
+                return
+            }
+            if images, err = iw.filterByAge( req, images ); err != nil {
+                http.Error( rw, err.Error(), http.StatusBadRequest )
+                return
+            }
+            if req.URL.Query().Get( "stream" ) == "true" {
+                streamList( rw, req, images )
+                return
+            }
+            //an empty store is listed as [] rather than null
+            if images == nil {
+                images = make( []string, 0 )
+            }
             rw.Header().Set("Content-Type", "application/json") // normal header
             if b, err := json.Marshal(images); err == nil {
                 rw.Write(b)
@@ -32,24 +616,294 @@ func (iw *ImageWeb) init() {
         }
 
     })
+    http.HandleFunc("/image/search", func(rw http.ResponseWriter, req *http.Request) {
+        images, err := iw.searchImages( req.URL.Query().Get( "prefix" ) )
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        images, ok := iw.filterReadable( rw, req, images )
+        if !ok {
+            return
+        }
+        rw.Header().Set("Content-Type", "application/json")
+        json.NewEncoder( rw ).Encode( images )
+    })
+
     http.HandleFunc("/image/save/", func(rw http.ResponseWriter, req *http.Request) {
-        image_name_info := strings.Split(req.URL.Path, "/")
-        n := len( image_name_info )
         if req.Method == "POST" {
             defer req.Body.Close()
-            err := iw.image_storage.Write( image_name_info[n-2] + ":" + image_name_info[n-1], req.Body )
+            original_name := pathImageName( req.URL.Path, "/image/save/" )
+            name := iw.nameTransform.Apply( original_name )
+            if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, true ) {
+                return
+            }
+
+            //a retried upload gets the result of the previous one
+            //and a repeat of a running upload waits for its result
+            idempotency_key := req.Header.Get( "Idempotency-Key" )
+            saved := false
+            if idempotency_key != "" {
+                idempotency_key = name + "\n" + idempotency_key
+                body, ok, err := iw.idempotency.Start( req.Context(), idempotency_key )
+                if err != nil {
+                    http.Error( rw, err.Error(), http.StatusConflict )
+                    return
+                }
+                if ok {
+                    rw.WriteHeader( http.StatusCreated )
+                    rw.Write( []byte( body ) )
+                    return
+                }
+                defer func() {
+                    iw.idempotency.Finish( idempotency_key, "save image successfully", saved )
+                }()
+            }
+            //the replayed results above don't count as pushes
+            if !iw.allowPush( rw, name ) {
+                return
+            }
+            //the image is read from the source URL instead of the body
+            src := req.URL.Query().Get( "src" )
+            if src != "" {
+                body, length, status, err := iw.fetchImage( req, src )
+                if err != nil {
+                    http.Error( rw, err.Error(), status )
+                    return
+                }
+                defer body.Close()
+                req.Body = body
+                req.ContentLength = length
+            }
+            //stream the load progress of the storage if the client asks for it
+            var progress io.Writer
+            if _, ok := iw.storage( req ).(ProgressStorage); ok && strings.Contains( req.Header.Get( "Accept" ), "application/x-ndjson" ) {
+                rw.Header().Set( "Content-Type", "application/x-ndjson" )
+                progress = &flushWriter{ rw }
+            }
+            existed, ok := iw.imageExists( rw, req, name )
+            if !ok {
+                return
+            }
+            if iw.maxImageSize > 0 && req.ContentLength > iw.maxImageSize {
+                http.Error( rw, fmt.Sprintf( "image is larger than %d bytes", iw.maxImageSize ), http.StatusRequestEntityTooLarge )
+                return
+            }
+            if existed && iw.immutable.IsImmutable( name ) {
+                http.Error( rw, "image " + normalizeImageName( name ) + " is immutable", http.StatusConflict )
+                return
+            }
+            warnings := make( Warnings, 0 )
+            if existed {
+                warnings.Add( "image %s already existed and is overwritten", normalizeImageName( name ) )
+            }
+            if src == "" && isMultipartUpload( req ) {
+                part, err := multipartImagePart( req )
+                if err != nil {
+                    http.Error( rw, err.Error(), http.StatusBadRequest )
+                    return
+                }
+                defer part.Close()
+                req.Body = part
+            }
+            if iw.maxImageSize > 0 {
+                req.Body = http.MaxBytesReader( rw, req.Body, iw.maxImageSize )
+            }
+            body := &countingReadCloser{ ReadCloser: req.Body }
+            req.Body = body
+            err := iw.writeImage( name, req, progress, &warnings )
+            var fetch_err *fetchError
+            iw.uploadHistory.Add( name, body.Count(), err )
             if err == nil {
-                rw.Write( []byte("save image successfully" ) )
+                saved = true
+                if name != original_name {
+                    iw.originalNames.Store( normalizeImageName( name ), original_name )
+                }
+            } else {
+                iw.metrics.CountFailure( "save", err )
+                if isClientAbort( req, err ) || isTooLarge( err ) || errors.As( err, &fetch_err ) {
+                    iw.cleanupAbortedUpload( iw.storage( req ), name, existed )
+                }
+            }
+            iw.listCache.Invalidate()
+            if progress != nil {
+                //the status is already sent with the progress, so
+                //the result is reported as the last progress message
+                result := map[string]interface{}{ "status": "save image successfully", "warnings": warnings }
+                if err != nil {
+                    result = map[string]interface{}{ "error": err.Error() }
+                }
+                json.NewEncoder( progress ).Encode( result )
+            } else if err == nil {
+                warnings.WriteHeaders( rw )
+                if strings.Contains( req.Header.Get( "Accept" ), "application/json" ) {
+                    rw.Header().Set( "Content-Type", "application/json" )
+                    rw.WriteHeader( http.StatusCreated )
+                    json.NewEncoder( rw ).Encode( map[string]interface{}{ "name": normalizeImageName( name ), "status": "ok", "warnings": warnings } )
+                } else {
+                    rw.WriteHeader( http.StatusCreated )
+                    rw.Write( []byte("save image successfully" ) )
+                }
+            } else if errors.Is( err, ErrCorruptUpload ) {
+                http.Error( rw, err.Error(), http.StatusUnprocessableEntity )
+            } else if errors.Is( err, ErrBusy ) {
+                http.Error( rw, err.Error(), http.StatusServiceUnavailable )
+            } else if errors.Is( err, ErrImageConflict ) {
+                http.Error( rw, err.Error(), http.StatusConflict )
+            } else if errors.Is( err, ErrInvalidName ) || errors.Is( err, ErrInvalidImageArchive ) || errors.Is( err, ErrChecksumMismatch ) || errors.Is( err, ErrInvalidStorageClass ) {
+                http.Error( rw, err.Error(), http.StatusBadRequest )
+            } else if errors.Is( err, ErrInsufficientStorage ) {
+                http.Error( rw, err.Error(), http.StatusInsufficientStorage )
+            } else if isTooLarge( err ) {
+                http.Error( rw, fmt.Sprintf( "image is larger than %d bytes", iw.maxImageSize ), http.StatusRequestEntityTooLarge )
+            } else if errors.As( err, &fetch_err ) {
+                //the truncated source is not a client abort
+                http.Error( rw, fetch_err.Error(), fetch_err.Status() )
+            } else if isClientAbort( req, err ) {
+                http.Error( rw, err.Error(), statusClientClosedRequest )
             } else {
-                rw.Write( []byte("fail to save image" ))
+                http.Error( rw, "fail to save image: " + err.Error(), http.StatusInternalServerError )
             }
+        } else {
+            rw.Header().Set( "Allow", "POST" )
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
         }
 
     })
 
+    iw.initBackup()
+    iw.initManifest()
+    iw.initDelete()
+    iw.initListDetailed()
+    iw.initCatalog()
+    iw.initProtect()
+    iw.initConsistency()
+    iw.initOCI()
+    iw.initUploadHistory()
+    iw.initLayers()
+    iw.initConfig()
+    iw.initMetadata()
+    iw.initDownloadToken()
+    iw.initBatchGet()
+    iw.initMaintenance()
+    iw.initHealth()
+    iw.initDiagnostics()
+    iw.initRetag()
+    iw.initVerify()
+    iw.initExists()
+    iw.initRegistry()
+    iw.initExport()
+    iw.initDedup()
+
+    http.Handle("/metrics", iw.metrics.Handler())
+
+    http.HandleFunc("/admin/reindex", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "POST" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        if !iw.reindexing.TryLock() {
+            http.Error( rw, "reindex is already running", http.StatusConflict )
+            return
+        }
+        defer iw.reindexing.Unlock()
+        iw.reindex( rw, req.URL.Query().Get( "full" ) == "true" )
+    })
+
+    http.HandleFunc("/image/sbom/", func(rw http.ResponseWriter, req *http.Request) {
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/sbom/" ) )
+        sbom_storage, ok := iw.image_storage.(SbomStorage)
+        if !ok {
+            http.Error( rw, "SBOM is not supported by the storage", http.StatusNotImplemented )
+            return
+        }
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, req.Method != "GET" ) {
+            return
+        }
+
+        switch req.Method {
+        case "GET":
+            b, content_type, err := sbom_storage.GetSbom( name )
+            if isNotFound( err ) {
+                http.Error( rw, "no SBOM is attached to " + name, http.StatusNotFound )
+            } else if err != nil {
+                http.Error( rw, err.Error(), http.StatusInternalServerError )
+            } else {
+                rw.Header().Set( "Content-Type", content_type )
+                rw.Write( b )
+            }
+        case "POST":
+            defer req.Body.Close()
+            content_type := req.Header.Get( "Content-Type" )
+            if !iw.isSbomContentTypeAllowed( content_type ) {
+                http.Error( rw, "SBOM content type must be one of " + strings.Join( iw.sbomContentTypes, ", " ), http.StatusUnsupportedMediaType )
+                return
+            }
+            b, err := ioutil.ReadAll( http.MaxBytesReader( rw, req.Body, iw.sbomMaxSize ) )
+            if err != nil {
+                http.Error( rw, "SBOM is too large", http.StatusRequestEntityTooLarge )
+                return
+            }
+            err = sbom_storage.WriteSbom( name, content_type, bytes.NewReader( b ) )
+            if isNotFound( err ) {
+                http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+            } else if err != nil {
+                http.Error( rw, err.Error(), http.StatusInternalServerError )
+            } else {
+                rw.Write( []byte("save SBOM successfully") )
+            }
+        default:
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+        }
+    })
+
 }
 
-func (iw *ImageWeb)Serve() {
-    http.ListenAndServe("0.0.0.0:8080", nil)
+// set the permission of the unix socket created when listening on
+// "unix:<path>", 0660 by default. It is ignored for the TCP addresses
+func (iw *ImageWeb) SetSocketMode( socketMode os.FileMode ) {
+    iw.socketMode = socketMode
+}
+
+// the address listened on if none is given
+const defaultListenAddr = ":8080"
+
+// serve the requests on addr, the TCP address or the unix socket
+// "unix:<path>", until Shutdown is called. HTTPS is served if SetTLS is
+// called. http.ErrServerClosed is returned after the shutdown, the listen
+// error is returned as it is
+func (iw *ImageWeb)Serve( addr string ) error {
+    if addr == "" {
+        addr = defaultListenAddr
+    }
+    iw.server.Addr = addr
+    if !strings.HasPrefix( iw.server.Addr, "unix:" ) {
+        if iw.tlsCert != "" {
+            return iw.server.ListenAndServeTLS( iw.tlsCert, iw.tlsKey )
+        }
+        return iw.server.ListenAndServe()
+    }
+
+    //remove the stale socket left by the previous run
+    socket_file := strings.TrimPrefix( iw.server.Addr, "unix:" )
+    if err := os.Remove( socket_file ); err != nil && !os.IsNotExist( err ) {
+        return err
+    }
+    listener, err := net.Listen( "unix", socket_file )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( socket_file )
+    if err = os.Chmod( socket_file, iw.socketMode ); err != nil {
+        listener.Close()
+        return err
+    }
+    if iw.tlsCert != "" {
+        return iw.server.ServeTLS( listener, iw.tlsCert, iw.tlsKey )
+    }
+    return iw.server.Serve( listener )
 }
 
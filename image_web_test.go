@@ -0,0 +1,113 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestGetStatus( t *testing.T ) {
+    storage := &failingStorage{ ImageStorage: newFileStorage( t ), failAfter: 2 }
+    storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/image/get/app:1", nil )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != "image" {
+        t.Errorf( "expected the image, got %d", rw.Code )
+    }
+    rw = doRequest( handler, "GET", "/image/get/app:2", nil )
+    if rw.Code != http.StatusNotFound || !strings.Contains( responseBody( t, rw ), "app:2" ) {
+        t.Errorf( "expected 404 naming the missing image, got %d", rw.Code )
+    }
+    //the storage fails from the third get on
+    rw = doRequest( handler, "GET", "/image/get/app:1", nil )
+    if rw.Code != http.StatusInternalServerError || !strings.Contains( responseBody( t, rw ), "storage failure" ) {
+        t.Errorf( "expected 500 with the error, got %d", rw.Code )
+    }
+}
+
+// a storage whose writes fail with err
+type writeFailingStorage struct {
+    ImageStorage
+    err error
+}
+
+func (wfs *writeFailingStorage) Write( name string, reader io.Reader ) error {
+    return wfs.err
+}
+
+func TestSaveStatus( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    rw := doRequest( handler, "POST", "/image/save/app:1", strings.NewReader( "image" ) )
+    if rw.Code != http.StatusCreated || rw.Body.String() != "save image successfully" {
+        t.Errorf( "expected 201, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    rw = doRequest( handler, "POST", "/image/save/app:2", strings.NewReader( "image" ), "Accept", "application/json" )
+    result := map[string]interface{}{}
+    if err := json.Unmarshal( rw.Body.Bytes(), &result ); err != nil || rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201 with JSON, got %d %q: %v", rw.Code, rw.Body.String(), err )
+    }
+    if result["name"] != "app:2" || result["status"] != "ok" {
+        t.Errorf( "unexpected result %v", result )
+    }
+    for _, method := range []string{ "GET", "PUT", "DELETE" } {
+        rw = doRequest( handler, method, "/image/save/app:1", nil )
+        if rw.Code != http.StatusMethodNotAllowed || rw.Header().Get( "Allow" ) != "POST" {
+            t.Errorf( "%s: expected 405 allowing POST, got %d %q", method, rw.Code, rw.Header().Get( "Allow" ) )
+        }
+    }
+
+    _, handler = newTestWeb( t, &writeFailingStorage{ ImageStorage: newFileStorage( t ), err: errors.New( "disk failure" ) } )
+    rw = doRequest( handler, "POST", "/image/save/app:1", strings.NewReader( "image" ) )
+    if rw.Code != http.StatusInternalServerError || !strings.Contains( rw.Body.String(), "disk failure" ) {
+        t.Errorf( "expected 500 with the error, got %d: %s", rw.Code, rw.Body.String() )
+    }
+}
+
+func TestGetRedirectsToPresignedURL( t *testing.T ) {
+    _, storage := newFakeAzureStorage( t, "images", "" )
+    if err := storage.Write( "app:1", strings.NewReader( "image" ) ); err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    iw.SetPresignExpires( 5 * time.Minute )
+
+    rw := doRequest( handler, "GET", "/image/get/app:1?redirect=true", nil )
+    if rw.Code != http.StatusFound {
+        t.Fatalf( "expected 302, got %d", rw.Code )
+    }
+    location, err := url.Parse( rw.Header().Get( "Location" ) )
+    if err != nil || !strings.HasSuffix( location.Path, "/images/app/1" ) || location.Query().Get( "sig" ) == "" {
+        t.Errorf( "expected the presigned URL of the blob, got %s", rw.Header().Get( "Location" ) )
+    }
+
+    //the image is streamed without ?redirect=true
+    rw = doRequest( handler, "GET", "/image/get/app:1", nil )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != "image" {
+        t.Errorf( "expected the image to be streamed, got %d", rw.Code )
+    }
+
+    //no URL is handed out for the missing image
+    rw = doRequest( handler, "GET", "/image/get/app:2?redirect=true", nil )
+    if rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
+
+func TestGetStreamsWithoutPresigning( t *testing.T ) {
+    storage := NewMemoryImageStorage()
+    if err := storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "GET", "/image/get/app:1?redirect=true", nil )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != "image" {
+        t.Errorf( "expected the image to be streamed, got %d", rw.Code )
+    }
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+    "archive/tar"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// an entry of the docker-save tar which is stored as a separate blob
+type layeredEntry struct {
+    //the entry name in the tar
+    Name string `json:"name"`
+
+    //where the content of the entry is in the skeleton
+    Offset int64 `json:"offset"`
+
+    Digest string `json:"digest"`
+    Size int64 `json:"size"`
+}
+
+// how the docker-save tar of an image is composed of the blobs
+type layeredRecord struct {
+    //the tar without the content of the entries
+    Skeleton string `json:"skeleton"`
+
+    Entries []layeredEntry `json:"entries"`
+}
+
+// a layer listed in the manifest of an image
+type LayerInfo struct {
+    Digest string `json:"digest"`
+    Size int64 `json:"size"`
+}
+
+// the config and layers of a stored image
+type LayerManifest struct {
+    Name string `json:"name"`
+    Config string `json:"config"`
+    Layers []LayerInfo `json:"layers"`
+}
+
+// store the docker-save tar of the images decomposed into content
+// addressable blobs, one per tar entry, so the images share the identical
+// layers and the layers can be downloaded one by one. The blobs are kept
+// in Dir/blobs/sha256/<hex> and the image records in Dir/images/<name>/<version>
+type LayeredImageStorage struct {
+    Dir string
+
+    //protect the records and the reference counts of the blobs
+    mutex sync.Mutex
+
+    images *ImageNameList
+
+    //the number of the records referencing every blob
+    refs map[string]int
+}
+
+func NewLayeredImageStorage( dir string ) (*LayeredImageStorage, error) {
+    lis := &LayeredImageStorage{ Dir: dir, images: NewImageNameList(), refs: make( map[string]int ) }
+    for _, sub_dir := range []string{ "blobs/sha256", "images", "tmp" } {
+        if err := os.MkdirAll( filepath.Join( dir, sub_dir ), 0777 ); err != nil {
+            return nil, err
+        }
+    }
+    if err := lis.load(); err != nil {
+        return nil, err
+    }
+    return lis, nil
+}
+
+// load the image names and count the references of the blobs
+func (lis *LayeredImageStorage) load() error {
+    images_dir := filepath.Join( lis.Dir, "images" )
+    return filepath.Walk( images_dir, func( file string, info os.FileInfo, err error ) error {
+        if err != nil || info.IsDir() {
+            return err
+        }
+        rel, err := filepath.Rel( images_dir, file )
+        if err != nil {
+            return err
+        }
+        name := backupImageName( filepath.ToSlash( rel ) )
+        record, err := lis.readRecord( name )
+        if err != nil {
+            return err
+        }
+        lis.images.Add( name )
+        lis.addRefs( record, 1 )
+        return nil
+    } )
+}
+
+func (lis *LayeredImageStorage) recordFile( name string ) string {
+    return filepath.Join( lis.Dir, "images", filepath.FromSlash( backupEntryName( name ) ) )
+}
+
+func (lis *LayeredImageStorage) blobFile( digest string ) string {
+    return filepath.Join( lis.Dir, "blobs", "sha256", strings.TrimPrefix( digest, "sha256:" ) )
+}
+
+func (lis *LayeredImageStorage) readRecord( name string ) (*layeredRecord, error) {
+    b, err := ioutil.ReadFile( lis.recordFile( name ) )
+    if err != nil {
+        if os.IsNotExist( err ) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    record := &layeredRecord{}
+    if err = json.Unmarshal( b, record ); err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+// change the reference counts of the blobs of record by delta, the blobs
+// no longer referenced are deleted
+func (lis *LayeredImageStorage) addRefs( record *layeredRecord, delta int ) {
+    digests := []string{ record.Skeleton }
+    for _, entry := range record.Entries {
+        digests = append( digests, entry.Digest )
+    }
+    for _, digest := range digests {
+        if digest == "" {
+            continue
+        }
+        lis.refs[digest] += delta
+        if lis.refs[digest] <= 0 {
+            delete( lis.refs, digest )
+            os.Remove( lis.blobFile( digest ) )
+        }
+    }
+}
+
+// write the content read from reader to the blob named by its sha256.
+// The blob is referenced once on behalf of the caller so it is not deleted
+// before the record referencing it is written
+func (lis *LayeredImageStorage) putBlob( reader io.Reader ) (string, int64, error) {
+    f, err := ioutil.TempFile( filepath.Join( lis.Dir, "tmp" ), "blob" )
+    if err != nil {
+        return "", 0, err
+    }
+    hash := sha256.New()
+    size, err := io.Copy( io.MultiWriter( f, hash ), reader )
+    if close_err := f.Close(); err == nil {
+        err = close_err
+    }
+    if err != nil {
+        os.Remove( f.Name() )
+        return "", 0, err
+    }
+    digest := "sha256:" + hex.EncodeToString( hash.Sum( nil ) )
+    lis.mutex.Lock()
+    defer lis.mutex.Unlock()
+    //the same content is already kept
+    if _, err = os.Stat( lis.blobFile( digest ) ); err == nil {
+        os.Remove( f.Name() )
+    } else if err = os.Rename( f.Name(), lis.blobFile( digest ) ); err != nil {
+        os.Remove( f.Name() )
+        return "", 0, err
+    }
+    lis.refs[digest]++
+    return digest, size, nil
+}
+
+// write to w unless it is switched off
+type switchWriter struct {
+    w io.Writer
+    off bool
+    written int64
+}
+
+func (sw *switchWriter) Write( p []byte ) (int, error) {
+    if sw.off {
+        return len( p ), nil
+    }
+    n, err := sw.w.Write( p )
+    sw.written += int64( n )
+    return n, err
+}
+
+// the tar is split into the skeleton (the headers, paddings and trailer)
+// and the content of the regular entries, the tar reader reads exactly
+// the content of the regular entry so it can be cut out of the skeleton
+func (lis *LayeredImageStorage) Write( name string, reader io.Reader ) (err error) {
+    skeleton, err := ioutil.TempFile( filepath.Join( lis.Dir, "tmp" ), "skeleton" )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( skeleton.Name() )
+    defer skeleton.Close()
+
+    sw := &switchWriter{ w: skeleton }
+    raw := io.TeeReader( reader, sw )
+    record := &layeredRecord{ Entries: make( []layeredEntry, 0 ) }
+    //release the blobs already written if the image is not stored
+    defer func() {
+        if err != nil {
+            lis.mutex.Lock()
+            lis.addRefs( record, -1 )
+            lis.mutex.Unlock()
+        }
+    }()
+    tr := tar.NewReader( raw )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return fmt.Errorf( "image %s is not a valid tar: %v", name, err )
+        }
+        if header.Typeflag != tar.TypeReg || header.Size == 0 {
+            continue
+        }
+        sw.off = true
+        digest, size, err := lis.putBlob( tr )
+        sw.off = false
+        if err != nil {
+            return err
+        }
+        record.Entries = append( record.Entries, layeredEntry{ Name: path.Clean( header.Name ), Offset: sw.written, Digest: digest, Size: size } )
+    }
+    //keep the trailer as it is
+    if _, err = io.Copy( ioutil.Discard, raw ); err != nil {
+        return err
+    }
+    if _, err = skeleton.Seek( 0, io.SeekStart ); err != nil {
+        return err
+    }
+    if record.Skeleton, _, err = lis.putBlob( skeleton ); err != nil {
+        return err
+    }
+
+    b, err := json.Marshal( record )
+    if err != nil {
+        return err
+    }
+    lis.mutex.Lock()
+    defer lis.mutex.Unlock()
+    record_file := lis.recordFile( name )
+    if err = os.MkdirAll( filepath.Dir( record_file ), 0777 ); err != nil {
+        return err
+    }
+    old_record, old_err := lis.readRecord( name )
+    if err = ioutil.WriteFile( record_file + ".tmp", b, 0666 ); err == nil {
+        err = os.Rename( record_file + ".tmp", record_file )
+    }
+    if err != nil {
+        return err
+    }
+    if old_err == nil {
+        lis.addRefs( old_record, -1 )
+    }
+    lis.images.Add( normalizeImageName( name ) )
+    return nil
+}
+
+func (lis *LayeredImageStorage) Get( name string, writer io.Writer ) error {
+    record, err := lis.readRecord( name )
+    if err != nil {
+        return err
+    }
+    skeleton, err := os.Open( lis.blobFile( record.Skeleton ) )
+    if err != nil {
+        return err
+    }
+    defer skeleton.Close()
+
+    var offset int64 = 0
+    for _, entry := range record.Entries {
+        if _, err = io.CopyN( writer, skeleton, entry.Offset - offset ); err != nil {
+            return err
+        }
+        offset = entry.Offset
+        if err = lis.GetLayer( entry.Digest, writer ); err != nil {
+            return err
+        }
+    }
+    _, err = io.Copy( writer, skeleton )
+    return err
+}
+
+func (lis *LayeredImageStorage) Delete( name string ) error {
+    lis.mutex.Lock()
+    defer lis.mutex.Unlock()
+
+    record, err := lis.readRecord( name )
+    if err != nil {
+        return err
+    }
+    if err = os.Remove( lis.recordFile( name ) ); err != nil {
+        return err
+    }
+    lis.addRefs( record, -1 )
+    lis.images.Remove( normalizeImageName( name ) )
+    return nil
+}
+
+func (lis *LayeredImageStorage) List() ([]string, error) {
+    return lis.images.Names(), nil
+}
+
+func (lis *LayeredImageStorage) ListAfter( after string, limit int ) ([]string, error) {
+    return lis.images.After( after, limit ), nil
+}
+
+func (lis *LayeredImageStorage) Search( prefix string ) ([]string, error) {
+    return lis.images.Search( prefix ), nil
+}
+
+// write the blob "sha256:<hex>" to writer
+func (lis *LayeredImageStorage) GetLayer( digest string, writer io.Writer ) error {
+    if !strings.HasPrefix( digest, "sha256:" ) {
+        return ErrNotFound
+    }
+    if _, err := hex.DecodeString( strings.TrimPrefix( digest, "sha256:" ) ); err != nil {
+        return ErrNotFound
+    }
+    f, err := os.Open( lis.blobFile( digest ) )
+    if err != nil {
+        if os.IsNotExist( err ) {
+            return ErrNotFound
+        }
+        return err
+    }
+    defer f.Close()
+    _, err = io.Copy( writer, f )
+    return err
+}
+
+// get the config and the layers of image name from its manifest.json
+func (lis *LayeredImageStorage) Layers( name string ) (*LayerManifest, error) {
+    record, err := lis.readRecord( name )
+    if err != nil {
+        return nil, err
+    }
+    entries := make( map[string]layeredEntry )
+    for _, entry := range record.Entries {
+        entries[entry.Name] = entry
+    }
+    manifest_entry, ok := entries["manifest.json"]
+    if !ok {
+        return nil, fmt.Errorf( "no manifest.json in image %s", name )
+    }
+    var manifest_json strings.Builder
+    if err = lis.GetLayer( manifest_entry.Digest, &manifest_json ); err != nil {
+        return nil, err
+    }
+    manifests := make( []dockerSaveManifest, 0 )
+    if err = json.Unmarshal( []byte( manifest_json.String() ), &manifests ); err != nil || len( manifests ) == 0 {
+        return nil, fmt.Errorf( "invalid manifest.json in image %s", name )
+    }
+    result := &LayerManifest{ Name: normalizeImageName( name ),
+                Config: entries[path.Clean( manifests[0].Config )].Digest,
+                Layers: make( []LayerInfo, 0, len( manifests[0].Layers ) ) }
+    for _, layer := range manifests[0].Layers {
+        entry := entries[path.Clean( layer )]
+        result.Layers = append( result.Layers, LayerInfo{ Digest: entry.Digest, Size: entry.Size } )
+    }
+    return result, nil
+}
+
+// get the images having the blob digest
+func (lis *LayeredImageStorage) ImagesWithLayer( digest string ) []string {
+    result := make( []string, 0 )
+    for _, name := range lis.images.Names() {
+        record, err := lis.readRecord( name )
+        if err != nil {
+            continue
+        }
+        for _, entry := range record.Entries {
+            if entry.Digest == digest {
+                result = append( result, name )
+                break
+            }
+        }
+    }
+    return result
+}
+
+func (iw *ImageWeb) initLayers() {
+    http.HandleFunc("/image/manifest/", func(rw http.ResponseWriter, req *http.Request) {
+        layer_storage, ok := iw.image_storage.(LayerStorage)
+        if !ok {
+            http.Error( rw, "the storage does not keep the layers separately", http.StatusNotImplemented )
+            return
+        }
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/manifest/" ) )
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        manifest, err := layer_storage.Layers( name )
+        if isNotFound( err ) {
+            http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+            return
+        } else if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( manifest )
+    })
+
+    http.HandleFunc("/image/layer/", func(rw http.ResponseWriter, req *http.Request) {
+        layer_storage, ok := iw.image_storage.(LayerStorage)
+        if !ok {
+            http.Error( rw, "the storage does not keep the layers separately", http.StatusNotImplemented )
+            return
+        }
+        digest := strings.TrimPrefix( req.URL.Path, "/image/layer/" )
+        //the layer can be read if any image having it can be read
+        images, ok := iw.filterReadable( rw, req, layer_storage.ImagesWithLayer( digest ) )
+        if !ok {
+            return
+        }
+        if len( images ) == 0 {
+            http.Error( rw, "layer " + digest + " is not found", http.StatusNotFound )
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/octet-stream" )
+        rw.Header().Set( "Docker-Content-Digest", digest )
+        if err := layer_storage.GetLayer( digest, rw ); err != nil {
+            iw.metrics.CountFailure( "get", err )
+        }
+    })
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestLayerManifestAndLayer( t *testing.T ) {
+    storage, err := NewLayeredImageStorage( t.TempDir() )
+    if err != nil {
+        t.Fatal( err )
+    }
+    archive := makeImageArchive( t, "app", "app:1" )
+    if err = storage.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/image/manifest/app:1", nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    manifest := LayerManifest{}
+    if err = json.Unmarshal( rw.Body.Bytes(), &manifest ); err != nil {
+        t.Fatal( err )
+    }
+    if manifest.Config != archiveImageID( t, archive ) || len( manifest.Layers ) != 1 {
+        t.Fatalf( "unexpected manifest %+v", manifest )
+    }
+
+    layer := manifest.Layers[0]
+    rw = doRequest( handler, "GET", "/image/layer/" + layer.Digest, nil )
+    if rw.Code != http.StatusOK || rw.Header().Get( "Docker-Content-Digest" ) != layer.Digest {
+        t.Fatalf( "expected the layer, got %d", rw.Code )
+    }
+    if sha256Digest( rw.Body.Bytes() ) != layer.Digest || int64( rw.Body.Len() ) != layer.Size {
+        t.Errorf( "the layer doesn't match the manifest" )
+    }
+
+    if rw = doRequest( handler, "GET", "/image/manifest/app:2", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+    if rw = doRequest( handler, "GET", "/image/layer/" + sha256Digest( []byte( "other" ) ), nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing layer, got %d", rw.Code )
+    }
+    //the layer is gone with its last image
+    storage.Delete( "app:1" )
+    if rw = doRequest( handler, "GET", "/image/layer/" + layer.Digest, nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the layer of the deleted image, got %d", rw.Code )
+    }
+}
+
+func TestLayersNeedLayeredStorage( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    for _, url := range []string{ "/image/manifest/app:1", "/image/layer/" + sha256Digest( []byte( "layer" ) ) } {
+        if rw := doRequest( handler, "GET", url, nil ); rw.Code != http.StatusNotImplemented {
+            t.Errorf( "expected 501 for %s, got %d", url, rw.Code )
+        }
+    }
+}
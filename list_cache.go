@@ -0,0 +1,105 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// keep the image names and details listed by the storage for a short
+// time, so the endpoints hit often don't list an expensive backend on
+// every request. It is invalidated when the images are written or deleted
+type ListCache struct {
+    mutex sync.Mutex
+
+    //how long a listing is reused, 0 to disable the cache
+    ttl time.Duration
+
+    names []string
+    namesAt time.Time
+
+    infos []ImageInfo
+    infosAt time.Time
+
+    //increased by every invalidation, so a listing started before
+    //it is not cached after it
+    generation int64
+}
+
+func NewListCache( ttl time.Duration ) *ListCache {
+    return &ListCache{ ttl: ttl }
+}
+
+// get the image names cached within the ttl or list them with list
+func (lc *ListCache) Names( list func() ([]string, error) ) ([]string, error) {
+    lc.mutex.Lock()
+    if lc.ttl <= 0 {
+        lc.mutex.Unlock()
+        return list()
+    }
+    if lc.names != nil && time.Since( lc.namesAt ) < lc.ttl {
+        names := append( []string{}, lc.names... )
+        lc.mutex.Unlock()
+        return names, nil
+    }
+    generation := lc.generation
+    lc.mutex.Unlock()
+
+    names, err := list()
+    if err != nil {
+        return nil, err
+    }
+    lc.mutex.Lock()
+    defer lc.mutex.Unlock()
+    if generation == lc.generation {
+        lc.names = append( []string{}, names... )
+        lc.namesAt = time.Now()
+    }
+    return names, nil
+}
+
+// get the image details cached within the ttl or list them with list
+func (lc *ListCache) Infos( list func() ([]ImageInfo, error) ) ([]ImageInfo, error) {
+    lc.mutex.Lock()
+    if lc.ttl <= 0 {
+        lc.mutex.Unlock()
+        return list()
+    }
+    if lc.infos != nil && time.Since( lc.infosAt ) < lc.ttl {
+        infos := append( []ImageInfo{}, lc.infos... )
+        lc.mutex.Unlock()
+        return infos, nil
+    }
+    generation := lc.generation
+    lc.mutex.Unlock()
+
+    infos, err := list()
+    if err != nil {
+        return nil, err
+    }
+    lc.mutex.Lock()
+    defer lc.mutex.Unlock()
+    if generation == lc.generation {
+        lc.infos = append( []ImageInfo{}, infos... )
+        lc.infosAt = time.Now()
+    }
+    return infos, nil
+}
+
+// drop the cached listings after the images are changed
+func (lc *ListCache) Invalidate() {
+    lc.mutex.Lock()
+    defer lc.mutex.Unlock()
+    lc.names = nil
+    lc.infos = nil
+    lc.generation++
+}
+
+// reuse the listings of the storage for ttl, 0 to list it on every request
+func (iw *ImageWeb) SetListCacheTTL( ttl time.Duration ) {
+    iw.listCache = NewListCache( ttl )
+}
+
+// list the image names of the storage through the cache
+func (iw *ImageWeb) listImages() ([]string, error) {
+    return iw.listCache.Names( iw.image_storage.List )
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestListCache( t *testing.T ) {
+    storage := &countingStorage{ ImageStorage: newFileStorage( t ) }
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetListCacheTTL( time.Minute )
+    list := func() string {
+        rw := doRequest( handler, "GET", "/image/list", nil )
+        if rw.Code != http.StatusOK {
+            t.Fatalf( "expected 200, got %d", rw.Code )
+        }
+        return rw.Body.String()
+    }
+
+    for i := 0; i < 3; i++ {
+        list()
+    }
+    if n := storage.called( "List" ); n != 1 {
+        t.Errorf( "expected the storage to be listed once within the ttl, got %d", n )
+    }
+    //the write invalidates the cache
+    if rw := doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( makeImageArchive( t, "app", "app:2" ) ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+    lists := storage.called( "List" )
+    if names := list(); !strings.Contains( names, "app:2" ) {
+        t.Errorf( "expected the written image to be listed, got %s", names )
+    }
+    if n := storage.called( "List" ) - lists; n != 1 {
+        t.Errorf( "expected the storage to be listed again after the write, got %d", n )
+    }
+    //so does the delete
+    doRequest( handler, "DELETE", "/image/delete/app:1", nil )
+    if names := list(); strings.Contains( names, "app:1" ) {
+        t.Errorf( "expected the deleted image not to be listed, got %s", names )
+    }
+}
+
+func TestListCacheExpires( t *testing.T ) {
+    cache := NewListCache( 20 * time.Millisecond )
+    lists := 0
+    list := func() ([]string, error) {
+        lists++
+        return []string{ "app:1" }, nil
+    }
+    cache.Names( list )
+    cache.Names( list )
+    time.Sleep( 30 * time.Millisecond )
+    cache.Names( list )
+    if lists != 2 {
+        t.Errorf( "expected the names to be listed again after the ttl, got %d lists", lists )
+    }
+
+    //the cache is disabled with 0 ttl
+    cache = NewListCache( 0 )
+    lists = 0
+    cache.Names( list )
+    cache.Names( list )
+    if lists != 2 {
+        t.Errorf( "expected every call to list without the cache, got %d lists", lists )
+    }
+}
+
+func TestDockerListCache( t *testing.T ) {
+    fd, storage := newFakeDocker( t )
+    storage.SetListCacheTTL( time.Minute )
+    lists := func() int {
+        fd.mutex.Lock()
+        defer fd.mutex.Unlock()
+        return fd.lists
+    }
+    if err := storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "one", "app:1" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    start := lists()
+    storage.List()
+
+    //the concurrent lists share the cached names
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add( 1 )
+        go func() {
+            defer wg.Done()
+            if names, err := storage.List(); err != nil || len( names ) != 1 {
+                t.Errorf( "expected app:1, got %v: %v", names, err )
+            }
+        }()
+    }
+    wg.Wait()
+    if n := lists() - start; n != 1 {
+        t.Errorf( "expected the daemon to be listed once within the ttl, got %d", n )
+    }
+
+    //the write and the delete invalidate the cache
+    if err := storage.Write( "app:2", bytes.NewReader( makeImageArchive( t, "two", "app:2" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    if names, _ := storage.List(); len( names ) != 2 {
+        t.Errorf( "expected the written image to be listed, got %v", names )
+    }
+    if err := storage.Delete( "app:1" ); err != nil {
+        t.Fatal( err )
+    }
+    if names, _ := storage.List(); len( names ) != 1 || names[0] != "app:2" {
+        t.Errorf( "expected the deleted image not to be listed, got %v", names )
+    }
+    if n := lists() - start; n != 3 {
+        t.Errorf( "expected the daemon to be listed after every change, got %d", n )
+    }
+}
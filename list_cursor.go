@@ -0,0 +1,169 @@
+package main
+
+import (
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+const (
+    defaultPageLimit = 100
+    maxPageLimit = 1000
+)
+
+// a page of the image list
+type listPage struct {
+    Images []string `json:"images"`
+
+    //pass it as the cursor to get the next page, empty at the end
+    NextCursor string `json:"next_cursor,omitempty"`
+    End bool `json:"end"`
+}
+
+// a page of the image names matching a prefix, by offset
+type offsetListPage struct {
+    Images []string `json:"images"`
+
+    //the number of the matching images of all the pages
+    Total int `json:"total"`
+    Offset int `json:"offset"`
+    End bool `json:"end"`
+}
+
+// get the sorted names starting with prefix from offset, up to limit names,
+// and the number of all the names starting with prefix. The page is empty
+// if offset is beyond the matching names
+func pageImageNames( names []string, prefix string, offset int, limit int ) ([]string, int) {
+    matched := make( []string, 0 )
+    for _, name := range names {
+        if strings.HasPrefix( name, prefix ) {
+            matched = append( matched, name )
+        }
+    }
+    sort.Strings( matched )
+    if offset >= len( matched ) {
+        return make( []string, 0 ), len( matched )
+    }
+    end := len( matched )
+    if limit < end - offset {
+        end = offset + limit
+    }
+    return matched[offset:end], len( matched )
+}
+
+// the cursor is the last name of the page, opaque to the clients
+func encodeCursor( name string ) string {
+    return base64.RawURLEncoding.EncodeToString( []byte( name ) )
+}
+
+func decodeCursor( cursor string ) (string, error) {
+    b, err := base64.RawURLEncoding.DecodeString( cursor )
+    if err != nil {
+        return "", errors.New( "invalid cursor" )
+    }
+    return string( b ), nil
+}
+
+// get up to limit image names after the name after in sorted order, the
+// storage without a sorted index is paged by sorting all the names
+func (iw *ImageWeb) listAfter( after string, limit int ) ([]string, error) {
+    if lister, ok := iw.image_storage.(CursorLister); ok {
+        return lister.ListAfter( after, limit )
+    }
+    images, err := searchNames( iw.image_storage, "" )
+    if err != nil {
+        return nil, err
+    }
+    i := sort.SearchStrings( images, after )
+    if i < len( images ) && images[i] == after {
+        i++
+    }
+    images = images[i:]
+    if len( images ) > limit {
+        images = images[0:limit]
+    }
+    return images, nil
+}
+
+// parse the ?limit of the page, the error response is written and false
+// is returned if it is invalid
+func parsePageLimit( rw http.ResponseWriter, req *http.Request ) (int, bool) {
+    limit := defaultPageLimit
+    if s := req.URL.Query().Get( "limit" ); s != "" {
+        var err error
+        if limit, err = strconv.Atoi( s ); err != nil || limit <= 0 || limit > maxPageLimit {
+            http.Error( rw, "limit must be between 1 and " + strconv.Itoa( maxPageLimit ), http.StatusBadRequest )
+            return 0, false
+        }
+    }
+    return limit, true
+}
+
+// list a page of the images starting with ?prefix from ?offset with up
+// to ?limit names. The names the request can't read are not counted
+func (iw *ImageWeb) listOffsetPage( rw http.ResponseWriter, req *http.Request ) {
+    offset := 0
+    if s := req.URL.Query().Get( "offset" ); s != "" {
+        var err error
+        if offset, err = strconv.Atoi( s ); err != nil || offset < 0 {
+            http.Error( rw, "offset must be a non-negative integer", http.StatusBadRequest )
+            return
+        }
+    }
+    limit, ok := parsePageLimit( rw, req )
+    if !ok {
+        return
+    }
+    images, err := iw.listImages()
+    if err != nil {
+        http.Error( rw, err.Error(), http.StatusInternalServerError )
+        return
+    }
+    if images, ok = iw.filterReadable( rw, req, images ); !ok {
+        return
+    }
+    page := offsetListPage{ Offset: offset }
+    page.Images, page.Total = pageImageNames( images, req.URL.Query().Get( "prefix" ), offset, limit )
+    page.End = offset + len( page.Images ) >= page.Total
+    rw.Header().Set( "Content-Type", "application/json" )
+    json.NewEncoder( rw ).Encode( page )
+}
+
+// list a page of the images after the ?cursor with up to ?limit names
+func (iw *ImageWeb) listPage( rw http.ResponseWriter, req *http.Request ) {
+    after := ""
+    if cursor := req.URL.Query().Get( "cursor" ); cursor != "" {
+        var err error
+        if after, err = decodeCursor( cursor ); err != nil {
+            http.Error( rw, err.Error(), http.StatusBadRequest )
+            return
+        }
+    }
+    limit, ok := parsePageLimit( rw, req )
+    if !ok {
+        return
+    }
+    //one more name is got to know if this is the last page
+    images, err := iw.listAfter( after, limit + 1 )
+    if err != nil {
+        http.Error( rw, err.Error(), http.StatusInternalServerError )
+        return
+    }
+    page := listPage{ End: len( images ) <= limit }
+    if !page.End {
+        images = images[0:limit]
+        page.NextCursor = encodeCursor( images[limit - 1] )
+    }
+    //the cursor is based on all the names, so a page may be short
+    //after the names the request can't read are filtered out
+    if images, ok = iw.filterReadable( rw, req, images ); !ok {
+        return
+    }
+    page.Images = images
+    rw.Header().Set( "Content-Type", "application/json" )
+    json.NewEncoder( rw ).Encode( page )
+}
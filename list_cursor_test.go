@@ -0,0 +1,112 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strings"
+    "testing"
+)
+
+// walk the list page by page and get all the names in the order listed
+func walkListPages( t *testing.T, handler http.Handler, limit int ) []string {
+    t.Helper()
+    names := make( []string, 0 )
+    cursor := ""
+    for pages := 0; ; pages++ {
+        if pages > 1000 {
+            t.Fatal( "the list doesn't end" )
+        }
+        rw := doRequest( handler, "GET", fmt.Sprintf( "/image/list?limit=%d&cursor=%s", limit, url.QueryEscape( cursor ) ), nil )
+        if rw.Code != http.StatusOK {
+            t.Fatalf( "expected 200, got %d: %s", rw.Code, rw.Body.String() )
+        }
+        page := listPage{}
+        if err := json.Unmarshal( rw.Body.Bytes(), &page ); err != nil {
+            t.Fatal( err )
+        }
+        if len( page.Images ) > limit {
+            t.Fatalf( "expected at most %d names in a page, got %d", limit, len( page.Images ) )
+        }
+        names = append( names, page.Images... )
+        if page.End {
+            if page.NextCursor != "" {
+                t.Errorf( "expected no cursor at the end" )
+            }
+            return names
+        }
+        cursor = page.NextCursor
+    }
+}
+
+func TestListCursorPages( t *testing.T ) {
+    for _, storage := range []ImageStorage{ newFileStorage( t ), NewSplitImageStorage( newFileStorage( t ), newFileStorage( t ) ) } {
+        expected := make( []string, 0 )
+        for i := 0; i < 53; i++ {
+            name := fmt.Sprintf( "team-%d/app:%02d", i % 3, i )
+            storage.Write( name, bytes.NewReader( []byte( name ) ) )
+            expected = append( expected, name )
+        }
+        _, handler := newTestWeb( t, storage )
+        for _, limit := range []int{ 1, 10, 53, 100 } {
+            names := walkListPages( t, handler, limit )
+            seen := make( map[string]bool )
+            for i, name := range names {
+                if seen[name] {
+                    t.Errorf( "limit %d: %s is listed twice", limit, name )
+                }
+                seen[name] = true
+                if i > 0 && names[i - 1] >= name {
+                    t.Errorf( "limit %d: expected the names sorted, got %s after %s", limit, name, names[i - 1] )
+                }
+            }
+            for _, name := range expected {
+                if !seen[name] {
+                    t.Errorf( "limit %d: %s is not listed", limit, name )
+                }
+            }
+        }
+    }
+}
+
+func TestListCursorEmptyAndInvalid( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    if names := walkListPages( t, handler, 10 ); len( names ) != 0 {
+        t.Errorf( "expected no names, got %v", names )
+    }
+    if rw := doRequest( handler, "GET", "/image/list?cursor=%25%25", nil ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 for the invalid cursor, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/list?limit=0", nil ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 for the invalid limit, got %d", rw.Code )
+    }
+}
+
+func TestListPrefixOffset( t *testing.T ) {
+    storage := newFileStorage( t )
+    for _, name := range []string{ "app:1", "app:2", "app:3", "application:1", "web:1" } {
+        storage.Write( name, bytes.NewReader( []byte( name ) ) )
+    }
+    _, handler := newTestWeb( t, storage )
+    for _, c := range []struct{ query string; expected string }{
+                { "prefix=app:", `{"images":["app:1","app:2","app:3"],"total":3,"offset":0,"end":true}` },
+                { "prefix=app", `{"images":["app:1","app:2","app:3","application:1"],"total":4,"offset":0,"end":true}` },
+                { "prefix=app&limit=2", `{"images":["app:1","app:2"],"total":4,"offset":0,"end":false}` },
+                { "prefix=app&limit=2&offset=2", `{"images":["app:3","application:1"],"total":4,"offset":2,"end":true}` },
+                { "prefix=app&limit=2&offset=3", `{"images":["application:1"],"total":4,"offset":3,"end":true}` },
+                { "prefix=app&offset=4", `{"images":[],"total":4,"offset":4,"end":true}` },
+                { "offset=10", `{"images":[],"total":5,"offset":10,"end":true}` },
+                { "prefix=db", `{"images":[],"total":0,"offset":0,"end":true}` } } {
+        rw := doRequest( handler, "GET", "/image/list?" + c.query, nil )
+        if rw.Code != http.StatusOK || strings.TrimSpace( rw.Body.String() ) != c.expected {
+            t.Errorf( "%s: expected %s, got %d %s", c.query, c.expected, rw.Code, rw.Body.String() )
+        }
+    }
+    for _, query := range []string{ "offset=-1", "offset=x", "prefix=app&limit=0" } {
+        if rw := doRequest( handler, "GET", "/image/list?" + query, nil ); rw.Code != http.StatusBadRequest {
+            t.Errorf( "%s: expected 400, got %d", query, rw.Code )
+        }
+    }
+}
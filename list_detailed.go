@@ -0,0 +1,116 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+// the detailed information of a stored image
+type ImageInfo struct {
+    //the image name in "name:version" format
+    Name string `json:"name"`
+    Repository string `json:"repository"`
+    Tag string `json:"tag"`
+
+    //the ID and size of the image in the backend, if it is known
+    ID string `json:"id,omitempty"`
+    Size int64 `json:"size,omitempty"`
+
+    //the digest of the image content if it is known
+    Digest string `json:"digest,omitempty"`
+
+    //the number of image names sharing the stored content
+    RefCount int `json:"refcount"`
+
+    //the name uploaded by the client if it was rewritten on ingress
+    OriginalName string `json:"original_name,omitempty"`
+
+    //when the image was stored and how long ago, if it is known
+    Created *time.Time `json:"created,omitempty"`
+    Age string `json:"age,omitempty"`
+
+    //the storage class of the image in the cloud backend
+    StorageClass string `json:"storage_class,omitempty"`
+}
+
+// get the detailed information of the images
+func (iw *ImageWeb) listDetailed( images []string ) ([]ImageInfo, error) {
+    counter, _ := iw.image_storage.(RefCounter)
+    timed, _ := iw.image_storage.(TimedStorage)
+    //the details known by the backend are got at once
+    backend_infos := make( map[string]ImageInfo )
+    lister, has_details := iw.image_storage.(DetailedLister)
+    if has_details {
+        infos, err := iw.listCache.Infos( lister.ListDetailed )
+        if err != nil {
+            return nil, err
+        }
+        for _, info := range infos {
+            backend_infos[info.Name] = info
+        }
+    }
+    now := time.Now()
+    result := make( []ImageInfo, 0, len( images ) )
+    for _, image := range images {
+        info, ok := backend_infos[image]
+        if !ok && has_details {
+            //deleted while it is listed
+            continue
+        }
+        if !ok {
+            info = ImageInfo{ Name: image, RefCount: 1 }
+            info.Repository, info.Tag = parseImageName( image )
+        }
+        info.Digest, _ = iw.digests.Digest( image )
+        if original_name, ok := iw.originalNames.Load( image ); ok {
+            info.OriginalName = original_name.(string)
+        }
+        if counter != nil {
+            refs, err := counter.RefCount( image )
+            if isNotFound( err ) {
+                //deleted while it is listed
+                continue
+            }
+            if err != nil {
+                return nil, err
+            }
+            info.RefCount = refs
+        }
+        if info.Created != nil {
+            info.Age = now.Sub( *info.Created ).Round( time.Second ).String()
+        } else if timed != nil {
+            if created, err := timed.CreatedAt( image ); err == nil {
+                info.Created = &created
+                info.Age = now.Sub( created ).Round( time.Second ).String()
+            }
+        }
+        result = append( result, info )
+    }
+    return result, nil
+}
+
+func (iw *ImageWeb) initListDetailed() {
+    http.HandleFunc("/image/list/detailed", func(rw http.ResponseWriter, req *http.Request) {
+        images, err := iw.listImages()
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        images, ok := iw.filterReadable( rw, req, images )
+        if !ok {
+            return
+        }
+        if images, err = iw.filterByAge( req, images ); err != nil {
+            http.Error( rw, err.Error(), http.StatusBadRequest )
+            return
+        }
+        infos, err := iw.listDetailed( images )
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( infos )
+    })
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+    "compress/gzip"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// number of names written between two flushes of the streamed list
+const listStreamFlushEvery = 100
+
+// write the images as NDJSON, one JSON string per line. The stream is
+// gzipped if the client accepts it and is flushed periodically so the
+// client can start decoding before the whole list is written
+func streamList( rw http.ResponseWriter, req *http.Request, images []string ) {
+    rw.Header().Set( "Content-Type", "application/x-ndjson" )
+    var w io.Writer = rw
+    var gz *gzip.Writer
+    if strings.Contains( req.Header.Get( "Accept-Encoding" ), "gzip" ) {
+        rw.Header().Set( "Content-Encoding", "gzip" )
+        gz = gzip.NewWriter( rw )
+        defer gz.Close()
+        w = gz
+    }
+    flusher, _ := rw.(http.Flusher)
+    flush := func() {
+        if gz != nil {
+            gz.Flush()
+        }
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+
+    encoder := json.NewEncoder( w )
+    for i, image := range images {
+        if err := encoder.Encode( image ); err != nil {
+            return
+        }
+        if ( i + 1 ) % listStreamFlushEvery == 0 {
+            flush()
+        }
+    }
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestEmptyList( t *testing.T ) {
+    _, docker_storage := newFakeDocker( t )
+    for _, storage := range []ImageStorage{ newFileStorage( t ), docker_storage } {
+        _, handler := newTestWeb( t, storage )
+        rw := doRequest( handler, "GET", "/image/list", nil )
+        if body := strings.TrimSpace( rw.Body.String() ); rw.Code != 200 || body != "[]" {
+            t.Errorf( "expected [] for the empty %T, got %d %s", storage, rw.Code, body )
+        }
+        if rw := doRequest( handler, "GET", "/image/list?stream=true", nil ); rw.Code != 200 || rw.Body.Len() != 0 {
+            t.Errorf( "expected no line in the stream of the empty %T, got %q", storage, rw.Body.String() )
+        }
+    }
+}
+
+func TestStreamListGzipped( t *testing.T ) {
+    storage := newFileStorage( t )
+    for i := 0; i < 250; i++ {
+        storage.Write( fmt.Sprintf( "app:%03d", i ), bytes.NewReader( []byte( "image" ) ) )
+    }
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "GET", "/image/list?stream=true", nil, "Accept-Encoding", "gzip" )
+    if rw.Header().Get( "Content-Encoding" ) != "gzip" || rw.Header().Get( "Content-Type" ) != "application/x-ndjson" {
+        t.Fatalf( "expected a gzipped NDJSON stream, got %v", rw.Header() )
+    }
+    if !rw.Flushed {
+        t.Error( "expected the stream to be flushed before the end" )
+    }
+    gz, err := gzip.NewReader( rw.Body )
+    if err != nil {
+        t.Fatal( err )
+    }
+    decoder := json.NewDecoder( gz )
+    received := make( map[string]bool )
+    for {
+        var name string
+        if err := decoder.Decode( &name ); err == io.EOF {
+            break
+        } else if err != nil {
+            t.Fatalf( "fail to decode the stream after %d names: %v", len( received ), err )
+        }
+        received[name] = true
+    }
+    if len( received ) != 250 || !received["app:000"] || !received["app:249"] {
+        t.Errorf( "expected all the 250 names, got %d", len( received ) )
+    }
+}
+
+// get the detailed list by image name
+func listDetailedByName( t *testing.T, handler http.Handler ) map[string]ImageInfo {
+    t.Helper()
+    rw := doRequest( handler, "GET", "/image/list/detailed", nil )
+    var infos []ImageInfo
+    if err := json.Unmarshal( rw.Body.Bytes(), &infos ); err != nil {
+        t.Fatalf( "invalid detailed list %q: %v", rw.Body.String(), err )
+    }
+    result := make( map[string]ImageInfo )
+    for _, info := range infos {
+        result[info.Name] = info
+    }
+    return result
+}
+
+func TestListRefCount( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    storage.Dedup = true
+    _, handler := newTestWeb( t, storage )
+    shared := makeImageArchive( t, "shared", "app:1" )
+    for name, archive := range map[string][]byte{ "app:1": shared, "app:2": shared, "team/app:3": shared, "app:4": makeImageArchive( t, "unique", "app:4" ) } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+    infos := listDetailedByName( t, handler )
+    for name, expected := range map[string]int{ "app:1": 3, "app:2": 3, "team/app:3": 3, "app:4": 1 } {
+        if refs := infos[name].RefCount; refs != expected {
+            t.Errorf( "expected the refcount %d of %s, got %d", expected, name, refs )
+        }
+    }
+
+    //the storage without deduplication has one reference per image
+    _, handler = newTestWeb( t, newFileStorage( t ) )
+    doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( shared ) )
+    doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( shared ) )
+    for name, info := range listDetailedByName( t, handler ) {
+        if info.RefCount != 1 {
+            t.Errorf( "expected the refcount 1 of %s without deduplication, got %d", name, info.RefCount )
+        }
+    }
+}
+
+func TestFileStorageListInfo( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    sizes := map[string]int{ "app:1": 1, "app:2": 10, "team/web:1": 100, "gone:1": 5 }
+    for name, size := range sizes {
+        if err = storage.Write( name, bytes.NewReader( bytes.Repeat( []byte( "x" ), size ) ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    uploaded := time.Date( 2020, 1, 2, 3, 4, 5, 0, time.UTC )
+    image_file, _ := storage.imageFile( "app:2" )
+    if err = os.Chtimes( image_file, uploaded, uploaded ); err != nil {
+        t.Fatal( err )
+    }
+    //the file deleted behind the storage is skipped
+    image_file, _ = storage.imageFile( "gone:1" )
+    os.Remove( image_file )
+
+    _, handler := newTestWeb( t, storage )
+    infos := listDetailedByName( t, handler )
+    if len( infos ) != 3 {
+        t.Fatalf( "expected 3 images, got %v", infos )
+    }
+    for name, size := range sizes {
+        info, ok := infos[name]
+        if name == "gone:1" {
+            if ok {
+                t.Errorf( "expected the deleted file to be skipped, got %+v", info )
+            }
+            continue
+        }
+        if !ok || info.Size != int64( size ) || info.Created == nil {
+            t.Errorf( "expected %s of %d bytes, got %+v", name, size, info )
+        }
+    }
+    if created := infos["app:2"].Created; created == nil || !created.Equal( uploaded ) {
+        t.Errorf( "expected app:2 to be uploaded at %v, got %v", uploaded, created )
+    }
+    if info := infos["team/web:1"]; info.Repository != "team/web" || info.Tag != "1" {
+        t.Errorf( "expected the repository and tag of team/web:1, got %+v", info )
+    }
+}
@@ -1,15 +1,246 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
 	"github.com/fsouza/go-dockerclient"
 )
 
 func main() {
-	endpoint := "unix:///var/run/docker.sock"
-	client, err := docker.NewClient(endpoint)
+	sbomMaxSize := flag.Int64("sbom-max-size", 10*1024*1024, "max size in bytes of an uploaded SBOM document")
+	sbomContentTypes := flag.String("sbom-content-types", "application/spdx+json,application/vnd.cyclonedx+json", "comma separated media types accepted for SBOM documents")
+	ociCacheSize := flag.Int("oci-cache-size", 16, "how many images are kept converted to the OCI layout for the downloads, 0 to disable")
+	idempotencyWindow := flag.Duration("idempotency-window", 10*time.Minute, "how long the result of an upload is remembered by its Idempotency-Key, 0 to disable")
+	uploadHistory := flag.Int("upload-history", 10, "how many upload attempts are kept for every image, 0 to disable")
+	reindexConcurrency := flag.Int("reindex-concurrency", 4, "max number of images read at the same time when rebuilding the digest index")
+	accessConfig := flag.String("access-config", "", "the JSON file of the identities and the repositories they can access")
+	restoreFile := flag.String("restore", "", "import the images from the backup archive file into the storage and exit")
+	overwrite := flag.Bool("overwrite", false, "overwrite the existing images when importing with -restore")
+	shutdownGrace := flag.Duration("shutdown-grace", 5*time.Minute, "how long the in-flight transfers can take to finish on shutdown before they are force-closed")
+	listen := flag.String("listen", defaultListenAddr, "the TCP address or the unix socket \"unix:<path>\" to listen on")
+	registryAPI := flag.Bool("registry-api", false, "serve the pulls of the Docker Registry HTTP API v2 under /v2/, so \"docker pull <host>/<name>:<tag>\" works")
+	allowFetch := flag.Bool("allow-fetch", false, "let /image/save/ read the image from the URL of ?src=<url> instead of the request body")
+	fetchTimeout := flag.Duration("fetch-timeout", 30*time.Minute, "how long fetching an image from the ?src URL can take")
+	tlsCert := flag.String("tls-cert", "", "the certificate file to serve HTTPS with, -tls-key must be given too")
+	tlsKey := flag.String("tls-key", "", "the private key file of the certificate given by -tls-cert")
+	tlsMinVersion := flag.String("tls-min-version", "1.2", "the minimum TLS version accepted when serving HTTPS: 1.0, 1.1, 1.2 or 1.3")
+	socketMode := flag.Uint("socket-mode", 0660, "the permission of the unix socket")
+	dockerEndpoints := flag.String("docker-endpoints", "", "comma separated docker daemons \"<endpoint>[=<weight>]\" to spread the images over")
+	dockerReplicas := flag.Int("docker-replicas", 0, "the number of docker daemons an image is loaded into, 0 for all")
+	verbose := flag.Bool("verbose", false, "log which docker daemon serves each request")
+	dockerRetagInterval := flag.Duration("docker-retag-interval", 0, "how often the image tags dropped by the docker daemon are re-applied, 0 to disable")
+	dockerMaxConcurrency := flag.Int("docker-max-concurrency", 4, "max number of concurrent operations on a docker daemon, 0 for no limit")
+	backendWait := flag.Duration("backend-wait", time.Minute, "how long an operation waits when the storage is at its concurrency cap")
+	validateGzip := flag.Bool("validate-gzip", false, "check the integrity of the gzip encoded uploads which are stored as-is")
+	protectedTags := flag.String("protected-tags", "", "comma separated \"name:version\" patterns of the images which can't be deleted")
+	listCacheTTL := flag.Duration("list-cache-ttl", 0, "how long the image listing of the storage is reused by the requests, 0 to disable")
+	logFormat := flag.String("log-format", "text", "the format of the request log: text, json or none")
+	maxImageSize := flag.Int64("max-image-size", 0, "max size in bytes of an uploaded image, 0 for no limit")
+	authUser := flag.String("auth-user", "", "the user of the basic auth required by every request, empty to disable")
+	authPass := flag.String("auth-pass", "", "the password of the basic auth user, read from IMAGE_MGR_AUTH_PASS if it is empty")
+	immutableRepos := flag.String("immutable-repos", "", "comma separated repository patterns whose images can't be overwritten or deleted")
+	nameRewrite := flag.String("name-rewrite", "", "rewrite the image names on ingress with the rule \"<regexp>=><replacement>\"")
+	stripRegistryHost := flag.Bool("strip-registry-host", false, "strip the leading registry host from the image names on ingress")
+	upstreamRegistry := flag.String("upstream-registry", "", "pull the images missing locally from this registry through the docker daemon")
+	mirrorPersist := flag.Bool("mirror-persist", true, "keep the images pulled from the upstream registry in the storage")
+	maxNameLength := flag.Int("max-name-length", 255, "max length of the repository part of the image names, 0 for no limit")
+	maxTagLength := flag.Int("max-tag-length", 128, "max length of the tag part of the image names, 0 for no limit")
+	strictTags := flag.Bool("strict-tags", false, "only accept the image tags following the docker tag rules")
+	backend := flag.String("backend", "", "the storage backend: docker, file, mongo, gcs, azure or layered, the default is layered if -layered-dir is set and docker otherwise")
+	fileDir := flag.String("file-dir", "", "the directory of the file backend")
+	fileDirMode := flag.Uint("file-dir-mode", 0755, "the permission of the directories created by the file backend, applied regardless of the umask")
+	fileMode := flag.Uint("file-mode", 0644, "the permission of the files created by the file backend, applied regardless of the umask")
+	dedup := flag.Bool("dedup", false, "store the identical images of the file backend only once")
+	compress := flag.Bool("compress", false, "gzip the images of the file backend before storing them")
+	fileMaxConcurrency := flag.Int("file-max-concurrency", 0, "max number of concurrent operations on the files of the file backend, 0 for no limit")
+	minFreeSpace := flag.String("min-free-space", "", "reject the uploads of the file backend when the free disk space is below the comma separated thresholds in bytes or in percent, e.g. \"10737418240,5%\"")
+	mongoURL := flag.String("mongo-url", "", "the URL of the mongo server of the mongo backend")
+	mongoDB := flag.String("mongo-db", "", "the database of the mongo backend")
+	mongoPrefix := flag.String("mongo-prefix", "fs", "the GridFS prefix of the mongo backend")
+	mongoDialTimeout := flag.Duration("mongo-dial-timeout", 10*time.Second, "how long the dial to the mongo server can take")
+	mongoRetries := flag.Int("mongo-retries", 3, "how many times a failed mongo dial, open or remove is retried")
+	mongoInlineThreshold := flag.Int64("mongo-inline-threshold", 0, "store the images not larger than this many bytes in a document instead of the GridFS of the mongo backend, 0 to store all in the GridFS")
+	mongoMaxConcurrency := flag.Int("mongo-max-concurrency", 0, "max number of concurrent operations on the mongo backend, 0 for no limit")
+	gcsBucket := flag.String("gcs-bucket", "", "the Google Cloud Storage bucket of the gcs backend, accessed with the application default credentials")
+	gcsPrefix := flag.String("gcs-prefix", "", "the object path prefix of the images in the bucket of the gcs backend")
+	azureAccount := flag.String("azure-account", "", "the storage account of the azure backend, accessed with the managed identity unless AZURE_STORAGE_CONNECTION_STRING is set")
+	azureContainer := flag.String("azure-container", "", "the blob container of the azure backend")
+	azurePrefix := flag.String("azure-prefix", "", "the blob path prefix of the images in the container of the azure backend")
+	azureRestoreArchived := flag.Bool("azure-restore-archived", false, "start rehydrating the archived blob of the azure backend to the Hot tier when its pull is rejected")
+	objectContentType := flag.String("object-content-type", "", "the content type of the objects stored by the gcs and azure backends, detected from the image if empty: application/gzip or application/x-tar")
+	layeredDir := flag.String("layered-dir", "", "store the images decomposed into content addressable layers in the directory instead of the docker daemon")
+	splitIndexDir := flag.String("split-index-dir", "", "keep the image names, digests, labels and SBOMs in the directory and only the image content in the backend")
+	dockerRemoveDangling := flag.Bool("docker-remove-dangling", false, "remove the previous image of a tag once a new one is loaded and the previous one is dangling and unused")
+	dockerListCacheTTL := flag.Duration("docker-list-cache-ttl", 0, "how long the image listing of a docker daemon is reused until an image is loaded or removed, 0 to disable")
+	operationPriorities := flag.String("operation-priorities", "get=10,delete=5,write=0", "which waiting operations are served first when the backend is at its concurrency cap, in <operation>=<priority> format")
+	tokenSecret := flag.String("download-token-secret", "", "the key signing the download tokens, a random one is used if it is empty")
+	tokenTTL := flag.Duration("download-token-ttl", 5*time.Minute, "how long a download token is valid")
+	presignExpires := flag.Duration("presign-expires", 15*time.Minute, "how long the presigned URL of the gcs and azure backends a ?redirect=true download is redirected to is valid")
+	maintenanceInterval := flag.Duration("maintenance-interval", 0, "how often the storage is compacted, 0 to disable")
+	maintenanceJitter := flag.Duration("maintenance-jitter", 5*time.Minute, "the max random delay added to every scheduled compaction")
+	pushRate := flag.Float64("push-rate", 0, "max pushes per second to every repository, 0 for no limit")
+	pushBurst := flag.Int("push-burst", 10, "max pushes to a repository in a burst when -push-rate is set")
+	flag.Parse()
+
+	priorities, err := ParseOperationPriorities(*operationPriorities)
 	if err != nil {
 		panic(err)
 	}
-	image_storage := NewDockerImageStorage(client)
-    NewImageWeb( image_storage ).Serve()
+
+	min_free_space, err := ParseFreeSpaceThreshold(*minFreeSpace)
+	if err != nil {
+		panic(err)
+	}
+
+	image_storage, err := newStorage(Config{
+		Backend:              *backend,
+		FileDir:              *fileDir,
+		FileDirMode:          os.FileMode(*fileDirMode),
+		FileMode:             os.FileMode(*fileMode),
+		Dedup:                *dedup,
+		Compress:             *compress,
+		MinFreeSpace:         min_free_space,
+		FileMaxConcurrency:   *fileMaxConcurrency,
+		MongoURL:             *mongoURL,
+		MongoDB:              *mongoDB,
+		MongoPrefix:          *mongoPrefix,
+		MongoDialTimeout:     *mongoDialTimeout,
+		MongoRetries:         *mongoRetries,
+		MongoMaxConcurrency:  *mongoMaxConcurrency,
+		MongoInlineThreshold: *mongoInlineThreshold,
+		GCSBucket:            *gcsBucket,
+		GCSPrefix:            *gcsPrefix,
+		AzureAccount:         *azureAccount,
+		AzureContainer:       *azureContainer,
+		AzurePrefix:          *azurePrefix,
+		AzureRestoreArchived: *azureRestoreArchived,
+		ObjectContentType:    *objectContentType,
+		LayeredDir:           *layeredDir,
+		SplitIndexDir:        *splitIndexDir,
+		DockerEndpoints:      *dockerEndpoints,
+		DockerReplicas:       *dockerReplicas,
+		DockerMaxConcurrency: *dockerMaxConcurrency,
+		DockerRemoveDangling: *dockerRemoveDangling,
+		DockerListCacheTTL:   *dockerListCacheTTL,
+		Verbose:              *verbose,
+		BackendWait:          *backendWait,
+		OperationPriorities:  priorities})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if docker_storage, ok := image_storage.(*DockerImageStorage); ok && *dockerRetagInterval > 0 {
+		docker_storage.StartRetag(*dockerRetagInterval)
+		defer docker_storage.StopRetag()
+	}
+	if *upstreamRegistry != "" {
+		endpoint, err := mirrorDockerEndpoint(*dockerEndpoints)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		client, err := docker.NewClient(endpoint)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		image_storage = NewMirrorImageStorage(image_storage, client, *upstreamRegistry, *mirrorPersist)
+	}
+	image_web := NewImageWeb(image_storage)
+	image_web.SetSocketMode(os.FileMode(*socketMode))
+	if err = image_web.SetTLS(*tlsCert, *tlsKey, *tlsMinVersion); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	image_web.SetMaintenanceSchedule(*maintenanceInterval, *maintenanceJitter)
+	image_web.SetPushRateLimit(*pushRate, *pushBurst)
+	image_web.SetEffectiveConfig(EffectiveConfig(flag.CommandLine))
+	image_web.SetSbomLimits(*sbomMaxSize, strings.Split(*sbomContentTypes, ","))
+	image_web.SetIdempotencyWindow(*idempotencyWindow)
+	if err = image_web.SetDownloadTokens([]byte(*tokenSecret), *tokenTTL); err != nil {
+		panic(err)
+	}
+	image_web.SetPresignExpires(*presignExpires)
+	image_web.SetUploadHistory(*uploadHistory)
+	image_web.SetOCICacheSize(*ociCacheSize)
+	image_web.SetReindexConcurrency(*reindexConcurrency)
+	image_web.SetValidateGzip(*validateGzip)
+	image_web.SetNameLimits(NameLimits{MaxNameLength: *maxNameLength, MaxTagLength: *maxTagLength, Strict: *strictTags})
+	if *nameRewrite != "" || *stripRegistryHost {
+		nt, err := NewNameTransform(*nameRewrite, *stripRegistryHost)
+		if err != nil {
+			panic(err)
+		}
+		image_web.SetNameTransform(nt)
+	}
+	if *protectedTags != "" {
+		image_web.SetProtectedPatterns(strings.Split(*protectedTags, ","))
+	}
+	image_web.SetListCacheTTL(*listCacheTTL)
+	image_web.SetRegistryAPI(*registryAPI)
+	image_web.SetFetch(*allowFetch, *fetchTimeout)
+	image_web.SetMaxImageSize(*maxImageSize)
+	if err = image_web.SetLogFormat(*logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *authUser != "" {
+		password := *authPass
+		if password == "" {
+			password = os.Getenv("IMAGE_MGR_AUTH_PASS")
+		}
+		image_web.SetBasicAuth(*authUser, password)
+	}
+	if *immutableRepos != "" {
+		image_web.SetImmutableRepositories(strings.Split(*immutableRepos, ","))
+	}
+	if *accessConfig != "" {
+		ac, err := LoadAccessControl(*accessConfig)
+		if err != nil {
+			panic(err)
+		}
+		image_web.SetAccessControl(ac)
+	}
+	if *restoreFile != "" {
+		f, err := os.Open(*restoreFile)
+		if err != nil {
+			panic(err)
+		}
+		defer f.Close()
+		results, err := image_web.restore(f, *overwrite)
+		for _, result := range results {
+			fmt.Printf("%s: %s %s\n", result.Name, result.Status, result.Error)
+		}
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	//the digests are looked up in the background so the server starts at
+	//once, the index is kept in memory only. The registry index is built
+	//after it, as the converted manifests are kept by the image digests
+	go func() {
+		if _, err := image_web.loadDigests(); err != nil {
+			fmt.Fprintln(os.Stderr, "fail to load the image digests:", err)
+		}
+		if _, err := image_web.loadRegistryIndex(); err != nil {
+			fmt.Fprintln(os.Stderr, "fail to index the images for the registry API:", err)
+		}
+	}()
+
+	//drain the in-flight transfers on SIGINT/SIGTERM
+	image_web.SetShutdownGrace(*shutdownGrace)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if err := image_web.ServeContext(ctx, *listen); err != nil {
+		panic(err)
+	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "io/ioutil"
+    "log"
+    "math/rand"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// optional interface implemented by the storage which can reclaim the
+// space of the unreferenced blobs and the leftover temporary files
+type Compactor interface {
+    Compact() error
+}
+
+// run the maintenance of the storage periodically, at most one run is
+// in progress at any time
+type MaintenanceScheduler struct {
+    storage ImageStorage
+
+    //1 while a run is in progress
+    running int32
+
+    stop chan struct{}
+    done sync.WaitGroup
+}
+
+func NewMaintenanceScheduler( storage ImageStorage ) *MaintenanceScheduler {
+    return &MaintenanceScheduler{ storage: storage }
+}
+
+// run the maintenance now unless a run is already in progress, false is
+// returned if it is skipped
+func (ms *MaintenanceScheduler) RunOnce() (bool, error) {
+    if !atomic.CompareAndSwapInt32( &ms.running, 0, 1 ) {
+        return false, nil
+    }
+    defer atomic.StoreInt32( &ms.running, 0 )
+    compactor, ok := ms.storage.(Compactor)
+    if !ok {
+        return true, nil
+    }
+    return true, compactor.Compact()
+}
+
+// run the maintenance every interval plus a random delay up to jitter, so
+// the instances sharing a backend don't run it at the same time
+func (ms *MaintenanceScheduler) Start( interval time.Duration, jitter time.Duration ) {
+    if interval <= 0 || ms.stop != nil {
+        return
+    }
+    ms.stop = make( chan struct{} )
+    ms.done.Add( 1 )
+    go func() {
+        defer ms.done.Done()
+        for {
+            delay := interval
+            if jitter > 0 {
+                delay += time.Duration( rand.Int63n( int64( jitter ) ) )
+            }
+            timer := time.NewTimer( delay )
+            select {
+            case <-ms.stop:
+                timer.Stop()
+                return
+            case <-timer.C:
+            }
+            if ran, err := ms.RunOnce(); !ran {
+                log.Printf( "skip the scheduled maintenance, the previous one is still running" )
+            } else if err != nil {
+                log.Printf( "fail to run the scheduled maintenance: %v", err )
+            }
+        }
+    }()
+}
+
+// stop the schedule and wait for the run in progress to finish
+func (ms *MaintenanceScheduler) Stop() {
+    if ms.stop == nil {
+        return
+    }
+    close( ms.stop )
+    ms.done.Wait()
+    ms.stop = nil
+}
+
+// remove the blobs no image references, e.g. left by a crash between
+// writing the blobs and the record, and the stale temporary files
+func (lis *LayeredImageStorage) Compact() error {
+    tmp_dir := filepath.Join( lis.Dir, "tmp" )
+    entries, err := ioutil.ReadDir( tmp_dir )
+    if err != nil {
+        return err
+    }
+    //the temporary files of the uploads in progress are recent
+    for _, entry := range entries {
+        if time.Since( entry.ModTime() ) > time.Hour {
+            os.Remove( filepath.Join( tmp_dir, entry.Name() ) )
+        }
+    }
+
+    blobs_dir := filepath.Join( lis.Dir, "blobs", "sha256" )
+    if entries, err = ioutil.ReadDir( blobs_dir ); err != nil {
+        return err
+    }
+    lis.mutex.Lock()
+    defer lis.mutex.Unlock()
+    for _, entry := range entries {
+        if _, ok := lis.refs["sha256:" + entry.Name()]; !ok {
+            os.Remove( filepath.Join( blobs_dir, entry.Name() ) )
+        }
+    }
+    return nil
+}
+
+// run the maintenance every interval with a random delay up to jitter,
+// 0 interval to disable it
+func (iw *ImageWeb) SetMaintenanceSchedule( interval time.Duration, jitter time.Duration ) {
+    iw.maintenance.Stop()
+    iw.maintenance.Start( interval, jitter )
+}
+
+func (iw *ImageWeb) initMaintenance() {
+    http.HandleFunc("/admin/compact", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "POST" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        if !iw.authorizeAdmin( rw, req ) {
+            return
+        }
+        ran, err := iw.maintenance.RunOnce()
+        if !ran {
+            http.Error( rw, "the maintenance is already running", http.StatusConflict )
+            return
+        }
+        if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        rw.Write( []byte( "compact successfully" ) )
+    })
+}
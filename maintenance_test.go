@@ -0,0 +1,111 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// a storage whose compaction signals started and waits for release
+type blockingCompactor struct {
+    ImageStorage
+
+    runs int32
+    started chan struct{}
+    release chan struct{}
+}
+
+func newBlockingCompactor( t *testing.T ) *blockingCompactor {
+    return &blockingCompactor{ ImageStorage: newFileStorage( t ), started: make( chan struct{}, 1 ), release: make( chan struct{} ) }
+}
+
+func (bc *blockingCompactor) Compact() error {
+    atomic.AddInt32( &bc.runs, 1 )
+    select {
+    case bc.started <- struct{}{}:
+    default:
+    }
+    <-bc.release
+    return nil
+}
+
+func TestMaintenanceScheduleFires( t *testing.T ) {
+    storage := newBlockingCompactor( t )
+    close( storage.release )
+    ms := NewMaintenanceScheduler( storage )
+    ms.Start( 10 * time.Millisecond, 5 * time.Millisecond )
+    select {
+    case <-storage.started:
+    case <-time.After( 5 * time.Second ):
+        t.Fatal( "the scheduled compaction is not run" )
+    }
+    ms.Stop()
+    runs := atomic.LoadInt32( &storage.runs )
+    time.Sleep( 50 * time.Millisecond )
+    if atomic.LoadInt32( &storage.runs ) != runs {
+        t.Errorf( "expected no compaction after the schedule is stopped" )
+    }
+}
+
+func TestMaintenanceSkippedWhileRunning( t *testing.T ) {
+    storage := newBlockingCompactor( t )
+    iw, handler := newTestWeb( t, storage )
+    done := make( chan bool )
+    go func() {
+        ran, _ := iw.maintenance.RunOnce()
+        done <- ran
+    }()
+    <-storage.started
+
+    if ran, _ := iw.maintenance.RunOnce(); ran {
+        t.Errorf( "expected the second compaction to be skipped" )
+    }
+    if rw := doRequest( handler, "POST", "/admin/compact", nil ); rw.Code != http.StatusConflict {
+        t.Errorf( "expected 409 while compacting, got %d", rw.Code )
+    }
+    close( storage.release )
+    if !<-done {
+        t.Errorf( "expected the first compaction to run" )
+    }
+    if rw := doRequest( handler, "POST", "/admin/compact", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected 200 once the compaction finished, got %d", rw.Code )
+    }
+    if runs := atomic.LoadInt32( &storage.runs ); runs != 2 {
+        t.Errorf( "expected 2 compactions, got %d", runs )
+    }
+}
+
+func TestLayeredStorageCompact( t *testing.T ) {
+    dir := t.TempDir()
+    storage, err := NewLayeredImageStorage( dir )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if err = storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "app", "app:1" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    //an unreferenced blob and a stale temporary file
+    orphan := filepath.Join( dir, "blobs", "sha256", "0000" )
+    stale := filepath.Join( dir, "tmp", "upload" )
+    ioutil.WriteFile( orphan, []byte( "orphan" ), 0644 )
+    ioutil.WriteFile( stale, []byte( "stale" ), 0644 )
+    old := time.Now().Add( -2 * time.Hour )
+    os.Chtimes( stale, old, old )
+
+    if err = storage.Compact(); err != nil {
+        t.Fatal( err )
+    }
+    for _, file := range []string{ orphan, stale } {
+        if _, err := os.Stat( file ); !os.IsNotExist( err ) {
+            t.Errorf( "expected %s to be removed", file )
+        }
+    }
+    if manifest, err := storage.Layers( "app:1" ); err != nil || len( manifest.Layers ) != 1 {
+        t.Errorf( "expected the blobs of app:1 to be kept: %v", err )
+    }
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+    "archive/tar"
+    "bufio"
+    "compress/gzip"
+    "errors"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "path"
+    "strings"
+)
+
+// returned when the tar has no such entry, unlike ErrNotFound of the
+// storage which means there is no such image
+var errNoTarEntry = errors.New( "no such tar entry" )
+
+// read the entry of the (gzipped) tar from r, the rest of the tar after
+// the entry is not read. errNoTarEntry is returned if there is no such entry
+func readTarEntry( r io.Reader, entry string ) ([]byte, error) {
+    br := bufio.NewReader( r )
+    var src io.Reader = br
+    if magic, err := br.Peek( 2 ); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+        gz, err := gzip.NewReader( br )
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        src = gz
+    }
+
+    tr := tar.NewReader( src )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil, errNoTarEntry
+        }
+        if err != nil {
+            return nil, err
+        }
+        if path.Clean( header.Name ) == entry {
+            return ioutil.ReadAll( tr )
+        }
+    }
+}
+
+// read the manifest.json of the docker-save tar of image name, the
+// transfer of the image is stopped once the manifest is read
+func (iw *ImageWeb) readManifest( name string ) ([]byte, error) {
+    pr, pw := io.Pipe()
+    go func() {
+        pw.CloseWithError( iw.image_storage.Get( name, pw ) )
+    }()
+    defer pr.Close()
+
+    return readTarEntry( pr, "manifest.json" )
+}
+
+func (iw *ImageWeb) initManifest() {
+    http.HandleFunc("/image/manifest-raw/", func(rw http.ResponseWriter, req *http.Request) {
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/manifest-raw/" ) )
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        b, err := iw.readManifest( name )
+        if err == errNoTarEntry {
+            http.Error( rw, "no manifest.json in image " + name, http.StatusNotFound )
+        } else if isNotFound( err ) {
+            http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+        } else if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+        } else {
+            rw.Header().Set( "Content-Type", "application/json" )
+            rw.Write( b )
+        }
+    })
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "compress/gzip"
+    "io"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestManifestRaw( t *testing.T ) {
+    storage := newFileStorage( t )
+    archive := makeImageArchive( t, "manifest", "app:1" )
+    storage.Write( "app:1", bytes.NewReader( archive ) )
+    storage.Write( "app:2", bytes.NewReader( make( []byte, 1024 ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/image/manifest-raw/app:1", nil )
+    if rw.Code != http.StatusOK || !strings.Contains( responseBody( t, rw ), `"app:1"` ) {
+        t.Errorf( "expected the manifest.json of app:1, got %d", rw.Code )
+    }
+    rw = doRequest( handler, "GET", "/image/manifest-raw/app:2", nil )
+    if body := responseBody( t, rw ); rw.Code != http.StatusNotFound || !strings.Contains( body, "no manifest.json" ) {
+        t.Errorf( "expected 404 for the image without manifest.json, got %d %s", rw.Code, body )
+    }
+    rw = doRequest( handler, "GET", "/image/manifest-raw/app:3", nil )
+    if body := responseBody( t, rw ); rw.Code != http.StatusNotFound || !strings.Contains( body, "is not found" ) {
+        t.Errorf( "expected 404 for the missing image, got %d %s", rw.Code, body )
+    }
+}
+
+// count the bytes read from the reader
+type countingReader struct {
+    r io.Reader
+    n int64
+}
+
+func (cr *countingReader) Read( p []byte ) (int, error) {
+    n, err := cr.r.Read( p )
+    cr.n += int64( n )
+    return n, err
+}
+
+func TestReadManifestOfLargeTar( t *testing.T ) {
+    var archive bytes.Buffer
+    tw := tar.NewWriter( &archive )
+    writeTarFile( t, tw, "manifest.json", []byte( `[{"RepoTags":["app:1"]}]` ) )
+    writeTarFile( t, tw, "layer/layer.tar", make( []byte, 16 << 20 ) )
+    tw.Close()
+    var gzipped bytes.Buffer
+    gw := gzip.NewWriter( &gzipped )
+    gw.Write( archive.Bytes() )
+    gw.Close()
+
+    for kind, b := range map[string][]byte{ "plain": archive.Bytes(), "gzipped": gzipped.Bytes() } {
+        cr := &countingReader{ r: bytes.NewReader( b ) }
+        manifest, err := readTarEntry( cr, "manifest.json" )
+        if err != nil || string( manifest ) != `[{"RepoTags":["app:1"]}]` {
+            t.Errorf( "expected the manifest of the %s tar, got %q: %v", kind, manifest, err )
+        }
+        if cr.n > 1 << 20 {
+            t.Errorf( "expected only the manifest of the %s tar to be read, read %d bytes", kind, cr.n )
+        }
+    }
+}
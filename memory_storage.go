@@ -0,0 +1,84 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "sort"
+    "sync"
+)
+
+// keep the images in memory, e.g. to serve the handlers without any
+// daemon, database or directory. The images are lost when the process exits
+type MemoryImageStorage struct {
+    mutex sync.Mutex
+    images map[string][]byte
+}
+
+func NewMemoryImageStorage() *MemoryImageStorage {
+    return &MemoryImageStorage{ images: make( map[string][]byte ) }
+}
+
+// the image is only stored if it is read completely
+func (mis *MemoryImageStorage) Write( name string, reader io.Reader ) error {
+    data, err := ioutil.ReadAll( reader )
+    if err != nil {
+        return err
+    }
+    mis.mutex.Lock()
+    defer mis.mutex.Unlock()
+    mis.images[normalizeImageName( name )] = data
+    return nil
+}
+
+func (mis *MemoryImageStorage) Get( name string, writer io.Writer ) error {
+    mis.mutex.Lock()
+    data, ok := mis.images[normalizeImageName( name )]
+    mis.mutex.Unlock()
+    if !ok {
+        return fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    //the data is never changed in place, so it is written without the lock
+    _, err := io.Copy( writer, bytes.NewReader( data ) )
+    return err
+}
+
+func (mis *MemoryImageStorage) Delete( name string ) error {
+    mis.mutex.Lock()
+    defer mis.mutex.Unlock()
+    name = normalizeImageName( name )
+    if _, ok := mis.images[name]; !ok {
+        return fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    delete( mis.images, name )
+    return nil
+}
+
+func (mis *MemoryImageStorage) List() ([]string, error) {
+    mis.mutex.Lock()
+    defer mis.mutex.Unlock()
+    result := make( []string, 0, len( mis.images ) )
+    for name := range mis.images {
+        result = append( result, name )
+    }
+    sort.Strings( result )
+    return result, nil
+}
+
+func (mis *MemoryImageStorage) Exists( name string ) (bool, error) {
+    mis.mutex.Lock()
+    defer mis.mutex.Unlock()
+    _, ok := mis.images[normalizeImageName( name )]
+    return ok, nil
+}
+
+func (mis *MemoryImageStorage) Size( name string ) (int64, bool, error) {
+    mis.mutex.Lock()
+    defer mis.mutex.Unlock()
+    data, ok := mis.images[normalizeImageName( name )]
+    if !ok {
+        return 0, false, fmt.Errorf( "%w: %s", ErrNotFound, name )
+    }
+    return int64( len( data ) ), true, nil
+}
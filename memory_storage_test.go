@@ -0,0 +1,83 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestMemoryStorageIncompleteWrite( t *testing.T ) {
+    storage := NewMemoryImageStorage()
+    storage.Write( "app:1", strings.NewReader( "old" ) )
+    broken := &errorAfterReader{ r: strings.NewReader( "new" ), err: io.ErrUnexpectedEOF }
+    if err := storage.Write( "app:1", broken ); err == nil {
+        t.Fatal( "expected the broken write to fail" )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "app:1", &b ); err != nil || b.String() != "old" {
+        t.Errorf( "expected the image to be kept, got %q: %v", b.String(), err )
+    }
+    if size, known, err := storage.Size( "app:1" ); err != nil || !known || size != 3 {
+        t.Errorf( "expected the size 3, got %d %v: %v", size, known, err )
+    }
+    if _, _, err := storage.Size( "app:2" ); !isNotFound( err ) {
+        t.Errorf( "expected the missing image to be not found, got %v", err )
+    }
+}
+
+func TestMemoryStorageConcurrent( t *testing.T ) {
+    storage := NewMemoryImageStorage()
+    var wg sync.WaitGroup
+    for i := 0; i < 20; i++ {
+        wg.Add( 1 )
+        go func( i int ) {
+            defer wg.Done()
+            name := fmt.Sprintf( "app:%d", i % 5 )
+            storage.Write( name, strings.NewReader( name ) )
+            storage.Get( name, ioutil.Discard )
+            storage.List()
+            if i % 2 == 0 {
+                storage.Delete( name )
+            }
+        }( i )
+    }
+    wg.Wait()
+    names, err := storage.List()
+    if err != nil {
+        t.Fatal( err )
+    }
+    for _, name := range names {
+        var b bytes.Buffer
+        if err = storage.Get( name, &b ); err != nil || b.String() != name {
+            t.Errorf( "expected %s to keep its content, got %q: %v", name, b.String(), err )
+        }
+    }
+}
+
+// the handlers served from the memory without any daemon or directory
+func TestMemoryStorageHandlers( t *testing.T ) {
+    _, handler := newTestWeb( t, NewMemoryImageStorage() )
+    if rw := doRequest( handler, "POST", "/image/save/team/app:1", strings.NewReader( "image" ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/list", nil ); strings.TrimSpace( rw.Body.String() ) != `["team/app:1"]` {
+        t.Errorf( "expected team/app:1 to be listed, got %s", rw.Body.String() )
+    }
+    if rw := doRequest( handler, "HEAD", "/image/exists/team/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected team/app:1 to exist, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/get/team/app:1", nil ); rw.Code != http.StatusOK || rw.Body.String() != "image" {
+        t.Errorf( "expected the image, got %d %q", rw.Code, rw.Body.String() )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/team/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected 200 for the delete, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/get/team/app:1", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 after the delete, got %d", rw.Code )
+    }
+}
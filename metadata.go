@@ -0,0 +1,136 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+)
+
+// returned when the metadata is updated based on an outdated version
+var ErrVersionConflict = errors.New( "metadata version conflict" )
+
+// the labels of an image, Version is increased by every update
+type ImageMetadata struct {
+    Version int64 `json:"version"`
+    Labels map[string]string `json:"labels"`
+}
+
+// the metadata of image is kept in the sidecar file ".<version>.metadata"
+func (fis *FileImageStorage) GetMetadata( name string ) (*ImageMetadata, error) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat( image_file ); err != nil {
+        if os.IsNotExist( err ) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    metadata := &ImageMetadata{ Labels: make( map[string]string ) }
+    b, err := ioutil.ReadFile( fis.sidecarFile( name, "metadata" ) )
+    if os.IsNotExist( err ) {
+        return metadata, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    if err = json.Unmarshal( b, metadata ); err != nil {
+        return nil, err
+    }
+    return metadata, nil
+}
+
+// replace the labels of image name if its metadata is still at version,
+// any version is accepted if version is negative. The read-modify-write
+// of the sidecar is serialized per image so no update is lost
+func (fis *FileImageStorage) UpdateMetadata( name string, version int64, labels map[string]string ) (*ImageMetadata, error) {
+    unlock := fis.metadataLocker.Lock( normalizeImageName( name ) )
+    defer unlock()
+
+    metadata, err := fis.GetMetadata( name )
+    if err != nil {
+        return nil, err
+    }
+    if version >= 0 && version != metadata.Version {
+        return metadata, ErrVersionConflict
+    }
+    metadata = &ImageMetadata{ Version: metadata.Version + 1, Labels: labels }
+    b, err := json.Marshal( metadata )
+    if err != nil {
+        return nil, err
+    }
+    metadata_file := fis.sidecarFile( name, "metadata" )
+    if err = fis.writeSmallFile( metadata_file + ".tmp", b ); err == nil {
+        err = os.Rename( metadata_file + ".tmp", metadata_file )
+    }
+    if err != nil {
+        return nil, err
+    }
+    return metadata, nil
+}
+
+func metadataETag( metadata *ImageMetadata ) string {
+    return strconv.Quote( strconv.FormatInt( metadata.Version, 10 ) )
+}
+
+func (iw *ImageWeb) initMetadata() {
+    http.HandleFunc("/image/metadata/", func(rw http.ResponseWriter, req *http.Request) {
+        metadata_storage, ok := iw.image_storage.(MetadataStorage)
+        if !ok {
+            http.Error( rw, "the storage does not support the image metadata", http.StatusNotImplemented )
+            return
+        }
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/metadata/" ) )
+        write := req.Method == "PUT"
+        if !write && req.Method != "GET" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, write ) {
+            return
+        }
+
+        var metadata *ImageMetadata
+        var err error
+        if write {
+            //the update is unconditional without If-Match
+            version := int64( -1 )
+            if if_match := req.Header.Get( "If-Match" ); if_match != "" {
+                if version, err = strconv.ParseInt( strings.Trim( if_match, `"` ), 10, 64 ); err != nil {
+                    http.Error( rw, "invalid If-Match " + if_match, http.StatusBadRequest )
+                    return
+                }
+            }
+            update := ImageMetadata{}
+            if err = json.NewDecoder( req.Body ).Decode( &update ); err != nil {
+                http.Error( rw, "invalid metadata: " + err.Error(), http.StatusBadRequest )
+                return
+            }
+            if update.Labels == nil {
+                update.Labels = make( map[string]string )
+            }
+            metadata, err = metadata_storage.UpdateMetadata( name, version, update.Labels )
+        } else {
+            metadata, err = metadata_storage.GetMetadata( name )
+        }
+        if err == ErrVersionConflict {
+            rw.Header().Set( "ETag", metadataETag( metadata ) )
+            http.Error( rw, "the metadata is updated to version " + strconv.FormatInt( metadata.Version, 10 ) + " meanwhile", http.StatusConflict )
+            return
+        } else if isNotFound( err ) {
+            http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+            return
+        } else if err != nil {
+            http.Error( rw, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        rw.Header().Set( "ETag", metadataETag( metadata ) )
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( metadata )
+    })
+}
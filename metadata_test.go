@@ -0,0 +1,88 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+)
+
+func TestMetadataUpdates( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "app", "app:1" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "PUT", "/image/metadata/app:1", strings.NewReader( `{"labels":{"team":"a"}}` ), "If-Match", `"0"` )
+    if rw.Code != http.StatusOK || rw.Header().Get( "ETag" ) != `"1"` {
+        t.Fatalf( "expected version 1, got %d %s", rw.Code, rw.Header().Get( "ETag" ) )
+    }
+    //the update based on the outdated version is rejected
+    rw = doRequest( handler, "PUT", "/image/metadata/app:1", strings.NewReader( `{"labels":{"team":"b"}}` ), "If-Match", `"0"` )
+    if rw.Code != http.StatusConflict || rw.Header().Get( "ETag" ) != `"1"` {
+        t.Errorf( "expected 409 with the current version, got %d %s", rw.Code, rw.Header().Get( "ETag" ) )
+    }
+    rw = doRequest( handler, "GET", "/image/metadata/app:1", nil )
+    if rw.Code != http.StatusOK || !strings.Contains( rw.Body.String(), `"team":"a"` ) {
+        t.Errorf( "expected the first labels, got %d %s", rw.Code, rw.Body.String() )
+    }
+    if rw = doRequest( handler, "GET", "/image/metadata/app:2", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+    if rw = doRequest( handler, "PUT", "/image/metadata/app:1", strings.NewReader( `{}` ), "If-Match", "latest" ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 for the invalid If-Match, got %d", rw.Code )
+    }
+}
+
+// every client adds its label by read-modify-write, retrying on conflicts
+func TestConcurrentMetadataUpdates( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, "app", "app:1" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    clients := 8
+    var wg sync.WaitGroup
+    for i := 0; i < clients; i++ {
+        wg.Add( 1 )
+        go func( i int ) {
+            defer wg.Done()
+            for {
+                rw := doRequest( handler, "GET", "/image/metadata/app:1", nil )
+                metadata := ImageMetadata{}
+                if err := json.Unmarshal( rw.Body.Bytes(), &metadata ); err != nil {
+                    t.Error( err )
+                    return
+                }
+                metadata.Labels[fmt.Sprintf( "client-%d", i )] = "done"
+                b, _ := json.Marshal( metadata )
+                rw = doRequest( handler, "PUT", "/image/metadata/app:1", bytes.NewReader( b ), "If-Match", rw.Header().Get( "ETag" ) )
+                if rw.Code == http.StatusOK {
+                    return
+                }
+                if rw.Code != http.StatusConflict {
+                    t.Errorf( "expected 200 or 409, got %d", rw.Code )
+                    return
+                }
+            }
+        }( i )
+    }
+    wg.Wait()
+
+    metadata, err := storage.GetMetadata( "app:1" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if len( metadata.Labels ) != clients || metadata.Version != int64( clients ) {
+        t.Errorf( "expected the %d labels at version %d, got %+v", clients, clients, metadata )
+    }
+}
+
+func TestMetadataNotSupported( t *testing.T ) {
+    _, docker_storage := newFakeDocker( t )
+    _, handler := newTestWeb( t, docker_storage )
+    if rw := doRequest( handler, "GET", "/image/metadata/app:1", nil ); rw.Code != http.StatusNotImplemented {
+        t.Errorf( "expected 501, got %d", rw.Code )
+    }
+}
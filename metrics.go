@@ -0,0 +1,162 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "syscall"
+    "time"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the metrics of the image transfers, they are registered in a dedicated
+// registry rather than the global one
+type Metrics struct {
+    registry *prometheus.Registry
+
+    //the failed operations by operation and reason
+    failures *prometheus.CounterVec
+
+    //the saves, gets and deletes by operation and outcome
+    operations *prometheus.CounterVec
+    duration *prometheus.HistogramVec
+    transferSize *prometheus.HistogramVec
+
+    //the saves and gets being served
+    inFlight prometheus.Gauge
+}
+
+func NewMetrics() *Metrics {
+    m := &Metrics{ registry: prometheus.NewRegistry(),
+                failures: prometheus.NewCounterVec( prometheus.CounterOpts{
+                    Name: "image_failures_total",
+                    Help: "Number of failed image operations by reason.",
+                }, []string{ "operation", "reason" } ),
+                operations: prometheus.NewCounterVec( prometheus.CounterOpts{
+                    Name: "image_operations_total",
+                    Help: "Number of image saves, gets and deletes by outcome.",
+                }, []string{ "operation", "outcome" } ),
+                duration: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+                    Name: "image_request_duration_seconds",
+                    Help: "Duration of the image saves, gets and deletes.",
+                    //10ms up to about 45 minutes
+                    Buckets: prometheus.ExponentialBuckets( 0.01, 4, 10 ),
+                }, []string{ "operation" } ),
+                transferSize: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+                    Name: "image_transfer_size_bytes",
+                    Help: "Size of the uploaded and downloaded images.",
+                    //1KiB up to 4GiB
+                    Buckets: prometheus.ExponentialBuckets( 1024, 4, 12 ),
+                }, []string{ "operation" } ),
+                inFlight: prometheus.NewGauge( prometheus.GaugeOpts{
+                    Name: "image_transfers_in_flight",
+                    Help: "Number of image saves and gets being served.",
+                } ) }
+    m.registry.MustRegister( m.failures, m.operations, m.duration, m.transferSize, m.inFlight )
+    return m
+}
+
+// the operation of the path counted by the metrics, empty if the path is
+// not a save, get or delete
+func metricsOperation( path string ) string {
+    switch {
+    case strings.HasPrefix( path, "/image/save/" ):
+        return "save"
+    case strings.HasPrefix( path, "/image/get/" ):
+        return "get"
+    case strings.HasPrefix( path, "/image/delete/" ):
+        return "delete"
+    }
+    return ""
+}
+
+// the outcome of a request by its status, "success", "client_error",
+// "server_error" or "aborted" if the handler aborted the response
+func metricsOutcome( status int, aborted bool ) string {
+    switch {
+    case aborted || status == statusClientClosedRequest:
+        return "aborted"
+    case status >= 500:
+        return "server_error"
+    case status >= 400:
+        return "client_error"
+    }
+    return "success"
+}
+
+// wrap the handler so the saves, gets and deletes are counted and timed
+func (m *Metrics) Instrument( handler http.Handler ) http.Handler {
+    return http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        operation := metricsOperation( req.URL.Path )
+        if operation == "" {
+            handler.ServeHTTP( rw, req )
+            return
+        }
+        start := time.Now()
+        if operation != "delete" {
+            m.inFlight.Inc()
+        }
+        recorder := &statusRecorder{ ResponseWriter: rw }
+        body := &countingReadCloser{ ReadCloser: req.Body }
+        req.Body = body
+        defer func() {
+            aborted := recover()
+            if operation != "delete" {
+                m.inFlight.Dec()
+            }
+            status := recorder.status
+            if status == 0 {
+                status = http.StatusOK
+            }
+            m.operations.WithLabelValues( operation, metricsOutcome( status, aborted != nil ) ).Inc()
+            m.duration.WithLabelValues( operation ).Observe( time.Since( start ).Seconds() )
+            switch operation {
+            case "save":
+                m.transferSize.WithLabelValues( operation ).Observe( float64( body.Count() ) )
+            case "get":
+                m.transferSize.WithLabelValues( operation ).Observe( float64( recorder.bytes ) )
+            }
+            if aborted != nil {
+                panic( aborted )
+            }
+        }()
+        handler.ServeHTTP( recorder, req )
+    })
+}
+
+// the handler exposing the metrics in the Prometheus format
+func (m *Metrics) Handler() http.Handler {
+    return promhttp.HandlerFor( m.registry, promhttp.HandlerOpts{} )
+}
+
+// count the failure of operation ("save", "get", ...) by the reason of err
+func (m *Metrics) CountFailure( operation string, err error ) {
+    m.failures.WithLabelValues( operation, failureReason( err ) ).Inc()
+}
+
+// categorize the error of a failed transfer as one of "client_disconnect",
+// "checksum_mismatch", "disk_full", "backend_unavailable", "timeout" or "other"
+func failureReason( err error ) string {
+    var net_err net.Error
+    switch {
+    case errors.Is( err, ErrCorruptUpload ), errors.Is( err, ErrChecksumMismatch ):
+        return "checksum_mismatch"
+    case errors.Is( err, syscall.ENOSPC ):
+        return "disk_full"
+    case errors.Is( err, ErrBusy ), errors.Is( err, context.DeadlineExceeded ), errors.Is( err, os.ErrDeadlineExceeded ):
+        return "timeout"
+    case errors.As( err, &net_err ) && net_err.Timeout():
+        return "timeout"
+    case errors.Is( err, io.ErrUnexpectedEOF ), errors.Is( err, context.Canceled ), errors.Is( err, syscall.EPIPE ), errors.Is( err, syscall.ECONNRESET ):
+        return "client_disconnect"
+    case errors.Is( err, syscall.ECONNREFUSED ), errors.Is( err, syscall.EHOSTUNREACH ):
+        return "backend_unavailable"
+    }
+    return "other"
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "syscall"
+    "testing"
+)
+
+// a reader failing with err after the content is read
+type errorAfterReader struct {
+    r io.Reader
+    err error
+}
+
+func (ear *errorAfterReader) Read( p []byte ) (int, error) {
+    n, err := ear.r.Read( p )
+    if err == io.EOF {
+        return n, ear.err
+    }
+    return n, err
+}
+
+// get the value of the metric line starting with prefix from /metrics
+func scrapeMetric( t *testing.T, handler http.Handler, prefix string ) string {
+    t.Helper()
+    for _, line := range strings.Split( doRequest( handler, "GET", "/metrics", nil ).Body.String(), "\n" ) {
+        if strings.HasPrefix( line, prefix + " " ) {
+            return strings.TrimPrefix( line, prefix + " " )
+        }
+    }
+    return ""
+}
+
+func TestFailureReasonMetrics( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    archive := makeImageArchive( t, "failures", "app:1" )
+
+    doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), checksumHeader, "sha256:" + strings.Repeat( "0", 64 ) )
+    disconnected := &errorAfterReader{ r: bytes.NewReader( archive[:100] ), err: io.ErrUnexpectedEOF }
+    doRequest( handler, "POST", "/image/save/app/2", disconnected )
+
+    for reason, expected := range map[string]string{ "checksum_mismatch": "1", "client_disconnect": "1" } {
+        metric := fmt.Sprintf( `image_failures_total{operation="save",reason="%s"}`, reason )
+        if value := scrapeMetric( t, handler, metric ); value != expected {
+            t.Errorf( "expected %s to be %s, got %q", metric, expected, value )
+        }
+    }
+}
+
+func TestFailureReason( t *testing.T ) {
+    for err, reason := range map[error]string{
+                ErrCorruptUpload: "checksum_mismatch",
+                ErrChecksumMismatch: "checksum_mismatch",
+                fmt.Errorf( "write: %w", syscall.ENOSPC ): "disk_full",
+                ErrBusy: "timeout",
+                context.DeadlineExceeded: "timeout",
+                io.ErrUnexpectedEOF: "client_disconnect",
+                syscall.ECONNRESET: "client_disconnect",
+                syscall.ECONNREFUSED: "backend_unavailable",
+                fmt.Errorf( "unknown" ): "other" } {
+        if r := failureReason( err ); r != reason {
+            t.Errorf( "expected the reason %s of %v, got %s", reason, err, r )
+        }
+    }
+}
+
+func TestOperationMetrics( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    doRequest( handler, "POST", "/image/save/app:1", strings.NewReader( "12345" ) )
+    doRequest( handler, "POST", "/image/save/app:2", strings.NewReader( "123" ) )
+    doRequest( handler, "GET", "/image/get/app:1", nil )
+    doRequest( handler, "GET", "/image/get/app:3", nil )
+    doRequest( handler, "DELETE", "/image/delete/app:2", nil )
+
+    for metric, expected := range map[string]string{
+                `image_operations_total{operation="save",outcome="success"}`: "2",
+                `image_operations_total{operation="get",outcome="success"}`: "1",
+                `image_operations_total{operation="get",outcome="client_error"}`: "1",
+                `image_operations_total{operation="delete",outcome="success"}`: "1",
+                `image_request_duration_seconds_count{operation="save"}`: "2",
+                `image_transfer_size_bytes_sum{operation="save"}`: "8",
+                `image_transfer_size_bytes_count{operation="get"}`: "2",
+                `image_transfers_in_flight`: "0" } {
+        if value := scrapeMetric( t, handler, metric ); value != expected {
+            t.Errorf( "expected %s to be %s, got %q", metric, expected, value )
+        }
+    }
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "io"
+    "log"
+    "strings"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// act as a caching mirror of an upstream registry: an image missing in
+// the local storage is pulled from the upstream registry into the docker
+// daemon and, if persist is true, stored in the local storage too
+type MirrorImageStorage struct {
+    ImageStorage
+
+    //pull the missing images with the docker daemon
+    client *docker.Client
+
+    //the upstream registry host, e.g. "registry-1.docker.io"
+    upstream string
+
+    //keep the pulled images in the local storage
+    persist bool
+}
+
+func NewMirrorImageStorage( local ImageStorage, client *docker.Client, upstream string, persist bool ) *MirrorImageStorage {
+    return &MirrorImageStorage{ ImageStorage: local, client: client, upstream: upstream, persist: persist }
+}
+
+func (mis *MirrorImageStorage) Get( name string, writer io.Writer ) error {
+    err := mis.ImageStorage.Get( name, writer )
+    if !isNotFound( err ) {
+        return err
+    }
+
+    image_name, image_version := parseImageName( name )
+    log.Printf( "image %s is not found locally, pull it from %s", name, mis.upstream )
+    err = mis.client.PullImage( docker.PullImageOptions{ Repository: mis.upstream + "/" + image_name,
+                Registry: mis.upstream,
+                Tag: image_version }, docker.AuthConfiguration{} )
+    if err != nil {
+        return err
+    }
+    upstream_name := mis.upstream + "/" + image_name + ":" + image_version
+    if !mis.persist {
+        return mis.client.ExportImages( docker.ExportImagesOptions{ Names: []string{ upstream_name }, OutputStream: writer } )
+    }
+
+    //store the pulled image locally so the later pulls hit the local copy
+    pr, pw := io.Pipe()
+    go func() {
+        pw.CloseWithError( mis.client.ExportImages( docker.ExportImagesOptions{ Names: []string{ upstream_name }, OutputStream: pw } ) )
+    }()
+    err = mis.ImageStorage.Write( name, pr )
+    pr.Close()
+    if err != nil {
+        return err
+    }
+    return mis.ImageStorage.Get( name, writer )
+}
+
+// the image is indexed and verified like the image of the local storage
+func (mis *MirrorImageStorage) ContentDigest( name string ) (string, bool, error) {
+    return contentDigest( mis.ImageStorage, name )
+}
+
+// the docker daemon pulling the images from the upstream registry, it
+// is the first one of the configured endpoints or the local daemon
+func mirrorDockerEndpoint( endpoints string ) (string, error) {
+    if endpoints == "" {
+        return defaultDockerEndpoint, nil
+    }
+    endpoint, _, err := parseDockerEndpoint( strings.Split( endpoints, "," )[0] )
+    return endpoint, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "testing"
+)
+
+func TestMirrorCachesThePulledImage( t *testing.T ) {
+    fd, docker_storage := newFakeDocker( t )
+    archive := makeImageArchive( t, "upstream", "upstream.example.com/team/app:1" )
+    fd.upstream["upstream.example.com/team/app:1"] = archive
+    local := newFileStorage( t )
+    storage := NewMirrorImageStorage( local, docker_storage.client, "upstream.example.com", true )
+
+    for i := 0; i < 2; i++ {
+        var b bytes.Buffer
+        if err := storage.Get( "team/app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), archive ) {
+            t.Fatalf( "expected the upstream image on get %d, got %d bytes: %v", i, b.Len(), err )
+        }
+    }
+    if fd.pulls != 1 {
+        t.Errorf( "expected one pull from the upstream, got %d", fd.pulls )
+    }
+    if err := local.Get( "team/app:1", ioutil.Discard ); err != nil {
+        t.Error( "expected the pulled image to be stored locally" )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "team/other:1", &b ); err == nil {
+        t.Error( "expected an error for the image missing in the upstream" )
+    }
+}
+
+func TestMirrorWithoutPersist( t *testing.T ) {
+    fd, docker_storage := newFakeDocker( t )
+    archive := makeImageArchive( t, "upstream", "upstream.example.com/app:1" )
+    fd.upstream["upstream.example.com/app:1"] = archive
+    local := newFileStorage( t )
+    storage := NewMirrorImageStorage( local, docker_storage.client, "upstream.example.com", false )
+
+    for i := 0; i < 2; i++ {
+        var b bytes.Buffer
+        if err := storage.Get( "app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), archive ) {
+            t.Fatalf( "expected the upstream image on get %d: %v", i, err )
+        }
+    }
+    if fd.pulls != 2 {
+        t.Errorf( "expected every get to pull without persisting, got %d pulls", fd.pulls )
+    }
+    if names, _ := local.List(); len( names ) != 0 {
+        t.Errorf( "expected nothing to be stored locally, got %v", names )
+    }
+}
+
+func TestMirrorDockerEndpoint( t *testing.T ) {
+    tests := map[string]string{ "": defaultDockerEndpoint,
+                "tcp://a:2375": "tcp://a:2375",
+                "tcp://a:2375=2,tcp://b:2375": "tcp://a:2375" }
+    for endpoints, expected := range tests {
+        if endpoint, err := mirrorDockerEndpoint( endpoints ); err != nil || endpoint != expected {
+            t.Errorf( "expected %s for the endpoints %q, got %s: %v", expected, endpoints, endpoint, err )
+        }
+    }
+    if _, err := mirrorDockerEndpoint( "tcp://a:2375=x" ); err == nil {
+        t.Error( "expected an error for the invalid weight" )
+    }
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "time"
+
+    "gopkg.in/mgo.v2"
+)
+
+// the delay before the first retry of a failed mongo operation, it is
+// doubled for every further retry
+const mongoRetryDelay = 100 * time.Millisecond
+
+// copy the long-lived session for an operation, the session is dialed
+// on the first use. The copy must be closed by the caller
+func (mis *MongoImageStorage) copySession() (*mgo.Session, error) {
+    mis.sessionMutex.Lock()
+    defer mis.sessionMutex.Unlock()
+
+    if mis.session == nil {
+        err := mis.withRetry( func() error {
+            session, err := mgo.DialWithTimeout( mis.url, mis.dialTimeout )
+            if err == nil {
+                mis.session = session
+            }
+            return err
+        } )
+        if err != nil {
+            return nil, err
+        }
+    }
+    return mis.session.Copy(), nil
+}
+
+// run op and retry it up to the configured times with backoff. The
+// not found errors are returned at once as they are not transient
+func (mis *MongoImageStorage) withRetry( op func() error ) error {
+    delay := mongoRetryDelay
+    for attempt := 0; ; attempt++ {
+        err := op()
+        if err == nil || isNotFound( err ) || attempt >= mis.retries {
+            return err
+        }
+        time.Sleep( delay )
+        delay *= 2
+    }
+}
+
+// open the GridFS file of name, the session is refreshed before a retry
+// so a broken connection is replaced
+func (mis *MongoImageStorage) openGridFile( session *mgo.Session, fs *mgo.GridFS, name string ) (*mgo.GridFile, error) {
+    var file *mgo.GridFile
+    err := mis.withRetry( func() error {
+        var err error
+        if file, err = fs.Open( name ); err != nil && err != mgo.ErrNotFound {
+            session.Refresh()
+        }
+        return err
+    } )
+    return file, err
+}
+
+// remove the GridFS files of name with the retries like openGridFile
+func (mis *MongoImageStorage) removeGridFile( session *mgo.Session, fs *mgo.GridFS, name string ) error {
+    return mis.withRetry( func() error {
+        err := fs.Remove( name )
+        if err != nil && err != mgo.ErrNotFound {
+            session.Refresh()
+        }
+        return err
+    } )
+}
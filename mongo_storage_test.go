@@ -0,0 +1,113 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "testing"
+    "time"
+
+    "gopkg.in/mgo.v2"
+)
+
+// the storage of a fresh GridFS prefix on the mongod at MONGO_URL
+func newTestMongoStorage( t *testing.T ) *MongoImageStorage {
+    t.Helper()
+    if os.Getenv( "MONGO_URL" ) == "" {
+        t.Skip( "MONGO_URL is not set" )
+    }
+    return NewMongoImageStorage( os.Getenv( "MONGO_URL" ), "image_mgr_test", fmt.Sprintf( "fs%d", time.Now().UnixNano() ), 5 * time.Second, 1 )
+}
+
+func TestMongoInlineImage( t *testing.T ) {
+    storage := newTestMongoStorage( t )
+    storage.SetInlineThreshold( 16 )
+    for _, content := range []string{ "small", strings.Repeat( "large", 10 ) } {
+        if err := storage.Write( "app:1", strings.NewReader( content ) ); err != nil {
+            t.Fatal( err )
+        }
+        if size, ok, err := storage.Size( "app:1" ); err != nil || !ok || size != int64( len( content ) ) {
+            t.Errorf( "expected the size %d, got %d: %v", len( content ), size, err )
+        }
+        if exists, err := storage.Exists( "app:1" ); err != nil || !exists {
+            t.Errorf( "expected the image to exist: %v", err )
+        }
+        if checksum, ok, err := storage.Checksum( "app:1" ); err != nil || !ok || checksum != sha256Digest( []byte( content ) ) {
+            t.Errorf( "expected the checksum of the image, got %s: %v", checksum, err )
+        }
+        if err := storage.WriteSbom( "app:1", "application/spdx+json", strings.NewReader( "{}" ) ); err != nil {
+            t.Errorf( "expected the SBOM of the image to be written: %v", err )
+        }
+        var b bytes.Buffer
+        if err := storage.Get( "app:1", &b ); err != nil || b.String() != content {
+            t.Errorf( "expected %q, got %q: %v", content, b.String(), err )
+        }
+    }
+    if err := storage.WriteSbom( "app:2", "application/spdx+json", strings.NewReader( "{}" ) ); !errors.Is( err, ErrNotFound ) {
+        t.Errorf( "expected ErrNotFound for the SBOM of the missing image, got %v", err )
+    }
+    if err := storage.Delete( "app:1" ); err != nil {
+        t.Fatal( err )
+    }
+    if _, _, err := storage.Size( "app:1" ); !isNotFound( err ) {
+        t.Errorf( "expected the deleted image to be not found, got %v", err )
+    }
+}
+
+// the image of several GridFS chunks is read back as written, and the
+// write of an existing name replaces it
+func TestMongoWriteRead( t *testing.T ) {
+    storage := newTestMongoStorage( t )
+    first := bytes.Repeat( []byte( "first" ), 200 * 1024 )
+    second := bytes.Repeat( []byte( "second" ), 100 * 1024 )
+    for _, content := range [][]byte{ first, second } {
+        if err := storage.Write( "app:1", bytes.NewReader( content ) ); err != nil {
+            t.Fatal( err )
+        }
+        var b bytes.Buffer
+        if err := storage.Get( "app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), content ) {
+            t.Errorf( "expected the %d bytes written, got %d: %v", len( content ), b.Len(), err )
+        }
+    }
+    if names, err := storage.List(); err != nil || len( names ) != 1 {
+        t.Errorf( "expected a single image, got %v: %v", names, err )
+    }
+}
+
+// the transient failures are retried with backoff without a mongod
+func TestMongoRetry( t *testing.T ) {
+    mis := &MongoImageStorage{ retries: 2 }
+    transient := errors.New( "connection reset" )
+
+    attempts := 0
+    err := mis.withRetry( func() error {
+        if attempts++; attempts == 1 {
+            return transient
+        }
+        return nil
+    } )
+    if err != nil || attempts != 2 {
+        t.Errorf( "expected to succeed on the retry, got %d attempts: %v", attempts, err )
+    }
+
+    attempts = 0
+    start := time.Now()
+    err = mis.withRetry( func() error {
+        attempts++
+        return transient
+    } )
+    if err != transient || attempts != 3 {
+        t.Errorf( "expected to give up after 2 retries, got %d attempts: %v", attempts, err )
+    }
+    if elapsed := time.Since( start ); elapsed < 3 * mongoRetryDelay {
+        t.Errorf( "expected the retries to back off, took %v", elapsed )
+    }
+
+    //a missing file is not transient
+    attempts = 0
+    if err = mis.withRetry( func() error { attempts++; return mgo.ErrNotFound } ); err != mgo.ErrNotFound || attempts != 1 {
+        t.Errorf( "expected no retry of not found, got %d attempts: %v", attempts, err )
+    }
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+const defaultDockerEndpoint = "unix:///var/run/docker.sock"
+
+type dockerDaemon struct {
+    endpoint string
+    storage *DockerImageStorage
+
+    //the static weight and the current weight of the smooth
+    //weighted round-robin
+    weight int
+    currentWeight int
+}
+
+// spread the images over several docker daemons. The daemons are chosen
+// by smooth weighted round-robin: Write loads the image into the chosen
+// replicas and Get/Delete target the daemons having the image
+type MultiDockerImageStorage struct {
+    mutex sync.Mutex
+    daemons []*dockerDaemon
+
+    //the number of daemons an image is loaded into, 0 for all the daemons
+    replicas int
+
+    //log which daemon serves each request
+    Verbose bool
+}
+
+func NewMultiDockerImageStorage( replicas int ) *MultiDockerImageStorage {
+    return &MultiDockerImageStorage{ daemons: make( []*dockerDaemon, 0 ), replicas: replicas }
+}
+
+// add the docker daemon at endpoint with the weight
+func (mdis *MultiDockerImageStorage) AddDaemon( endpoint string, storage *DockerImageStorage, weight int ) {
+    if weight <= 0 {
+        weight = 1
+    }
+    mdis.mutex.Lock()
+    defer mdis.mutex.Unlock()
+    mdis.daemons = append( mdis.daemons, &dockerDaemon{ endpoint: endpoint, storage: storage, weight: weight } )
+}
+
+// choose n daemons from candidates by smooth weighted round-robin
+func (mdis *MultiDockerImageStorage) choose( candidates []*dockerDaemon, n int ) []*dockerDaemon {
+    mdis.mutex.Lock()
+    defer mdis.mutex.Unlock()
+
+    result := make( []*dockerDaemon, 0, n )
+    remaining := append( []*dockerDaemon{}, candidates... )
+    for len( result ) < n && len( remaining ) > 0 {
+        total := 0
+        best := 0
+        for i, daemon := range remaining {
+            daemon.currentWeight += daemon.weight
+            total += daemon.weight
+            if daemon.currentWeight > remaining[best].currentWeight {
+                best = i
+            }
+        }
+        remaining[best].currentWeight -= total
+        result = append( result, remaining[best] )
+        remaining = append( remaining[:best], remaining[best+1:]... )
+    }
+    return result
+}
+
+// get the daemons having the image name
+func (mdis *MultiDockerImageStorage) daemonsHaving( name string ) []*dockerDaemon {
+    mdis.mutex.Lock()
+    daemons := append( []*dockerDaemon{}, mdis.daemons... )
+    mdis.mutex.Unlock()
+
+    result := make( []*dockerDaemon, 0 )
+    for _, daemon := range daemons {
+        if _, err := daemon.storage.client.InspectImage( name ); err == nil {
+            result = append( result, daemon )
+        }
+    }
+    return result
+}
+
+func (mdis *MultiDockerImageStorage) logf( format string, args ...interface{} ) {
+    if mdis.Verbose {
+        log.Printf( format, args... )
+    }
+}
+
+// load the image into the chosen replicas at the same time, the
+// image read from reader is fanned out to every daemon through a pipe
+func (mdis *MultiDockerImageStorage) Write( name string, reader io.Reader ) error {
+    mdis.mutex.Lock()
+    n := mdis.replicas
+    if n <= 0 || n > len( mdis.daemons ) {
+        n = len( mdis.daemons )
+    }
+    candidates := append( []*dockerDaemon{}, mdis.daemons... )
+    mdis.mutex.Unlock()
+
+    daemons := mdis.choose( candidates, n )
+    if len( daemons ) == 0 {
+        return fmt.Errorf( "no docker daemon is configured" )
+    }
+
+    writers := make( []*io.PipeWriter, len( daemons ) )
+    errs := make( []error, len( daemons ) )
+    var wg sync.WaitGroup
+    for i, daemon := range daemons {
+        pr, pw := io.Pipe()
+        writers[i] = pw
+        wg.Add( 1 )
+        go func( i int, daemon *dockerDaemon ) {
+            defer wg.Done()
+            mdis.logf( "load image %s into docker daemon %s", name, daemon.endpoint )
+            errs[i] = daemon.storage.Write( name, pr )
+            //unblock the fan out if the daemon stops reading
+            pr.CloseWithError( io.ErrClosedPipe )
+        }( i, daemon )
+    }
+
+    dst := make( []io.Writer, len( writers ) )
+    for i, pw := range writers {
+        dst[i] = pw
+    }
+    _, err := io.Copy( io.MultiWriter( dst... ), reader )
+    for _, pw := range writers {
+        pw.CloseWithError( err )
+    }
+    wg.Wait()
+
+    for i, e := range errs {
+        if e != nil {
+            return fmt.Errorf( "fail to load image %s into %s: %v", name, daemons[i].endpoint, e )
+        }
+    }
+    return err
+}
+
+func (mdis *MultiDockerImageStorage) Get( name string, writer io.Writer ) error {
+    daemons := mdis.choose( mdis.daemonsHaving( name ), 1 )
+    if len( daemons ) == 0 {
+        return ErrNotFound
+    }
+    mdis.logf( "export image %s from docker daemon %s", name, daemons[0].endpoint )
+    return daemons[0].storage.Get( name, writer )
+}
+
+func (mdis *MultiDockerImageStorage) Delete( name string ) error {
+    daemons := mdis.daemonsHaving( name )
+    if len( daemons ) == 0 {
+        return ErrNotFound
+    }
+    for _, daemon := range daemons {
+        mdis.logf( "remove image %s from docker daemon %s", name, daemon.endpoint )
+        if err := daemon.storage.Delete( name ); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// list the images of all the daemons without duplicates
+func (mdis *MultiDockerImageStorage) List() ([]string, error) {
+    mdis.mutex.Lock()
+    daemons := append( []*dockerDaemon{}, mdis.daemons... )
+    mdis.mutex.Unlock()
+
+    names := make( map[string]bool )
+    for _, daemon := range daemons {
+        images, err := daemon.storage.List()
+        if err != nil {
+            return nil, err
+        }
+        for _, image := range images {
+            names[image] = true
+        }
+    }
+    result := make( []string, 0, len( names ) )
+    for name := range names {
+        result = append( result, name )
+    }
+    sort.Strings( result )
+    return result, nil
+}
+
+// parse the docker endpoint in "<endpoint>[=<weight>]" format
+func parseDockerEndpoint( endpoint string ) (string, int, error) {
+    pos := strings.LastIndex( endpoint, "=" )
+    if pos == -1 {
+        return endpoint, 1, nil
+    }
+    weight, err := strconv.Atoi( endpoint[pos+1:] )
+    if err != nil {
+        return "", 0, fmt.Errorf( "invalid weight of docker endpoint %s: %v", endpoint, err )
+    }
+    return endpoint[0:pos], weight, nil
+}
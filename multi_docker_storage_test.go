@@ -0,0 +1,78 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "testing"
+)
+
+// spread the images over the fake daemons with the weights
+func newFakeMultiDocker( t *testing.T, replicas int, weights ...int ) ([]*fakeDocker, *MultiDockerImageStorage) {
+    t.Helper()
+    mdis := NewMultiDockerImageStorage( replicas )
+    fakes := make( []*fakeDocker, len( weights ) )
+    for i, weight := range weights {
+        fd, storage := newFakeDocker( t )
+        fakes[i] = fd
+        mdis.AddDaemon( fmt.Sprintf( "daemon-%d", i ), storage, weight )
+    }
+    return fakes, mdis
+}
+
+// the number of the tags of the fake daemon
+func (fd *fakeDocker) tagCount() int {
+    fd.mutex.Lock()
+    defer fd.mutex.Unlock()
+    return len( fd.tags )
+}
+
+func TestMultiDockerWeightedDistribution( t *testing.T ) {
+    fakes, storage := newFakeMultiDocker( t, 1, 3, 1, 1 )
+    for i := 0; i < 10; i++ {
+        name := fmt.Sprintf( "app:%d", i )
+        if err := storage.Write( name, bytes.NewReader( makeImageArchive( t, name, name ) ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    for i, expected := range []int{ 6, 2, 2 } {
+        if n := fakes[i].tagCount(); n != expected {
+            t.Errorf( "expected daemon-%d to get %d images, got %d", i, expected, n )
+        }
+    }
+    images, err := storage.List()
+    if err != nil || len( images ) != 10 {
+        t.Errorf( "expected the 10 images of all the daemons, got %v: %v", images, err )
+    }
+}
+
+func TestMultiDockerReplicas( t *testing.T ) {
+    fakes, storage := newFakeMultiDocker( t, 2, 1, 1, 1 )
+    archive := makeImageArchive( t, "replicated", "app:1" )
+    if err := storage.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    having := 0
+    for _, fd := range fakes {
+        if fd.tagged( "app:1" ) != "" {
+            having++
+        }
+    }
+    if having != 2 {
+        t.Fatalf( "expected the image to be loaded into 2 daemons, got %d", having )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "app:1", &b ); err != nil || b.Len() == 0 {
+        t.Errorf( "expected the image from a daemon having it: %v", err )
+    }
+    if err := storage.Delete( "app:1" ); err != nil {
+        t.Fatal( err )
+    }
+    for i, fd := range fakes {
+        if fd.tagged( "app:1" ) != "" {
+            t.Errorf( "expected the image to be removed from daemon-%d", i )
+        }
+    }
+    if err := storage.Get( "app:1", &b ); !isNotFound( err ) {
+        t.Errorf( "expected the deleted image to be not found, got %v", err )
+    }
+}
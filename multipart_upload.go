@@ -0,0 +1,65 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "mime"
+    "net/http"
+)
+
+// the status of the upload aborted by the client, it is never seen by the
+// client but shows up in the access logs and metrics
+const statusClientClosedRequest = 499
+
+// returned if a multipart upload has no image part
+var ErrNoImagePart = errors.New( "no image part in the multipart upload" )
+
+// check if the upload is sent as multipart/form-data
+func isMultipartUpload( req *http.Request ) bool {
+    media_type, _, err := mime.ParseMediaType( req.Header.Get( "Content-Type" ) )
+    return err == nil && media_type == "multipart/form-data"
+}
+
+// get the part named "image" (or the first file part) of the multipart
+// upload. The parts are streamed, so nothing is spooled to temporary
+// files which could be left behind by an aborted upload
+func multipartImagePart( req *http.Request ) (io.ReadCloser, error) {
+    mr, err := req.MultipartReader()
+    if err != nil {
+        return nil, err
+    }
+    for {
+        part, err := mr.NextPart()
+        if err == io.EOF {
+            return nil, ErrNoImagePart
+        }
+        if err != nil {
+            return nil, err
+        }
+        if part.FormName() == "image" || part.FileName() != "" {
+            return part, nil
+        }
+        part.Close()
+    }
+}
+
+// check if the upload failed because the client went away
+func isClientAbort( req *http.Request, err error ) bool {
+    return errors.Is( req.Context().Err(), context.Canceled ) || errors.Is( err, io.ErrUnexpectedEOF )
+}
+
+// check if the upload failed because it is larger than the max image size
+func isTooLarge( err error ) bool {
+    var max_bytes_err *http.MaxBytesError
+    return errors.As( err, &max_bytes_err )
+}
+
+// remove what is left by the aborted upload of image name which did not
+// exist before, the existing image is kept as the storage left it
+func (iw *ImageWeb) cleanupAbortedUpload( storage ImageStorage, name string, existed bool ) {
+    if !existed {
+        storage.Delete( name )
+        iw.digests.Remove( normalizeImageName( name ) )
+    }
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "mime/multipart"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestMultipartUpload( t *testing.T ) {
+    storage := newFileStorage( t )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "app", "app:1" )
+    var body bytes.Buffer
+    mw := multipart.NewWriter( &body )
+    mw.WriteField( "comment", "built by CI" )
+    part, _ := mw.CreateFormFile( "image", "app.tar" )
+    part.Write( archive )
+    mw.Close()
+
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( body.Bytes() ), "Content-Type", mw.FormDataContentType() )
+    if rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    var stored bytes.Buffer
+    if err := storage.Get( "app:1", &stored ); err != nil || !bytes.Equal( stored.Bytes(), archive ) {
+        t.Errorf( "expected the image part to be stored" )
+    }
+
+    //no file part
+    body.Reset()
+    mw = multipart.NewWriter( &body )
+    mw.WriteField( "comment", "built by CI" )
+    mw.Close()
+    rw = doRequest( handler, "POST", "/image/save/app/2", bytes.NewReader( body.Bytes() ), "Content-Type", mw.FormDataContentType() )
+    if rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 without an image part, got %d", rw.Code )
+    }
+}
+
+func TestMultipartUploadAborted( t *testing.T ) {
+    tmp := t.TempDir()
+    t.Setenv( "TMPDIR", tmp )
+    dir := t.TempDir()
+    storage, err := NewFileImageStorage( dir, 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    server := httptest.NewServer( handler )
+    defer server.Close()
+
+    //send the half of the image part and close the connection
+    archive := makeImageArchive( t, "app", "app:1" )
+    var body bytes.Buffer
+    mw := multipart.NewWriter( &body )
+    part, _ := mw.CreateFormFile( "image", "app.tar" )
+    part.Write( archive )
+    mw.Close()
+    conn, err := net.Dial( "tcp", server.Listener.Addr().String() )
+    if err != nil {
+        t.Fatal( err )
+    }
+    fmt.Fprintf( conn, "POST /image/save/app/1 HTTP/1.1\r\nHost: localhost\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", mw.FormDataContentType(), body.Len() )
+    conn.Write( body.Bytes()[0:body.Len() / 2] )
+    time.Sleep( 50 * time.Millisecond )
+    conn.Close()
+
+    for deadline := time.Now().Add( 5 * time.Second ); len( iw.uploadHistory.Get( "app:1" ) ) == 0; {
+        if time.Now().After( deadline ) {
+            t.Fatal( "the aborted upload is not finished" )
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+    if attempts := iw.uploadHistory.Get( "app:1" ); attempts[0].Success {
+        t.Errorf( "expected the aborted upload to fail" )
+    }
+    if names, _ := storage.List(); len( names ) != 0 {
+        t.Errorf( "expected no image, got %v", names )
+    }
+    for _, root := range []string{ dir, tmp } {
+        filepath.Walk( root, func( path string, info os.FileInfo, err error ) error {
+            //the layout marker is written when the storage is created
+            if err == nil && !info.IsDir() && info.Name() != ".layout" {
+                t.Errorf( "expected no file left, got %s", path )
+            }
+            return nil
+        } )
+    }
+}
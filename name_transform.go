@@ -0,0 +1,59 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// rewrite the image names on ingress, e.g. normalize the name
+// "internal.registry/team/app" to "team/app" for storage
+type NameTransform struct {
+    //strip the leading registry host of the name
+    stripHost bool
+
+    //replace the matches of pattern in the name with replacement
+    pattern *regexp.Regexp
+    replacement string
+}
+
+// create the transform from the rule "<regexp>=><replacement>", the
+// leading registry host is also stripped if stripHost is true
+func NewNameTransform( rule string, stripHost bool ) (*NameTransform, error) {
+    nt := &NameTransform{ stripHost: stripHost }
+    if rule != "" {
+        pos := strings.Index( rule, "=>" )
+        if pos == -1 {
+            return nil, fmt.Errorf( "name rewrite rule %s is not in <regexp>=><replacement> format", rule )
+        }
+        pattern, err := regexp.Compile( rule[0:pos] )
+        if err != nil {
+            return nil, err
+        }
+        nt.pattern = pattern
+        nt.replacement = rule[pos+2:]
+    }
+    return nt, nil
+}
+
+// the first component of the name is a registry host if it looks
+// like a domain, has a port or is localhost, same as docker does
+func isRegistryHost( component string ) bool {
+    return strings.ContainsAny( component, ".:" ) || component == "localhost"
+}
+
+// transform the image name, a nil *NameTransform keeps the name as it is
+func (nt *NameTransform) Apply( name string ) string {
+    if nt == nil {
+        return name
+    }
+    if nt.stripHost {
+        if pos := strings.Index( name, "/" ); pos != -1 && isRegistryHost( name[0:pos] ) {
+            name = name[pos+1:]
+        }
+    }
+    if nt.pattern != nil {
+        name = nt.pattern.ReplaceAllString( name, nt.replacement )
+    }
+    return name
+}
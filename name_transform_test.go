@@ -0,0 +1,49 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "testing"
+)
+
+func TestNameTransformApply( t *testing.T ) {
+    nt, err := NewNameTransform( "^legacy-=>", true )
+    if err != nil {
+        t.Fatal( err )
+    }
+    for name, expected := range map[string]string{
+                "registry.local:5000/team/app:1": "team/app:1",
+                "localhost/app:1": "app:1",
+                "team/legacy-app:1": "team/legacy-app:1",
+                "legacy-app:1": "app:1",
+                "internal.example.com/legacy-app:2": "app:2" } {
+        if transformed := nt.Apply( name ); transformed != expected {
+            t.Errorf( "expected %s to be transformed to %s, got %s", name, expected, transformed )
+        }
+    }
+    if _, err = NewNameTransform( "no-arrow", false ); err == nil {
+        t.Error( "expected an error for the rule without =>" )
+    }
+}
+
+func TestNameTransformOnSaveAndGet( t *testing.T ) {
+    storage := newFileStorage( t )
+    iw, handler := newTestWeb( t, storage )
+    nt, _ := NewNameTransform( "", true )
+    iw.SetNameTransform( nt )
+    archive := makeImageArchive( t, "transformed", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/registry.local:5000/team/app:1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "fail to save: %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    if names, _ := storage.List(); len( names ) != 1 || names[0] != "team/app:1" {
+        t.Errorf( "expected the image to be stored as team/app:1, got %v", names )
+    }
+    for _, name := range []string{ "registry.local:5000/team/app:1", "team/app:1" } {
+        if rw := doRequest( handler, "GET", "/image/get/" + name, nil ); rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+            t.Errorf( "expected the image by %s, got %d", name, rw.Code )
+        }
+    }
+    if info := listDetailedByName( t, handler )["team/app:1"]; info.OriginalName != "registry.local:5000/team/app:1" {
+        t.Errorf( "expected the original name to be recorded, got %q", info.OriginalName )
+    }
+}
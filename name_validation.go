@@ -0,0 +1,72 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// the tag rule of docker: a word character followed by
+// up to 127 word characters, dots and dashes
+var dockerTagPattern = regexp.MustCompile( `^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$` )
+
+// the limits of the image names accepted by the service
+type NameLimits struct {
+    //max length of the repository part, 0 for no limit
+    MaxNameLength int
+
+    //max length of the tag part, 0 for no limit
+    MaxTagLength int
+
+    //enforce the docker tag character rules
+    Strict bool
+}
+
+// check the characters of the tag every storage can keep: no whitespace,
+// control characters or path separators
+func validateTag( tag string ) error {
+    for _, c := range tag {
+        if unicode.IsSpace( c ) || unicode.IsControl( c ) || c == '/' || c == '\\' {
+            return fmt.Errorf( "%w: image tag %q contains the invalid character %q", ErrInvalidName, tag, c )
+        }
+    }
+    return nil
+}
+
+// check the repository and tag parsed from the image name
+func (nl NameLimits) Validate( name string ) error {
+    image_name, image_version := parseImageName( name )
+    if image_name == "" {
+        return fmt.Errorf( "%w: the image name is missing, the path must end with <name>[:<tag>]", ErrInvalidName )
+    }
+    for _, segment := range strings.Split( image_name, "/" ) {
+        if segment == "" {
+            return fmt.Errorf( "%w: image name %s has an empty path segment", ErrInvalidName, image_name )
+        }
+    }
+    if err := validateTag( image_version ); err != nil {
+        return err
+    }
+    if nl.MaxNameLength > 0 && len( image_name ) > nl.MaxNameLength {
+        return fmt.Errorf( "image name %s is longer than %d characters", image_name, nl.MaxNameLength )
+    }
+    if nl.MaxTagLength > 0 && len( image_version ) > nl.MaxTagLength {
+        return fmt.Errorf( "image tag %s is longer than %d characters", image_version, nl.MaxTagLength )
+    }
+    if nl.Strict && !dockerTagPattern.MatchString( image_version ) {
+        return fmt.Errorf( "image tag %s must be up to 128 alphanumerics, '_', '.' or '-' and not start with '.' or '-'", image_version )
+    }
+    return nil
+}
+
+// check the image name of the request against the limits. The 400
+// response is written and false is returned if it is rejected
+func (iw *ImageWeb) checkName( rw http.ResponseWriter, name string ) bool {
+    if err := iw.nameLimits.Validate( name ); err != nil {
+        http.Error( rw, err.Error(), http.StatusBadRequest )
+        return false
+    }
+    return true
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestNameLimits( t *testing.T ) {
+    lenient := NameLimits{ MaxNameLength: 20, MaxTagLength: 10 }
+    strict := NameLimits{ MaxNameLength: 20, MaxTagLength: 10, Strict: true }
+    for _, c := range []struct{ name string; lenient bool; strict bool }{
+                { "team/app:1.0", true, true },
+                { strings.Repeat( "a", 21 ) + ":1", false, false },
+                { "app:" + strings.Repeat( "1", 11 ), false, false },
+                { "app:v1+build", true, false },
+                { "app:.hidden", true, false },
+                { "app:-dash", true, false },
+                { "app:with space", false, false },
+                { "team//app:1", false, false },
+                { ":1", false, false } } {
+        if err := lenient.Validate( c.name ); ( err == nil ) != c.lenient {
+            t.Errorf( "expected %q to be accepted %v in the lenient mode, got %v", c.name, c.lenient, err )
+        }
+        if err := strict.Validate( c.name ); ( err == nil ) != c.strict {
+            t.Errorf( "expected %q to be accepted %v in the strict mode, got %v", c.name, c.strict, err )
+        }
+    }
+    //docker allows up to 128 characters in the strict mode
+    if err := ( NameLimits{ Strict: true } ).Validate( "app:" + strings.Repeat( "1", 129 ) ); err == nil {
+        t.Error( "expected the tag over 128 characters to be rejected in the strict mode" )
+    }
+}
+
+func TestSaveRejectsLongName( t *testing.T ) {
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    iw.SetNameLimits( NameLimits{ MaxNameLength: 8 } )
+    rw := doRequest( handler, "POST", "/image/save/very-long-app/1", bytes.NewReader( makeImageArchive( t, "long", "app:1" ) ) )
+    if rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 for the over-long name, got %d", rw.Code )
+    }
+}
+
+func TestNormalizeImageName( t *testing.T ) {
+    for name, expected := range map[string]string{
+                "app": "app:latest",
+                "app:": "app:latest",
+                "app:latest": "app:latest",
+                "team/app:1": "team/app:1",
+                "registry:5000/app": "registry:5000/app:latest",
+                "registry:5000/app:v1": "registry:5000/app:v1" } {
+        if normalized := normalizeImageName( name ); normalized != expected {
+            t.Errorf( "expected %s to be normalized to %s, got %s", name, expected, normalized )
+        }
+    }
+}
+
+// "app", "app:" and "app:latest" are one image in every storage
+func TestDefaultTagAcrossBackends( t *testing.T ) {
+    _, docker_storage := newFakeDocker( t )
+    archive := makeImageArchive( t, "app", "app:latest" )
+    for _, storage := range []ImageStorage{ newFileStorage( t ), docker_storage } {
+        _, handler := newTestWeb( t, storage )
+        for _, name := range []string{ "app", "app:", "app:latest" } {
+            if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+                t.Fatalf( "%T: fail to save %s: %d %s", storage, name, rw.Code, rw.Body.String() )
+            }
+        }
+        if names, err := storage.List(); err != nil || len( names ) != 1 || names[0] != "app:latest" {
+            t.Errorf( "%T: expected only app:latest to be listed, got %v: %v", storage, names, err )
+        }
+        for _, name := range []string{ "app", "app:", "app:latest" } {
+            if rw := doRequest( handler, "GET", "/image/get/" + name, nil ); rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+                t.Errorf( "%T: expected %s to be app:latest, got %d", storage, name, rw.Code )
+            }
+        }
+    }
+}
+
+func TestSaveRejectsInvalidTag( t *testing.T ) {
+    storage := newFileStorage( t )
+    _, handler := newTestWeb( t, storage )
+    rw := doRequest( handler, "POST", "/image/save/app:with%09tab", strings.NewReader( "image" ) )
+    if rw.Code != http.StatusBadRequest || !strings.Contains( rw.Body.String(), "invalid character" ) {
+        t.Errorf( "expected 400 naming the invalid character, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    if names, _ := storage.List(); len( names ) != 0 {
+        t.Errorf( "expected nothing to be saved, got %v", names )
+    }
+}
+
+func TestMalformedPaths( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    for _, c := range []struct{ method string; path string }{
+                { "POST", "/image/save/" },
+                { "POST", "/image/save/:1" },
+                { "GET", "/image/get/" },
+                { "GET", "/image/get/:1" },
+                { "GET", "/image/exists/" },
+                { "DELETE", "/image/delete/:1" } } {
+        rw := doRequest( handler, c.method, c.path, strings.NewReader( "image" ) )
+        if rw.Code != http.StatusBadRequest {
+            t.Errorf( "%s %s: expected 400, got %d: %s", c.method, c.path, rw.Code, rw.Body.String() )
+        }
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// get the object path "<prefix>/<name>/<tag>" of image name in an object
+// storage. The names with empty or relative segments are rejected so every
+// image has exactly one object
+func imageObjectName( prefix string, name string ) (string, error) {
+    image_name, image_version := parseImageName( name )
+    if image_name == "" || image_version == "" || strings.Contains( image_version, "/" ) {
+        return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+    }
+    for _, segment := range strings.Split( image_name, "/" ) {
+        if segment == "" || segment == "." || segment == ".." {
+            return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+        }
+    }
+    if image_version == "." || image_version == ".." {
+        return "", fmt.Errorf( "%w: %s", ErrInvalidName, name )
+    }
+    if prefix == "" {
+        return image_name + "/" + image_version, nil
+    }
+    return prefix + "/" + image_name + "/" + image_version, nil
+}
+
+// get the image name "<name>:<tag>" of the object path under prefix, false
+// if the object is not an image like the objects directly under prefix
+func objectImageName( prefix string, object string ) (string, bool) {
+    if prefix != "" {
+        if !strings.HasPrefix( object, prefix + "/" ) {
+            return "", false
+        }
+        object = object[len( prefix ) + 1:]
+    }
+    pos := strings.LastIndex( object, "/" )
+    if pos <= 0 || pos == len( object ) - 1 {
+        return "", false
+    }
+    return object[0:pos] + ":" + object[pos+1:], true
+}
+
+// the content types of the objects of the images
+const (
+    tarContentType = "application/x-tar"
+    gzipContentType = "application/gzip"
+)
+
+// get the content type of the object of the image read from reader, it
+// is the override if it is given, otherwise it is detected from the
+// first bytes: the gzipped image is "application/gzip" and the plain
+// docker-save tar is "application/x-tar". The returned reader reads the
+// whole image
+func objectContentType( reader io.Reader, override string ) (string, io.Reader) {
+    if override != "" {
+        return override, reader
+    }
+    br := bufio.NewReader( reader )
+    if magic, err := br.Peek( 2 ); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+        return gzipContentType, br
+    }
+    return tarContentType, br
+}
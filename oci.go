@@ -0,0 +1,540 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "os"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+type ociDescriptor struct {
+    MediaType string `json:"mediaType"`
+    Digest string `json:"digest"`
+    Size int64 `json:"size"`
+    Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+    SchemaVersion int `json:"schemaVersion"`
+    MediaType string `json:"mediaType"`
+    Config ociDescriptor `json:"config"`
+    Layers []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+    SchemaVersion int `json:"schemaVersion"`
+    MediaType string `json:"mediaType"`
+    Manifests []ociDescriptor `json:"manifests"`
+}
+
+// the digest and size of an entry of the docker-save tar
+type tarEntryInfo struct {
+    digest string
+    size int64
+    gzipped bool
+}
+
+// write the images converted from docker-save tars as an OCI image layout
+// (oci-layout, index.json and blobs/sha256/...) in a tar
+type OCILayoutWriter struct {
+    tw *tar.Writer
+
+    //the digests of the blobs already written
+    written map[string]bool
+
+    manifests []ociDescriptor
+}
+
+func NewOCILayoutWriter( w io.Writer ) (*OCILayoutWriter, error) {
+    olw := &OCILayoutWriter{ tw: tar.NewWriter( w ), written: make( map[string]bool ), manifests: make( []ociDescriptor, 0 ) }
+    if err := olw.writeFile( "oci-layout", []byte( `{"imageLayoutVersion":"1.0.0"}` ) ); err != nil {
+        return nil, err
+    }
+    return olw, nil
+}
+
+func (olw *OCILayoutWriter) writeFile( name string, b []byte ) error {
+    if err := olw.tw.WriteHeader( &tar.Header{ Name: name, Mode: 0644, Size: int64( len( b ) ) } ); err != nil {
+        return err
+    }
+    _, err := olw.tw.Write( b )
+    return err
+}
+
+// write the blob "sha256:<hex>" once even if several images share it
+func (olw *OCILayoutWriter) writeBlob( digest string, size int64, r io.Reader ) error {
+    if olw.written[digest] {
+        return nil
+    }
+    header := &tar.Header{ Name: "blobs/sha256/" + strings.TrimPrefix( digest, "sha256:" ), Mode: 0644, Size: size }
+    if err := olw.tw.WriteHeader( header ); err != nil {
+        return err
+    }
+    if _, err := io.Copy( olw.tw, r ); err != nil {
+        return err
+    }
+    olw.written[digest] = true
+    return nil
+}
+
+func sha256Digest( b []byte ) string {
+    sum := sha256.Sum256( b )
+    return "sha256:" + hex.EncodeToString( sum[:] )
+}
+
+// scan the docker-save tar to get the digest of every regular entry and
+// the content of the entries to capture by their cleaned entry name
+func scanDockerSave( f io.ReadSeeker, capture map[string]bool ) (map[string]tarEntryInfo, map[string][]byte, error) {
+    if _, err := f.Seek( 0, io.SeekStart ); err != nil {
+        return nil, nil, err
+    }
+    entries := make( map[string]tarEntryInfo )
+    captured := make( map[string][]byte )
+    tr := tar.NewReader( f )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return entries, captured, nil
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        if header.Typeflag != tar.TypeReg {
+            continue
+        }
+        name := path.Clean( header.Name )
+        hash := sha256.New()
+        var src io.Reader = tr
+        var content bytes.Buffer
+        if capture[name] {
+            src = io.TeeReader( tr, &content )
+        }
+        magic := make( []byte, 2 )
+        n, _ := io.ReadFull( src, magic )
+        hash.Write( magic[0:n] )
+        size, err := io.Copy( hash, src )
+        if err != nil {
+            return nil, nil, err
+        }
+        entries[name] = tarEntryInfo{ digest: "sha256:" + hex.EncodeToString( hash.Sum( nil ) ),
+                    size: size + int64( n ),
+                    gzipped: n == 2 && magic[0] == 0x1f && magic[1] == 0x8b }
+        //the magic bytes are read through the tee too
+        if capture[name] {
+            captured[name] = content.Bytes()
+        }
+    }
+}
+
+// convert the docker-save tar of image name in f to an OCI manifest, the
+// config and the layer entries of the tar by their cleaned entry name are
+// returned too. Only the single platform docker-save tar is supported
+func convertDockerSave( name string, f io.ReadSeeker ) (ociManifest, []byte, map[string]tarEntryInfo, error) {
+    if _, err := f.Seek( 0, io.SeekStart ); err != nil {
+        return ociManifest{}, nil, nil, err
+    }
+    manifests, err := scanForManifest( f )
+    if err != nil {
+        return ociManifest{}, nil, nil, err
+    }
+    if len( manifests ) == 0 {
+        return ociManifest{}, nil, nil, fmt.Errorf( "%w: no image in the manifest.json of %s", ErrInvalidImageArchive, name )
+    }
+    manifest := manifests[0]
+    for _, m := range manifests {
+        for _, tag := range m.RepoTags {
+            if tag == name {
+                manifest = m
+            }
+        }
+    }
+
+    //the config is "<hex>.json" in the legacy layout and
+    //"blobs/sha256/<hex>" in the OCI layout
+    config_entry := path.Clean( manifest.Config )
+    entries, captured, err := scanDockerSave( f, map[string]bool{ config_entry: true } )
+    if err != nil {
+        return ociManifest{}, nil, nil, err
+    }
+    config, ok := captured[config_entry]
+    if !ok {
+        return ociManifest{}, nil, nil, fmt.Errorf( "%w: config %s of image %s is not found", ErrInvalidImageArchive, manifest.Config, name )
+    }
+    oci_manifest := ociManifest{ SchemaVersion: 2,
+                MediaType: "application/vnd.oci.image.manifest.v1+json",
+                Config: ociDescriptor{ MediaType: "application/vnd.oci.image.config.v1+json", Digest: sha256Digest( config ), Size: int64( len( config ) ) },
+                Layers: make( []ociDescriptor, 0, len( manifest.Layers ) ) }
+    layers := make( map[string]tarEntryInfo )
+    for _, layer := range manifest.Layers {
+        info, ok := entries[path.Clean( layer )]
+        if !ok {
+            return ociManifest{}, nil, nil, fmt.Errorf( "%w: layer %s of image %s is not found", ErrInvalidImageArchive, layer, name )
+        }
+        media_type := "application/vnd.oci.image.layer.v1.tar"
+        if info.gzipped {
+            media_type += "+gzip"
+        }
+        oci_manifest.Layers = append( oci_manifest.Layers, ociDescriptor{ MediaType: media_type, Digest: info.digest, Size: info.size } )
+        layers[path.Clean( layer )] = info
+    }
+    return oci_manifest, config, layers, nil
+}
+
+// an image converted from its docker-save tar, the layers are still
+// read from the tar when the image is written to the layout
+type ociImage struct {
+    name string
+    file io.ReadSeeker
+    manifest ociManifest
+    config []byte
+    layers map[string]tarEntryInfo
+}
+
+// convert the docker-save tar of image name in f
+func convertImage( name string, f io.ReadSeeker ) (*ociImage, error) {
+    oci_manifest, config, layers, err := convertDockerSave( name, f )
+    if err != nil {
+        return nil, err
+    }
+    return &ociImage{ name: name, file: f, manifest: oci_manifest, config: config, layers: layers }, nil
+}
+
+// convert the docker-save tar of image name in f and add it to the layout
+func (olw *OCILayoutWriter) AddImage( name string, f io.ReadSeeker ) error {
+    image, err := convertImage( name, f )
+    if err != nil {
+        return err
+    }
+    return olw.addConverted( image )
+}
+
+// add the converted image to the layout
+func (olw *OCILayoutWriter) addConverted( image *ociImage ) error {
+    name, f, oci_manifest, layers := image.name, image.file, image.manifest, image.layers
+    err := olw.writeBlob( oci_manifest.Config.Digest, oci_manifest.Config.Size, bytes.NewReader( image.config ) )
+    if err != nil {
+        return err
+    }
+    //stream the layers from the tar again
+    if _, err = f.Seek( 0, io.SeekStart ); err != nil {
+        return err
+    }
+    tr := tar.NewReader( f )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return err
+        }
+        if info, ok := layers[path.Clean( header.Name )]; ok {
+            if err = olw.writeBlob( info.digest, info.size, tr ); err != nil {
+                return err
+            }
+        }
+    }
+
+    b, err := json.Marshal( oci_manifest )
+    if err != nil {
+        return err
+    }
+    manifest_digest := sha256Digest( b )
+    if err = olw.writeBlob( manifest_digest, int64( len( b ) ), bytes.NewReader( b ) ); err != nil {
+        return err
+    }
+    _, image_version := parseImageName( name )
+    olw.manifests = append( olw.manifests, ociDescriptor{ MediaType: oci_manifest.MediaType,
+                Digest: manifest_digest,
+                Size: int64( len( b ) ),
+                Annotations: map[string]string{ "org.opencontainers.image.ref.name": image_version,
+                            "io.containerd.image.name": normalizeImageName( name ) } } )
+    return nil
+}
+
+// write the index.json of all the added images and finish the tar
+func (olw *OCILayoutWriter) Close() error {
+    b, err := json.Marshal( ociIndex{ SchemaVersion: 2, MediaType: "application/vnd.oci.image.index.v1+json", Manifests: olw.manifests } )
+    if err != nil {
+        return err
+    }
+    if err = olw.writeFile( "index.json", b ); err != nil {
+        return err
+    }
+    return olw.tw.Close()
+}
+
+// get the image into a temporary file which is removed when it is closed
+func (iw *ImageWeb) spoolImage( name string ) (*os.File, error) {
+    f, err := ioutil.TempFile( "", "image-spool" )
+    if err != nil {
+        return nil, err
+    }
+    //the file is still accessible by f after it is removed
+    os.Remove( f.Name() )
+    if err = iw.image_storage.Get( name, f ); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+// write the converted images as one OCI image layout tar to w
+func (iw *ImageWeb) exportOCI( images []*ociImage, w io.Writer ) error {
+    olw, err := NewOCILayoutWriter( w )
+    if err != nil {
+        return err
+    }
+    for _, image := range images {
+        if err = olw.addConverted( image ); err != nil {
+            return err
+        }
+    }
+    return olw.Close()
+}
+
+func (iw *ImageWeb) initOCI() {
+    http.HandleFunc("/export/oci", func(rw http.ResponseWriter, req *http.Request) {
+        names := req.URL.Query()["name"]
+        if len( names ) == 0 {
+            http.Error( rw, "at least one name must be given", http.StatusBadRequest )
+            return
+        }
+        files := make( []*os.File, 0, len( names ) )
+        defer func() {
+            for _, f := range files {
+                f.Close()
+            }
+        }()
+        //all the images are converted before the response is started, so
+        //a failure is still reported by the status
+        images := make( []*ociImage, 0, len( names ) )
+        for i, name := range names {
+            names[i] = iw.nameTransform.Apply( name )
+            if !iw.checkName( rw, names[i] ) || !iw.authorize( rw, req, names[i], false ) {
+                return
+            }
+            f, err := iw.spoolImage( names[i] )
+            if isNotFound( err ) {
+                http.Error( rw, "image " + name + " is not found", http.StatusNotFound )
+                return
+            } else if err != nil {
+                http.Error( rw, err.Error(), http.StatusInternalServerError )
+                return
+            }
+            files = append( files, f )
+            image, err := convertImage( names[i], f )
+            if err != nil {
+                http.Error( rw, "fail to convert image " + name + ": " + err.Error(), http.StatusInternalServerError )
+                return
+            }
+            images = append( images, image )
+        }
+        rw.Header().Set( "Content-Type", "application/x-tar" )
+        tw := &trackingWriter{ ResponseWriter: rw }
+        if err := iw.exportOCI( images, tw ); err != nil {
+            //abort the partial layout so the client doesn't keep it
+            log.Printf( "fail to export %v as OCI layout: %v", names, err )
+            if tw.written {
+                panic( http.ErrAbortHandler )
+            }
+            http.Error( rw, "fail to export the images: " + err.Error(), http.StatusInternalServerError )
+        }
+    })
+}
+
+const ociLayoutMediaType = "application/vnd.oci.image.layout.v1+tar"
+
+// pick the download format from the Accept header, the stored docker-save
+// tar is the default. false is returned if no supported format is accepted
+func negotiateImageFormat( accept string ) (string, bool) {
+    if accept == "" {
+        return "docker-save", true
+    }
+    for _, part := range strings.Split( accept, "," ) {
+        media_type := strings.TrimSpace( strings.Split( part, ";" )[0] )
+        switch media_type {
+        case ociLayoutMediaType:
+            return "oci", true
+        case "*/*", "application/*", "application/x-tar", "application/octet-stream":
+            return "docker-save", true
+        }
+    }
+    return "", false
+}
+
+type ociCacheEntry struct {
+    digest string
+    file string
+
+    //when the entry was used last time
+    used time.Time
+}
+
+// keep the OCI layout converted from the images so that an image is only
+// converted again after it is uploaded again (its digest changes). The
+// least recently used entries are removed beyond the max entries
+type OCICache struct {
+    mutex sync.Mutex
+    dir string
+    maxEntries int
+    entries map[string]*ociCacheEntry
+}
+
+func NewOCICache( max_entries int ) *OCICache {
+    return &OCICache{ maxEntries: max_entries, entries: make( map[string]*ociCacheEntry ) }
+}
+
+// set the max number of the cached images, 0 to disable the cache
+func (oc *OCICache) SetMaxEntries( max_entries int ) {
+    oc.mutex.Lock()
+    oc.maxEntries = max_entries
+    removed := oc.evict()
+    oc.mutex.Unlock()
+    removeFiles( removed )
+}
+
+// open the converted image name with the digest, false if it is not cached
+func (oc *OCICache) Open( name string, digest string ) (*os.File, bool) {
+    oc.mutex.Lock()
+    entry, ok := oc.entries[name]
+    if ok && entry.digest == digest {
+        entry.used = time.Now()
+    }
+    oc.mutex.Unlock()
+    if !ok || entry.digest != digest {
+        return nil, false
+    }
+    f, err := os.Open( entry.file )
+    if err != nil {
+        return nil, false
+    }
+    return f, true
+}
+
+// convert the image and keep the result, the previously cached content of
+// the image is removed. The opened converted file is returned, it is not
+// kept if the cache is disabled
+func (oc *OCICache) Store( name string, digest string, convert func( w io.Writer ) error ) (*os.File, error) {
+    oc.mutex.Lock()
+    if oc.dir == "" {
+        dir, err := ioutil.TempDir( "", "oci-cache" )
+        if err != nil {
+            oc.mutex.Unlock()
+            return nil, err
+        }
+        oc.dir = dir
+    }
+    dir := oc.dir
+    oc.mutex.Unlock()
+
+    f, err := ioutil.TempFile( dir, "layout" )
+    if err != nil {
+        return nil, err
+    }
+    if err = convert( f ); err == nil {
+        _, err = f.Seek( 0, io.SeekStart )
+    }
+    if err != nil {
+        f.Close()
+        os.Remove( f.Name() )
+        return nil, err
+    }
+
+    oc.mutex.Lock()
+    removed := make( []string, 0 )
+    if old, ok := oc.entries[name]; ok {
+        removed = append( removed, old.file )
+    }
+    oc.entries[name] = &ociCacheEntry{ digest: digest, file: f.Name(), used: time.Now() }
+    removed = append( removed, oc.evict()... )
+    oc.mutex.Unlock()
+    //the opened file is still readable after it is removed
+    removeFiles( removed )
+    return f, nil
+}
+
+// remove the cached image name, it is called when the image is deleted
+func (oc *OCICache) Remove( name string ) {
+    oc.mutex.Lock()
+    entry, ok := oc.entries[name]
+    delete( oc.entries, name )
+    oc.mutex.Unlock()
+    if ok {
+        os.Remove( entry.file )
+    }
+}
+
+// remove the least recently used entries beyond the max entries and get
+// their files to remove, it is called with the lock held
+func (oc *OCICache) evict() []string {
+    removed := make( []string, 0 )
+    for len( oc.entries ) > 0 && len( oc.entries ) > oc.maxEntries {
+        oldest := ""
+        for name, entry := range oc.entries {
+            if oldest == "" || entry.used.Before( oc.entries[oldest].used ) {
+                oldest = name
+            }
+        }
+        removed = append( removed, oc.entries[oldest].file )
+        delete( oc.entries, oldest )
+    }
+    return removed
+}
+
+func removeFiles( files []string ) {
+    for _, file := range files {
+        os.Remove( file )
+    }
+}
+
+// open the image name converted to the OCI layout. The converted layout
+// is cached if the digest of the image is known
+func (iw *ImageWeb) openOCI( name string ) (*os.File, error) {
+    digest, cacheable := iw.digests.Digest( name )
+    if cacheable {
+        if f, ok := iw.ociCache.Open( name, digest ); ok {
+            return f, nil
+        }
+    }
+    spooled, err := iw.spoolImage( name )
+    if err != nil {
+        return nil, err
+    }
+    defer spooled.Close()
+    image, err := convertImage( name, spooled )
+    if err != nil {
+        return nil, err
+    }
+    convert := func( w io.Writer ) error {
+        return iw.exportOCI( []*ociImage{ image }, w )
+    }
+    if cacheable {
+        return iw.ociCache.Store( name, digest, convert )
+    }
+    //the layout is spooled too, so a failure is known before it is sent
+    f, err := ioutil.TempFile( "", "image-spool" )
+    if err != nil {
+        return nil, err
+    }
+    os.Remove( f.Name() )
+    if err = convert( f ); err == nil {
+        _, err = f.Seek( 0, io.SeekStart )
+    }
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+    "archive/tar"
+    "bytes"
+    "encoding/json"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// read the entries of the tar by their names
+func readTarEntries( t *testing.T, b []byte ) map[string][]byte {
+    t.Helper()
+    entries := make( map[string][]byte )
+    tr := tar.NewReader( bytes.NewReader( b ) )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return entries
+        }
+        if err != nil {
+            t.Fatalf( "invalid tar: %v", err )
+        }
+        content, err := ioutil.ReadAll( tr )
+        if err != nil {
+            t.Fatal( err )
+        }
+        entries[header.Name] = content
+    }
+}
+
+// check the OCI image layout in the tar b and get its index
+func checkOCILayout( t *testing.T, b []byte ) ociIndex {
+    t.Helper()
+    entries := readTarEntries( t, b )
+    if string( entries["oci-layout"] ) != `{"imageLayoutVersion":"1.0.0"}` {
+        t.Errorf( "expected the oci-layout file, got %q", entries["oci-layout"] )
+    }
+    blob := func( desc ociDescriptor ) []byte {
+        content, ok := entries["blobs/sha256/" + strings.TrimPrefix( desc.Digest, "sha256:" )]
+        if !ok {
+            t.Fatalf( "blob %s is not in the layout", desc.Digest )
+        }
+        if sha256Digest( content ) != desc.Digest || int64( len( content ) ) != desc.Size {
+            t.Errorf( "blob %s doesn't match its descriptor", desc.Digest )
+        }
+        return content
+    }
+    index := ociIndex{}
+    if err := json.Unmarshal( entries["index.json"], &index ); err != nil {
+        t.Fatalf( "invalid index.json: %v", err )
+    }
+    if index.SchemaVersion != 2 {
+        t.Errorf( "expected schema version 2, got %d", index.SchemaVersion )
+    }
+    for _, desc := range index.Manifests {
+        manifest := ociManifest{}
+        if err := json.Unmarshal( blob( desc ), &manifest ); err != nil {
+            t.Fatalf( "invalid manifest %s: %v", desc.Digest, err )
+        }
+        if manifest.Config.MediaType != "application/vnd.oci.image.config.v1+json" || len( manifest.Layers ) == 0 {
+            t.Errorf( "unexpected manifest %+v", manifest )
+        }
+        blob( manifest.Config )
+        for _, layer := range manifest.Layers {
+            blob( layer )
+        }
+    }
+    return index
+}
+
+func TestExportOCI( t *testing.T ) {
+    storage := newFileStorage( t )
+    //app:2 is saved by docker 25 or later, its config is an OCI blob
+    archives := map[string][]byte{ "app:1": makeImageArchive( t, "app:1", "app:1" ),
+                "app:2": makeOCIImageArchive( t, "app:2", "app:2" ) }
+    for name, archive := range archives {
+        if err := storage.Write( name, bytes.NewReader( archive ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    _, handler := newTestWeb( t, storage )
+
+    rw := doRequest( handler, "GET", "/export/oci?name=app:1&name=app:2", nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    index := checkOCILayout( t, rw.Body.Bytes() )
+    if len( index.Manifests ) != 2 {
+        t.Fatalf( "expected 2 manifests, got %d", len( index.Manifests ) )
+    }
+    for i, version := range []string{ "1", "2" } {
+        if ref := index.Manifests[i].Annotations["org.opencontainers.image.ref.name"]; ref != version {
+            t.Errorf( "expected the ref name %s, got %s", version, ref )
+        }
+    }
+
+    if rw = doRequest( handler, "GET", "/export/oci", nil ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected 400 without a name, got %d", rw.Code )
+    }
+    if rw = doRequest( handler, "GET", "/export/oci?name=app:1&name=app:3", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+
+    //the failed conversion is reported before the layout is sent
+    storage.Write( "plain:1", strings.NewReader( "not a tar" ) )
+    rw = doRequest( handler, "GET", "/export/oci?name=app:1&name=plain:1", nil )
+    if rw.Code != http.StatusInternalServerError || rw.Header().Get( "Content-Type" ) == "application/x-tar" {
+        t.Errorf( "expected 500 for the image which can't be converted, got %d %s", rw.Code, rw.Header().Get( "Content-Type" ) )
+    }
+}
+
+// the partially sent layout is aborted instead of ending as a complete
+// response
+func TestExportOCIAbort( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "app:1", bytes.NewReader( makeImageArchive( t, strings.Repeat( "app", 1000 ), "app:1" ) ) )
+    _, handler := newTestWeb( t, storage )
+
+    req, _ := http.NewRequest( "GET", "/export/oci?name=app:1", nil )
+    rw := &failingResponseWriter{ ResponseRecorder: httptest.NewRecorder(), limit: 2048 }
+    defer func() {
+        if r := recover(); r != http.ErrAbortHandler {
+            t.Errorf( "expected the handler to be aborted, got %v", r )
+        }
+    }()
+    handler.ServeHTTP( rw, req )
+}
+
+// the layers shared by the images are written once
+func TestExportOCISharedBlobs( t *testing.T ) {
+    var b bytes.Buffer
+    olw, err := NewOCILayoutWriter( &b )
+    if err != nil {
+        t.Fatal( err )
+    }
+    archive := makeImageArchive( t, "same", "app:1", "app:2" )
+    for _, name := range []string{ "app:1", "app:2" } {
+        if err = olw.AddImage( name, bytes.NewReader( archive ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    if err = olw.Close(); err != nil {
+        t.Fatal( err )
+    }
+    index := checkOCILayout( t, b.Bytes() )
+    if len( index.Manifests ) != 2 || index.Manifests[0].Digest != index.Manifests[1].Digest {
+        t.Errorf( "expected 2 refs of the same manifest, got %+v", index.Manifests )
+    }
+    //oci-layout, index.json, the config, the layer and the manifest
+    if n := len( readTarEntries( t, b.Bytes() ) ); n != 5 {
+        t.Errorf( "expected 5 entries, got %d", n )
+    }
+}
+
+func TestExportOCINotDockerSave( t *testing.T ) {
+    var b bytes.Buffer
+    olw, err := NewOCILayoutWriter( &b )
+    if err != nil {
+        t.Fatal( err )
+    }
+    if err = olw.AddImage( "app:1", strings.NewReader( "not a tar" ) ); err == nil {
+        t.Errorf( "expected the plain content to be rejected" )
+    }
+}
+
+func TestGetNegotiatesFormat( t *testing.T ) {
+    storage := &countingStorage{ ImageStorage: newFileStorage( t ) }
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "app", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+
+    //the stored docker-save tar is the default
+    for _, accept := range []string{ "", "*/*", "application/x-tar" } {
+        rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Accept", accept )
+        if rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+            t.Errorf( "expected the docker-save tar for Accept %q, got %d", accept, rw.Code )
+        }
+    }
+
+    gets := storage.called( "Get" )
+    for i := 0; i < 2; i++ {
+        rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Accept", ociLayoutMediaType )
+        if rw.Code != http.StatusOK || rw.Header().Get( "Content-Type" ) != ociLayoutMediaType {
+            t.Fatalf( "expected the OCI layout, got %d %s", rw.Code, rw.Header().Get( "Content-Type" ) )
+        }
+        if index := checkOCILayout( t, rw.Body.Bytes() ); len( index.Manifests ) != 1 {
+            t.Errorf( "expected 1 manifest, got %d", len( index.Manifests ) )
+        }
+    }
+    //the second download is served from the converted form
+    if n := storage.called( "Get" ) - gets; n != 1 {
+        t.Errorf( "expected the image to be converted once, got %d gets", n )
+    }
+
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Accept", "application/json" ); rw.Code != http.StatusNotAcceptable {
+        t.Errorf( "expected 406 for the unsupported format, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/get/app:2", nil, "Accept", ociLayoutMediaType ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
+
+// the download of the OCI layout reports the failed conversion by the
+// status instead of an empty 200
+func TestGetOCIFailure( t *testing.T ) {
+    storage := newFileStorage( t )
+    _, handler := newTestWeb( t, storage )
+    storage.Write( "plain:1", strings.NewReader( "not a tar" ) )
+    rw := doRequest( handler, "GET", "/image/get/plain:1", nil, "Accept", ociLayoutMediaType )
+    if rw.Code != http.StatusInternalServerError || rw.Header().Get( "Content-Type" ) == ociLayoutMediaType {
+        t.Errorf( "expected 500 for the image which can't be converted, got %d %s", rw.Code, rw.Header().Get( "Content-Type" ) )
+    }
+
+    if rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( makeOCIImageArchive( t, strings.Repeat( "app", 1000 ), "app:1" ) ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+    req, _ := http.NewRequest( "GET", "/image/get/app:1", nil )
+    req.Header.Set( "Accept", ociLayoutMediaType )
+    func() {
+        defer func() {
+            if r := recover(); r != http.ErrAbortHandler {
+                t.Errorf( "expected the partial download to be aborted, got %v", r )
+            }
+        }()
+        handler.ServeHTTP( &failingResponseWriter{ ResponseRecorder: httptest.NewRecorder(), limit: 2048 }, req )
+    }()
+}
+
+func TestOCICacheEviction( t *testing.T ) {
+    storage := newFileStorage( t )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetOCICacheSize( 2 )
+    for _, name := range []string{ "app:1", "app:2", "app:3" } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, name, name ) ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "expected 201, got %d", rw.Code )
+        }
+        if rw := doRequest( handler, "GET", "/image/get/" + name, nil, "Accept", ociLayoutMediaType ); rw.Code != http.StatusOK {
+            t.Fatalf( "expected 200, got %d", rw.Code )
+        }
+    }
+    cached := func() []string {
+        files, _ := ioutil.ReadDir( iw.ociCache.dir )
+        names := make( []string, 0 )
+        for name := range iw.ociCache.entries {
+            names = append( names, name )
+        }
+        if len( files ) != len( names ) {
+            t.Errorf( "expected a file for each of the %d entries, got %d files", len( names ), len( files ) )
+        }
+        return names
+    }
+    if names := cached(); len( names ) != 2 {
+        t.Errorf( "expected the cache to keep 2 images, got %v", names )
+    }
+    if _, ok := iw.ociCache.entries["app:1"]; ok {
+        t.Error( "expected the least recently used image to be evicted" )
+    }
+
+    if rw := doRequest( handler, "DELETE", "/image/delete/app:3", nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+    if names := cached(); len( names ) != 1 || names[0] != "app:2" {
+        t.Errorf( "expected the deleted image to be evicted, got %v", names )
+    }
+}
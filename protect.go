@@ -0,0 +1,117 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "path"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// the images which can't be deleted, either matching one of the
+// protected tag patterns or explicitly pinned at runtime
+type ProtectedImages struct {
+    mutex sync.RWMutex
+
+    //the "name:version" patterns in path.Match syntax, e.g. "*:release-*"
+    patterns []string
+
+    //the explicitly pinned image names
+    pinned map[string]bool
+}
+
+func NewProtectedImages( patterns []string ) *ProtectedImages {
+    return &ProtectedImages{ patterns: patterns, pinned: make( map[string]bool ) }
+}
+
+// check if the image name is protected
+func (pi *ProtectedImages) IsProtected( name string ) bool {
+    name = normalizeImageName( name )
+    pi.mutex.RLock()
+    defer pi.mutex.RUnlock()
+
+    if pi.pinned[name] {
+        return true
+    }
+    for _, pattern := range pi.patterns {
+        if ok, _ := path.Match( pattern, name ); ok {
+            return true
+        }
+    }
+    return false
+}
+
+func (pi *ProtectedImages) Pin( name string ) {
+    pi.mutex.Lock()
+    defer pi.mutex.Unlock()
+    pi.pinned[normalizeImageName( name )] = true
+}
+
+func (pi *ProtectedImages) Unpin( name string ) {
+    pi.mutex.Lock()
+    defer pi.mutex.Unlock()
+    delete( pi.pinned, normalizeImageName( name ) )
+}
+
+// get the protected tag patterns and the pinned images
+func (pi *ProtectedImages) List() ([]string, []string) {
+    pi.mutex.RLock()
+    defer pi.mutex.RUnlock()
+
+    pinned := make( []string, 0, len( pi.pinned ) )
+    for name := range pi.pinned {
+        pinned = append( pinned, name )
+    }
+    sort.Strings( pinned )
+    return append( []string{}, pi.patterns... ), pinned
+}
+
+func (iw *ImageWeb) initProtect() {
+    http.HandleFunc("/image/protected", func(rw http.ResponseWriter, req *http.Request) {
+        patterns, pinned := iw.protected.List()
+        pinned, ok := iw.filterReadable( rw, req, pinned )
+        if !ok {
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( map[string][]string{ "patterns": patterns, "pinned": pinned } )
+    })
+
+    pin := func( prefix string, protect bool ) {
+        http.HandleFunc(prefix, func(rw http.ResponseWriter, req *http.Request) {
+            if req.Method != "POST" {
+                http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+                return
+            }
+            name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, prefix ) )
+            if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, true ) {
+                return
+            }
+            if protect {
+                iw.protected.Pin( name )
+                rw.Write( []byte( "protect image successfully" ) )
+            } else {
+                iw.protected.Unpin( name )
+                rw.Write( []byte( "unprotect image successfully" ) )
+            }
+        })
+    }
+    pin( "/image/protect/", true )
+    pin( "/image/unprotect/", false )
+}
+
+// the repository patterns in path.Match syntax, e.g. "release/*", whose
+// images can't be overwritten or deleted once they are written
+type ImmutableRepositories []string
+
+// check if the repository of image name is immutable
+func (ir ImmutableRepositories) IsImmutable( name string ) bool {
+    image_name, _ := parseImageName( name )
+    for _, pattern := range ir {
+        if ok, _ := path.Match( pattern, image_name ); ok {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func TestProtectedImageCantBeDeleted( t *testing.T ) {
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    iw.SetProtectedPatterns( []string{ "*:release-*" } )
+    for _, name := range []string{ "app:1", "app:release-1" } {
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, name, name ) ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "fail to save %s: %d", name, rw.Code )
+        }
+    }
+
+    if rw := doRequest( handler, "POST", "/image/protect/app:1", nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected app:1 to be pinned, got %d", rw.Code )
+    }
+    rw := doRequest( handler, "GET", "/image/protected", nil )
+    var protected map[string][]string
+    if err := json.Unmarshal( rw.Body.Bytes(), &protected ); err != nil || len( protected["pinned"] ) != 1 || protected["pinned"][0] != "app:1" || len( protected["patterns"] ) != 1 {
+        t.Errorf( "expected the pinned app:1 and the release pattern, got %s", rw.Body.String() )
+    }
+    for _, name := range []string{ "app:1", "app:release-1" } {
+        if rw := doRequest( handler, "DELETE", "/image/delete/" + name, nil ); rw.Code != http.StatusForbidden {
+            t.Errorf( "expected 403 for the delete of the protected %s, got %d", name, rw.Code )
+        }
+    }
+
+    if rw := doRequest( handler, "POST", "/image/unprotect/app:1", nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected app:1 to be unpinned, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the unpinned image to be deleted, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/image/protect/app:1", nil ); rw.Code != http.StatusMethodNotAllowed {
+        t.Errorf( "expected 405 for GET, got %d", rw.Code )
+    }
+}
+
+func TestImmutableRepositories( t *testing.T ) {
+    storage := newFileStorage( t )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetImmutableRepositories( []string{ "release/*" } )
+    save := func( name string ) int {
+        return doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, name, name ) ) ).Code
+    }
+
+    for _, name := range []string{ "release/app:1", "release/app:2", "team/app:1" } {
+        if status := save( name ); status != http.StatusCreated {
+            t.Fatalf( "expected the first push of %s to pass, got %d", name, status )
+        }
+    }
+    if status := save( "release/app:1" ); status != http.StatusConflict {
+        t.Errorf( "expected 409 for the overwrite of the immutable image, got %d", status )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/release/app:1", nil ); rw.Code != http.StatusForbidden {
+        t.Errorf( "expected 403 for the delete of the immutable image, got %d", rw.Code )
+    }
+    if names, _ := storage.List(); len( names ) != 3 {
+        t.Errorf( "expected the immutable image to be kept, got %v", names )
+    }
+    //the other repositories are not affected
+    if status := save( "team/app:1" ); status != http.StatusCreated {
+        t.Errorf( "expected the overwrite of team/app:1 to pass, got %d", status )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/team/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the delete of team/app:1 to pass, got %d", rw.Code )
+    }
+}
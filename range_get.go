@@ -0,0 +1,79 @@
+package main
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "os"
+    "time"
+)
+
+// returned by OpenReader if the stored image can't be read by offset,
+// e.g. it is stored encoded
+var ErrNotSeekable = errors.New( "image is not seekable" )
+
+// optional interface implemented by the storage which can read an image
+// from any offset, so the download can be served by ranges
+type ReaderOpener interface {
+    // open image name for reading, ErrNotSeekable if it can't be seeked
+    OpenReader(name string) (io.ReadSeekCloser, error)
+}
+
+// the opened image file holds a slot of the limiter until it is closed
+type limitedFile struct {
+    *os.File
+    limiter *Semaphore
+}
+
+func (lf *limitedFile) Close() error {
+    defer lf.limiter.Release()
+    return lf.File.Close()
+}
+
+// the image file is opened unless it is stored encoded
+func (fis *FileImageStorage) OpenReader( name string ) (io.ReadSeekCloser, error) {
+    image_file, err := fis.imageFile( name )
+    if err != nil {
+        return nil, err
+    }
+    if _, err = os.Stat( fis.sidecarFile( name, "codec" ) ); err == nil {
+        return nil, ErrNotSeekable
+    }
+    if err = fis.limiter.AcquireFor( OperationGet ); err != nil {
+        return nil, err
+    }
+    f, err := os.Open( image_file )
+    if err != nil {
+        fis.limiter.Release()
+        return nil, err
+    }
+    return &limitedFile{ File: f, limiter: fis.limiter }, nil
+}
+
+// serve the image with the Range support of http.ServeContent. false is
+// returned without writing anything if the storage can't read the image
+// by offset, so it is sent as a whole
+func (iw *ImageWeb) serveRange( rw http.ResponseWriter, req *http.Request, name string ) bool {
+    opener, ok := iw.storage( req ).(ReaderOpener)
+    if !ok {
+        return false
+    }
+    r, err := opener.OpenReader( name )
+    if errors.Is( err, ErrNotSeekable ) {
+        return false
+    }
+    if err != nil {
+        iw.failGet( &trackingWriter{ ResponseWriter: rw }, name, err )
+        return true
+    }
+    defer r.Close()
+
+    //the modification time makes If-Range and If-Modified-Since work
+    var modtime time.Time
+    if timed, ok := iw.storage( req ).(TimedStorage); ok {
+        modtime, _ = timed.CreatedAt( name )
+    }
+    rw.Header().Set( "Content-Type", "application/x-tar" )
+    http.ServeContent( rw, req, name, modtime, r )
+    return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestGetRange( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    content := "0123456789abcdef"
+    storage.Write( "app:1", strings.NewReader( content ) )
+    _, handler := newTestWeb( t, storage )
+
+    tests := []struct {
+        rangeHeader string
+        status int
+        body string
+        contentRange string
+    }{
+        { "bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/16" },
+        { "bytes=10-", http.StatusPartialContent, "abcdef", "bytes 10-15/16" },
+        { "bytes=-3", http.StatusPartialContent, "def", "bytes 13-15/16" },
+        { "bytes=100-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */16" },
+        { "", http.StatusOK, content, "" },
+    }
+    for _, test := range tests {
+        rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Range", test.rangeHeader )
+        if rw.Code != test.status || rw.Header().Get( "Content-Range" ) != test.contentRange {
+            t.Errorf( "%q: expected %d %q, got %d %q", test.rangeHeader, test.status, test.contentRange, rw.Code, rw.Header().Get( "Content-Range" ) )
+            continue
+        }
+        if test.status == http.StatusRequestedRangeNotSatisfiable {
+            continue
+        }
+        if rw.Body.String() != test.body {
+            t.Errorf( "%q: expected %q, got %q", test.rangeHeader, test.body, rw.Body.String() )
+        }
+        if rw.Header().Get( "Accept-Ranges" ) != "bytes" {
+            t.Errorf( "%q: expected Accept-Ranges bytes, got %q", test.rangeHeader, rw.Header().Get( "Accept-Ranges" ) )
+        }
+    }
+    if rw := doRequest( handler, "GET", "/image/get/app:2", nil, "Range", "bytes=0-1" ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
+
+// the storages which can't seek the image send it as a whole
+func TestGetRangeNotSeekable( t *testing.T ) {
+    compressed, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    compressed.Compress = true
+    for _, storage := range []ImageStorage{ struct{ ImageStorage }{ newFileStorage( t ) }, compressed } {
+        storage.Write( "app:1", strings.NewReader( "0123456789" ) )
+        _, handler := newTestWeb( t, storage )
+        rw := doRequest( handler, "GET", "/image/get/app:1", nil, "Range", "bytes=2-5" )
+        if rw.Code != http.StatusOK || rw.Body.String() != "0123456789" {
+            t.Errorf( "%T: expected the whole image, got %d %q", storage, rw.Code, rw.Body.String() )
+        }
+    }
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+    "math"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// the tokens of a repository, refilled continuously up to the burst
+type tokenBucket struct {
+    tokens float64
+    updated time.Time
+}
+
+// limit the pushes of every repository with its own token bucket, so one
+// busy repository can't starve the others. A nil *RepoRateLimiter does not
+// limit anything
+type RepoRateLimiter struct {
+    mutex sync.Mutex
+
+    //the tokens added per second and the max tokens of a bucket
+    rate float64
+    burst float64
+
+    buckets map[string]*tokenBucket
+}
+
+// allow rate pushes per second with bursts of up to burst pushes for
+// every repository, nil is returned if rate is not positive
+func NewRepoRateLimiter( rate float64, burst int ) *RepoRateLimiter {
+    if rate <= 0 {
+        return nil
+    }
+    if burst < 1 {
+        burst = 1
+    }
+    return &RepoRateLimiter{ rate: rate, burst: float64( burst ), buckets: make( map[string]*tokenBucket ) }
+}
+
+// take a token of repo, if there is none the time until the next
+// token is available is returned with false
+func (rrl *RepoRateLimiter) Allow( repo string ) (bool, time.Duration) {
+    if rrl == nil {
+        return true, 0
+    }
+    rrl.mutex.Lock()
+    defer rrl.mutex.Unlock()
+
+    now := time.Now()
+    bucket, ok := rrl.buckets[repo]
+    if !ok {
+        rrl.purge( now )
+        bucket = &tokenBucket{ tokens: rrl.burst, updated: now }
+        rrl.buckets[repo] = bucket
+    }
+    bucket.tokens = math.Min( rrl.burst, bucket.tokens + now.Sub( bucket.updated ).Seconds() * rrl.rate )
+    bucket.updated = now
+    if bucket.tokens < 1 {
+        return false, time.Duration( ( 1 - bucket.tokens ) / rrl.rate * float64( time.Second ) )
+    }
+    bucket.tokens--
+    return true, 0
+}
+
+// forget the buckets which are full again, they are the same as new ones
+func (rrl *RepoRateLimiter) purge( now time.Time ) {
+    for repo, bucket := range rrl.buckets {
+        if bucket.tokens + now.Sub( bucket.updated ).Seconds() * rrl.rate >= rrl.burst {
+            delete( rrl.buckets, repo )
+        }
+    }
+}
+
+// limit the pushes of every repository to rate per second with bursts
+// of up to burst pushes, 0 rate for no limit
+func (iw *ImageWeb) SetPushRateLimit( rate float64, burst int ) {
+    iw.pushLimiter = NewRepoRateLimiter( rate, burst )
+}
+
+// check the push rate of the repository of image name. The 429 response
+// is written and false is returned if the repository pushes too often
+func (iw *ImageWeb) allowPush( rw http.ResponseWriter, name string ) bool {
+    repo, _ := parseImageName( name )
+    ok, retry_after := iw.pushLimiter.Allow( repo )
+    if !ok {
+        rw.Header().Set( "Retry-After", strconv.Itoa( int( math.Ceil( retry_after.Seconds() ) ) ) )
+        http.Error( rw, "too many pushes to repository " + repo, http.StatusTooManyRequests )
+    }
+    return ok
+}
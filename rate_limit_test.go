@@ -0,0 +1,43 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "strconv"
+    "testing"
+)
+
+func TestPushRateLimitPerRepository( t *testing.T ) {
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    //the bucket of a repository refills once every 100 seconds
+    iw.SetPushRateLimit( 0.01, 3 )
+    push := func( name string ) *http.Response {
+        return doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, name, name ) ) ).Result()
+    }
+
+    for i := 1; i <= 3; i++ {
+        if resp := push( "busy/app:" + strconv.Itoa( i ) ); resp.StatusCode != http.StatusCreated {
+            t.Fatalf( "expected the push %d in the burst to pass, got %d", i, resp.StatusCode )
+        }
+    }
+    resp := push( "busy/app:4" )
+    if resp.StatusCode != http.StatusTooManyRequests {
+        t.Fatalf( "expected 429 after the burst, got %d", resp.StatusCode )
+    }
+    if retry_after, err := strconv.Atoi( resp.Header.Get( "Retry-After" ) ); err != nil || retry_after < 1 || retry_after > 100 {
+        t.Errorf( "expected the seconds until the next token, got %q", resp.Header.Get( "Retry-After" ) )
+    }
+    //the other repository is not throttled
+    if resp = push( "quiet/app:1" ); resp.StatusCode != http.StatusCreated {
+        t.Errorf( "expected the push to the other repository to pass, got %d", resp.StatusCode )
+    }
+}
+
+func TestNoPushRateLimit( t *testing.T ) {
+    rrl := NewRepoRateLimiter( 0, 1 )
+    for i := 0; i < 100; i++ {
+        if ok, _ := rrl.Allow( "app" ); !ok {
+            t.Fatal( "expected no limit without a rate" )
+        }
+    }
+}
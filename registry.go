@@ -0,0 +1,382 @@
+package main
+
+import (
+    "archive/tar"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// the manifest of an image served by the registry API
+type registryManifest struct {
+    //the digest of the image it is converted from, empty if it is unknown
+    imageDigest string
+
+    body []byte
+    digest string
+    mediaType string
+}
+
+// a blob of an image served by the registry API: the config or a layer
+// entry of the docker-save tar of the image
+type registryBlob struct {
+    image string
+
+    //the tar entry of the layer, empty for the config
+    entry string
+    config []byte
+    size int64
+}
+
+// remember the manifests converted from the images and where their blobs
+// are, so a pull reads the image only for the blobs it fetches. The images
+// are indexed when they are written and at the start, so a manifest or blob
+// pulled by its digest is looked up without converting any image
+type RegistryIndex struct {
+    mutex sync.Mutex
+    manifests map[string]registryManifest
+
+    //the image names by the manifest digest
+    names map[string][]string
+
+    //the blobs by their digest, one for every image having it
+    blobs map[string][]registryBlob
+}
+
+func NewRegistryIndex() *RegistryIndex {
+    return &RegistryIndex{ manifests: make( map[string]registryManifest ),
+                names: make( map[string][]string ),
+                blobs: make( map[string][]registryBlob ) }
+}
+
+func (ri *RegistryIndex) add( name string, manifest registryManifest, oci_manifest ociManifest, config []byte, layers map[string]tarEntryInfo ) {
+    ri.mutex.Lock()
+    defer ri.mutex.Unlock()
+    ri.remove( name )
+    ri.manifests[name] = manifest
+    ri.names[manifest.digest] = append( ri.names[manifest.digest], name )
+    ri.blobs[oci_manifest.Config.Digest] = append( ri.blobs[oci_manifest.Config.Digest], registryBlob{ image: name, config: config, size: int64( len( config ) ) } )
+    for entry, info := range layers {
+        ri.blobs[info.digest] = append( ri.blobs[info.digest], registryBlob{ image: name, entry: entry, size: info.size } )
+    }
+}
+
+// forget the image name, it is called when the image is deleted
+func (ri *RegistryIndex) Remove( name string ) {
+    ri.mutex.Lock()
+    defer ri.mutex.Unlock()
+    ri.remove( name )
+}
+
+func (ri *RegistryIndex) remove( name string ) {
+    manifest, ok := ri.manifests[name]
+    if !ok {
+        return
+    }
+    delete( ri.manifests, name )
+    names := make( []string, 0 )
+    for _, n := range ri.names[manifest.digest] {
+        if n != name {
+            names = append( names, n )
+        }
+    }
+    if len( names ) == 0 {
+        delete( ri.names, manifest.digest )
+    } else {
+        ri.names[manifest.digest] = names
+    }
+    for digest, blobs := range ri.blobs {
+        kept := make( []registryBlob, 0, len( blobs ) )
+        for _, blob := range blobs {
+            if blob.image != name {
+                kept = append( kept, blob )
+            }
+        }
+        if len( kept ) == 0 {
+            delete( ri.blobs, digest )
+        } else {
+            ri.blobs[digest] = kept
+        }
+    }
+}
+
+// get the manifest of image name converted from the image with digest
+func (ri *RegistryIndex) manifest( name string, digest string ) (registryManifest, bool) {
+    ri.mutex.Lock()
+    defer ri.mutex.Unlock()
+    manifest, ok := ri.manifests[name]
+    return manifest, ok && digest != "" && manifest.imageDigest == digest
+}
+
+// get the image of the repository with the manifest digest
+func (ri *RegistryIndex) name( repository string, manifest_digest string ) (string, bool) {
+    ri.mutex.Lock()
+    defer ri.mutex.Unlock()
+    for _, name := range ri.names[manifest_digest] {
+        if image_name, _ := parseImageName( name ); image_name == repository {
+            return name, true
+        }
+    }
+    return "", false
+}
+
+// get the blob with digest of an image of the repository
+func (ri *RegistryIndex) blob( repository string, digest string ) (registryBlob, bool) {
+    ri.mutex.Lock()
+    defer ri.mutex.Unlock()
+    for _, blob := range ri.blobs[digest] {
+        if image_name, _ := parseImageName( blob.image ); image_name == repository {
+            return blob, true
+        }
+    }
+    return registryBlob{}, false
+}
+
+// serve the pulls of the Docker Registry HTTP API v2, so the images can
+// be pulled by "docker pull <host>/<name>:<tag>" directly
+func (iw *ImageWeb) SetRegistryAPI( enabled bool ) {
+    iw.registryAPI = enabled
+}
+
+// write the error in the format of the registry API
+func writeRegistryError( rw http.ResponseWriter, status int, code string, message string ) {
+    rw.Header().Set( "Content-Type", "application/json" )
+    rw.WriteHeader( status )
+    json.NewEncoder( rw ).Encode( map[string]interface{}{
+        "errors": []map[string]string{ { "code": code, "message": message } } } )
+}
+
+// open the image for reading by offset, it is copied to a temporary file
+// if the storage can't read it by offset
+func (iw *ImageWeb) openImage( name string ) (io.ReadSeekCloser, error) {
+    if opener, ok := iw.image_storage.(ReaderOpener); ok {
+        f, err := opener.OpenReader( name )
+        if err != ErrNotSeekable {
+            return f, err
+        }
+    }
+    f, err := iw.spoolImage( name )
+    if err != nil {
+        return nil, err
+    }
+    if _, err = f.Seek( 0, io.SeekStart ); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return f, nil
+}
+
+// get the manifest of image name, it is converted again only after the
+// image is uploaded again
+func (iw *ImageWeb) registryManifest( name string ) (registryManifest, error) {
+    image_digest, _ := iw.digests.Digest( name )
+    if manifest, ok := iw.registry.manifest( name, image_digest ); ok {
+        return manifest, nil
+    }
+    image, err := iw.openImage( name )
+    if err != nil {
+        return registryManifest{}, err
+    }
+    defer image.Close()
+    oci_manifest, config, layers, err := convertDockerSave( name, image )
+    if err != nil {
+        return registryManifest{}, err
+    }
+    body, err := json.Marshal( oci_manifest )
+    if err != nil {
+        return registryManifest{}, err
+    }
+    manifest := registryManifest{ imageDigest: image_digest, body: body, digest: sha256Digest( body ), mediaType: oci_manifest.MediaType }
+    iw.registry.add( name, manifest, oci_manifest, config, layers )
+    return manifest, nil
+}
+
+// get the tags of the repository, sorted
+func (iw *ImageWeb) repositoryTags( repository string ) ([]string, error) {
+    images, err := iw.searchImages( repository + ":" )
+    if err != nil {
+        return nil, err
+    }
+    tags := make( []string, 0 )
+    for _, image := range images {
+        if image_name, image_version := parseImageName( image ); image_name == repository {
+            tags = append( tags, image_version )
+        }
+    }
+    sort.Strings( tags )
+    return tags, nil
+}
+
+// index the manifest and the blobs of the written image name in the
+// background, so they can be pulled by digest
+func (iw *ImageWeb) indexRegistryImage( name string ) {
+    if !iw.registryAPI {
+        return
+    }
+    go func() {
+        if _, err := iw.registryManifest( name ); err != nil && !isNotFound( err ) {
+            log.Printf( "fail to index image %s for the registry API: %v", name, err )
+        }
+    }()
+}
+
+// index the manifests and the blobs of all the stored images for the
+// registry API, it is called at the start after the digests are loaded.
+// Get the number of indexed images
+func (iw *ImageWeb) loadRegistryIndex() (int, error) {
+    if !iw.registryAPI {
+        return 0, nil
+    }
+    names, err := iw.image_storage.List()
+    if err != nil {
+        return 0, err
+    }
+    indexed := 0
+    for _, name := range names {
+        if _, err = iw.registryManifest( name ); err == nil {
+            indexed++
+        } else if !isNotFound( err ) {
+            log.Printf( "fail to index image %s for the registry API: %v", name, err )
+        }
+    }
+    return indexed, nil
+}
+
+func (iw *ImageWeb) serveRegistryManifest( rw http.ResponseWriter, req *http.Request, repository string, reference string ) {
+    name := normalizeImageName( repository + ":" + reference )
+    by_digest := strings.HasPrefix( reference, "sha256:" )
+    if by_digest {
+        image, ok := iw.registry.name( repository, reference )
+        if !ok {
+            writeRegistryError( rw, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest " + reference + " is not found" )
+            return
+        }
+        name = image
+    }
+    manifest, err := iw.registryManifest( name )
+    if err == nil && by_digest && manifest.digest != reference {
+        //the image is uploaded again with another content
+        err = ErrNotFound
+    }
+    if err != nil {
+        if isNotFound( err ) || errors.Is( err, ErrInvalidName ) {
+            writeRegistryError( rw, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest " + name + " is not found" )
+        } else {
+            writeRegistryError( rw, http.StatusInternalServerError, "UNKNOWN", err.Error() )
+        }
+        return
+    }
+    rw.Header().Set( "Content-Type", manifest.mediaType )
+    rw.Header().Set( "Content-Length", strconv.Itoa( len( manifest.body ) ) )
+    rw.Header().Set( "Docker-Content-Digest", manifest.digest )
+    if req.Method != "HEAD" {
+        rw.Write( manifest.body )
+    }
+}
+
+func (iw *ImageWeb) serveRegistryBlob( rw http.ResponseWriter, req *http.Request, repository string, digest string ) {
+    blob, found := iw.registry.blob( repository, digest )
+    if !found {
+        writeRegistryError( rw, http.StatusNotFound, "BLOB_UNKNOWN", "blob " + digest + " is not found" )
+        return
+    }
+    rw.Header().Set( "Content-Type", "application/octet-stream" )
+    rw.Header().Set( "Content-Length", strconv.FormatInt( blob.size, 10 ) )
+    rw.Header().Set( "Docker-Content-Digest", digest )
+    if req.Method == "HEAD" {
+        return
+    }
+    if blob.entry == "" {
+        rw.Write( blob.config )
+        return
+    }
+    if err := iw.copyImageEntry( rw, blob.image, blob.entry, blob.size ); err != nil {
+        //the headers are already sent, so the client sees a truncated blob
+        panic( http.ErrAbortHandler )
+    }
+}
+
+// write the size bytes of the tar entry of the image to w
+func (iw *ImageWeb) copyImageEntry( w io.Writer, name string, entry string, size int64 ) error {
+    image, err := iw.openImage( name )
+    if err != nil {
+        return err
+    }
+    defer image.Close()
+    tr := tar.NewReader( image )
+    for {
+        header, err := tr.Next()
+        if err != nil {
+            return err
+        }
+        if path.Clean( header.Name ) == entry {
+            if header.Size != size {
+                return fmt.Errorf( "entry %s of image %s is changed", entry, name )
+            }
+            _, err = io.CopyN( w, tr, size )
+            return err
+        }
+    }
+}
+
+func (iw *ImageWeb) initRegistry() {
+    http.HandleFunc("/v2/", func(rw http.ResponseWriter, req *http.Request) {
+        if !iw.registryAPI {
+            http.NotFound( rw, req )
+            return
+        }
+        rw.Header().Set( "Docker-Distribution-API-Version", "registry/2.0" )
+        //only the pulls are supported
+        if req.Method != "GET" && req.Method != "HEAD" {
+            writeRegistryError( rw, http.StatusMethodNotAllowed, "UNSUPPORTED", "only the pulls are supported" )
+            return
+        }
+        p := strings.TrimPrefix( req.URL.Path, "/v2/" )
+        if p == "" {
+            rw.Header().Set( "Content-Type", "application/json" )
+            rw.Write( []byte( "{}" ) )
+            return
+        }
+        for _, kind := range []string{ "/manifests/", "/blobs/", "/tags/list" } {
+            pos := strings.LastIndex( p, kind )
+            if pos <= 0 {
+                continue
+            }
+            repository, reference := iw.nameTransform.Apply( p[0:pos] ), p[pos+len( kind ):]
+            if image_name, _ := parseImageName( repository ); image_name != repository {
+                break
+            }
+            if !iw.authorize( rw, req, repository, false ) {
+                return
+            }
+            switch kind {
+            case "/manifests/":
+                iw.serveRegistryManifest( rw, req, repository, reference )
+            case "/blobs/":
+                iw.serveRegistryBlob( rw, req, repository, reference )
+            default:
+                tags, err := iw.repositoryTags( repository )
+                if err != nil {
+                    writeRegistryError( rw, http.StatusInternalServerError, "UNKNOWN", err.Error() )
+                    return
+                }
+                if len( tags ) == 0 {
+                    writeRegistryError( rw, http.StatusNotFound, "NAME_UNKNOWN", "repository " + repository + " is not found" )
+                    return
+                }
+                rw.Header().Set( "Content-Type", "application/json" )
+                json.NewEncoder( rw ).Encode( map[string]interface{}{ "name": repository, "tags": tags } )
+            }
+            return
+        }
+        writeRegistryError( rw, http.StatusNotFound, "NAME_INVALID", "unknown registry path " + req.URL.Path )
+    })
+}
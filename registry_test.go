@@ -0,0 +1,151 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRegistryDisabled( t *testing.T ) {
+    _, handler := newTestWeb( t, NewMemoryImageStorage() )
+    if rw := doRequest( handler, "GET", "/v2/", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 without the registry API, got %d", rw.Code )
+    }
+}
+
+func TestRegistryPull( t *testing.T ) {
+    //the archive saved by docker 25 and later too
+    for _, make_archive := range []func( *testing.T, string, ...string ) []byte{ makeImageArchive, makeOCIImageArchive } {
+        testRegistryPull( t, make_archive( t, "pulled", "team/app:1" ) )
+    }
+}
+
+func testRegistryPull( t *testing.T, archive []byte ) {
+    iw, handler := newTestWeb( t, NewMemoryImageStorage() )
+    iw.SetRegistryAPI( true )
+    if rw := doRequest( handler, "POST", "/image/save/team/app:1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+
+    //the handshake of the docker client
+    rw := doRequest( handler, "GET", "/v2/", nil )
+    if rw.Code != http.StatusOK || rw.Header().Get( "Docker-Distribution-API-Version" ) != "registry/2.0" {
+        t.Fatalf( "expected the registry API version, got %d %v", rw.Code, rw.Header() )
+    }
+
+    rw = doRequest( handler, "GET", "/v2/team/app/manifests/1", nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected the manifest, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    body := rw.Body.Bytes()
+    digest := rw.Header().Get( "Docker-Content-Digest" )
+    if digest != sha256Digest( body ) {
+        t.Errorf( "expected the digest %s of the manifest, got %s", sha256Digest( body ), digest )
+    }
+    manifest := ociManifest{}
+    if err := json.Unmarshal( body, &manifest ); err != nil {
+        t.Fatalf( "invalid manifest %s: %v", body, err )
+    }
+    if rw.Header().Get( "Content-Type" ) != manifest.MediaType || len( manifest.Layers ) == 0 {
+        t.Errorf( "unexpected manifest %s of %s", body, rw.Header().Get( "Content-Type" ) )
+    }
+
+    //the manifest can be pulled by its digest
+    if rw = doRequest( handler, "GET", "/v2/team/app/manifests/" + digest, nil ); rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), body ) {
+        t.Errorf( "expected the manifest by digest, got %d", rw.Code )
+    }
+    for _, desc := range append( []ociDescriptor{ manifest.Config }, manifest.Layers... ) {
+        rw = doRequest( handler, "HEAD", "/v2/team/app/blobs/" + desc.Digest, nil )
+        if rw.Code != http.StatusOK || rw.Body.Len() != 0 || rw.Header().Get( "Docker-Content-Digest" ) != desc.Digest {
+            t.Errorf( "expected the headers of blob %s, got %d", desc.Digest, rw.Code )
+        }
+        rw = doRequest( handler, "GET", "/v2/team/app/blobs/" + desc.Digest, nil )
+        if rw.Code != http.StatusOK || sha256Digest( rw.Body.Bytes() ) != desc.Digest || int64( rw.Body.Len() ) != desc.Size {
+            t.Errorf( "expected blob %s, got %d with %d bytes", desc.Digest, rw.Code, rw.Body.Len() )
+        }
+    }
+    rw = doRequest( handler, "GET", "/v2/team/app/tags/list", nil )
+    if strings.TrimSpace( rw.Body.String() ) != `{"name":"team/app","tags":["1"]}` {
+        t.Errorf( "expected the tags of team/app, got %d %s", rw.Code, rw.Body.String() )
+    }
+}
+
+func TestRegistryErrors( t *testing.T ) {
+    iw, handler := newTestWeb( t, NewMemoryImageStorage() )
+    iw.SetRegistryAPI( true )
+    doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( makeImageArchive( t, "app", "app:1" ) ) )
+    for _, c := range []struct{ method string; path string; status int; code string }{
+                { "GET", "/v2/app/manifests/2", http.StatusNotFound, "MANIFEST_UNKNOWN" },
+                { "GET", "/v2/app/manifests/sha256:" + strings.Repeat( "0", 64 ), http.StatusNotFound, "MANIFEST_UNKNOWN" },
+                { "GET", "/v2/app/blobs/sha256:" + strings.Repeat( "0", 64 ), http.StatusNotFound, "BLOB_UNKNOWN" },
+                { "GET", "/v2/web/tags/list", http.StatusNotFound, "NAME_UNKNOWN" },
+                { "GET", "/v2/app/unknown", http.StatusNotFound, "NAME_INVALID" },
+                { "PUT", "/v2/app/manifests/1", http.StatusMethodNotAllowed, "UNSUPPORTED" } } {
+        rw := doRequest( handler, c.method, c.path, nil )
+        result := struct{ Errors []map[string]string }{}
+        json.Unmarshal( rw.Body.Bytes(), &result )
+        if rw.Code != c.status || len( result.Errors ) != 1 || result.Errors[0]["code"] != c.code {
+            t.Errorf( "%s %s: expected %d %s, got %d %s", c.method, c.path, c.status, c.code, rw.Code, rw.Body.String() )
+        }
+    }
+}
+
+// wait until the image is indexed for the registry API in the background
+func waitRegistryIndexed( t *testing.T, iw *ImageWeb, name string ) {
+    t.Helper()
+    for i := 0; i < 200; i++ {
+        iw.registry.mutex.Lock()
+        _, ok := iw.registry.manifests[name]
+        iw.registry.mutex.Unlock()
+        if ok {
+            return
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+    t.Fatalf( "image %s is not indexed", name )
+}
+
+// the manifests and blobs are indexed on write and at the start, so the
+// pulls by digest never convert the images of the repository
+func TestRegistryDigestIndex( t *testing.T ) {
+    storage := &countingStorage{ ImageStorage: NewMemoryImageStorage() }
+    iw, handler := newTestWeb( t, storage )
+    iw.SetRegistryAPI( true )
+    for _, tag := range []string{ "1", "2" } {
+        name := "app:" + tag
+        if rw := doRequest( handler, "POST", "/image/save/" + name, bytes.NewReader( makeImageArchive( t, name, name ) ) ); rw.Code != http.StatusCreated {
+            t.Fatalf( "expected 201, got %d", rw.Code )
+        }
+        waitRegistryIndexed( t, iw, name )
+    }
+    //an image stored before the start
+    storage.ImageStorage.Write( "app:3", bytes.NewReader( makeOCIImageArchive( t, "app:3", "app:3" ) ) )
+    if indexed, err := iw.loadRegistryIndex(); err != nil || indexed != 3 {
+        t.Fatalf( "expected 3 images indexed at the start, got %d: %v", indexed, err )
+    }
+
+    gets := storage.called( "Get" )
+    for _, p := range []string{ "/v2/app/manifests/sha256:" + strings.Repeat( "0", 64 ),
+                "/v2/app/blobs/sha256:" + strings.Repeat( "0", 64 ) } {
+        if rw := doRequest( handler, "GET", p, nil ); rw.Code != http.StatusNotFound {
+            t.Errorf( "expected 404 for %s, got %d", p, rw.Code )
+        }
+    }
+    if n := storage.called( "Get" ) - gets; n != 0 {
+        t.Errorf( "expected the unknown digests to be answered from the index, got %d gets", n )
+    }
+
+    digest := doRequest( handler, "HEAD", "/v2/app/manifests/3", nil ).Header().Get( "Docker-Content-Digest" )
+    if rw := doRequest( handler, "GET", "/v2/app/manifests/" + digest, nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the manifest of the image indexed at the start, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "DELETE", "/image/delete/app:3", nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "GET", "/v2/app/manifests/" + digest, nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the manifest of the deleted image, got %d", rw.Code )
+    }
+}
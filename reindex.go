@@ -0,0 +1,145 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "sync"
+)
+
+type reindexResult struct {
+    Name string `json:"name"`
+    Digest string `json:"digest,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+type reindexSummary struct {
+    Total int `json:"total"`
+    Indexed int `json:"indexed"`
+    Skipped int `json:"skipped"`
+    Failed []string `json:"failed"`
+}
+
+// compute the digest of image name by streaming it through sha256, the
+// storage with a stable digest gets it without reading the image
+func (iw *ImageWeb) computeDigest( name string ) (string, error) {
+    if digest, ok, err := contentDigest( iw.image_storage, name ); ok || err != nil {
+        return digest, err
+    }
+    hash := sha256.New()
+    if err := iw.image_storage.Get( name, hash ); err != nil {
+        return "", err
+    }
+    return "sha256:" + hex.EncodeToString( hash.Sum( nil ) ), nil
+}
+
+// seed the digest index with the checksums recorded by the storage or its
+// stable digests, so the images can be looked up by digest after a restart.
+// The images already indexed are kept, the images without a recorded
+// checksum are only indexed by /admin/reindex. Get the number of indexed
+// images
+func (iw *ImageWeb) loadDigests() (int, error) {
+    checksum_storage, has_checksums := iw.image_storage.(ChecksumStorage)
+    _, has_digests := iw.image_storage.(ContentDigester)
+    if !has_checksums && !has_digests {
+        return 0, nil
+    }
+    names, err := iw.image_storage.List()
+    if err != nil {
+        return 0, err
+    }
+    indexed := 0
+    for _, name := range names {
+        if _, ok := iw.digests.Digest( name ); ok {
+            continue
+        }
+        checksum, ok, err := contentDigest( iw.image_storage, name )
+        if !ok && err == nil && has_checksums {
+            checksum, ok, err = checksum_storage.Checksum( name )
+        }
+        if isNotFound( err ) {
+            continue
+        }
+        if err != nil {
+            return indexed, err
+        }
+        if ok {
+            iw.digests.Add( checksum, name )
+            indexed++
+        }
+    }
+    return indexed, nil
+}
+
+// rebuild the digest index from all the stored images. The images already
+// in the index are skipped unless full is true, so an interrupted reindex
+// can simply be run again. The progress is written to rw as one JSON object
+// per image followed by the summary
+func (iw *ImageWeb) reindex( rw http.ResponseWriter, full bool ) {
+    names, err := iw.image_storage.List()
+    if err != nil {
+        http.Error( rw, err.Error(), http.StatusInternalServerError )
+        return
+    }
+
+    //forget the images which are not in the storage any more
+    listed := make( map[string]bool )
+    for _, name := range names {
+        listed[name] = true
+    }
+    for _, entry := range iw.digests.Match( "" ) {
+        if !listed[entry.Name] {
+            iw.digests.Remove( entry.Name )
+        }
+    }
+
+    rw.Header().Set("Content-Type", "application/x-ndjson")
+    encoder := json.NewEncoder( rw )
+    flusher, _ := rw.(http.Flusher)
+    summary := reindexSummary{ Total: len( names ), Failed: make( []string, 0 ) }
+
+    results := make( chan reindexResult )
+    names_ch := make( chan string )
+    var wg sync.WaitGroup
+    for i := 0; i < iw.reindexConcurrency; i++ {
+        wg.Add( 1 )
+        go func() {
+            defer wg.Done()
+            for name := range names_ch {
+                digest, err := iw.computeDigest( name )
+                if err != nil {
+                    results <- reindexResult{ Name: name, Error: err.Error() }
+                } else {
+                    iw.digests.Add( digest, name )
+                    results <- reindexResult{ Name: name, Digest: digest }
+                }
+            }
+        }()
+    }
+    go func() {
+        for _, name := range names {
+            if _, ok := iw.digests.Digest( name ); ok && !full {
+                continue
+            }
+            names_ch <- name
+        }
+        close( names_ch )
+        wg.Wait()
+        close( results )
+    }()
+
+    for result := range results {
+        if result.Error != "" {
+            summary.Failed = append( summary.Failed, result.Name )
+        } else {
+            summary.Indexed++
+        }
+        encoder.Encode( result )
+        if flusher != nil {
+            flusher.Flush()
+        }
+    }
+    summary.Skipped = summary.Total - summary.Indexed - len( summary.Failed )
+    encoder.Encode( summary )
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+// decode the last line of the /admin/reindex response
+func reindexSummaryOf( t *testing.T, body string ) reindexSummary {
+    t.Helper()
+    lines := strings.Split( strings.TrimSpace( body ), "\n" )
+    summary := reindexSummary{}
+    if err := json.Unmarshal( []byte( lines[len( lines ) - 1] ), &summary ); err != nil {
+        t.Fatalf( "invalid reindex summary %q: %v", lines[len( lines ) - 1], err )
+    }
+    return summary
+}
+
+func TestReindexSeedsTheIndex( t *testing.T ) {
+    storage := &failingStorage{ ImageStorage: newFileStorage( t ), failAfter: 1 << 30 }
+    archives := make( map[string][]byte )
+    for _, name := range []string{ "app:1", "app:2", "app:3" } {
+        archives[name] = makeImageArchive( t, name, name )
+        if err := storage.Write( name, bytes.NewReader( archives[name] ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+    iw, handler := newTestWeb( t, storage )
+    if _, ok := iw.digests.Digest( "app:1" ); ok {
+        t.Fatal( "expected the images written to the storage directly not to be indexed" )
+    }
+
+    rw := doRequest( handler, "POST", "/admin/reindex", nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected the reindex to succeed, got %d", rw.Code )
+    }
+    if summary := reindexSummaryOf( t, rw.Body.String() ); summary.Total != 3 || summary.Indexed != 3 || len( summary.Failed ) != 0 {
+        t.Errorf( "expected all 3 images to be indexed, got %+v", summary )
+    }
+    for name, archive := range archives {
+        sum := sha256.Sum256( archive )
+        expected := "sha256:" + hex.EncodeToString( sum[:] )
+        if digest, ok := iw.digests.Digest( name ); !ok || digest != expected {
+            t.Errorf( "expected %s to be indexed with %s, got %s", name, expected, digest )
+        }
+    }
+
+    //running it again skips the indexed images, and reports the
+    //unreadable ones
+    storage.failAfter = 0
+    rw = doRequest( handler, "POST", "/admin/reindex", nil )
+    if summary := reindexSummaryOf( t, rw.Body.String() ); summary.Skipped != 3 || summary.Indexed != 0 {
+        t.Errorf( "expected the indexed images to be skipped, got %+v", summary )
+    }
+    rw = doRequest( handler, "POST", "/admin/reindex?full=true", nil )
+    if summary := reindexSummaryOf( t, rw.Body.String() ); len( summary.Failed ) != 3 {
+        t.Errorf( "expected the unreadable images to be reported, got %+v", summary )
+    }
+}
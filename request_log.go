@@ -0,0 +1,140 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// the formats of the request log
+const (
+    LogFormatText = "text"
+    LogFormatJSON = "json"
+    LogFormatNone = "none"
+)
+
+// one line of the request log
+type requestLogEntry struct {
+    Method string `json:"method"`
+    Path string `json:"path"`
+    Image string `json:"image,omitempty"`
+    Status int `json:"status"`
+    Bytes int64 `json:"bytes"`
+    Duration string `json:"duration"`
+
+    //the connection is aborted by the handler, e.g. a truncated download
+    Aborted bool `json:"aborted,omitempty"`
+}
+
+// the prefixes of the paths ending with an image name
+var imagePathPrefixes = []string{ "/image/get/", "/image/save/", "/image/delete/", "/image/verify/", "/image/exists/",
+    "/image/retag/", "/image/token/", "/image/metadata/", "/image/manifest/", "/image/manifest-raw/",
+    "/image/sbom/", "/image/upload-history/", "/image/protect/", "/image/unprotect/" }
+
+// get the image name of the request path, empty if it has no image name
+func requestImageName( path string ) string {
+    for _, prefix := range imagePathPrefixes {
+        if !strings.HasPrefix( path, prefix ) || len( path ) == len( prefix ) {
+            continue
+        }
+        //the save path may have the tag as the last segment
+        if prefix == "/image/save/" {
+            return pathImageName( path, prefix )
+        }
+        return normalizeImageName( strings.TrimPrefix( path, prefix ) )
+    }
+    return ""
+}
+
+// record the status and the number of bytes of the response
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes int64
+}
+
+func (sr *statusRecorder) WriteHeader( status int ) {
+    if sr.status == 0 {
+        sr.status = status
+    }
+    sr.ResponseWriter.WriteHeader( status )
+}
+
+func (sr *statusRecorder) Write( b []byte ) (int, error) {
+    if sr.status == 0 {
+        sr.status = http.StatusOK
+    }
+    n, err := sr.ResponseWriter.Write( b )
+    sr.bytes += int64( n )
+    return n, err
+}
+
+// keep the streamed responses like the progress of a load working
+func (sr *statusRecorder) Flush() {
+    if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+        flusher.Flush()
+    }
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+    return sr.ResponseWriter
+}
+
+// set the format of the request log, "text", "json" or "none"
+func (iw *ImageWeb) SetLogFormat( format string ) error {
+    switch format {
+    case LogFormatText, LogFormatJSON, LogFormatNone:
+        iw.logFormat = format
+        return nil
+    }
+    return fmt.Errorf( "unknown log format %s, it must be text, json or none", format )
+}
+
+// log one line for every request after it is served
+func (iw *ImageWeb) logRequests( handler http.Handler ) http.Handler {
+    return http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        if iw.logFormat == LogFormatNone {
+            handler.ServeHTTP( rw, req )
+            return
+        }
+        start := time.Now()
+        recorder := &statusRecorder{ ResponseWriter: rw }
+        defer func() {
+            aborted := recover()
+            status := recorder.status
+            if status == 0 {
+                status = http.StatusOK
+            }
+            iw.logRequest( requestLogEntry{ Method: req.Method,
+                    Path: req.URL.Path,
+                    Image: requestImageName( req.URL.Path ),
+                    Status: status,
+                    Bytes: recorder.bytes,
+                    Duration: time.Since( start ).String(),
+                    Aborted: aborted != nil } )
+            if aborted != nil {
+                panic( aborted )
+            }
+        }()
+        handler.ServeHTTP( recorder, req )
+    })
+}
+
+func (iw *ImageWeb) logRequest( entry requestLogEntry ) {
+    if iw.logFormat == LogFormatJSON {
+        b, _ := json.Marshal( entry )
+        log.Print( string( b ) )
+        return
+    }
+    line := fmt.Sprintf( "method=%s path=%q status=%d bytes=%d duration=%s", entry.Method, entry.Path, entry.Status, entry.Bytes, entry.Duration )
+    if entry.Image != "" {
+        line += fmt.Sprintf( " image=%q", entry.Image )
+    }
+    if entry.Aborted {
+        line += " aborted=true"
+    }
+    log.Print( line )
+}
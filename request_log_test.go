@@ -0,0 +1,67 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "testing"
+)
+
+// capture the lines logged while f runs
+func captureLog( f func() ) []string {
+    var logged bytes.Buffer
+    log.SetOutput( &logged )
+    flags := log.Flags()
+    log.SetFlags( 0 )
+    defer func() {
+        log.SetOutput( os.Stderr )
+        log.SetFlags( flags )
+    }()
+    f()
+    return strings.Split( strings.TrimSpace( logged.String() ), "\n" )
+}
+
+func TestRequestLogJSON( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Write( "team/app:1", strings.NewReader( "image" ) )
+    iw, handler := newTestWeb( t, storage )
+    if err := iw.SetLogFormat( LogFormatJSON ); err != nil {
+        t.Fatal( err )
+    }
+    lines := captureLog( func() {
+        doRequest( handler, "GET", "/image/get/team/app:1", nil )
+    })
+    entry := requestLogEntry{}
+    if err := json.Unmarshal( []byte( lines[len( lines ) - 1] ), &entry ); err != nil {
+        t.Fatalf( "expected a JSON log line, got %q: %v", lines, err )
+    }
+    if entry.Method != "GET" || entry.Path != "/image/get/team/app:1" || entry.Image != "team/app:1" ||
+            entry.Status != http.StatusOK || entry.Bytes != 5 || entry.Duration == "" {
+        t.Errorf( "unexpected log entry %+v", entry )
+    }
+}
+
+func TestRequestLogText( t *testing.T ) {
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    iw.SetLogFormat( LogFormatText )
+    lines := captureLog( func() {
+        doRequest( handler, "GET", "/image/get/app:1", nil )
+    })
+    line := lines[len( lines ) - 1]
+    for _, field := range []string{ "method=GET", `path="/image/get/app:1"`, "status=404", `image="app:1"`, "duration=" } {
+        if !strings.Contains( line, field ) {
+            t.Errorf( "expected %s in the log line %q", field, line )
+        }
+    }
+
+    iw.SetLogFormat( LogFormatNone )
+    if lines = captureLog( func() { doRequest( handler, "GET", "/image/list", nil ) } ); lines[0] != "" {
+        t.Errorf( "expected no log line, got %q", lines )
+    }
+    if err := iw.SetLogFormat( "xml" ); err == nil {
+        t.Error( "expected the unknown format to be rejected" )
+    }
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "errors"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "strings"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// copy the image src to dst with the native copy of the storage, or
+// through a temporary file for the storages without one
+func copyImage( storage ImageStorage, src string, dst string ) error {
+    if copier, ok := storage.(Copier); ok {
+        return copier.Copy( src, dst )
+    }
+    f, err := ioutil.TempFile( "", "image-copy" )
+    if err != nil {
+        return err
+    }
+    defer os.Remove( f.Name() )
+    defer f.Close()
+
+    if err = storage.Get( src, f ); err != nil {
+        return err
+    }
+    if _, err = f.Seek( 0, io.SeekStart ); err != nil {
+        return err
+    }
+    return storage.Write( dst, f )
+}
+
+// the stored bytes are copied with the codec of src. The file is not
+// hard-linked because an image file is overwritten in place, which
+// would change the linked copy too, unless the images are deduplicated
+// and writeFile links the copy to the blob of src
+func (fis *FileImageStorage) Copy( src string, dst string ) error {
+    if err := fis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer fis.limiter.Release()
+    src_file, err := fis.imageFile( src )
+    if err != nil {
+        return err
+    }
+    if _, err = fis.imageFile( dst ); err != nil {
+        return err
+    }
+    f, err := os.Open( src_file )
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    codec, err := ioutil.ReadFile( fis.sidecarFile( src, "codec" ) )
+    if err != nil && !os.IsNotExist( err ) {
+        return err
+    }
+    if err = fis.writeFile( dst, f, string( codec ), false ); err != nil || len( codec ) == 0 {
+        return err
+    }
+    //the checksum of the encoded image is not computed by writeFile
+    if checksum, err := ioutil.ReadFile( fis.sidecarFile( src, "sha256" ) ); err == nil {
+        return fis.writeSmallFile( fis.sidecarFile( dst, "sha256" ), checksum )
+    }
+    return nil
+}
+
+// tag the image of src as dst in the docker daemon
+func (dis *DockerImageStorage) Copy( src string, dst string ) error {
+    if err := dis.limiter.AcquireFor( OperationWrite ); err != nil {
+        return err
+    }
+    defer dis.limiter.Release()
+    image_name, image_version := parseImageName( dst )
+    dst = image_name + ":" + image_version
+    unlock := dis.locker.Lock( dst )
+    defer unlock()
+
+    image, err := dis.client.InspectImage( normalizeImageName( src ) )
+    if err != nil {
+        return err
+    }
+    if err = dis.client.TagImage( image.ID, docker.TagImageOptions{ Repo: image_name, Tag: image_version } ); err != nil {
+        return err
+    }
+    dis.expectTag( dst )
+    dis.listCache.Invalidate()
+    return nil
+}
+
+func (iw *ImageWeb) initRetag() {
+    http.HandleFunc("/image/retag/", func(rw http.ResponseWriter, req *http.Request) {
+        if req.Method != "POST" {
+            http.Error( rw, "method not allowed", http.StatusMethodNotAllowed )
+            return
+        }
+        src := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/retag/" ) )
+        dst := iw.nameTransform.Apply( req.URL.Query().Get( "to" ) )
+        if dst == "" {
+            http.Error( rw, "the new image name is missing in the \"to\" parameter", http.StatusBadRequest )
+            return
+        }
+        if !iw.checkName( rw, src ) || !iw.checkName( rw, dst ) || !iw.authorize( rw, req, src, false ) || !iw.authorize( rw, req, dst, true ) {
+            return
+        }
+        src, dst = normalizeImageName( src ), normalizeImageName( dst )
+        if exists, ok := iw.imageExists( rw, req, src ); !ok {
+            return
+        } else if !exists {
+            http.Error( rw, "image " + src + " is not found", http.StatusNotFound )
+            return
+        }
+        if exists, ok := iw.imageExists( rw, req, dst ); !ok {
+            return
+        } else if exists {
+            http.Error( rw, "image " + dst + " already exists", http.StatusConflict )
+            return
+        }
+        err := copyImage( iw.image_storage, src, dst )
+        iw.listCache.Invalidate()
+        if err != nil {
+            iw.metrics.CountFailure( "retag", err )
+            switch {
+            case isNotFound( err ):
+                http.Error( rw, "image " + src + " is not found", http.StatusNotFound )
+            case errors.Is( err, ErrBusy ):
+                http.Error( rw, err.Error(), http.StatusServiceUnavailable )
+            case errors.Is( err, ErrInsufficientStorage ):
+                http.Error( rw, err.Error(), http.StatusInsufficientStorage )
+            default:
+                http.Error( rw, "fail to retag image " + src + ": " + err.Error(), http.StatusInternalServerError )
+            }
+            return
+        }
+        if digest, ok := iw.digests.Digest( src ); ok {
+            iw.digests.Add( digest, dst )
+        }
+        iw.indexRegistryImage( dst )
+        rw.Write( []byte( "retag image successfully" ) )
+    })
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+    "bytes"
+    "net/http"
+    "testing"
+)
+
+func TestRetagFileStorage( t *testing.T ) {
+    for _, compress := range []bool{ false, true } {
+        storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+        if err != nil {
+            t.Fatal( err )
+        }
+        storage.Compress = compress
+        archive := makeImageArchive( t, "app", "app:1" )
+        storage.Write( "app:1", bytes.NewReader( archive ) )
+        storage.Write( "app:3", bytes.NewReader( []byte( "three" ) ) )
+        _, handler := newTestWeb( t, storage )
+
+        if rw := doRequest( handler, "POST", "/image/retag/app:1?to=team/app:2", nil ); rw.Code != http.StatusOK {
+            t.Fatalf( "compress %v: expected 200, got %d: %s", compress, rw.Code, rw.Body.String() )
+        }
+        //the copy is independent of the source
+        storage.Write( "app:1", bytes.NewReader( []byte( "rewritten" ) ) )
+        if rw := doRequest( handler, "GET", "/image/get/team/app:2", nil ); rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+            t.Errorf( "compress %v: expected the copied image, got %d", compress, rw.Code )
+        }
+
+        for url, status := range map[string]int{
+                    "/image/retag/app:9?to=app:10": http.StatusNotFound,
+                    "/image/retag/app:1?to=app:3": http.StatusConflict,
+                    "/image/retag/app:1": http.StatusBadRequest } {
+            if rw := doRequest( handler, "POST", url, nil ); rw.Code != status {
+                t.Errorf( "compress %v: expected %d for %s, got %d", compress, status, url, rw.Code )
+            }
+        }
+        var b bytes.Buffer
+        if storage.Get( "app:3", &b ); b.String() != "three" {
+            t.Errorf( "compress %v: expected the existing destination to be kept, got %q", compress, b.String() )
+        }
+    }
+}
+
+// the storage without a native copy is copied through Get and Write
+func TestRetagWithoutCopier( t *testing.T ) {
+    storage := struct{ ImageStorage }{ newFileStorage( t ) }
+    storage.Write( "app:1", bytes.NewReader( []byte( "one" ) ) )
+    _, handler := newTestWeb( t, storage )
+    if rw := doRequest( handler, "GET", "/image/retag/app:1?to=app:2", nil ); rw.Code != http.StatusMethodNotAllowed {
+        t.Errorf( "expected 405 for GET, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "POST", "/image/retag/app:1?to=app:2", nil ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+    var b bytes.Buffer
+    if storage.Get( "app:2", &b ); b.String() != "one" {
+        t.Errorf( "expected the copy of app:1, got %q", b.String() )
+    }
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestSbomAttachAndGet( t *testing.T ) {
+    storage := newFileStorage( t )
+    if err := storage.Write( "team/app:1", strings.NewReader( "image" ) ); err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    iw.SetSbomLimits( 16, []string{ "application/spdx+json" } )
+
+    if rw := doRequest( handler, "GET", "/image/sbom/team/app:1", nil ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 before the SBOM is attached, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "POST", "/image/sbom/team/app:1", strings.NewReader( `{"spdx":1}` ), "Content-Type", "application/spdx+json" ); rw.Code != http.StatusOK {
+        t.Fatalf( "expected the SBOM to be attached, got %d %s", rw.Code, responseBody( t, rw ) )
+    }
+    rw := doRequest( handler, "GET", "/image/sbom/team/app:1", nil )
+    if rw.Code != http.StatusOK || responseBody( t, rw ) != `{"spdx":1}` {
+        t.Errorf( "expected the attached SBOM, got %d", rw.Code )
+    }
+    if content_type := rw.Header().Get( "Content-Type" ); content_type != "application/spdx+json" {
+        t.Errorf( "expected the content type of the SBOM, got %s", content_type )
+    }
+
+    if rw := doRequest( handler, "POST", "/image/sbom/team/app:1", strings.NewReader( "{}" ), "Content-Type", "text/plain" ); rw.Code != http.StatusUnsupportedMediaType {
+        t.Errorf( "expected 415 for the content type not allowed, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "POST", "/image/sbom/team/app:1", strings.NewReader( strings.Repeat( "x", 17 ) ), "Content-Type", "application/spdx+json" ); rw.Code != http.StatusRequestEntityTooLarge {
+        t.Errorf( "expected 413 for the SBOM over the limit, got %d", rw.Code )
+    }
+    if rw := doRequest( handler, "POST", "/image/sbom/other:1", strings.NewReader( "{}" ), "Content-Type", "application/spdx+json" ); rw.Code != http.StatusNotFound {
+        t.Errorf( "expected 404 for the missing image, got %d", rw.Code )
+    }
+}
+
+func TestSbomNotSupported( t *testing.T ) {
+    //only the methods of ImageStorage are promoted from the embedded storage
+    _, handler := newTestWeb( t, struct{ ImageStorage }{ newFileStorage( t ) } )
+    if rw := doRequest( handler, "GET", "/image/sbom/app:1", nil ); rw.Code != http.StatusNotImplemented {
+        t.Errorf( "expected 501 for the storage without SBOM, got %d", rw.Code )
+    }
+}
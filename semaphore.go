@@ -0,0 +1,181 @@
+package main
+
+import (
+    "container/heap"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// returned when the storage is still at its concurrency cap after waiting
+var ErrBusy = errors.New("storage is busy")
+
+// the operations of a storage competing for the slots of its Semaphore
+const (
+    OperationGet = "get"
+    OperationWrite = "write"
+    OperationDelete = "delete"
+)
+
+// the default priorities of the operations, the interactive pulls are
+// served before the bulk pushes
+var defaultOperationPriorities = map[string]int{ OperationGet: 10, OperationDelete: 5, OperationWrite: 0 }
+
+// an operation waiting for a free slot
+type semaphoreWaiter struct {
+    priority int
+
+    //the order of arrival among the waiters of the same priority
+    seq uint64
+
+    //closed when the slot is handed over to the waiter
+    ready chan struct{}
+
+    index int
+}
+
+// the waiters ordered by priority (highest first) and then arrival
+type waiterQueue []*semaphoreWaiter
+
+func (wq waiterQueue) Len() int { return len( wq ) }
+
+func (wq waiterQueue) Less( i, j int ) bool {
+    if wq[i].priority != wq[j].priority {
+        return wq[i].priority > wq[j].priority
+    }
+    return wq[i].seq < wq[j].seq
+}
+
+func (wq waiterQueue) Swap( i, j int ) {
+    wq[i], wq[j] = wq[j], wq[i]
+    wq[i].index = i
+    wq[j].index = j
+}
+
+func (wq *waiterQueue) Push( x interface{} ) {
+    waiter := x.(*semaphoreWaiter)
+    waiter.index = len( *wq )
+    *wq = append( *wq, waiter )
+}
+
+func (wq *waiterQueue) Pop() interface{} {
+    old := *wq
+    waiter := old[len( old ) - 1]
+    *wq = old[0:len( old ) - 1]
+    waiter.index = -1
+    return waiter
+}
+
+// limit the number of concurrent operations of a storage, a nil
+// *Semaphore does not limit anything. When all slots are taken, the
+// freed slot goes to the waiting operation with the highest priority
+type Semaphore struct {
+    mutex sync.Mutex
+
+    max int
+    used int
+
+    waiters waiterQueue
+    seq uint64
+
+    //how long an operation waits for a free slot
+    wait time.Duration
+
+    priorities map[string]int
+}
+
+func NewSemaphore( max int, wait time.Duration ) *Semaphore {
+    if max <= 0 {
+        return nil
+    }
+    return &Semaphore{ max: max, wait: wait, priorities: defaultOperationPriorities }
+}
+
+// set the priorities of the operations, the operations not given get 0
+func (s *Semaphore) SetPriorities( priorities map[string]int ) {
+    if s == nil {
+        return
+    }
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    s.priorities = priorities
+}
+
+// wait for a free slot with the lowest priority
+func (s *Semaphore) Acquire() error {
+    return s.AcquireFor( "" )
+}
+
+// wait for a free slot for the operation, ErrBusy is returned if no slot
+// is handed over in time
+func (s *Semaphore) AcquireFor( operation string ) error {
+    if s == nil {
+        return nil
+    }
+    s.mutex.Lock()
+    if s.used < s.max && len( s.waiters ) == 0 {
+        s.used++
+        s.mutex.Unlock()
+        return nil
+    }
+    s.seq++
+    waiter := &semaphoreWaiter{ priority: s.priorities[operation], seq: s.seq, ready: make( chan struct{} ) }
+    heap.Push( &s.waiters, waiter )
+    s.mutex.Unlock()
+
+    timer := time.NewTimer( s.wait )
+    defer timer.Stop()
+    select {
+    case <-waiter.ready:
+        return nil
+    case <-timer.C:
+    }
+
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    //the slot may be handed over just when the wait expires
+    if waiter.index == -1 {
+        return nil
+    }
+    heap.Remove( &s.waiters, waiter.index )
+    return ErrBusy
+}
+
+// hand the slot over to the waiter with the highest priority if any
+func (s *Semaphore) Release() {
+    if s == nil {
+        return
+    }
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+    if len( s.waiters ) > 0 {
+        waiter := heap.Pop( &s.waiters ).(*semaphoreWaiter)
+        close( waiter.ready )
+        return
+    }
+    s.used--
+}
+
+// parse the priorities in "<operation>=<priority>,..." format
+func ParseOperationPriorities( s string ) (map[string]int, error) {
+    priorities := make( map[string]int )
+    for _, item := range strings.Split( s, "," ) {
+        item = strings.TrimSpace( item )
+        if item == "" {
+            continue
+        }
+        pos := strings.Index( item, "=" )
+        if pos == -1 {
+            return nil, fmt.Errorf( "invalid operation priority %s", item )
+        }
+        priority, err := strconv.Atoi( item[pos+1:] )
+        if err != nil {
+            return nil, fmt.Errorf( "invalid operation priority %s", item )
+        }
+        priorities[item[0:pos]] = priority
+    }
+    return priorities, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "io/ioutil"
+    "testing"
+    "time"
+)
+
+// start a write holding a slot of the storage until the returned writer
+// is closed
+func holdWrite( t *testing.T, image_storage ImageStorage, name string ) (*io.PipeWriter, chan error) {
+    pr, pw := io.Pipe()
+    done := make( chan error, 1 )
+    go func() {
+        done <- image_storage.Write( name, pr )
+    }()
+    //the write is blocked on the pipe once it has the slot
+    pw.Write( []byte( "partial" ) )
+    return pw, done
+}
+
+func TestFileStorageConcurrencyCap( t *testing.T ) {
+    limited := newFileStorage( t )
+    limited.SetConcurrency( 1, 50 * time.Millisecond )
+    other := newFileStorage( t )
+    other.SetConcurrency( 2, 50 * time.Millisecond )
+    var err error
+    for _, storage := range []*FileImageStorage{ limited, other } {
+        if err = storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); err != nil {
+            t.Fatal( err )
+        }
+    }
+
+    pw, done := holdWrite( t, limited, "app:2" )
+    if err = limited.Get( "app:1", ioutil.Discard ); !errors.Is( err, ErrBusy ) {
+        t.Errorf( "expected ErrBusy at the cap, got %v", err )
+    }
+    //the cap of the other storage is independent
+    other_pw, other_done := holdWrite( t, other, "app:2" )
+    if err = other.Get( "app:1", ioutil.Discard ); err != nil {
+        t.Errorf( "expected the other storage to have a free slot, got %v", err )
+    }
+
+    for i, writer := range []*io.PipeWriter{ pw, other_pw } {
+        writer.Close()
+        if err = <-[]chan error{ done, other_done }[i]; err != nil {
+            t.Fatal( err )
+        }
+    }
+    if err = limited.Get( "app:1", ioutil.Discard ); err != nil {
+        t.Errorf( "expected the slot to be released, got %v", err )
+    }
+}
+
+func TestQueuedGetServedBeforeQueuedWrite( t *testing.T ) {
+    image_storage := newFileStorage( t )
+    image_storage.SetConcurrency( 1, 2 * time.Second )
+    image_storage.SetOperationPriorities( map[string]int{ OperationGet: 10, OperationWrite: 0 } )
+    var err error
+    if err = image_storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    pw, done := holdWrite( t, image_storage, "app:2" )
+
+    //the push is queued before the pull
+    queued_pr, queued_pw := io.Pipe()
+    queued_done := make( chan error, 1 )
+    go func() {
+        queued_done <- image_storage.Write( "app:3", queued_pr )
+    }()
+    time.Sleep( 20 * time.Millisecond )
+    got := make( chan error, 1 )
+    go func() {
+        got <- image_storage.Get( "app:1", ioutil.Discard )
+    }()
+    time.Sleep( 20 * time.Millisecond )
+
+    //the pull gets the freed slot while the push still waits
+    pw.Close()
+    if err = <-done; err != nil {
+        t.Fatal( err )
+    }
+    select {
+    case err = <-got:
+        if err != nil {
+            t.Errorf( "expected the queued pull to be served, got %v", err )
+        }
+    case <-time.After( time.Second ):
+        t.Error( "the queued push is served before the queued pull" )
+    }
+    queued_pw.Close()
+    if err = <-queued_done; err != nil {
+        t.Fatal( err )
+    }
+}
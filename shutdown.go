@@ -0,0 +1,96 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// keep the requests being served so the transfers interrupted
+// by the shutdown can be reported
+type TransferTracker struct {
+    mutex sync.Mutex
+    nextId int64
+    transfers map[int64]string
+}
+
+func NewTransferTracker() *TransferTracker {
+    return &TransferTracker{ transfers: make( map[int64]string ) }
+}
+
+// wrap the handler so every request is tracked while it is served
+func (tt *TransferTracker)Wrap( handler http.Handler ) http.Handler {
+    return http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        start := time.Now()
+        tt.mutex.Lock()
+        tt.nextId++
+        id := tt.nextId
+        tt.transfers[id] = fmt.Sprintf( "%s %s from %s started at %s", req.Method, req.URL.Path, req.RemoteAddr, start.Format( time.RFC3339 ) )
+        tt.mutex.Unlock()
+
+        defer func() {
+            tt.mutex.Lock()
+            delete( tt.transfers, id )
+            tt.mutex.Unlock()
+        }()
+        handler.ServeHTTP( rw, req )
+    })
+}
+
+// get the description of all the requests being served
+func (tt *TransferTracker)Active() []string {
+    tt.mutex.Lock()
+    defer tt.mutex.Unlock()
+
+    result := make( []string, 0, len( tt.transfers ) )
+    for _, transfer := range tt.transfers {
+        result = append( result, transfer )
+    }
+    return result
+}
+
+// stop accepting new requests and wait up to grace for the in-flight
+// transfers to finish, the remaining ones are force-closed and logged
+func (iw *ImageWeb) Shutdown( grace time.Duration ) error {
+    ctx, cancel := context.WithTimeout( context.Background(), grace )
+    defer cancel()
+
+    err := iw.server.Shutdown( ctx )
+    iw.maintenance.Stop()
+    if err == context.DeadlineExceeded {
+        for _, transfer := range iw.transfers.Active() {
+            log.Printf( "transfer %s is interrupted by shutdown", transfer )
+        }
+        return iw.server.Close()
+    }
+    return err
+}
+
+// how long the in-flight transfers can take to finish when the server
+// of ServeContext is shut down
+func (iw *ImageWeb) SetShutdownGrace( grace time.Duration ) {
+    iw.shutdownGrace = grace
+}
+
+// serve the requests on addr like Serve until ctx is done, then shut
+// down within the shutdown grace. It returns after the listener is
+// closed, nil if the server is shut down by ctx
+func (iw *ImageWeb) ServeContext( ctx context.Context, addr string ) error {
+    served := make( chan error, 1 )
+    go func() {
+        served <- iw.Serve( addr )
+    }()
+    select {
+    case err := <-served:
+        return err
+    case <-ctx.Done():
+    }
+    err := iw.Shutdown( iw.shutdownGrace )
+    if serve_err := <-served; err == nil && serve_err != http.ErrServerClosed {
+        err = serve_err
+    }
+    return err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestServeUnixSocket( t *testing.T ) {
+    iw, _ := newTestWeb( t, newFileStorage( t ) )
+    iw.SetSocketMode( 0600 )
+    socket_file := filepath.Join( t.TempDir(), "image-mgr.sock" )
+    ctx, cancel := context.WithCancel( context.Background() )
+    served := make( chan error, 1 )
+    go func() {
+        served <- iw.ServeContext( ctx, "unix:" + socket_file )
+    }()
+
+    client := &http.Client{ Transport: &http.Transport{ DialContext: func( ctx context.Context, _, _ string ) (net.Conn, error) {
+        return ( &net.Dialer{} ).DialContext( ctx, "unix", socket_file )
+    } } }
+    var resp *http.Response
+    var err error
+    for i := 0; i < 50; i++ {
+        if resp, err = client.Get( "http://unix/image/list" ); err == nil {
+            break
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+    if err != nil {
+        t.Fatal( err )
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Errorf( "expected 200, got %d", resp.StatusCode )
+    }
+    if info, err := os.Stat( socket_file ); err != nil {
+        t.Error( err )
+    } else if info.Mode().Perm() != 0600 {
+        t.Errorf( "expected the socket permission 0600, got %v", info.Mode().Perm() )
+    }
+
+    cancel()
+    if err := <-served; err != nil {
+        t.Errorf( "expected a clean shutdown, got %v", err )
+    }
+    if _, err := os.Stat( socket_file ); !os.IsNotExist( err ) {
+        t.Error( "the socket is not removed after the shutdown" )
+    }
+}
+
+// get a free local TCP address to serve on
+func freeAddr( t *testing.T ) string {
+    t.Helper()
+    l, err := net.Listen( "tcp", "127.0.0.1:0" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    defer l.Close()
+    return l.Addr().String()
+}
+
+// wrap a storage so its Get writes the first byte of the image, signals
+// started and then waits for delay before writing the rest
+type slowStorage struct {
+    ImageStorage
+    delay time.Duration
+    started chan struct{}
+}
+
+func (ss *slowStorage) Get( name string, writer io.Writer ) error {
+    var b bytes.Buffer
+    if err := ss.ImageStorage.Get( name, &b ); err != nil {
+        return err
+    }
+    writer.Write( b.Bytes()[:1] )
+    if flusher, ok := writer.(http.Flusher); ok {
+        flusher.Flush()
+    }
+    close( ss.started )
+    time.Sleep( ss.delay )
+    _, err := writer.Write( b.Bytes()[1:] )
+    return err
+}
+
+// serve storage on a local address and start downloading app:1 while it is
+// served slowly, then shut the server down within grace
+func shutdownDuringTransfer( t *testing.T, delay time.Duration, grace time.Duration ) ([]byte, error, time.Duration) {
+    t.Helper()
+    storage := &slowStorage{ ImageStorage: newFileStorage( t ), delay: delay, started: make( chan struct{} ) }
+    storage.ImageStorage.Write( "app:1", bytes.NewReader( []byte( "slow image" ) ) )
+    iw, _ := newTestWeb( t, storage )
+    addr := freeAddr( t )
+    served := make( chan error, 1 )
+    go func() {
+        served <- iw.Serve( addr )
+    }()
+
+    type download struct {
+        body []byte
+        err error
+    }
+    downloaded := make( chan download, 1 )
+    go func() {
+        var resp *http.Response
+        var err error
+        for i := 0; i < 50; i++ {
+            if resp, err = http.Get( "http://" + addr + "/image/get/app:1" ); err == nil {
+                break
+            }
+            time.Sleep( 10 * time.Millisecond )
+        }
+        if err != nil {
+            downloaded <- download{ err: err }
+            return
+        }
+        defer resp.Body.Close()
+        body, err := ioutil.ReadAll( resp.Body )
+        downloaded <- download{ body, err }
+    }()
+    <-storage.started
+    start := time.Now()
+    iw.Shutdown( grace )
+    elapsed := time.Since( start )
+    if err := <-served; err != http.ErrServerClosed {
+        t.Errorf( "expected the server to be closed, got %v", err )
+    }
+    result := <-downloaded
+    return result.body, result.err, elapsed
+}
+
+func TestShutdownWaitsForTransfer( t *testing.T ) {
+    body, err, _ := shutdownDuringTransfer( t, 100 * time.Millisecond, 5 * time.Second )
+    if err != nil || string( body ) != "slow image" {
+        t.Errorf( "expected the transfer to finish within the grace, got %q: %v", body, err )
+    }
+}
+
+func TestShutdownClosesLongTransfer( t *testing.T ) {
+    var logged bytes.Buffer
+    log.SetOutput( &logged )
+    defer log.SetOutput( os.Stderr )
+
+    body, err, elapsed := shutdownDuringTransfer( t, 2 * time.Second, 100 * time.Millisecond )
+    if err == nil && string( body ) == "slow image" {
+        t.Error( "expected the transfer over the grace to be force-closed" )
+    }
+    if elapsed > time.Second {
+        t.Errorf( "expected the shutdown to return after the grace, took %v", elapsed )
+    }
+    if !strings.Contains( logged.String(), "GET /image/get/app:1" ) {
+        t.Errorf( "expected the interrupted transfer to be logged, got %q", logged.String() )
+    }
+}
+
+func TestServeContextDrainsTransfer( t *testing.T ) {
+    storage := &slowStorage{ ImageStorage: newFileStorage( t ), delay: 100 * time.Millisecond, started: make( chan struct{} ) }
+    storage.ImageStorage.Write( "app:1", bytes.NewReader( []byte( "slow image" ) ) )
+    iw, _ := newTestWeb( t, storage )
+    iw.SetShutdownGrace( 5 * time.Second )
+    addr := freeAddr( t )
+    ctx, cancel := context.WithCancel( context.Background() )
+    served := make( chan error, 1 )
+    go func() {
+        served <- iw.ServeContext( ctx, addr )
+    }()
+
+    downloaded := make( chan string, 1 )
+    go func() {
+        var resp *http.Response
+        var err error
+        for i := 0; i < 50; i++ {
+            if resp, err = http.Get( "http://" + addr + "/image/get/app:1" ); err == nil {
+                break
+            }
+            time.Sleep( 10 * time.Millisecond )
+        }
+        if err != nil {
+            downloaded <- err.Error()
+            return
+        }
+        defer resp.Body.Close()
+        body, _ := ioutil.ReadAll( resp.Body )
+        downloaded <- string( body )
+    }()
+    <-storage.started
+    cancel()
+    if err := <-served; err != nil {
+        t.Errorf( "expected a clean shutdown, got %v", err )
+    }
+    if body := <-downloaded; body != "slow image" {
+        t.Errorf( "expected the transfer to complete, got %q", body )
+    }
+    if conn, err := net.Dial( "tcp", addr ); err == nil {
+        conn.Close()
+        t.Error( "expected the listener to be closed" )
+    }
+}
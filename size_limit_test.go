@@ -0,0 +1,61 @@
+package main
+
+import (
+    "bytes"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestMaxImageSize( t *testing.T ) {
+    storage, err := NewFileImageStorage( t.TempDir(), 0, 0 )
+    if err != nil {
+        t.Fatal( err )
+    }
+    iw, handler := newTestWeb( t, storage )
+    iw.SetMaxImageSize( 10 )
+
+    tests := []struct {
+        name string
+        content string
+        //the body is streamed without a Content-Length
+        streamed bool
+        status int
+    }{
+        { "app:under", "123456789", false, http.StatusCreated },
+        { "app:at", "1234567890", false, http.StatusCreated },
+        { "app:at-streamed", "1234567890", true, http.StatusCreated },
+        { "app:over", "12345678901", false, http.StatusRequestEntityTooLarge },
+        { "app:over-streamed", "12345678901", true, http.StatusRequestEntityTooLarge },
+    }
+    for _, test := range tests {
+        var body io.Reader = bytes.NewReader( []byte( test.content ) )
+        if test.streamed {
+            body = ioutil.NopCloser( body )
+        }
+        rw := doRequest( handler, "POST", "/image/save/" + test.name, body )
+        if rw.Code != test.status {
+            t.Errorf( "%s: expected %d, got %d: %s", test.name, test.status, rw.Code, rw.Body.String() )
+            continue
+        }
+        exists, err := storage.Exists( test.name )
+        if err != nil {
+            t.Fatal( err )
+        }
+        if exists != ( test.status == http.StatusCreated ) {
+            t.Errorf( "%s: expected the image to exist %v, got %v", test.name, test.status == http.StatusCreated, exists )
+        }
+    }
+
+    //nothing of the oversized images is left on the disk
+    filepath.Walk( storage.Dir, func( path string, info os.FileInfo, err error ) error {
+        if err == nil && !info.IsDir() && strings.Contains( filepath.Base( path ), "over" ) {
+            t.Errorf( "%s is left by the oversized upload", path )
+        }
+        return nil
+    })
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "io"
+    "time"
+)
+
+// compose a fast file storage keeping the image names and their metadata
+// with a slow/cheap storage keeping the image content, so listing and the
+// metadata never touch the blob storage
+type SplitImageStorage struct {
+    //keep a splitRecord for every image name and the sidecars of the
+    //image, e.g. the labels and the SBOM
+    index *FileImageStorage
+
+    //keep the image content
+    blobs ImageStorage
+}
+
+// the index entry of an image, the index written before the records were
+// introduced has empty entries
+type splitRecord struct {
+    //the "sha256:<hex>" and the size of the image as it was written
+    Digest string `json:"digest"`
+    Size int64 `json:"size"`
+}
+
+func NewSplitImageStorage( index *FileImageStorage, blobs ImageStorage ) *SplitImageStorage {
+    return &SplitImageStorage{ index: index, blobs: blobs }
+}
+
+// write the blob first and then the name, so a listed image always
+// has its content. The blob is removed if the name can't be written
+func (sis *SplitImageStorage) Write( name string, reader io.Reader ) error {
+    hash := sha256.New()
+    counter := &countingWriter{ w: hash }
+    if err := sis.blobs.Write( name, io.TeeReader( reader, counter ) ); err != nil {
+        return err
+    }
+    record, err := json.Marshal( splitRecord{ Digest: "sha256:" + hex.EncodeToString( hash.Sum( nil ) ), Size: counter.n } )
+    if err == nil {
+        err = sis.index.Write( name, bytes.NewReader( record ) )
+    }
+    if err != nil {
+        sis.blobs.Delete( name )
+        return err
+    }
+    return nil
+}
+
+func (sis *SplitImageStorage) Get( name string, writer io.Writer ) error {
+    return sis.blobs.Get( name, writer )
+}
+
+// delete the name first so the image disappears from the list
+// even if the blob can't be deleted
+func (sis *SplitImageStorage) Delete( name string ) error {
+    if err := sis.index.Delete( name ); err != nil {
+        return err
+    }
+    return sis.blobs.Delete( name )
+}
+
+func (sis *SplitImageStorage) List() ([]string, error) {
+    return sis.index.List()
+}
+
+func (sis *SplitImageStorage) ListAfter( after string, limit int ) ([]string, error) {
+    return sis.index.ListAfter( after, limit )
+}
+
+func (sis *SplitImageStorage) Search( prefix string ) ([]string, error) {
+    return sis.index.Search( prefix )
+}
+
+// the image content is only in the blob storage
+func (sis *SplitImageStorage) ContentDigest( name string ) (string, bool, error) {
+    return contentDigest( sis.blobs, name )
+}
+
+// read the record of image name, nil if the index entry has no record
+func (sis *SplitImageStorage) record( name string ) (*splitRecord, error) {
+    var b bytes.Buffer
+    if err := sis.index.Get( name, &b ); err != nil {
+        return nil, err
+    }
+    if b.Len() == 0 {
+        return nil, nil
+    }
+    record := &splitRecord{}
+    if err := json.Unmarshal( b.Bytes(), record ); err != nil {
+        return nil, err
+    }
+    return record, nil
+}
+
+func (sis *SplitImageStorage) Checksum( name string ) (string, bool, error) {
+    record, err := sis.record( name )
+    if err != nil || record == nil {
+        return "", false, err
+    }
+    return record.Digest, true, nil
+}
+
+func (sis *SplitImageStorage) Size( name string ) (int64, bool, error) {
+    record, err := sis.record( name )
+    if err != nil || record == nil {
+        return 0, false, err
+    }
+    return record.Size, true, nil
+}
+
+// the index entry is written right after the blob
+func (sis *SplitImageStorage) CreatedAt( name string ) (time.Time, error) {
+    return sis.index.CreatedAt( name )
+}
+
+func (sis *SplitImageStorage) GetMetadata( name string ) (*ImageMetadata, error) {
+    return sis.index.GetMetadata( name )
+}
+
+func (sis *SplitImageStorage) UpdateMetadata( name string, version int64, labels map[string]string ) (*ImageMetadata, error) {
+    return sis.index.UpdateMetadata( name, version, labels )
+}
+
+func (sis *SplitImageStorage) WriteSbom( name string, contentType string, reader io.Reader ) error {
+    return sis.index.WriteSbom( name, contentType, reader )
+}
+
+func (sis *SplitImageStorage) GetSbom( name string ) ([]byte, string, error) {
+    return sis.index.GetSbom( name )
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+    "bytes"
+    "strings"
+    "testing"
+)
+
+func newTestSplitStorage( t *testing.T ) (*SplitImageStorage, *countingStorage) {
+    t.Helper()
+    blobs := &countingStorage{ ImageStorage: newFileStorage( t ) }
+    return NewSplitImageStorage( newFileStorage( t ), blobs ), blobs
+}
+
+func TestSplitMetadataSkipsBlobs( t *testing.T ) {
+    storage, blobs := newTestSplitStorage( t )
+    archive := makeImageArchive( t, "split", "app:1" )
+    if err := storage.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    writes := len( blobs.calls )
+
+    if images, err := storage.List(); err != nil || len( images ) != 1 || images[0] != "app:1" {
+        t.Errorf( "expected app:1 to be listed, got %v, %v", images, err )
+    }
+    if images, err := storage.Search( "app" ); err != nil || len( images ) != 1 {
+        t.Errorf( "expected app:1 to be found, got %v, %v", images, err )
+    }
+    if images, err := storage.ListAfter( "", 10 ); err != nil || len( images ) != 1 {
+        t.Errorf( "expected one page with app:1, got %v, %v", images, err )
+    }
+    if exists, err := storage.Exists( "app:1" ); err != nil || !exists {
+        t.Errorf( "expected app:1 to exist, got %v, %v", exists, err )
+    }
+    if checksum, ok, err := storage.Checksum( "app:1" ); err != nil || !ok || checksum != sha256Digest( archive ) {
+        t.Errorf( "expected the checksum %s, got %s, %v, %v", sha256Digest( archive ), checksum, ok, err )
+    }
+    if size, ok, err := storage.Size( "app:1" ); err != nil || !ok || size != int64( len( archive ) ) {
+        t.Errorf( "expected the size %d, got %d, %v, %v", len( archive ), size, ok, err )
+    }
+    if _, err := storage.CreatedAt( "app:1" ); err != nil {
+        t.Errorf( "expected the creation time, got %v", err )
+    }
+    if _, err := storage.UpdateMetadata( "app:1", -1, map[string]string{ "team": "infra" } ); err != nil {
+        t.Fatal( err )
+    }
+    if metadata, err := storage.GetMetadata( "app:1" ); err != nil || metadata.Labels["team"] != "infra" {
+        t.Errorf( "expected the label to be kept, got %+v, %v", metadata, err )
+    }
+    if err := storage.WriteSbom( "app:1", "application/spdx+json", strings.NewReader( "{}" ) ); err != nil {
+        t.Fatal( err )
+    }
+    if sbom, _, err := storage.GetSbom( "app:1" ); err != nil || string( sbom ) != "{}" {
+        t.Errorf( "expected the SBOM to be kept, got %q, %v", sbom, err )
+    }
+    if len( blobs.calls ) != writes {
+        t.Errorf( "expected the metadata calls to stay in the index, the blob storage got %v", blobs.calls[writes:] )
+    }
+
+    var b bytes.Buffer
+    if err := storage.Get( "app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), archive ) {
+        t.Errorf( "expected the image to be read from the blobs, got %v", err )
+    }
+}
+
+func TestSplitDeleteRemovesBoth( t *testing.T ) {
+    storage, blobs := newTestSplitStorage( t )
+    if err := storage.Write( "app:1", bytes.NewReader( []byte( "image" ) ) ); err != nil {
+        t.Fatal( err )
+    }
+    if err := storage.Delete( "app:1" ); err != nil {
+        t.Fatal( err )
+    }
+    if images, _ := storage.List(); len( images ) != 0 {
+        t.Errorf( "expected no image to be listed, got %v", images )
+    }
+    if images, _ := blobs.ImageStorage.List(); len( images ) != 0 {
+        t.Errorf( "expected the blob to be deleted, got %v", images )
+    }
+}
+
+func TestSplitLegacyIndexEntry( t *testing.T ) {
+    storage, _ := newTestSplitStorage( t )
+    archive := makeImageArchive( t, "legacy", "app:1" )
+    if err := storage.blobs.Write( "app:1", bytes.NewReader( archive ) ); err != nil {
+        t.Fatal( err )
+    }
+    if err := storage.index.Write( "app:1", bytes.NewReader( nil ) ); err != nil {
+        t.Fatal( err )
+    }
+    if _, ok, err := storage.Checksum( "app:1" ); err != nil || ok {
+        t.Errorf( "expected no recorded checksum for the empty index entry, got %v, %v", ok, err )
+    }
+    if _, ok, err := storage.Size( "app:1" ); err != nil || ok {
+        t.Errorf( "expected no recorded size for the empty index entry, got %v, %v", ok, err )
+    }
+    var b bytes.Buffer
+    if err := storage.Get( "app:1", &b ); err != nil || !bytes.Equal( b.Bytes(), archive ) {
+        t.Errorf( "expected the image to be read from the blobs, got %v", err )
+    }
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+// the header selecting the storage class of the uploaded image, e.g.
+// NEARLINE for GCS or Cool for Azure
+const storageClassHeader = "X-Storage-Class"
+
+// returned when the image is archived and must be restored before it
+// can be pulled
+var ErrArchived = errors.New( "image is archived" )
+
+// returned when the backend has no such storage class
+var ErrInvalidStorageClass = errors.New( "invalid storage class" )
+
+// optional interface implemented by the storage which can store the
+// images in the storage classes of its backend
+type ClassStorage interface {
+    // get the storage writing the images in the storage class,
+    // ErrInvalidStorageClass if the backend has no such class
+    WithStorageClass(class string) (ImageStorage, error)
+}
+
+// the storage the image of the request is written with, it writes in the
+// storage class requested by the client if there is one
+func classStorage( storage ImageStorage, req *http.Request ) (ImageStorage, error) {
+    class := req.Header.Get( storageClassHeader )
+    if class == "" {
+        return storage, nil
+    }
+    class_storage, ok := storage.(ClassStorage)
+    if !ok {
+        return nil, fmt.Errorf( "%w: the storage has no storage classes", ErrInvalidStorageClass )
+    }
+    return class_storage.WithStorageClass( class )
+}
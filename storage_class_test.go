@@ -0,0 +1,106 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+// the storage classes of /image/list/detailed by image name
+func listedStorageClasses( t *testing.T, handler http.Handler ) map[string]string {
+    t.Helper()
+    rw := doRequest( handler, "GET", "/image/list/detailed", nil )
+    infos := make( []ImageInfo, 0 )
+    if err := json.Unmarshal( rw.Body.Bytes(), &infos ); err != nil {
+        t.Fatalf( "expected the detailed list, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    result := make( map[string]string )
+    for _, info := range infos {
+        result[info.Name] = info.StorageClass
+    }
+    return result
+}
+
+func TestGCSStorageClass( t *testing.T ) {
+    bucket, storage := newFakeGCSStorage( "" )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "app", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( archive ), storageClassHeader, "nearline" ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected the upload to succeed, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( archive ) )
+    if attrs, err := bucket.Attrs( context.Background(), "app/1" ); err != nil || attrs.StorageClass != "NEARLINE" {
+        t.Errorf( "expected the object to be written in NEARLINE, got %v: %v", attrs, err )
+    }
+    classes := listedStorageClasses( t, handler )
+    if classes["app:1"] != "NEARLINE" || classes["app:2"] != "STANDARD" {
+        t.Errorf( "expected the storage classes to be listed, got %v", classes )
+    }
+
+    if rw := doRequest( handler, "POST", "/image/save/app:3", bytes.NewReader( archive ), storageClassHeader, "GLACIER" ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected the unknown storage class to be rejected, got %d", rw.Code )
+    }
+
+    //the ARCHIVE objects of GCS are online, so they are pulled directly
+    doRequest( handler, "POST", "/image/save/app:4", bytes.NewReader( archive ), storageClassHeader, "ARCHIVE" )
+    if rw := doRequest( handler, "GET", "/image/get/app:4", nil ); rw.Code != http.StatusOK || !bytes.Equal( rw.Body.Bytes(), archive ) {
+        t.Errorf( "expected the ARCHIVE image to be pulled, got %d", rw.Code )
+    }
+}
+
+func TestAzureStorageClass( t *testing.T ) {
+    fab, storage := newFakeAzureStorage( t, "images", "" )
+    _, handler := newTestWeb( t, storage )
+    archive := makeImageArchive( t, "app", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( archive ), storageClassHeader, "cool" ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected the upload to succeed, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    doRequest( handler, "POST", "/image/save/app:2", bytes.NewReader( archive ), storageClassHeader, "Archive" )
+    fab.mutex.Lock()
+    tiers := map[string]string{ "app:1": fab.tiers["images/app/1"], "app:2": fab.tiers["images/app/2"] }
+    fab.mutex.Unlock()
+    if tiers["app:1"] != "Cool" || tiers["app:2"] != "Archive" {
+        t.Errorf( "expected the blobs to be written in their tiers, got %v", tiers )
+    }
+    classes := listedStorageClasses( t, handler )
+    if classes["app:1"] != "Cool" || classes["app:2"] != "Archive" {
+        t.Errorf( "expected the access tiers to be listed, got %v", classes )
+    }
+
+    //the archived blob is rejected instead of redirected to
+    for _, url := range []string{ "/image/get/app:2", "/image/get/app:2?redirect=true" } {
+        rw := doRequest( handler, "GET", url, nil )
+        if rw.Code != http.StatusConflict || !strings.Contains( rw.Body.String(), "restored" ) {
+            t.Errorf( "expected %s to be rejected until it is restored, got %d: %s", url, rw.Code, rw.Body.String() )
+        }
+    }
+    fab.mutex.Lock()
+    restoring := len( fab.rehydrating )
+    fab.mutex.Unlock()
+    if restoring != 0 {
+        t.Errorf( "expected no restore without -azure-restore-archived" )
+    }
+
+    storage.SetRestoreArchived( true )
+    rw := doRequest( handler, "GET", "/image/get/app:2", nil )
+    if rw.Code != http.StatusConflict || !strings.Contains( rw.Body.String(), "restore of app:2" ) {
+        t.Errorf( "expected the restore to be reported, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    fab.mutex.Lock()
+    defer fab.mutex.Unlock()
+    if fab.rehydrating["images/app/2"] != "Hot" {
+        t.Errorf( "expected the blob to be rehydrated to Hot, got %v", fab.rehydrating )
+    }
+}
+
+// the storage class is rejected by the backend without storage classes
+func TestStorageClassUnsupported( t *testing.T ) {
+    _, handler := newTestWeb( t, NewMemoryImageStorage() )
+    archive := makeImageArchive( t, "app", "app:1" )
+    if rw := doRequest( handler, "POST", "/image/save/app:1", bytes.NewReader( archive ), storageClassHeader, "COLDLINE" ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected the storage class to be rejected, got %d", rw.Code )
+    }
+}
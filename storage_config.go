@@ -0,0 +1,223 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/fsouza/go-dockerclient"
+)
+
+// the settings selecting and configuring the storage backend
+type Config struct {
+    //"docker", "file", "mongo", "gcs", "azure" or "layered". The default is "layered"
+    //if LayeredDir is set and "docker" otherwise
+    Backend string
+
+    FileDir string
+
+    //the permissions of the directories and files of the file backend
+    FileDirMode os.FileMode
+    FileMode os.FileMode
+
+    //store the identical images of the file backend once
+    Dedup bool
+
+    //gzip the images of the file backend before storing them
+    Compress bool
+
+    //reject the uploads of the file backend when the disk is nearly full
+    MinFreeSpace FreeSpaceThreshold
+
+    //the max number of concurrent operations on the files, 0 for no limit
+    FileMaxConcurrency int
+
+    MongoURL string
+    MongoDB string
+    MongoPrefix string
+    MongoDialTimeout time.Duration
+
+    //how many times a failed mongo dial, open or remove is retried
+    MongoRetries int
+
+    //the max number of concurrent operations on mongo, 0 for no limit
+    MongoMaxConcurrency int
+
+    //the images not larger than it are stored in a document instead of
+    //the GridFS, 0 to store all in the GridFS
+    MongoInlineThreshold int64
+
+    GCSBucket string
+    GCSPrefix string
+
+    //the account is not needed if the connection string is given by
+    //the AZURE_STORAGE_CONNECTION_STRING environment variable
+    AzureAccount string
+    AzureContainer string
+    AzurePrefix string
+
+    //start rehydrating the archived blob whose pull is rejected
+    AzureRestoreArchived bool
+
+    //the content type of the objects stored in the cloud backends, it
+    //is detected from the image if it is empty
+    ObjectContentType string
+
+    LayeredDir string
+
+    //keep the image names, the digests and the sidecars of the images in
+    //this directory and only the image content in the backend
+    SplitIndexDir string
+
+    //comma separated "<endpoint>[=<weight>]" docker daemons, the local
+    //daemon is used if it is empty
+    DockerEndpoints string
+    DockerReplicas int
+    DockerMaxConcurrency int
+    DockerRemoveDangling bool
+
+    //how long the listing of a docker daemon is reused, 0 to disable
+    DockerListCacheTTL time.Duration
+    Verbose bool
+
+    //how long an operation waits when the backend is at its concurrency cap
+    BackendWait time.Duration
+
+    //which waiting operations get the freed slot of a capped backend first
+    OperationPriorities map[string]int
+}
+
+// create the storage of the backend selected by cfg, an error is
+// returned if a setting required by the backend is missing
+func newStorage( cfg Config ) (ImageStorage, error) {
+    storage, err := newBackendStorage( cfg )
+    if err != nil || cfg.SplitIndexDir == "" {
+        return storage, err
+    }
+    //the split index keeps the digest of the written bytes, which the
+    //docker daemon doesn't give back
+    switch storage.(type) {
+    case *DockerImageStorage, *MultiDockerImageStorage:
+        return nil, fmt.Errorf( "-split-index-dir can't be used with the docker backend" )
+    }
+    index, err := NewFileImageStorage( cfg.SplitIndexDir, cfg.FileDirMode, cfg.FileMode )
+    if err != nil {
+        return nil, err
+    }
+    return NewSplitImageStorage( index, storage ), nil
+}
+
+func newBackendStorage( cfg Config ) (ImageStorage, error) {
+    backend := cfg.Backend
+    if backend == "" {
+        backend = "docker"
+        if cfg.LayeredDir != "" {
+            backend = "layered"
+        }
+    }
+    switch backend {
+    case "docker":
+        return newDockerStorage( cfg )
+    case "file":
+        if cfg.FileDir == "" {
+            return nil, fmt.Errorf( "-file-dir is required by the file backend" )
+        }
+        file_storage, err := NewFileImageStorage( cfg.FileDir, cfg.FileDirMode, cfg.FileMode )
+        if err != nil {
+            return nil, err
+        }
+        file_storage.Dedup = cfg.Dedup
+        file_storage.Compress = cfg.Compress
+        file_storage.SetMinFreeSpace( cfg.MinFreeSpace.MinFreeBytes, cfg.MinFreeSpace.MinFreePercent )
+        file_storage.SetConcurrency( cfg.FileMaxConcurrency, cfg.BackendWait )
+        file_storage.SetOperationPriorities( cfg.OperationPriorities )
+        return file_storage, nil
+    case "mongo":
+        if cfg.MongoURL == "" {
+            return nil, fmt.Errorf( "-mongo-url is required by the mongo backend" )
+        }
+        if cfg.MongoDB == "" {
+            return nil, fmt.Errorf( "-mongo-db is required by the mongo backend" )
+        }
+        prefix := cfg.MongoPrefix
+        if prefix == "" {
+            prefix = "fs"
+        }
+        mongo_storage := NewMongoImageStorage( cfg.MongoURL, cfg.MongoDB, prefix, cfg.MongoDialTimeout, cfg.MongoRetries )
+        mongo_storage.SetConcurrency( cfg.MongoMaxConcurrency, cfg.BackendWait )
+        mongo_storage.SetOperationPriorities( cfg.OperationPriorities )
+        mongo_storage.SetInlineThreshold( cfg.MongoInlineThreshold )
+        return mongo_storage, nil
+    case "gcs":
+        if cfg.GCSBucket == "" {
+            return nil, fmt.Errorf( "-gcs-bucket is required by the gcs backend" )
+        }
+        gcs_storage, err := NewGCSImageStorage( cfg.GCSBucket, cfg.GCSPrefix )
+        if err != nil {
+            return nil, err
+        }
+        gcs_storage.SetContentType( cfg.ObjectContentType )
+        return gcs_storage, nil
+    case "azure":
+        if cfg.AzureContainer == "" {
+            return nil, fmt.Errorf( "-azure-container is required by the azure backend" )
+        }
+        azure_storage, err := NewAzureBlobImageStorage( cfg.AzureAccount, cfg.AzureContainer, cfg.AzurePrefix )
+        if err != nil {
+            return nil, err
+        }
+        azure_storage.SetContentType( cfg.ObjectContentType )
+        azure_storage.SetRestoreArchived( cfg.AzureRestoreArchived )
+        return azure_storage, nil
+    case "layered":
+        if cfg.LayeredDir == "" {
+            return nil, fmt.Errorf( "-layered-dir is required by the layered backend" )
+        }
+        layered_storage, err := NewLayeredImageStorage( cfg.LayeredDir )
+        if err != nil {
+            return nil, err
+        }
+        return layered_storage, nil
+    }
+    return nil, fmt.Errorf( "unknown backend \"%s\", the supported backends are docker, file, mongo, gcs, azure and layered", backend )
+}
+
+func newDockerImageStorage( cfg Config, endpoint string ) (*DockerImageStorage, error) {
+    client, err := docker.NewClient( endpoint )
+    if err != nil {
+        return nil, err
+    }
+    docker_storage := NewDockerImageStorage( client )
+    docker_storage.SetConcurrency( cfg.DockerMaxConcurrency, cfg.BackendWait )
+    docker_storage.SetRemoveDangling( cfg.DockerRemoveDangling )
+    docker_storage.SetOperationPriorities( cfg.OperationPriorities )
+    docker_storage.SetListCacheTTL( cfg.DockerListCacheTTL )
+    return docker_storage, nil
+}
+
+// the local docker daemon, or the images spread over several daemons
+// if the endpoints are given
+func newDockerStorage( cfg Config ) (ImageStorage, error) {
+    if cfg.DockerEndpoints == "" {
+        docker_storage, err := newDockerImageStorage( cfg, defaultDockerEndpoint )
+        if err != nil {
+            return nil, err
+        }
+        return docker_storage, nil
+    }
+    multi_storage := NewMultiDockerImageStorage( cfg.DockerReplicas )
+    multi_storage.Verbose = cfg.Verbose
+    for _, endpoint := range strings.Split( cfg.DockerEndpoints, "," ) {
+        endpoint, weight, err := parseDockerEndpoint( endpoint )
+        if err != nil {
+            return nil, err
+        }
+        docker_storage, err := newDockerImageStorage( cfg, endpoint )
+        if err != nil {
+            return nil, err
+        }
+        multi_storage.AddDaemon( endpoint, docker_storage, weight )
+    }
+    return multi_storage, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestNewFileStorage( t *testing.T ) {
+    if _, err := newStorage( Config{ Backend: "file" } ); err == nil {
+        t.Error( "expected an error without -file-dir" )
+    }
+    min_free := FreeSpaceThreshold{ MinFreeBytes: 1024, MinFreePercent: 5 }
+    image_storage, err := newStorage( Config{ Backend: "file", FileDir: t.TempDir(), Dedup: true, Compress: true, MinFreeSpace: min_free, FileMaxConcurrency: 8, BackendWait: time.Second } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    file_storage, ok := image_storage.(*FileImageStorage)
+    if !ok {
+        t.Fatalf( "expected the file storage, got %T", image_storage )
+    }
+    if !file_storage.Dedup || !file_storage.Compress {
+        t.Errorf( "expected the dedup and compress settings to be applied, got %v and %v", file_storage.Dedup, file_storage.Compress )
+    }
+    if file_storage.limiter == nil || file_storage.limiter.max != 8 || file_storage.limiter.wait != time.Second {
+        t.Errorf( "expected the concurrency cap of 8 to be applied, got %+v", file_storage.limiter )
+    }
+    if file_storage.minFree != min_free {
+        t.Errorf( "expected the free space threshold %+v, got %+v", min_free, file_storage.minFree )
+    }
+}
+
+func TestNewStorageUnknownBackend( t *testing.T ) {
+    if _, err := newStorage( Config{ Backend: "tape" } ); err == nil {
+        t.Error( "expected an error for the unknown backend" )
+    }
+}
+
+func TestNewMongoStorage( t *testing.T ) {
+    if _, err := newStorage( Config{ Backend: "mongo", MongoDB: "images" } ); err == nil {
+        t.Error( "expected an error without -mongo-url" )
+    }
+    //the storage is created even if the server can't be reached
+    image_storage, err := newStorage( Config{ Backend: "mongo", MongoURL: "127.0.0.1:1", MongoDB: "images", MongoDialTimeout: 10 * time.Millisecond, MongoMaxConcurrency: 3, MongoInlineThreshold: 4096 } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    mongo_storage, ok := image_storage.(*MongoImageStorage)
+    if !ok {
+        t.Fatalf( "expected the mongo storage, got %T", image_storage )
+    }
+    if mongo_storage.limiter == nil || mongo_storage.limiter.max != 3 {
+        t.Errorf( "expected the concurrency cap of 3 to be applied, got %+v", mongo_storage.limiter )
+    }
+    if mongo_storage.inlineThreshold != 4096 {
+        t.Errorf( "expected the inline threshold of 4096, got %d", mongo_storage.inlineThreshold )
+    }
+}
+
+func TestNewSplitStorage( t *testing.T ) {
+    image_storage, err := newStorage( Config{ Backend: "file", FileDir: t.TempDir(), SplitIndexDir: t.TempDir() } )
+    if err != nil {
+        t.Fatal( err )
+    }
+    split_storage, ok := image_storage.(*SplitImageStorage)
+    if !ok {
+        t.Fatalf( "expected the split storage, got %T", image_storage )
+    }
+    if _, ok := split_storage.blobs.(*FileImageStorage); !ok {
+        t.Errorf( "expected the file backend to keep the blobs, got %T", split_storage.blobs )
+    }
+    if _, err := newStorage( Config{ Backend: "docker", SplitIndexDir: t.TempDir() } ); err == nil {
+        t.Error( "expected an error for the split index over the docker backend" )
+    }
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "fmt"
+    "os"
+    "sort"
+    "testing"
+    "time"
+
+    "cloud.google.com/go/storage"
+)
+
+// check the behavior every ImageStorage has. The images are docker-save
+// archives so the docker storages can load them
+func checkImageStorage( t *testing.T, image_storage ImageStorage ) {
+    t.Helper()
+    first := makeImageArchive( t, "first", "conformance/app:1" )
+    second := makeImageArchive( t, "second", "conformance/app:1" )
+    other := makeImageArchive( t, "other", "conformance/app:2" )
+
+    get := func( name string ) ([]byte, error) {
+        var b bytes.Buffer
+        err := image_storage.Get( name, &b )
+        return b.Bytes(), err
+    }
+    //the empty storage is listed as [] rather than null
+    if names, err := image_storage.List(); err != nil || names == nil || len( names ) != 0 {
+        t.Errorf( "expected an empty non-nil list, got %#v: %v", names, err )
+    }
+    if err := image_storage.Write( "conformance/app:1", bytes.NewReader( first ) ); err != nil {
+        t.Fatalf( "write: %v", err )
+    }
+    if err := image_storage.Write( "conformance/app:2", bytes.NewReader( other ) ); err != nil {
+        t.Fatalf( "write: %v", err )
+    }
+    if b, err := get( "conformance/app:1" ); err != nil || !bytes.Equal( b, first ) {
+        t.Errorf( "expected the written image, got %d bytes: %v", len( b ), err )
+    }
+
+    //the image is replaced by the second write
+    if err := image_storage.Write( "conformance/app:1", bytes.NewReader( second ) ); err != nil {
+        t.Fatalf( "overwrite: %v", err )
+    }
+    if b, err := get( "conformance/app:1" ); err != nil || !bytes.Equal( b, second ) {
+        t.Errorf( "expected the overwritten image, got %d bytes: %v", len( b ), err )
+    }
+
+    names, err := image_storage.List()
+    if err != nil {
+        t.Fatalf( "list: %v", err )
+    }
+    sort.Strings( names )
+    if fmt.Sprint( names ) != "[conformance/app:1 conformance/app:2]" {
+        t.Errorf( "expected the 2 images to be listed, got %v", names )
+    }
+    if exists, err := storageExists( image_storage, "conformance/app:2" ); err != nil || !exists {
+        t.Errorf( "expected conformance/app:2 to exist: %v", err )
+    }
+
+    if err = image_storage.Delete( "conformance/app:2" ); err != nil {
+        t.Fatalf( "delete: %v", err )
+    }
+    if _, err = get( "conformance/app:2" ); !isNotFound( err ) {
+        t.Errorf( "expected the deleted image to be not found, got %v", err )
+    }
+    if exists, err := storageExists( image_storage, "conformance/app:2" ); err != nil || exists {
+        t.Errorf( "expected conformance/app:2 to be deleted: %v", err )
+    }
+    if err = image_storage.Delete( "conformance/app:3" ); !isNotFound( err ) {
+        t.Errorf( "expected the missing image to be not found on delete, got %v", err )
+    }
+    if names, err = image_storage.List(); err != nil || fmt.Sprint( names ) != "[conformance/app:1]" {
+        t.Errorf( "expected only conformance/app:1 to be listed, got %v: %v", names, err )
+    }
+}
+
+func TestMemoryStorageConformance( t *testing.T ) {
+    checkImageStorage( t, NewMemoryImageStorage() )
+}
+
+func TestFileStorageConformance( t *testing.T ) {
+    checkImageStorage( t, newFileStorage( t ) )
+}
+
+func TestCompressedFileStorageConformance( t *testing.T ) {
+    storage := newFileStorage( t )
+    storage.Compress = true
+    checkImageStorage( t, storage )
+}
+
+func TestLayeredStorageConformance( t *testing.T ) {
+    storage, err := NewLayeredImageStorage( t.TempDir() )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkImageStorage( t, storage )
+}
+
+func TestSplitStorageConformance( t *testing.T ) {
+    checkImageStorage( t, NewSplitImageStorage( newFileStorage( t ), newFileStorage( t ) ) )
+}
+
+func TestDockerStorageConformance( t *testing.T ) {
+    _, storage := newFakeDocker( t )
+    checkImageStorage( t, storage )
+}
+
+func TestAzureStorageConformance( t *testing.T ) {
+    _, storage := newFakeAzureStorage( t, "images", "conformance-test" )
+    checkImageStorage( t, storage )
+}
+
+func TestGCSStorageConformance( t *testing.T ) {
+    _, storage := newFakeGCSStorage( "conformance-test" )
+    checkImageStorage( t, storage )
+}
+
+// the GCS client is checked against the emulator at STORAGE_EMULATOR_HOST,
+// e.g. "fake-gcs-server -scheme http -public-host localhost:4443"
+func TestGCSEmulatorConformance( t *testing.T ) {
+    if os.Getenv( "STORAGE_EMULATOR_HOST" ) == "" {
+        t.Skip( "STORAGE_EMULATOR_HOST is not set" )
+    }
+    bucket := fmt.Sprintf( "image-mgr-test-%d", time.Now().UnixNano() )
+    storage_client, err := storage.NewClient( context.Background() )
+    if err != nil {
+        t.Fatal( err )
+    }
+    defer storage_client.Close()
+    if err = storage_client.Bucket( bucket ).Create( context.Background(), "test", nil ); err != nil {
+        t.Fatal( err )
+    }
+    gcs_storage, err := NewGCSImageStorage( bucket, "images" )
+    if err != nil {
+        t.Fatal( err )
+    }
+    checkImageStorage( t, gcs_storage )
+}
+
+// the mongo storage is checked against the mongod at MONGO_URL
+func TestMongoStorageConformance( t *testing.T ) {
+    checkImageStorage( t, newTestMongoStorage( t ) )
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// the header carrying the short-lived credentials of the backend the
+// request is done with, e.g. an OAuth2 access token for GCS or a SAS
+// token for Azure
+const storageCredentialsHeader = "X-Storage-Credentials"
+
+// optional interface implemented by the cloud storages which can access
+// the backend with the credentials of a request instead of the server's
+type CredentialStorage interface {
+    // get the storage accessing the backend with the credentials, it is
+    // closed after the request if it is an io.Closer
+    WithCredentials(credentials string) (ImageStorage, error)
+}
+
+// the paths whose handlers do the image operations with the storage of
+// the request, the credentials are rejected by the other paths so they
+// are never done with the server's credentials instead
+var credentialPaths = []string{ "/image/save/", "/image/get/", "/image/delete/", "/image/exists/" }
+
+type requestStorageKey struct{}
+
+// serve the request carrying its own credentials with a storage created
+// for it. The credentials are removed from the request, so they are
+// never logged or passed further
+func (iw *ImageWeb) withRequestStorage( handler http.Handler ) http.Handler {
+    return http.HandlerFunc( func(rw http.ResponseWriter, req *http.Request) {
+        credentials := req.Header.Get( storageCredentialsHeader )
+        if credentials == "" {
+            handler.ServeHTTP( rw, req )
+            return
+        }
+        req.Header.Del( storageCredentialsHeader )
+        supported := false
+        for _, prefix := range credentialPaths {
+            supported = supported || strings.HasPrefix( req.URL.Path, prefix )
+        }
+        credential_storage, ok := iw.image_storage.(CredentialStorage)
+        if !ok || !supported {
+            http.Error( rw, "the storage credentials are not supported by " + req.URL.Path, http.StatusBadRequest )
+            return
+        }
+        storage, err := credential_storage.WithCredentials( credentials )
+        if err != nil {
+            //the error may quote the credentials
+            http.Error( rw, "invalid storage credentials", http.StatusBadRequest )
+            return
+        }
+        if closer, ok := storage.(io.Closer); ok {
+            defer closer.Close()
+        }
+        handler.ServeHTTP( rw, req.WithContext( context.WithValue( req.Context(), requestStorageKey{}, storage ) ) )
+    })
+}
+
+// the storage the request is served with, the one with the credentials
+// of the request if it carries them, otherwise the server's
+func (iw *ImageWeb) storage( req *http.Request ) ImageStorage {
+    if storage, ok := req.Context().Value( requestStorageKey{} ).(ImageStorage); ok {
+        return storage
+    }
+    return iw.image_storage
+}
+
+// check if the request is served with its own credentials
+func hasRequestStorage( req *http.Request ) bool {
+    _, ok := req.Context().Value( requestStorageKey{} ).(ImageStorage)
+    return ok
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+// the requests carrying credentials are done with the bucket opened with
+// them, the others with the server's bucket
+func TestGCSRequestCredentials( t *testing.T ) {
+    bucket, storage := newFakeGCSStorage( "" )
+    iw, handler := newTestWeb( t, storage )
+    iw.SetLogFormat( LogFormatText )
+    archive := makeImageArchive( t, "app", "app:1" )
+    var rw *httptest.ResponseRecorder
+    lines := captureLog( func() {
+        rw = doRequest( handler, "POST", "/image/save/app:1", strings.NewReader( string( archive ) ), storageCredentialsHeader, "secret-token" )
+    })
+    if rw.Code != http.StatusCreated {
+        t.Fatalf( "expected the upload to succeed, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    if token, ok := bucket.writtenWith( "app/1" ); !ok || token != "secret-token" {
+        t.Errorf( "expected the image to be written with the bucket of the token, got %q", token )
+    }
+    if len( bucket.opened ) != 1 || bucket.opened[0].token != "secret-token" || !bucket.opened[0].closed {
+        t.Errorf( "expected one bucket to be opened with the token and closed after the request" )
+    }
+    for _, line := range lines {
+        if strings.Contains( line, "secret-token" ) {
+            t.Errorf( "the credentials are logged: %s", line )
+        }
+    }
+
+    //without the credentials the server's bucket is used
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil ); rw.Code != http.StatusOK {
+        t.Errorf( "expected the image to be got with the server's bucket, got %d", rw.Code )
+    }
+    if len( bucket.opened ) != 1 {
+        t.Errorf( "expected no bucket to be opened without the credentials, got %v", bucket.opened )
+    }
+}
+
+func TestAzureRequestCredentials( t *testing.T ) {
+    fab, storage := newFakeAzureStorage( t, "images", "" )
+    storage.Write( "app:1", strings.NewReader( "image" ) )
+    _, handler := newTestWeb( t, storage )
+
+    fab.signatures = nil
+    rw := doRequest( handler, "GET", "/image/get/app:1", nil, storageCredentialsHeader, "sv=2021-08-06&sp=r&sig=c2lnbmF0dXJl" )
+    if rw.Code != http.StatusOK || rw.Body.String() != "image" {
+        t.Fatalf( "expected the image to be got with the SAS token, got %d: %s", rw.Code, rw.Body.String() )
+    }
+    if len( fab.signatures ) == 0 {
+        t.Fatal( "expected the account to be requested" )
+    }
+    for _, signature := range fab.signatures {
+        if signature != "c2lnbmF0dXJl" {
+            t.Errorf( "expected every request to be signed with the SAS token, got %q", fab.signatures )
+            break
+        }
+    }
+
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil, storageCredentialsHeader, "not a SAS token" ); rw.Code != http.StatusBadRequest || strings.Contains( rw.Body.String(), "not a SAS" ) {
+        t.Errorf( "expected the invalid token to be rejected without quoting it, got %d: %s", rw.Code, rw.Body.String() )
+    }
+}
+
+// the credentials are rejected when they can not be used, instead of
+// doing the request with the server's credentials
+func TestRequestCredentialsUnsupported( t *testing.T ) {
+    _, handler := newTestWeb( t, NewMemoryImageStorage() )
+    if rw := doRequest( handler, "GET", "/image/get/app:1", nil, storageCredentialsHeader, "token" ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected the credentials to be rejected by the memory storage, got %d", rw.Code )
+    }
+
+    _, storage := newFakeGCSStorage( "" )
+    _, handler = newTestWeb( t, storage )
+    if rw := doRequest( handler, "GET", "/image/list", nil, storageCredentialsHeader, "token" ); rw.Code != http.StatusBadRequest {
+        t.Errorf( "expected the credentials to be rejected by the list, got %d", rw.Code )
+    }
+}
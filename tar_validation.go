@@ -0,0 +1,102 @@
+package main
+
+import (
+    "archive/tar"
+    "bufio"
+    "compress/gzip"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "path"
+)
+
+// returned when the uploaded stream is not a docker-save archive
+var ErrInvalidImageArchive = errors.New( "not a docker-save image archive" )
+
+// check the first block of the stream looks like a (gzipped) tar without
+// consuming it, so the obviously wrong uploads fail before any work
+func checkArchiveHeader( br *bufio.Reader ) error {
+    if magic, err := br.Peek( 2 ); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+        return nil
+    }
+    block, err := br.Peek( 512 )
+    if err != nil {
+        return fmt.Errorf( "%w: the stream is shorter than a tar header", ErrInvalidImageArchive )
+    }
+    //the ustar, pax and gnu tar headers all have the "ustar" magic
+    if string( block[257:262] ) != "ustar" {
+        return fmt.Errorf( "%w: the stream is not a tar", ErrInvalidImageArchive )
+    }
+    return nil
+}
+
+// scan the tar written to it in the background for the manifest.json of
+// a docker-save archive, so the stream is checked while it is passed on
+type archiveValidator struct {
+    pw *io.PipeWriter
+    result chan error
+
+    //the entries of the manifest.json, set once the result is received
+    manifest []dockerSaveManifest
+}
+
+func newArchiveValidator() *archiveValidator {
+    pr, pw := io.Pipe()
+    av := &archiveValidator{ pw: pw, result: make( chan error, 1 ) }
+    go func() {
+        manifest, err := scanForManifest( pr )
+        //keep reading so the writer is never blocked by the validation
+        io.Copy( ioutil.Discard, pr )
+        av.manifest = manifest
+        av.result <- err
+    }()
+    return av
+}
+
+// read the entries of the manifest.json of the docker-save tar
+func scanForManifest( r io.Reader ) ([]dockerSaveManifest, error) {
+    br := bufio.NewReader( r )
+    var src io.Reader = br
+    if magic, err := br.Peek( 2 ); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+        gz, err := gzip.NewReader( br )
+        if err != nil {
+            return nil, fmt.Errorf( "%w: %v", ErrInvalidImageArchive, err )
+        }
+        defer gz.Close()
+        src = gz
+    }
+    tr := tar.NewReader( src )
+    for {
+        header, err := tr.Next()
+        if err == io.EOF {
+            return nil, fmt.Errorf( "%w: no manifest.json in the archive", ErrInvalidImageArchive )
+        }
+        if err != nil {
+            return nil, fmt.Errorf( "%w: %v", ErrInvalidImageArchive, err )
+        }
+        if path.Clean( header.Name ) == "manifest.json" {
+            manifest := make( []dockerSaveManifest, 0 )
+            if err = json.NewDecoder( tr ).Decode( &manifest ); err != nil {
+                return nil, fmt.Errorf( "%w: invalid manifest.json: %v", ErrInvalidImageArchive, err )
+            }
+            return manifest, nil
+        }
+    }
+}
+
+func (av *archiveValidator) Write( p []byte ) (int, error ) {
+    return av.pw.Write( p )
+}
+
+// finish the stream and get the result of the validation
+func (av *archiveValidator) Result() error {
+    av.pw.Close()
+    return <-av.result
+}
+
+// get the manifest.json entries of the valid archive after Result
+func (av *archiveValidator) Manifest() []dockerSaveManifest {
+    return av.manifest
+}
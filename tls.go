@@ -0,0 +1,37 @@
+package main
+
+import (
+    "crypto/tls"
+    "fmt"
+)
+
+// the TLS versions accepted by -tls-min-version
+var tlsVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+// serve HTTPS with the certificate and key files instead of plain HTTP,
+// the connections below minVersion ("1.0" to "1.3") are refused. Both
+// files must be given and are loaded now so a bad pair fails at startup
+func (iw *ImageWeb) SetTLS( certFile string, keyFile string, minVersion string ) error {
+    if certFile == "" && keyFile == "" {
+        return nil
+    }
+    if certFile == "" || keyFile == "" {
+        return fmt.Errorf( "both -tls-cert and -tls-key must be given to serve HTTPS" )
+    }
+    version, ok := tlsVersions[minVersion]
+    if !ok {
+        return fmt.Errorf( "unknown TLS version %s, it must be 1.0, 1.1, 1.2 or 1.3", minVersion )
+    }
+    if _, err := tls.LoadX509KeyPair( certFile, keyFile ); err != nil {
+        return fmt.Errorf( "fail to load the TLS certificate: %v", err )
+    }
+    iw.tlsCert = certFile
+    iw.tlsKey = keyFile
+    iw.server.TLSConfig = &tls.Config{ MinVersion: version }
+    return nil
+}
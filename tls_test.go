@@ -0,0 +1,120 @@
+package main
+
+import (
+    "context"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "io/ioutil"
+    "math/big"
+    "net"
+    "net/http"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// write a self-signed certificate of 127.0.0.1 and its key, and get
+// the certificate to trust
+func writeSelfSignedCert( t *testing.T ) (string, string, *x509.Certificate) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey( elliptic.P256(), rand.Reader )
+    if err != nil {
+        t.Fatal( err )
+    }
+    template := &x509.Certificate{ SerialNumber: big.NewInt( 1 ),
+            Subject: pkix.Name{ CommonName: "image-mgr-test" },
+            NotBefore: time.Now().Add( -time.Hour ),
+            NotAfter: time.Now().Add( time.Hour ),
+            KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+            ExtKeyUsage: []x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth },
+            IPAddresses: []net.IP{ net.ParseIP( "127.0.0.1" ) },
+            IsCA: true,
+            BasicConstraintsValid: true }
+    der, err := x509.CreateCertificate( rand.Reader, template, template, &key.PublicKey, key )
+    if err != nil {
+        t.Fatal( err )
+    }
+    cert, err := x509.ParseCertificate( der )
+    if err != nil {
+        t.Fatal( err )
+    }
+    key_der, err := x509.MarshalECPrivateKey( key )
+    if err != nil {
+        t.Fatal( err )
+    }
+    dir := t.TempDir()
+    cert_file := filepath.Join( dir, "cert.pem" )
+    key_file := filepath.Join( dir, "key.pem" )
+    ioutil.WriteFile( cert_file, pem.EncodeToMemory( &pem.Block{ Type: "CERTIFICATE", Bytes: der } ), 0600 )
+    ioutil.WriteFile( key_file, pem.EncodeToMemory( &pem.Block{ Type: "EC PRIVATE KEY", Bytes: key_der } ), 0600 )
+    return cert_file, key_file, cert
+}
+
+func TestServeTLS( t *testing.T ) {
+    cert_file, key_file, cert := writeSelfSignedCert( t )
+    iw, _ := newTestWeb( t, newFileStorage( t ) )
+    if err := iw.SetTLS( cert_file, key_file, "1.2" ); err != nil {
+        t.Fatal( err )
+    }
+    addr := freeAddr( t )
+    ctx, cancel := context.WithCancel( context.Background() )
+    served := make( chan error, 1 )
+    go func() {
+        served <- iw.ServeContext( ctx, addr )
+    }()
+    defer func() {
+        cancel()
+        <-served
+    }()
+
+    roots := x509.NewCertPool()
+    roots.AddCert( cert )
+    client := func( max_version uint16 ) *http.Client {
+        return &http.Client{ Transport: &http.Transport{ TLSClientConfig: &tls.Config{ RootCAs: roots, MaxVersion: max_version } } }
+    }
+    var resp *http.Response
+    var err error
+    for i := 0; i < 50; i++ {
+        if resp, err = client( 0 ).Get( "https://" + addr + "/image/list" ); err == nil {
+            break
+        }
+        time.Sleep( 10 * time.Millisecond )
+    }
+    if err != nil {
+        t.Fatal( err )
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+        t.Errorf( "expected 200 over HTTPS, got %d", resp.StatusCode )
+    }
+    //the connections below the min version are refused
+    if _, err = client( tls.VersionTLS11 ).Get( "https://" + addr + "/image/list" ); err == nil {
+        t.Error( "expected the TLS 1.1 connection to be refused" )
+    }
+    //plain HTTP is not served on the HTTPS port
+    if resp, err = http.Get( "http://" + addr + "/image/list" ); err == nil {
+        resp.Body.Close()
+        if resp.StatusCode == http.StatusOK {
+            t.Error( "expected plain HTTP to be refused" )
+        }
+    }
+}
+
+func TestSetTLSInvalid( t *testing.T ) {
+    cert_file, key_file, _ := writeSelfSignedCert( t )
+    iw, _ := newTestWeb( t, newFileStorage( t ) )
+    for _, c := range [][]string{ { cert_file, "", "1.2" }, { "", key_file, "1.2" }, { cert_file, key_file, "1.4" }, { key_file, cert_file, "1.2" } } {
+        if err := iw.SetTLS( c[0], c[1], c[2] ); err == nil {
+            t.Errorf( "expected %v to be rejected", c )
+        }
+    }
+    //plain HTTP without a certificate and key
+    if err := iw.SetTLS( "", "", "" ); err != nil || iw.server.TLSConfig != nil {
+        t.Errorf( "expected plain HTTP, got %v", err )
+    }
+}
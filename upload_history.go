@@ -0,0 +1,110 @@
+package main
+
+import (
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// one upload of an image
+type UploadAttempt struct {
+    Time time.Time `json:"time"`
+
+    //the number of bytes received from the client
+    Bytes int64 `json:"bytes"`
+
+    Success bool `json:"success"`
+    Error string `json:"error,omitempty"`
+}
+
+// remember the last uploads of every image to diagnose the clients
+// which fail partway repeatedly
+type UploadHistory struct {
+    mutex sync.Mutex
+
+    //how many attempts are kept for an image, 0 to disable the history
+    limit int
+
+    attempts map[string][]UploadAttempt
+}
+
+func NewUploadHistory( limit int ) *UploadHistory {
+    return &UploadHistory{ limit: limit, attempts: make( map[string][]UploadAttempt ) }
+}
+
+func (uh *UploadHistory) SetLimit( limit int ) {
+    uh.mutex.Lock()
+    defer uh.mutex.Unlock()
+    uh.limit = limit
+    for name, attempts := range uh.attempts {
+        uh.attempts[name] = uh.truncate( attempts )
+    }
+}
+
+// keep the last limit attempts
+func (uh *UploadHistory) truncate( attempts []UploadAttempt ) []UploadAttempt {
+    if uh.limit <= 0 {
+        return nil
+    }
+    if len( attempts ) > uh.limit {
+        attempts = append( []UploadAttempt{}, attempts[len( attempts ) - uh.limit:]... )
+    }
+    return attempts
+}
+
+// record an attempt to upload image name, err is nil if it succeeded
+func (uh *UploadHistory) Add( name string, bytes int64, err error ) {
+    uh.mutex.Lock()
+    defer uh.mutex.Unlock()
+    if uh.limit <= 0 {
+        return
+    }
+    attempt := UploadAttempt{ Time: time.Now(), Bytes: bytes, Success: err == nil }
+    if err != nil {
+        attempt.Error = err.Error()
+    }
+    name = normalizeImageName( name )
+    uh.attempts[name] = uh.truncate( append( uh.attempts[name], attempt ) )
+}
+
+// get the attempts of image name, the oldest first
+func (uh *UploadHistory) Get( name string ) []UploadAttempt {
+    uh.mutex.Lock()
+    defer uh.mutex.Unlock()
+    return append( make( []UploadAttempt, 0 ), uh.attempts[normalizeImageName( name )]... )
+}
+
+// count the bytes read from the request body
+type countingReadCloser struct {
+    io.ReadCloser
+    n int64
+}
+
+func (crc *countingReadCloser) Read( p []byte ) (int, error) {
+    n, err := crc.ReadCloser.Read( p )
+    atomic.AddInt64( &crc.n, int64( n ) )
+    return n, err
+}
+
+func (crc *countingReadCloser) Count() int64 {
+    return atomic.LoadInt64( &crc.n )
+}
+
+func (iw *ImageWeb) SetUploadHistory( limit int ) {
+    iw.uploadHistory.SetLimit( limit )
+}
+
+func (iw *ImageWeb) initUploadHistory() {
+    http.HandleFunc("/image/upload-history/", func(rw http.ResponseWriter, req *http.Request) {
+        name := iw.nameTransform.Apply( strings.TrimPrefix( req.URL.Path, "/image/upload-history/" ) )
+        if !iw.checkName( rw, name ) || !iw.authorize( rw, req, name, false ) {
+            return
+        }
+        rw.Header().Set( "Content-Type", "application/json" )
+        json.NewEncoder( rw ).Encode( iw.uploadHistory.Get( name ) )
+    })
+}
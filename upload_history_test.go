@@ -0,0 +1,66 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "net/http"
+    "testing"
+)
+
+func uploadHistoryOf( t *testing.T, handler http.Handler, name string ) []UploadAttempt {
+    t.Helper()
+    rw := doRequest( handler, "GET", "/image/upload-history/" + name, nil )
+    if rw.Code != http.StatusOK {
+        t.Fatalf( "expected 200, got %d", rw.Code )
+    }
+    attempts := make( []UploadAttempt, 0 )
+    if err := json.Unmarshal( rw.Body.Bytes(), &attempts ); err != nil {
+        t.Fatal( err )
+    }
+    return attempts
+}
+
+func TestUploadHistory( t *testing.T ) {
+    iw, handler := newTestWeb( t, newFileStorage( t ) )
+    iw.SetUploadHistory( 2 )
+    archive := makeImageArchive( t, "app", "app:1" )
+
+    if attempts := uploadHistoryOf( t, handler, "app:1" ); len( attempts ) != 0 {
+        t.Errorf( "expected no attempts before an upload, got %v", attempts )
+    }
+    //the upload with a wrong checksum fails after the whole image is received
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), checksumHeader, sha256Digest( []byte( "other" ) ) )
+    if rw.Code == http.StatusCreated {
+        t.Fatalf( "expected the checksum mismatch to fail the upload" )
+    }
+    if rw = doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) ); rw.Code != http.StatusCreated {
+        t.Fatalf( "expected 201, got %d", rw.Code )
+    }
+    attempts := uploadHistoryOf( t, handler, "app:1" )
+    if len( attempts ) != 2 || attempts[0].Success || attempts[0].Error == "" || !attempts[1].Success {
+        t.Fatalf( "expected a failed and then a successful attempt, got %+v", attempts )
+    }
+    if attempts[1].Bytes != int64( len( archive ) ) || attempts[0].Time.After( attempts[1].Time ) {
+        t.Errorf( "unexpected attempts %+v", attempts )
+    }
+
+    //only the last 2 attempts are kept
+    doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ) )
+    if attempts = uploadHistoryOf( t, handler, "app:1" ); len( attempts ) != 2 || !attempts[0].Success || !attempts[1].Success {
+        t.Errorf( "expected the 2 successful attempts, got %+v", attempts )
+    }
+    //the other images have their own history
+    if attempts = uploadHistoryOf( t, handler, "app:2" ); len( attempts ) != 0 {
+        t.Errorf( "expected no attempts of app:2, got %v", attempts )
+    }
+}
+
+func TestUploadHistoryDisabled( t *testing.T ) {
+    history := NewUploadHistory( 3 )
+    history.Add( "app:1", 10, nil )
+    history.SetLimit( 0 )
+    history.Add( "app:1", 10, nil )
+    if attempts := history.Get( "app:1" ); len( attempts ) != 0 {
+        t.Errorf( "expected no attempts with the history disabled, got %v", attempts )
+    }
+}
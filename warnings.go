@@ -0,0 +1,21 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+)
+
+// the non-fatal issues of an operation which succeeded
+type Warnings []string
+
+func (w *Warnings) Add( format string, args ...interface{} ) {
+    *w = append( *w, fmt.Sprintf( format, args... ) )
+}
+
+// report every warning in a "Warning: 299 - <text>" header
+func (w Warnings) WriteHeaders( rw http.ResponseWriter ) {
+    for _, warning := range w {
+        rw.Header().Add( "Warning", "299 - " + strconv.Quote( warning ) )
+    }
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestSaveWarnings( t *testing.T ) {
+    _, handler := newTestWeb( t, newFileStorage( t ) )
+    archive := makeImageArchive( t, "warnings", "app:1" )
+
+    rw := doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Accept", "application/json" )
+    result := struct{ Status string `json:"status"`; Warnings []string `json:"warnings"` }{}
+    if err := json.Unmarshal( rw.Body.Bytes(), &result ); err != nil || result.Status != "ok" || len( result.Warnings ) != 0 {
+        t.Errorf( "expected no warning for the new image, got %s", rw.Body.String() )
+    }
+
+    rw = doRequest( handler, "POST", "/image/save/app/1", bytes.NewReader( archive ), "Accept", "application/json" )
+    if err := json.Unmarshal( rw.Body.Bytes(), &result ); err != nil || rw.Code != http.StatusCreated || len( result.Warnings ) != 1 || !strings.Contains( result.Warnings[0], "already existed" ) {
+        t.Errorf( "expected the overwrite warning, got %d %s", rw.Code, rw.Body.String() )
+    }
+    if header := rw.Header().Get( "Warning" ); !strings.HasPrefix( header, "299 - " ) || !strings.Contains( header, "already existed" ) {
+        t.Errorf( "expected the overwrite warning header, got %q", header )
+    }
+
+    var encoded bytes.Buffer
+    gw := gzip.NewWriter( &encoded )
+    gw.Write( archive )
+    gw.Close()
+    rw = doRequest( handler, "POST", "/image/save/app/2", bytes.NewReader( encoded.Bytes() ), "Content-Encoding", "gzip", "Accept", "application/json" )
+    if err := json.Unmarshal( rw.Body.Bytes(), &result ); err != nil || len( result.Warnings ) != 1 || !strings.Contains( result.Warnings[0], "without validation" ) {
+        t.Errorf( "expected the unvalidated gzip warning, got %s", rw.Body.String() )
+    }
+}